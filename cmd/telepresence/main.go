@@ -73,8 +73,13 @@ func main() {
 		})
 		ctx = output.WithStructure(ctx, cmd)
 		if err := cmd.ExecuteContext(ctx); err != nil {
+			if errcat.IsSilent(err) {
+				os.Exit(errcat.ExitCode(err))
+			}
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
-			if errcat.GetCategory(err) > errcat.NoDaemonLogs {
+			// A Timeout is reported by the CLI when a command doesn't finish in time. The daemon logs won't
+			// explain why, so it doesn't get a log summary even though it's ordered after Unknown.
+			if cat := errcat.GetCategory(err); cat > errcat.NoDaemonLogs && cat != errcat.Timeout {
 				summarizeLogs(ctx, cmd)
 				// If the user gets here, it might be an actual bug that they found, so
 				// point them to the `gather-logs` command in case they want to open an
@@ -84,7 +89,7 @@ func main() {
 					"telepresence_logs.zip to your github issue or create a new one: "+
 					"https://github.com/telepresenceio/telepresence/issues/new?template=Bug_report.md .")
 			}
-			os.Exit(1)
+			os.Exit(errcat.ExitCode(err))
 		}
 	}
 }
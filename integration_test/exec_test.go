@@ -0,0 +1,36 @@
+package integration_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/integration_test/itest"
+)
+
+func (s *connectedSuite) Test_ExecResolvesClusterName() {
+	ctx := s.Context()
+
+	// The name of the API server only resolves using the DNS of the connection. Curl fails with
+	// exit code 6 when it can't resolve the host, but not when the server responds with an error.
+	s.Eventually(func() bool {
+		_, _, err := itest.Telepresence(ctx, "exec", "--", "curl", "--silent", "-k", "--max-time", "2", "https://kubernetes.default:443")
+		return err == nil
+	}, 30*time.Second, 2*time.Second)
+
+	_, _, err := itest.Telepresence(ctx, "exec", "--", "curl", "--silent", "--max-time", "2", "http://no-such-service.no-such-namespace")
+	s.Error(err)
+}
+
+func (s *connectedSuite) Test_ExecRunsLocally() {
+	// The command runs with the environment of the telepresence command, not with that of a daemon
+	ctx := itest.WithEnv(s.Context(), map[string]string{"TP_EXEC_TEST": "local"})
+	stdout := itest.TelepresenceOk(ctx, "exec", "--", "sh", "-c", "echo $TP_EXEC_TEST")
+	s.Equal("local", strings.TrimSpace(stdout))
+
+	// and its exit code is that of the telepresence command
+	err := itest.TelepresenceCmd(ctx, "exec", "--", "sh", "-c", "exit 3").Run()
+	var ee *dexec.ExitError
+	s.Require().ErrorAs(err, &ee)
+	s.Equal(3, ee.ExitCode())
+}
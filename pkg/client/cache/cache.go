@@ -23,6 +23,8 @@ func ensureCacheDir(ctx context.Context) (string, error) {
 	return cacheDir, nil
 }
 
+// SaveToUserCache writes the object as JSON to the given file in the user cache. The file is replaced
+// atomically, so concurrent readers will never see a partially written file.
 func SaveToUserCache(ctx context.Context, object any, file string) error {
 	jsonContent, err := json.Marshal(object)
 	if err != nil {
@@ -32,7 +34,24 @@ func SaveToUserCache(ctx context.Context, object any, file string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, file), jsonContent, 0o600)
+	tmp, err := os.CreateTemp(dir, file+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(jsonContent); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), filepath.Join(dir, file))
+	return err
 }
 
 func LoadFromUserCache(ctx context.Context, dest any, file string) error {
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+const commandsFile = "commands.json"
+
+type cachedCommands struct {
+	Key      string          `json:"key"`
+	Time     time.Time       `json:"time"`
+	Commands json.RawMessage `json:"commands"`
+}
+
+// SaveCommandsToUserCache saves the command groups provided by the user daemon, identified by key, to
+// user cache and returns an error if something goes wrong while marshalling or persisting.
+func SaveCommandsToUserCache(ctx context.Context, key string, groups *connector.CommandGroups) error {
+	data, err := protojson.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	return SaveToUserCache(ctx, &cachedCommands{Key: key, Time: time.Now(), Commands: data}, commandsFile)
+}
+
+// LoadCommandsFromUserCache gets the command groups from cache. Nil is returned if the file does not exist,
+// if it was saved using a different key, or if it's older than the given ttl. An error is returned if
+// something goes wrong while loading or unmarshalling.
+func LoadCommandsFromUserCache(ctx context.Context, key string, ttl time.Duration) (*connector.CommandGroups, error) {
+	var cc cachedCommands
+	if err := LoadFromUserCache(ctx, &cc, commandsFile); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	if cc.Key != key || time.Since(cc.Time) > ttl {
+		return nil, nil
+	}
+	groups := &connector.CommandGroups{}
+	if err := protojson.Unmarshal(cc.Commands, groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// DeleteCommandsFromUserCache removes the commands cache if exists or returns an error. An attempt
+// to remove a non-existing cache is a no-op and the function returns nil.
+func DeleteCommandsFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, commandsFile)
+}
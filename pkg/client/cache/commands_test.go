@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func testCommandsContext(t *testing.T) context.Context {
+	return filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+}
+
+func testCommandGroups() *connector.CommandGroups {
+	return &connector.CommandGroups{
+		CommandGroups: map[string]*connector.CommandGroups_Commands{
+			"Traffic Commands": {
+				Commands: []*connector.CommandGroups_Command{{
+					Name:      "intercept",
+					ShortHelp: "Intercept a service",
+				}},
+			},
+		},
+	}
+}
+
+func TestCommandsCache(t *testing.T) {
+	t.Run("hit", func(t *testing.T) {
+		ctx := testCommandsContext(t)
+		require.NoError(t, SaveCommandsToUserCache(ctx, "k1", testCommandGroups()))
+		groups, err := LoadCommandsFromUserCache(ctx, "k1", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, groups)
+		cmds := groups.CommandGroups["Traffic Commands"].GetCommands()
+		require.Len(t, cmds, 1)
+		assert.Equal(t, "intercept", cmds[0].Name)
+		assert.Equal(t, "Intercept a service", cmds[0].ShortHelp)
+	})
+
+	t.Run("no cache", func(t *testing.T) {
+		ctx := testCommandsContext(t)
+		groups, err := LoadCommandsFromUserCache(ctx, "k1", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, groups)
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		ctx := testCommandsContext(t)
+		require.NoError(t, SaveCommandsToUserCache(ctx, "k1", testCommandGroups()))
+		groups, err := LoadCommandsFromUserCache(ctx, "k2", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, groups)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		ctx := testCommandsContext(t)
+		require.NoError(t, SaveCommandsToUserCache(ctx, "k1", testCommandGroups()))
+		time.Sleep(10 * time.Millisecond)
+		groups, err := LoadCommandsFromUserCache(ctx, "k1", time.Millisecond)
+		require.NoError(t, err)
+		assert.Nil(t, groups)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		ctx := testCommandsContext(t)
+		require.NoError(t, SaveCommandsToUserCache(ctx, "k1", testCommandGroups()))
+		require.NoError(t, DeleteCommandsFromUserCache(ctx))
+		groups, err := LoadCommandsFromUserCache(ctx, "k1", time.Minute)
+		require.NoError(t, err)
+		assert.Nil(t, groups)
+
+		// Deleting a non-existing cache is a no-op
+		require.NoError(t, DeleteCommandsFromUserCache(ctx))
+	})
+}
@@ -0,0 +1,74 @@
+package cliutil
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// CommandDescriptor describes a command provided by the user daemon. Unlike the cobra commands that
+// RPCToCommands returns, it's a plain value with a stable JSON representation that frontends other
+// than the CLI can use.
+type CommandDescriptor struct {
+	Name           string           `json:"name"`
+	Group          string           `json:"group"`
+	ShortHelp      string           `json:"short_help,omitempty"`
+	LongHelp       string           `json:"long_help,omitempty"`
+	Flags          []FlagDescriptor `json:"flags,omitempty"`
+	ValidArgs      []string         `json:"valid_args,omitempty"`
+	DefaultTimeout time.Duration    `json:"default_timeout,omitempty"`
+	Idempotent     bool             `json:"idempotent,omitempty"`
+	EnvAllow       []string         `json:"env_allow,omitempty"`
+	EnvDeny        []string         `json:"env_deny,omitempty"`
+	Aliases        []string         `json:"aliases,omitempty"`
+}
+
+// FlagDescriptor describes a flag of a command provided by the user daemon. The Type is the pflag
+// type of the flag, e.g. "string", "bool", or "stringArray".
+type FlagDescriptor struct {
+	Name         string `json:"name"`
+	Shorthand    string `json:"shorthand,omitempty"`
+	Type         string `json:"type"`
+	Help         string `json:"help,omitempty"`
+	DefaultValue string `json:"default_value,omitempty"`
+}
+
+// Commands returns descriptors of the commands in the given result of a ListCommands call, sorted by
+// group and name.
+func Commands(remote *connector.CommandGroups) []CommandDescriptor {
+	cds := []CommandDescriptor{}
+	for group, cmds := range remote.GetCommandGroups() {
+		for _, cmd := range cmds.GetCommands() {
+			cd := CommandDescriptor{
+				Name:           cmd.GetName(),
+				Group:          group,
+				ShortHelp:      cmd.GetShortHelp(),
+				LongHelp:       cmd.GetLongHelp(),
+				ValidArgs:      cmd.GetValidArgs(),
+				DefaultTimeout: cmd.GetDefaultTimeout().AsDuration(),
+				Idempotent:     cmd.GetIdempotent(),
+				EnvAllow:       cmd.GetEnvAllow(),
+				EnvDeny:        cmd.GetEnvDeny(),
+				Aliases:        cmd.GetAliases(),
+			}
+			for _, f := range cmd.GetFlags() {
+				cd.Flags = append(cd.Flags, FlagDescriptor{
+					Name:         f.GetFlag(),
+					Shorthand:    f.GetShorthand(),
+					Type:         f.GetType(),
+					Help:         f.GetHelp(),
+					DefaultValue: f.GetDefaultValue(),
+				})
+			}
+			cds = append(cds, cd)
+		}
+	}
+	sort.Slice(cds, func(i, j int) bool {
+		if cds[i].Group != cds[j].Group {
+			return cds[i].Group < cds[j].Group
+		}
+		return cds[i].Name < cds[j].Name
+	})
+	return cds
+}
@@ -0,0 +1,61 @@
+package cliutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCommands(t *testing.T) {
+	intercept := &cobra.Command{
+		Use:         "intercept",
+		Short:       "Intercept a service",
+		Long:        "Intercept a service and route its traffic to the local machine",
+		Annotations: map[string]string{CommandDefaultTimeout: "2m"},
+	}
+	intercept.Flags().StringP("port", "p", "8080", "Local port")
+	intercept.Flags().Bool("preview-url", false, "Create a preview URL")
+	gather := &cobra.Command{
+		Use:       "gather-traces",
+		Short:     "Gather traces",
+		ValidArgs: []string{"all", "root", "user"},
+	}
+	list := &cobra.Command{Use: "list", Short: "List workloads"}
+
+	rpc := CommandsToRPC(CommandGroups{
+		"Traffic": []*cobra.Command{list, intercept},
+		"Debug":   []*cobra.Command{gather},
+	})
+	expected := []CommandDescriptor{
+		{
+			Name:      "gather-traces",
+			Group:     "Debug",
+			ShortHelp: "Gather traces",
+			ValidArgs: []string{"all", "root", "user"},
+		},
+		{
+			Name:      "intercept",
+			Group:     "Traffic",
+			ShortHelp: "Intercept a service",
+			LongHelp:  "Intercept a service and route its traffic to the local machine",
+			Flags: []FlagDescriptor{
+				{Name: "port", Shorthand: "p", Type: "string", Help: "Local port", DefaultValue: "8080"},
+				{Name: "preview-url", Type: "bool", Help: "Create a preview URL", DefaultValue: "false"},
+			},
+			DefaultTimeout: 2 * time.Minute,
+		},
+		{
+			Name:      "list",
+			Group:     "Traffic",
+			ShortHelp: "List workloads",
+		},
+	}
+	if cds := Commands(rpc); !reflect.DeepEqual(expected, cds) {
+		t.Errorf("expected descriptors\n%+v\nwas\n%+v", expected, cds)
+	}
+	if cds := Commands(nil); cds == nil || len(cds) != 0 {
+		t.Errorf("expected no descriptors, was %+v", cds)
+	}
+}
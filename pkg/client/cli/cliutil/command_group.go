@@ -7,6 +7,21 @@ import (
 
 type CommandGroups map[string][]*cobra.Command
 
+// Select returns the groups with the given names. Names that aren't known are ignored, and all groups are
+// returned when no names are given.
+func (cg CommandGroups) Select(names []string) CommandGroups {
+	if len(names) == 0 {
+		return cg
+	}
+	selected := make(CommandGroups, len(names))
+	for _, name := range names {
+		if g, ok := cg[name]; ok {
+			selected[name] = g
+		}
+	}
+	return selected
+}
+
 // FlagGroup represents a group of flags and the name of that group.
 type FlagGroup struct {
 	Name  string
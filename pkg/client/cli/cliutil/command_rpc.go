@@ -1,12 +1,93 @@
 package cliutil
 
 import (
+	"path"
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 )
 
+// CommandDefaultTimeout is the annotation that holds the default timeout of a command, e.g. "5m". The user
+// daemon advertises it with the command, and the client applies it unless the user overrides it.
+const CommandDefaultTimeout = "cobra.telepresence.io/default-timeout"
+
+// DefaultTimeout returns the default timeout of the given command, and false if it has none.
+func DefaultTimeout(cmd *cobra.Command) (time.Duration, bool) {
+	if v, ok := cmd.Annotations[CommandDefaultTimeout]; ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// CommandIdempotent is the annotation that marks a command as idempotent when its value is "true". Running an
+// idempotent command twice has the same effect as running it once, so the client may retry it.
+const CommandIdempotent = "cobra.telepresence.io/idempotent"
+
+// IsIdempotent returns true if the given command is annotated as idempotent.
+func IsIdempotent(cmd *cobra.Command) bool {
+	return cmd.Annotations[CommandIdempotent] == "true"
+}
+
+// CommandEnvAllow is the annotation that holds the comma separated patterns, e.g. "AWS_*", of the names of
+// the environment variables that a command accepts from the client. All names are accepted when it's absent.
+const CommandEnvAllow = "cobra.telepresence.io/env-allow"
+
+// CommandEnvDeny is the annotation that holds the comma separated patterns of the names of the environment
+// variables that a command rejects, even when they match CommandEnvAllow.
+const CommandEnvDeny = "cobra.telepresence.io/env-deny"
+
+// envPatterns returns the patterns of the given annotation of the command.
+func envPatterns(cmd *cobra.Command, annotation string) []string {
+	var ps []string
+	for _, p := range strings.Split(cmd.Annotations[annotation], ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// matchesAny returns true if the name matches one of the given path.Match patterns. A malformed pattern
+// matches nothing.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsEnv returns true if the given command accepts the environment variable with the given name, see
+// CommandEnvAllow and CommandEnvDeny.
+func AcceptsEnv(cmd *cobra.Command, name string) bool {
+	if matchesAny(name, envPatterns(cmd, CommandEnvDeny)) {
+		return false
+	}
+	allow := envPatterns(cmd, CommandEnvAllow)
+	return len(allow) == 0 || matchesAny(name, allow)
+}
+
+// CommandRemoteName is the annotation that holds the name that the user daemon knows a command by. It's
+// used by aliases and wrappers of a remote command that are invoked by a different name.
+const CommandRemoteName = "cobra.telepresence.io/remote-name"
+
+// RemoteName returns the name that the user daemon knows the given command by. It's the value of the
+// CommandRemoteName annotation if present, and the name that the command was called as otherwise.
+func RemoteName(cmd *cobra.Command) string {
+	if v := cmd.Annotations[CommandRemoteName]; v != "" {
+		return v
+	}
+	return cmd.CalledAs()
+}
+
 func CommandsToRPC(cmds CommandGroups) *connector.CommandGroups {
 	groups := make(map[string]*connector.CommandGroups_Commands)
 	for name, g := range cmds {
@@ -22,12 +103,21 @@ func CommandsToRPC(cmds CommandGroups) *connector.CommandGroups {
 					DefaultValue: f.Value.String(),
 				})
 			})
-			cmds = append(cmds, &connector.CommandGroups_Command{
-				Name:      cmd.Use,
-				LongHelp:  cmd.Long,
-				ShortHelp: cmd.Short,
-				Flags:     flags,
-			})
+			rpcCmd := &connector.CommandGroups_Command{
+				Name:       cmd.Use,
+				LongHelp:   cmd.Long,
+				ShortHelp:  cmd.Short,
+				Flags:      flags,
+				ValidArgs:  cmd.ValidArgs,
+				Idempotent: IsIdempotent(cmd),
+				EnvAllow:   envPatterns(cmd, CommandEnvAllow),
+				EnvDeny:    envPatterns(cmd, CommandEnvDeny),
+				Aliases:    cmd.Aliases,
+			}
+			if d, ok := DefaultTimeout(cmd); ok {
+				rpcCmd.DefaultTimeout = durationpb.New(d)
+			}
+			cmds = append(cmds, rpcCmd)
 		}
 		groups[name] = &connector.CommandGroups_Commands{Commands: cmds}
 	}
@@ -49,12 +139,37 @@ func RPCToCommands(remote *connector.CommandGroups, funcBundle CommandFuncBundle
 				Use:                cmd.GetName(),
 				Long:               cmd.GetLongHelp(),
 				Short:              cmd.GetShortHelp(),
+				Aliases:            cmd.GetAliases(),
 				RunE:               funcBundle.RunE,
 				DisableFlagParsing: true,
 				SilenceUsage:       true,
 				SilenceErrors:      true,
 				ValidArgsFunction:  funcBundle.ValidArgsFunction,
 			}
+			if dt := cmd.GetDefaultTimeout(); dt != nil {
+				cobraCmd.Annotations = map[string]string{CommandDefaultTimeout: dt.AsDuration().String()}
+			}
+			if cmd.GetIdempotent() {
+				if cobraCmd.Annotations == nil {
+					cobraCmd.Annotations = make(map[string]string)
+				}
+				cobraCmd.Annotations[CommandIdempotent] = "true"
+			}
+			if len(cobraCmd.Aliases) > 0 {
+				// The user daemon knows the command by its name only
+				if cobraCmd.Annotations == nil {
+					cobraCmd.Annotations = make(map[string]string)
+				}
+				cobraCmd.Annotations[CommandRemoteName] = cobraCmd.Name()
+			}
+			for an, ps := range map[string][]string{CommandEnvAllow: cmd.GetEnvAllow(), CommandEnvDeny: cmd.GetEnvDeny()} {
+				if len(ps) > 0 {
+					if cobraCmd.Annotations == nil {
+						cobraCmd.Annotations = make(map[string]string)
+					}
+					cobraCmd.Annotations[an] = strings.Join(ps, ",")
+				}
+			}
 			for _, flag := range cmd.GetFlags() {
 				tp, err := TypeFromString(flag.GetType())
 				if err != nil {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -418,3 +419,189 @@ func TestToFromRPC(t *testing.T) { //nolint:gocognit,gocyclo // it's a long list
 		t.Error(err)
 	}
 }
+
+func TestToFromRPC_defaultTimeout(t *testing.T) {
+	withTimeout := &cobra.Command{
+		Use:         "slow-command",
+		Annotations: map[string]string{CommandDefaultTimeout: "5m"},
+	}
+	withoutTimeout := &cobra.Command{Use: "fast-command"}
+	rpc := CommandsToRPC(CommandGroups{"Test": []*cobra.Command{withTimeout, withoutTimeout}})
+	grp, err := RPCToCommands(rpc, CommandFuncBundle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range grp["Test"] {
+		d, ok := DefaultTimeout(cmd)
+		switch cmd.Use {
+		case "slow-command":
+			if !ok || d != 5*time.Minute {
+				t.Errorf("expected default timeout of %s to be %s, was %s", cmd.Use, 5*time.Minute, d)
+			}
+		case "fast-command":
+			if ok {
+				t.Errorf("expected %s to have no default timeout, was %s", cmd.Use, d)
+			}
+		}
+	}
+}
+
+func TestRemoteName(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		annotations map[string]string
+		want        string
+	}{
+		{"name", []string{"intercept"}, nil, "intercept"},
+		{"alias", []string{"ic"}, nil, "ic"},
+		{"alias with remote name", []string{"ic"}, map[string]string{CommandRemoteName: "intercept"}, "intercept"},
+		{"name with remote name", []string{"intercept"}, map[string]string{CommandRemoteName: "intercept"}, "intercept"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			cmd := &cobra.Command{
+				Use:         "intercept",
+				Aliases:     []string{"ic"},
+				Annotations: tt.annotations,
+				RunE: func(cmd *cobra.Command, _ []string) error {
+					got = RemoteName(cmd)
+					return nil
+				},
+			}
+			root := &cobra.Command{Use: "telepresence"}
+			root.AddCommand(cmd)
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected remote name %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCommandGroups_Select(t *testing.T) {
+	cgs := CommandGroups{
+		"net":       {{Use: "ping"}, {Use: "trace"}},
+		"intercept": {{Use: "intercept"}, {Use: "leave"}, {Use: "list"}},
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		want   []string
+	}{
+		{"all", nil, []string{"intercept", "net"}},
+		{"one", []string{"net"}, []string{"net"}},
+		{"unknown", []string{"nope"}, nil},
+		{"some unknown", []string{"nope", "intercept"}, []string{"intercept"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rpc := CommandsToRPC(cgs.Select(tt.groups))
+			var got []string
+			for name, g := range rpc.CommandGroups {
+				got = append(got, name)
+				if len(g.Commands) != len(cgs[name]) {
+					t.Errorf("expected %d commands in group %s, got %d", len(cgs[name]), name, len(g.Commands))
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected groups %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestToFromRPC_idempotent(t *testing.T) {
+	idempotent := &cobra.Command{
+		Use:         "read-command",
+		Annotations: map[string]string{CommandIdempotent: "true", CommandDefaultTimeout: "5m"},
+	}
+	mutating := &cobra.Command{Use: "write-command"}
+	rpc := CommandsToRPC(CommandGroups{"Test": []*cobra.Command{idempotent, mutating}})
+	grp, err := RPCToCommands(rpc, CommandFuncBundle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range grp["Test"] {
+		switch cmd.Use {
+		case "read-command":
+			if !IsIdempotent(cmd) {
+				t.Errorf("expected %s to be idempotent", cmd.Use)
+			}
+			if _, ok := DefaultTimeout(cmd); !ok {
+				t.Errorf("expected %s to keep its default timeout", cmd.Use)
+			}
+		case "write-command":
+			if IsIdempotent(cmd) {
+				t.Errorf("expected %s not to be idempotent", cmd.Use)
+			}
+		}
+	}
+	for _, cd := range Commands(rpc) {
+		if cd.Idempotent != (cd.Name == "read-command") {
+			t.Errorf("unexpected idempotent %t of descriptor %s", cd.Idempotent, cd.Name)
+		}
+	}
+}
+
+func TestToFromRPC_env(t *testing.T) {
+	restricted := &cobra.Command{
+		Use:         "restricted",
+		Annotations: map[string]string{CommandEnvAllow: "KUBECONFIG,AWS_*", CommandEnvDeny: "AWS_SECRET_ACCESS_KEY"},
+	}
+	open := &cobra.Command{Use: "open"}
+	rpc := CommandsToRPC(CommandGroups{"Test": []*cobra.Command{restricted, open}})
+	grp, err := RPCToCommands(rpc, CommandFuncBundle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range grp["Test"] {
+		for name, want := range map[string]bool{
+			"KUBECONFIG":            true,
+			"AWS_PROFILE":           true,
+			"AWS_SECRET_ACCESS_KEY": cmd.Use == "open",
+			"GITHUB_TOKEN":          cmd.Use == "open",
+		} {
+			if got := AcceptsEnv(cmd, name); got != want {
+				t.Errorf("expected AcceptsEnv(%s, %s) to be %t, got %t", cmd.Use, name, want, got)
+			}
+		}
+	}
+	for _, cd := range Commands(rpc) {
+		if cd.Name == "restricted" && (len(cd.EnvAllow) != 2 || len(cd.EnvDeny) != 1) {
+			t.Errorf("unexpected env patterns %v and %v of descriptor %s", cd.EnvAllow, cd.EnvDeny, cd.Name)
+		}
+	}
+}
+
+func TestToFromRPC_aliases(t *testing.T) {
+	aliased := &cobra.Command{Use: "intercept [flags] <name>", Aliases: []string{"ic"}}
+	plain := &cobra.Command{Use: "list"}
+	rpc := CommandsToRPC(CommandGroups{"Test": []*cobra.Command{aliased, plain}})
+	grp, err := RPCToCommands(rpc, CommandFuncBundle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range grp["Test"] {
+		switch cmd.Name() {
+		case "intercept":
+			if !reflect.DeepEqual(cmd.Aliases, []string{"ic"}) {
+				t.Errorf("expected %s to have aliases [ic], got %v", cmd.Name(), cmd.Aliases)
+			}
+			if rn := cmd.Annotations[CommandRemoteName]; rn != "intercept" {
+				t.Errorf("expected %s to have remote name intercept, got %q", cmd.Name(), rn)
+			}
+		case "list":
+			if _, ok := cmd.Annotations[CommandRemoteName]; ok {
+				t.Errorf("expected %s to have no remote name", cmd.Name())
+			}
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
@@ -48,7 +49,12 @@ func launchDaemon(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return proc.StartInBackgroundAsRoot(ctx, client.GetExe(), "daemon-foreground", logDir, configDir)
+	args := []string{client.GetExe(), "daemon-foreground", logDir, configDir}
+	if format := os.Getenv(logging.FormatEnv); format != "" {
+		// The environment isn't passed on when the daemon is started with elevated privileges
+		args = append(args, "--log-format", format)
+	}
+	return proc.StartInBackgroundAsRoot(ctx, args...)
 }
 
 // EnsureRootDaemonRunning ensures that the daemon is running.
@@ -146,10 +146,10 @@ func Command(ctx context.Context) *cobra.Command {
 
 	static := cliutil.CommandGroups{
 		"Session Commands": []*cobra.Command{connectCommand(), LoginCommand(), LogoutCommand(), LicenseCommand(), statusCommand(), quitCommand()},
-		"Traffic Commands": []*cobra.Command{listCommand(), leaveCommand(), previewCommand()},
+		"Traffic Commands": []*cobra.Command{listCommand(), leaveCommand(), previewCommand(), execCommand()},
 		"Install Commands": []*cobra.Command{helmCommand(), uninstallCommand()},
-		"Debug Commands":   []*cobra.Command{loglevelCommand(), gatherLogsCommand()},
-		"Other Commands":   []*cobra.Command{versionCommand(), dashboardCommand(), ClusterIdCommand(), genYAMLCommand(), vpnDiagCommand()},
+		"Debug Commands":   []*cobra.Command{loglevelCommand(), gatherLogsCommand(), daemonLogsCommand(), daemonPingCommand(), daemonWaitCommand(), daemonConfigCommand(), daemonLogLevelCommand(), supportBundleCommand(), dnsWatchCommand()},
+		"Other Commands":   []*cobra.Command{versionCommand(), commandsCommand(), dashboardCommand(), ClusterIdCommand(), genYAMLCommand(), vpnDiagCommand()},
 	}
 
 	groups := make(cliutil.CommandGroups)
@@ -180,7 +180,8 @@ func Command(ctx context.Context) *cobra.Command {
 						}
 					}
 				}
-			} else if err != nil {
+			} else if err != nil && !IsCommand("commands") {
+				// The "commands" command reports the error
 				fmt.Fprintln(os.Stderr, err.Error())
 				os.Exit(1)
 			}
@@ -279,6 +280,10 @@ func GlobalFlagGroups() []cliutil.FlagGroup {
 				"output", "default",
 				"set the output format, supported values are 'json' and 'default'",
 			)
+			flags.Bool(
+				"refresh-commands", false,
+				"bypass the cached list of commands provided by the user daemon",
+			)
 			return flags
 		}(),
 	}}
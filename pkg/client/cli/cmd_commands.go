@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/ann"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
+)
+
+func commandsCommand() *cobra.Command {
+	debug := false
+	running := false
+	cancelID := ""
+	attachID := ""
+	var groups []string
+	cmd := &cobra.Command{
+		Use:  "commands",
+		Args: cobra.NoArgs,
+
+		Short: "List the commands provided by the user daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if cancelID != "" {
+				return cancelRunningCommand(cmd, cancelID)
+			}
+			if attachID != "" {
+				return attachDetachedCommand(cmd, attachID)
+			}
+			if running {
+				return listRunningCommands(cmd)
+			}
+			if len(groups) > 0 {
+				return printRemoteCommandGroups(cmd, groups)
+			}
+			return printRemoteCommands(cmd, debug)
+		},
+		Annotations: map[string]string{
+			ann.UserDaemon: ann.Optional,
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&debug, "debug", false, "show why the commands provided by the user daemon are unavailable")
+	flags.BoolVar(&running, "running", false, "list the commands that the user daemon is currently running")
+	flags.StringVar(&cancelID, "cancel", "", "cancel the running command with the given id (see --running)")
+	flags.StringVar(&attachID, "attach", "", "attach to the detached command with the given id (see --detach)")
+	flags.StringSliceVar(&groups, "group", nil, "only list the commands in the given groups")
+	cmd.MarkFlagsMutuallyExclusive("running", "cancel", "attach", "group")
+	return cmd
+}
+
+// printRemoteCommands prints the commands that were obtained from the user daemon when the CLI started, or
+// a note saying that they are unavailable. The underlying error is included when debug is true.
+func printRemoteCommands(cmd *cobra.Command, debug bool) error {
+	out := cmd.OutOrStdout()
+	if output.WantsJSONOutput(cmd.Flags()) {
+		if remoteCommandsErr != nil {
+			return remoteCommandsErr
+		}
+		return printCommandDescriptors(out, remoteCommandsRPC)
+	}
+	if remoteCommandsErr != nil {
+		if debug {
+			fmt.Fprintf(out, "The commands provided by the user daemon are unavailable: %v\n", remoteCommandsErr)
+		} else {
+			fmt.Fprintln(out, "The commands provided by the user daemon are unavailable. Use --debug for details.")
+		}
+		return nil
+	}
+	if len(remoteCommands) == 0 {
+		fmt.Fprintln(out, "The user daemon provides no commands.")
+		return nil
+	}
+	printCommandGroups(out, remoteCommands)
+	return nil
+}
+
+// printRemoteCommandGroups fetches the commands in the given groups from the user daemon and prints them.
+func printRemoteCommandGroups(cmd *cobra.Command, groups []string) error {
+	if err := cliutil.InitCommand(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	userD := cliutil.GetUserDaemon(ctx)
+	if userD == nil {
+		return cliutil.ErrNoUserDaemon
+	}
+	remote, err := userD.ListCommands(ctx, &connector.ListCommandsRequest{Groups: groups})
+	if err != nil {
+		return fmt.Errorf("unable to call ListCommands: %w", err)
+	}
+	out := cmd.OutOrStdout()
+	if output.WantsJSONOutput(cmd.Flags()) {
+		return printCommandDescriptors(out, remote)
+	}
+	cgs, err := cliutil.RPCToCommands(remote, cliutil.CommandFuncBundle{})
+	if err != nil {
+		return err
+	}
+	if len(cgs) == 0 {
+		what := "group"
+		if len(groups) > 1 {
+			what = "groups"
+		}
+		fmt.Fprintf(out, "The user daemon provides no commands in the %s %s.\n", what, strings.Join(groups, ", "))
+		return nil
+	}
+	printCommandGroups(out, cgs)
+	return nil
+}
+
+// printCommandGroups prints the names and short descriptions of the given commands, by group.
+func printCommandGroups(out io.Writer, cgs cliutil.CommandGroups) {
+	names := make([]string, 0, len(cgs))
+	for name := range cgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s:\n", name)
+		for _, c := range cgs[name] {
+			fmt.Fprintf(out, "  %-20s %s\n", c.Name(), c.Short)
+		}
+	}
+}
+
+// printCommandDescriptors prints the descriptors of the given commands as JSON.
+func printCommandDescriptors(out io.Writer, remote *connector.CommandGroups) error {
+	cds := cliutil.Commands(remote)
+	if so, ok := out.(output.StructuredStreamer); ok {
+		so.StructuredStream(cds, nil)
+		return nil
+	}
+	return json.NewEncoder(out).Encode(cds)
+}
+
+// listRunningCommands prints the commands that the user daemon is currently running.
+func listRunningCommands(cmd *cobra.Command) error {
+	if err := cliutil.InitCommand(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	userD := cliutil.GetUserDaemon(ctx)
+	if userD == nil {
+		return cliutil.ErrNoUserDaemon
+	}
+	rcl, err := userD.RunningCommands(ctx, &empty.Empty{})
+	if err != nil {
+		return err
+	}
+	return printRunningCommands(cmd.OutOrStdout(), rcl.Commands, output.WantsJSONOutput(cmd.Flags()))
+}
+
+// cancelRunningCommand cancels the command with the given id that the user daemon is currently running.
+func cancelRunningCommand(cmd *cobra.Command, id string) error {
+	if err := cliutil.InitCommand(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	userD := cliutil.GetUserDaemon(ctx)
+	if userD == nil {
+		return cliutil.ErrNoUserDaemon
+	}
+	if _, err := userD.CancelCommand(ctx, &connector.CancelCommandRequest{Id: id}); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return errcat.User.Newf("the user daemon is not running a command with id %s", id)
+		}
+		return err
+	}
+	return nil
+}
+
+// attachDetachedCommand attaches to the detached command with the given id. The output that the command has
+// produced since it was detached is written first, and the command is then treated as if it had been started
+// by this client, except that no stdin is forwarded to it.
+func attachDetachedCommand(cmd *cobra.Command, id string) error {
+	if err := cliutil.InitCommand(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	userD := cliutil.GetUserDaemon(ctx)
+	if userD == nil {
+		return cliutil.ErrNoUserDaemon
+	}
+	rc := RemoteCommand{
+		AttachID:         id,
+		Stdout:           cmd.OutOrStdout(),
+		Stderr:           cmd.ErrOrStderr(),
+		HandleInterrupts: true,
+	}
+	return rc.Run(ctx, userD)
+}
+
+// runningCommand is the JSON representation of a connector.RunningCommand.
+type runningCommand struct {
+	ID        string    `json:"id"`
+	Args      []string  `json:"args"`
+	Cwd       string    `json:"cwd"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// printRunningCommands prints the given commands as a table, or as JSON when jsonOut is true.
+func printRunningCommands(out io.Writer, cmds []*connector.RunningCommand, jsonOut bool) error {
+	if jsonOut {
+		rcs := make([]runningCommand, len(cmds))
+		for i, c := range cmds {
+			rcs[i] = runningCommand{ID: c.Id, Args: c.OsArgs, Cwd: c.Cwd, StartTime: c.StartTime.AsTime()}
+		}
+		if so, ok := out.(output.StructuredStreamer); ok {
+			so.StructuredStream(rcs, nil)
+			return nil
+		}
+		return json.NewEncoder(out).Encode(rcs)
+	}
+	if len(cmds) == 0 {
+		fmt.Fprintln(out, "The user daemon is not running any commands.")
+		return nil
+	}
+	idLen, cwdLen := len("ID"), len("CWD")
+	for _, c := range cmds {
+		if l := len(c.Id); l > idLen {
+			idLen = l
+		}
+		if l := len(c.Cwd); l > cwdLen {
+			cwdLen = l
+		}
+	}
+	const timeFormat = "2006-01-02 15:04:05"
+	fmt.Fprintf(out, "%-*s  %-*s  %-*s  %s\n", idLen, "ID", len(timeFormat), "STARTED", cwdLen, "CWD", "COMMAND")
+	for _, c := range cmds {
+		fmt.Fprintf(out, "%-*s  %s  %-*s  %s\n", idLen, c.Id, c.StartTime.AsTime().Local().Format(timeFormat), cwdLen, c.Cwd, shellquote.ShellArgsString(c.OsArgs))
+	}
+	return nil
+}
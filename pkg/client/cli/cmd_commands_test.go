@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func TestPrintRunningCommands(t *testing.T) {
+	started := time.Date(2022, 11, 1, 10, 30, 0, 0, time.UTC)
+	cmds := []*connector.RunningCommand{
+		{Id: "1", OsArgs: []string{"intercept", "echo", "--", "sh", "-c", "sleep 10"}, Cwd: "/home/me", StartTime: timestamppb.New(started)},
+		{Id: "12", OsArgs: []string{"list"}, Cwd: "/tmp", StartTime: timestamppb.New(started.Add(time.Minute))},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, printRunningCommands(&buf, cmds, false))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "ID  STARTED"))
+	assert.Contains(t, lines[1], "/home/me")
+	assert.Contains(t, lines[1], "intercept echo -- sh -c")
+	assert.Contains(t, lines[1], "sleep 10")
+	assert.True(t, strings.HasPrefix(lines[2], "12  "))
+	assert.Contains(t, lines[2], started.Add(time.Minute).Local().Format("2006-01-02 15:04:05"))
+
+	buf.Reset()
+	require.NoError(t, printRunningCommands(&buf, cmds, true))
+	var rcs []runningCommand
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rcs))
+	require.Len(t, rcs, 2)
+	assert.Equal(t, "1", rcs[0].ID)
+	assert.Equal(t, cmds[0].OsArgs, rcs[0].Args)
+	assert.Equal(t, "/home/me", rcs[0].Cwd)
+	assert.True(t, started.Equal(rcs[0].StartTime))
+
+	buf.Reset()
+	require.NoError(t, printRunningCommands(&buf, nil, false))
+	assert.Equal(t, "The user daemon is not running any commands.\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, printRunningCommands(&buf, nil, true))
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestPrintCommandDescriptors(t *testing.T) {
+	remote := &connector.CommandGroups{CommandGroups: map[string]*connector.CommandGroups_Commands{
+		"Traffic": {Commands: []*connector.CommandGroups_Command{{
+			Name:      "intercept",
+			ShortHelp: "Intercept a service",
+			Flags:     []*connector.CommandGroups_Flag{{Type: "string", Flag: "port", DefaultValue: "8080"}},
+		}}},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, printCommandDescriptors(&buf, remote))
+	var cds []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &cds))
+	require.Len(t, cds, 1)
+	assert.Equal(t, "intercept", cds[0]["name"])
+	assert.Equal(t, "Traffic", cds[0]["group"])
+	assert.Equal(t, "Intercept a service", cds[0]["short_help"])
+	assert.Equal(t, []any{map[string]any{"name": "port", "type": "string", "default_value": "8080"}}, cds[0]["flags"])
+
+	buf.Reset()
+	require.NoError(t, printCommandDescriptors(&buf, nil))
+	assert.Equal(t, "[]\n", buf.String())
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/spf13/cobra"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func daemonConfigCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "daemon-config",
+		Args: cobra.NoArgs,
+
+		Short: "Print the configuration that the root daemon is running with",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+			dc, err := daemon.NewDaemonClient(conn).GetConfig(ctx, &empty.Empty{})
+			if err != nil {
+				return err
+			}
+			printDaemonConfig(cmd.OutOrStdout(), dc)
+			return nil
+		},
+	}
+}
+
+// printDaemonConfig prints the given configuration of the root daemon.
+func printDaemonConfig(out io.Writer, dc *daemon.DaemonConfig) {
+	fmt.Fprintf(out, "Socket          : %s\n", dc.SocketName)
+	fmt.Fprintf(out, "Connector socket: %s\n", dc.ConnectorSocketName)
+	fmt.Fprintf(out, "Log file        : %s\n", dc.LogFile)
+	if dns := dc.Dns; dns != nil {
+		fmt.Fprintln(out, "DNS             :")
+		if len(dns.LocalIp) > 0 {
+			fmt.Fprintf(out, "  Local IP        : %v\n", net.IP(dns.LocalIp))
+		}
+		if len(dns.FallbackIps) > 0 {
+			ips := make([]net.IP, len(dns.FallbackIps))
+			for i, ip := range dns.FallbackIps {
+				ips[i] = ip
+			}
+			fmt.Fprintf(out, "  Fallback IPs    : %v\n", ips)
+		}
+		fmt.Fprintf(out, "  Remote IP       : %v\n", net.IP(dns.RemoteIp))
+		fmt.Fprintf(out, "  Exclude suffixes: %v\n", dns.ExcludeSuffixes)
+		fmt.Fprintf(out, "  Include suffixes: %v\n", dns.IncludeSuffixes)
+		fmt.Fprintf(out, "  Timeout         : %v\n", dns.LookupTimeout.AsDuration())
+	} else {
+		fmt.Fprintln(out, "DNS             : not configured, the daemon has no session")
+	}
+	names := make([]string, 0, len(dc.Timeouts))
+	for name := range dc.Timeouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(out, "Timeouts        :")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-22s: %v\n", name, dc.Timeouts[name].AsDuration())
+	}
+}
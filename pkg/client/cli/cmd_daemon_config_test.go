@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+func Test_printDaemonConfig(t *testing.T) {
+	dc := &daemon.DaemonConfig{
+		SocketName:          "/run/daemon.socket",
+		ConnectorSocketName: "/run/connector.socket",
+		LogFile:             "/logs/daemon.log",
+		Timeouts: map[string]*durationpb.Duration{
+			"helm":  durationpb.New(42 * time.Second),
+			"apply": durationpb.New(time.Minute),
+		},
+	}
+	var out bytes.Buffer
+	printDaemonConfig(&out, dc)
+	assert.Equal(t, `Socket          : /run/daemon.socket
+Connector socket: /run/connector.socket
+Log file        : /logs/daemon.log
+DNS             : not configured, the daemon has no session
+Timeouts        :
+  apply                 : 1m0s
+  helm                  : 42s
+`, out.String())
+
+	dc.Dns = &daemon.DNSConfig{
+		LocalIp:       net.IP{192, 168, 1, 1},
+		FallbackIps:   [][]byte{net.IP{8, 8, 8, 8}, net.IP{1, 1, 1, 1}},
+		LookupTimeout: durationpb.New(4 * time.Second),
+	}
+	out.Reset()
+	printDaemonConfig(&out, dc)
+	assert.Contains(t, out.String(), "  Local IP        : 192.168.1.1\n")
+	assert.Contains(t, out.String(), "  Fallback IPs    : [8.8.8.8 1.1.1.1]\n")
+	assert.Contains(t, out.String(), "  Timeout         : 4s\n")
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func daemonLogLevelCommand() *cobra.Command {
+	lvStrs := logLevelNames()
+	duration := time.Duration(0)
+	cmd := &cobra.Command{
+		Use:       fmt.Sprintf("daemon-loglevel <%s>", strings.Join(lvStrs, ",")),
+		Args:      logLevelArg,
+		ValidArgs: lvStrs,
+
+		Short: "Temporarily change the log-level of the root daemon, without the need for a connection to a cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+			_, err = daemon.NewDaemonClient(conn).SetLogLevel(ctx, &manager.LogLevelRequest{LogLevel: args[0], Duration: durationpb.New(duration)})
+			return err
+		},
+	}
+	cmd.Flags().DurationVarP(&duration, "duration", "d", defaultDuration, "The time that the log-level will be in effect (0s means indefinitely)")
+	return cmd
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func daemonLogsCommand() *cobra.Command {
+	rq := daemon.TailLogRequest{}
+	cmd := &cobra.Command{
+		Use:  "daemon-logs",
+		Args: cobra.NoArgs,
+
+		Short: "Show the log of the root daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+			return printDaemonLog(ctx, daemon.NewDaemonClient(conn), &rq, cmd.OutOrStdout())
+		},
+	}
+	flags := cmd.Flags()
+	flags.Int32VarP(&rq.Lines, "lines", "n", 10, "number of lines to show from the end of the log, or all lines when negative")
+	flags.BoolVarP(&rq.Follow, "follow", "f", false, "show new lines as they are added to the log")
+	return cmd
+}
+
+// printDaemonLog prints the lines streamed by the daemon's TailLog until the stream ends or the context is cancelled.
+func printDaemonLog(ctx context.Context, d daemon.DaemonClient, rq *daemon.TailLogRequest, out io.Writer) error {
+	stream, err := d.TailLog(ctx, rq)
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF || ctx.Err() != nil && status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		}
+		if _, err = fmt.Fprintln(out, line.Text); err != nil {
+			return err
+		}
+	}
+}
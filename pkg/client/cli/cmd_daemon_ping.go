@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func daemonPingCommand() *cobra.Command {
+	count := 0
+	interval := time.Duration(0)
+	cmd := &cobra.Command{
+		Use:  "daemon-ping",
+		Args: cobra.NoArgs,
+
+		Short: "Measure the round-trip time of calls to the root daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if count < 1 {
+				return errcat.User.New("the --count must be at least 1")
+			}
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+			return pingDaemon(ctx, daemon.NewDaemonClient(conn), count, interval, cmd.OutOrStdout())
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVarP(&count, "count", "c", 4, "number of pings to send")
+	flags.DurationVarP(&interval, "interval", "i", time.Second, "time to wait between pings")
+	return cmd
+}
+
+// pingDaemon pings the daemon count times and prints the round-trip time of each ping, followed by a summary
+// when more than one ping was sent.
+func pingDaemon(ctx context.Context, d daemon.DaemonClient, count int, interval time.Duration, out io.Writer) error {
+	var minRTT, maxRTT, totalRTT time.Duration
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+		}
+		rtt, err := pingOnce(ctx, d)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reply from root daemon: seq=%d time=%s\n", seq, rtt)
+		if seq == 1 || rtt < minRTT {
+			minRTT = rtt
+		}
+		if rtt > maxRTT {
+			maxRTT = rtt
+		}
+		totalRTT += rtt
+	}
+	if count > 1 {
+		fmt.Fprintf(out, "round-trip min/avg/max = %s/%s/%s\n", minRTT, totalRTT/time.Duration(count), maxRTT)
+	}
+	return nil
+}
+
+// pingOnce sends a ping to the daemon and returns the time it took to receive its response.
+func pingOnce(ctx context.Context, d daemon.DaemonClient) (time.Duration, error) {
+	nonce := rand.Uint64() //nolint:gosec // the nonce only matches the response with its request
+	sent := time.Now()
+	rsp, err := d.Ping(ctx, &daemon.PingRequest{Nonce: nonce, Sent: timestamppb.New(sent)})
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(sent)
+	if rsp.Nonce != nonce {
+		return 0, fmt.Errorf("the daemon responded with nonce %d to a ping with nonce %d", rsp.Nonce, nonce)
+	}
+	return rtt, nil
+}
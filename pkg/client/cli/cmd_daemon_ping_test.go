@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// pingDaemonClient is a daemon.DaemonClient that only implements Ping. Each ping is delayed by delay and
+// its nonce is changed by nonceDelta.
+type pingDaemonClient struct {
+	daemon.DaemonClient
+	delay      time.Duration
+	nonceDelta uint64
+	requests   []*daemon.PingRequest
+}
+
+func (d *pingDaemonClient) Ping(_ context.Context, rq *daemon.PingRequest, _ ...grpc.CallOption) (*daemon.PingResponse, error) {
+	d.requests = append(d.requests, rq)
+	time.Sleep(d.delay)
+	return &daemon.PingResponse{Nonce: rq.Nonce + d.nonceDelta, Sent: rq.Sent, Received: timestamppb.Now()}, nil
+}
+
+func Test_pingDaemon(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &pingDaemonClient{delay: 20 * time.Millisecond}
+	var out bytes.Buffer
+	require.NoError(t, pingDaemon(ctx, d, 3, time.Millisecond, &out))
+
+	require.Len(t, d.requests, 3)
+	assert.NotEqual(t, d.requests[0].Nonce, d.requests[1].Nonce)
+	assert.NotNil(t, d.requests[0].Sent)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	for i, line := range lines[:3] {
+		var seq int
+		var rtt string
+		_, err := fmt.Sscanf(line, "reply from root daemon: seq=%d time=%s", &seq, &rtt)
+		require.NoError(t, err, line)
+		assert.Equal(t, i+1, seq)
+		dur, err := time.ParseDuration(rtt)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, dur, d.delay)
+	}
+	assert.True(t, strings.HasPrefix(lines[3], "round-trip min/avg/max = "), lines[3])
+}
+
+func Test_pingDaemon_nonceMismatch(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var out bytes.Buffer
+	err := pingDaemon(ctx, &pingDaemonClient{nonceDelta: 1}, 1, 0, &out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nonce")
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// daemonWaitStates are the states that daemon-wait can wait for, keyed by the value of its --for flag.
+var daemonWaitStates = map[string]func(client.DaemonState) bool{
+	"running": func(s client.DaemonState) bool {
+		return s != client.DaemonNotRunning && s != client.DaemonStateUnknown
+	},
+	"not-running": func(s client.DaemonState) bool { return s == client.DaemonNotRunning },
+	"idle":        func(s client.DaemonState) bool { return s == client.DaemonIdle },
+	"connected":   func(s client.DaemonState) bool { return s == client.DaemonConnected },
+}
+
+// DaemonWaitBackoff is the delay between the first two polls of daemon-wait. The delay is doubled for each
+// subsequent poll, up to DaemonWaitMaxBackoff.
+var DaemonWaitBackoff = 100 * time.Millisecond
+
+// DaemonWaitMaxBackoff is the maximum delay between two polls of daemon-wait.
+var DaemonWaitMaxBackoff = 2 * time.Second
+
+func daemonWaitCommand() *cobra.Command {
+	var state string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:  "daemon-wait",
+		Args: cobra.NoArgs,
+
+		Short: "Wait until the root daemon reaches the given state",
+		Long: `Wait until the root daemon reaches the given state. The state is one of 'running', 'not-running',
+'idle' (running without a session), or 'connected' (running with a session whose network is healthy). The
+command exits with 124 if the state isn't reached within the timeout.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			reached, ok := daemonWaitStates[strings.ToLower(state)]
+			if !ok {
+				return errcat.User.Newf("invalid --for %q, must be 'running', 'not-running', 'idle', or 'connected'", state)
+			}
+			ctx := cmd.Context()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			return waitForDaemonState(ctx, client.GetDaemonState, reached, state)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&state, "for", "connected", "the state to wait for: 'running', 'not-running', 'idle', or 'connected'")
+	flags.DurationVar(&timeout, "timeout", 30*time.Second, "the maximum time to wait. Zero means no limit")
+	return cmd
+}
+
+// waitForDaemonState polls the state of the root daemon, with backoff, until it's reached or the context is
+// done. Errors from getState are retried, because they're expected while the daemon is starting. A
+// Timeout error that describes the last state is returned when the context's deadline expires.
+func waitForDaemonState(
+	ctx context.Context,
+	getState func(context.Context) (client.DaemonState, string, error),
+	reached func(client.DaemonState) bool,
+	name string,
+) error {
+	backoff := DaemonWaitBackoff
+	for {
+		state, detail, err := getState(ctx)
+		switch {
+		case err != nil:
+			dlog.Debugf(ctx, "unable to get the state of the root daemon: %v", err)
+		case reached(state):
+			return nil
+		default:
+			dlog.Debugf(ctx, "root daemon is %s %s", state, detail)
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return ctx.Err()
+			}
+			if err != nil {
+				return errcat.Timeout.Newf("the root daemon didn't become %s in time: %v", name, err)
+			}
+			if detail != "" {
+				return errcat.Timeout.Newf("the root daemon didn't become %s in time, it's %s: %s", name, state, detail)
+			}
+			return errcat.Timeout.Newf("the root daemon didn't become %s in time, it's %s", name, state)
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > DaemonWaitMaxBackoff {
+			backoff = DaemonWaitMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// delayedDaemon returns the state of a root daemon that starts after startDelay, and that connects after
+// connectDelay. The state can't be determined while the daemon is starting.
+func delayedDaemon(startDelay, connectDelay time.Duration) (func(context.Context) (client.DaemonState, string, error), *int32) {
+	var polls int32
+	start := time.Now()
+	return func(context.Context) (client.DaemonState, string, error) {
+		atomic.AddInt32(&polls, 1)
+		switch elapsed := time.Since(start); {
+		case elapsed < startDelay/2:
+			return client.DaemonNotRunning, "", nil
+		case elapsed < startDelay:
+			return client.DaemonStateUnknown, "", errors.New("connection refused")
+		case elapsed < connectDelay:
+			return client.DaemonIdle, "", nil
+		default:
+			return client.DaemonConnected, "abc123", nil
+		}
+	}, &polls
+}
+
+func Test_waitForDaemonState(t *testing.T) {
+	defer func(b, m time.Duration) { DaemonWaitBackoff, DaemonWaitMaxBackoff = b, m }(DaemonWaitBackoff, DaemonWaitMaxBackoff)
+	DaemonWaitBackoff = time.Millisecond
+	DaemonWaitMaxBackoff = 10 * time.Millisecond
+
+	ctx := dlog.NewTestContext(t, false)
+	getState, polls := delayedDaemon(50*time.Millisecond, 100*time.Millisecond)
+	start := time.Now()
+	require.NoError(t, waitForDaemonState(ctx, getState, daemonWaitStates["connected"], "connected"))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Greater(t, atomic.LoadInt32(polls), int32(2))
+
+	getState, _ = delayedDaemon(50*time.Millisecond, time.Hour)
+	require.NoError(t, waitForDaemonState(ctx, getState, daemonWaitStates["running"], "running"))
+}
+
+func Test_waitForDaemonState_timeout(t *testing.T) {
+	defer func(b time.Duration) { DaemonWaitBackoff = b }(DaemonWaitBackoff)
+	DaemonWaitBackoff = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), 50*time.Millisecond)
+	defer cancel()
+	getState, _ := delayedDaemon(0, time.Hour)
+	err := waitForDaemonState(ctx, getState, daemonWaitStates["connected"], "connected")
+	require.Error(t, err)
+	assert.Equal(t, errcat.Timeout, errcat.GetCategory(err))
+	assert.Equal(t, 124, errcat.ExitCode(err))
+	assert.Contains(t, err.Error(), "it's idle")
+
+	// A cancellation is not a timeout
+	ctx, cancel = context.WithCancel(dlog.NewTestContext(t, false))
+	cancel()
+	err = waitForDaemonState(ctx, getState, daemonWaitStates["connected"], "connected")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDaemonWaitCommand_invalidState(t *testing.T) {
+	cmd := daemonWaitCommand()
+	cmd.SetArgs([]string{"--for", "paused"})
+	cmd.SetContext(dlog.NewTestContext(t, false))
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
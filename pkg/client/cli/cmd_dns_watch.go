@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func dnsWatchCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "dns-watch",
+		Args: cobra.NoArgs,
+
+		Short: "Show the DNS queries that the root daemon serves as they happen",
+		Long: `Show the DNS queries that the root daemon serves as they happen, together with the resolver that
+answered them, the result, and the time it took. The queries are shown until the command is interrupted or the
+session ends.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+			return printDNSQueries(ctx, daemon.NewDaemonClient(conn), cmd.OutOrStdout())
+		},
+	}
+}
+
+// printDNSQueries prints the queries streamed by the daemon's DNSQueryLog until the stream ends or the context
+// is cancelled.
+func printDNSQueries(ctx context.Context, d daemon.DaemonClient, out io.Writer) error {
+	stream, err := d.DNSQueryLog(ctx, &empty.Empty{})
+	if err != nil {
+		return err
+	}
+	for {
+		q, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF || ctx.Err() != nil && status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		}
+		if _, err = fmt.Fprintln(out, formatDNSQuery(q)); err != nil {
+			return err
+		}
+	}
+}
+
+// formatDNSQuery returns a line that describes the given query.
+func formatDNSQuery(q *daemon.DNSQuery) string {
+	s := fmt.Sprintf("%-6s %s -> %s (%s, %s)", q.Qtype, q.Name, q.Result, q.Resolver, q.Latency.AsDuration())
+	if q.Answer != "" {
+		s += " " + q.Answer
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// dnsDaemonClient is a daemon.DaemonClient that only implements DNSQueryLog. It streams queries and then
+// ends the stream with err, or with io.EOF when err is nil.
+type dnsDaemonClient struct {
+	daemon.DaemonClient
+	queries []*daemon.DNSQuery
+	err     error
+}
+
+type dnsQueryStream struct {
+	grpc.ClientStream
+	d *dnsDaemonClient
+}
+
+func (d *dnsDaemonClient) DNSQueryLog(context.Context, *empty.Empty, ...grpc.CallOption) (daemon.Daemon_DNSQueryLogClient, error) {
+	return &dnsQueryStream{d: d}, nil
+}
+
+func (s *dnsQueryStream) Recv() (*daemon.DNSQuery, error) {
+	if len(s.d.queries) == 0 {
+		if s.d.err != nil {
+			return nil, s.d.err
+		}
+		return nil, io.EOF
+	}
+	q := s.d.queries[0]
+	s.d.queries = s.d.queries[1:]
+	return q, nil
+}
+
+func Test_printDNSQueries(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &dnsDaemonClient{queries: []*daemon.DNSQuery{
+		{
+			Name:     "web.default.",
+			Qtype:    "A",
+			Resolver: "cluster",
+			Result:   "NOERROR",
+			Answer:   "web.default.\t4\tIN\tA\t10.0.0.1",
+			Latency:  durationpb.New(1500 * time.Microsecond),
+		},
+		{
+			Name:     "example.com.",
+			Qtype:    "AAAA",
+			Resolver: "192.168.1.1",
+			Result:   "SERVFAIL",
+			Latency:  durationpb.New(2 * time.Second),
+		},
+	}}
+	var out bytes.Buffer
+	require.NoError(t, printDNSQueries(ctx, d, &out))
+	assert.Equal(t, "A      web.default. -> NOERROR (cluster, 1.5ms) web.default.\t4\tIN\tA\t10.0.0.1\n"+
+		"AAAA   example.com. -> SERVFAIL (192.168.1.1, 2s)\n", out.String())
+
+	d = &dnsDaemonClient{err: errors.New("broken")}
+	assert.EqualError(t, printDNSQueries(ctx, d, &out), "broken")
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/ann"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// execCommand runs a local program, such as psql, that needs nothing but the connection to reach the services
+// of the cluster. Unlike intercept, it neither intercepts a workload nor adds its environment, and unlike
+// "connect -- <command>", it leaves the connection in place when the program ends.
+func execCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec [flags] -- <command with arguments...>",
+		Args:  cobra.MinimumNArgs(1),
+		Short: "Run a local command that reaches the cluster using the names and addresses of its services",
+		Long: `Run a local command that reaches the cluster using the names and addresses of its services.
+
+The command is started by the telepresence command once it's connected. The DNS and routing of the
+connection apply to all local processes, so the command needs nothing else to reach the cluster. It runs
+as the current user, in the current directory, and with the current environment. Its stdin, stdout, and
+stderr are those of the telepresence command, and so is its exit code.`,
+		Annotations: map[string]string{
+			ann.RootDaemon: ann.Required,
+			ann.Session:    ann.Required,
+		},
+		RunE: runExec,
+	}
+	// The arguments are those of the command, not of exec
+	cmd.Flags().SetInterspersed(false)
+	return cmd
+}
+
+func runExec(cmd *cobra.Command, args []string) error {
+	if err := cliutil.InitCommand(cmd); err != nil {
+		return err
+	}
+	return execLocal(cmd, args)
+}
+
+// execLocal runs the given command line using the stdio of cmd. An error that the command exits with
+// carries its exit code, see errcat.ExitCode.
+func execLocal(cmd *cobra.Command, args []string) error {
+	// The output of the command is its own, so an error isn't a reason to look in the daemon logs
+	return errcat.NoDaemonLogs.New(proc.Run(cmd.Context(), nil, cmd, args[0], args[1:]...))
+}
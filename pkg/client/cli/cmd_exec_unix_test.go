@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func Test_execLocal(t *testing.T) {
+	run := func(t *testing.T, env map[string]string, args ...string) (string, error) {
+		for k, v := range env {
+			t.Setenv(k, v)
+		}
+		var stdout bytes.Buffer
+		cmd := &cobra.Command{
+			Use:           "exec",
+			SilenceErrors: true,
+			SilenceUsage:  true,
+			RunE:          execLocal,
+		}
+		cmd.Flags().SetInterspersed(false)
+		cmd.SetArgs(args)
+		cmd.SetIn(&bytes.Buffer{})
+		cmd.SetOut(&stdout)
+		cmd.SetErr(&bytes.Buffer{})
+		err := cmd.ExecuteContext(dlog.NewTestContext(t, false))
+		return stdout.String(), err
+	}
+
+	t.Run("local environment", func(t *testing.T) {
+		out, err := run(t, map[string]string{"TP_EXEC_TEST": "local"}, "sh", "-c", "echo $TP_EXEC_TEST")
+		require.NoError(t, err)
+		assert.Equal(t, "local\n", out)
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		_, err := run(t, nil, "sh", "-c", "exit 3")
+		var ee *proc.ExitError
+		require.True(t, errors.As(err, &ee), "expected a *proc.ExitError, got %T", err)
+		assert.Equal(t, 3, ee.Code)
+		assert.Equal(t, 3, errcat.ExitCode(err))
+		assert.Equal(t, errcat.NoDaemonLogs, errcat.GetCategory(err))
+	})
+}
@@ -40,12 +40,18 @@ func logLevelArg(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func loglevelCommand() *cobra.Command {
+// logLevelNames returns the names of the log levels that can be set.
+func logLevelNames() []string {
 	lvs := logrus.AllLevels[2:] // Don't include `panic` and `fatal`
 	lvStrs := make([]string, len(lvs))
 	for i, lv := range lvs {
 		lvStrs[i] = lv.String()
 	}
+	return lvStrs
+}
+
+func loglevelCommand() *cobra.Command {
+	lvStrs := logLevelNames()
 	lls := logLevelSetter{}
 	cmd := &cobra.Command{
 		Use:       fmt.Sprintf("loglevel <%s>", strings.Join(lvStrs, ",")),
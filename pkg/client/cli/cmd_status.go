@@ -32,6 +32,7 @@ type daemonStatus struct {
 	Running           bool             `json:"running,omitempty"`
 	Version           string           `json:"version,omitempty"`
 	APIVersion        int32            `json:"api_version,omitempty"`
+	LastHealthyAt     *time.Time       `json:"last_healthy_at,omitempty"`
 	DNS               *daemonStatusDNS `json:"dns,omitempty"`
 	AlsoProxySubnets  []string         `json:"also_proxy_subnets,omitempty"`
 	NeverProxySubnets []string         `json:"never_proxy_subnets,omitempty"`
@@ -39,10 +40,13 @@ type daemonStatus struct {
 
 type daemonStatusDNS struct {
 	LocalIP         net.IP        `json:"local_ip,omitempty"`
+	FallbackIPs     []net.IP      `json:"fallback_ips,omitempty"`
 	RemoteIP        net.IP        `json:"remote_ip,omitempty"`
 	ExcludeSuffixes []string      `json:"exclude_suffixes,omitempty"`
 	IncludeSuffixes []string      `json:"include_suffixes,omitempty"`
 	LookupTimeout   time.Duration `json:"lookup_timeout_in_nanos,omitempty"`
+	Health          string        `json:"health,omitempty"`
+	HealthError     string        `json:"health_error,omitempty"`
 }
 
 type connectorStatus struct {
@@ -176,6 +180,10 @@ func (s *statusInfo) connectorStatus(ctx context.Context) (*daemonStatus, *conne
 		ds.Running = true
 		ds.Version = rStatus.Version.Version
 		ds.APIVersion = rStatus.Version.ApiVersion
+		if lh := rStatus.LastHealthyAt; lh != nil {
+			t := lh.AsTime()
+			ds.LastHealthyAt = &t
+		}
 		if obc := rStatus.OutboundConfig; obc != nil {
 			ds.DNS = &daemonStatusDNS{}
 			dns := obc.Dns
@@ -183,10 +191,17 @@ func (s *statusInfo) connectorStatus(ctx context.Context) (*daemonStatus, *conne
 				// Local IP is only set when the overriding resolver is used
 				ds.DNS.LocalIP = dns.LocalIp
 			}
+			for _, ip := range dns.FallbackIps {
+				ds.DNS.FallbackIPs = append(ds.DNS.FallbackIPs, ip)
+			}
 			ds.DNS.RemoteIP = dns.RemoteIp
 			ds.DNS.ExcludeSuffixes = dns.ExcludeSuffixes
 			ds.DNS.IncludeSuffixes = dns.IncludeSuffixes
 			ds.DNS.LookupTimeout = dns.LookupTimeout.AsDuration()
+			if h := rStatus.DnsHealth; h != nil {
+				ds.DNS.Health = h.State.String()
+				ds.DNS.HealthError = h.Error
+			}
 			for _, subnet := range obc.AlsoProxySubnets {
 				ds.AlsoProxySubnets = append(ds.AlsoProxySubnets, iputil.IPNetFromRPC(subnet).String())
 			}
@@ -219,15 +234,29 @@ func (s *statusInfo) printDaemonText(ds *daemonStatus) {
 	if ds.Running {
 		s.println("Root Daemon: Running")
 		s.printf("  Version   : %s (api %d)\n", ds.Version, ds.APIVersion)
+		if ds.LastHealthyAt != nil {
+			s.printf("  Healthy at: %s (%s ago)\n", ds.LastHealthyAt.Local().Format(time.RFC3339),
+				time.Since(*ds.LastHealthyAt).Round(time.Second))
+		}
 		if ds.DNS != nil {
 			s.printf("  DNS       :\n")
 			if len(ds.DNS.LocalIP) > 0 {
 				s.printf("    Local IP        : %v\n", ds.DNS.LocalIP)
 			}
+			if len(ds.DNS.FallbackIPs) > 0 {
+				s.printf("    Fallback IPs    : %v\n", ds.DNS.FallbackIPs)
+			}
 			s.printf("    Remote IP       : %v\n", ds.DNS.RemoteIP)
 			s.printf("    Exclude suffixes: %v\n", ds.DNS.ExcludeSuffixes)
 			s.printf("    Include suffixes: %v\n", ds.DNS.IncludeSuffixes)
 			s.printf("    Timeout         : %v\n", ds.DNS.LookupTimeout)
+			if ds.DNS.Health != "" {
+				if ds.DNS.HealthError != "" {
+					s.printf("    Health          : %s, %s\n", ds.DNS.Health, ds.DNS.HealthError)
+				} else {
+					s.printf("    Health          : %s\n", ds.DNS.Health)
+				}
+			}
 			s.printf("  Also Proxy : (%d subnets)\n", len(ds.AlsoProxySubnets))
 			for _, subnet := range ds.AlsoProxySubnets {
 				s.printf("    - %s\n", subnet)
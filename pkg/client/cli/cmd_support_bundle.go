@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func supportBundleCommand() *cobra.Command {
+	var outputFile string
+	rq := daemon.SupportBundleRequest{}
+	cmd := &cobra.Command{
+		Use:  "support-bundle",
+		Args: cobra.NoArgs,
+
+		Short: "Export the log, configuration, status, version, and metrics of the root daemon into a gzipped tarball",
+		Long: `Export the log, configuration, status, version, and metrics of the root daemon into a gzipped tarball
+that can be attached to a bug report. Use --scrub to replace IP addresses, and values that look like secrets,
+with placeholders.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+			if err != nil {
+				return cliutil.ErrNoRootDaemon
+			}
+			defer conn.Close()
+
+			// If the user did not provide an outputFile, we'll use their current working directory
+			if outputFile == "" {
+				pwd, err := os.Getwd()
+				if err != nil {
+					return err
+				}
+				outputFile = filepath.Join(pwd, "telepresence_support_bundle.tar.gz")
+			} else if !strings.HasSuffix(outputFile, ".tar.gz") {
+				outputFile += ".tar.gz"
+			}
+			f, err := os.Create(outputFile)
+			if err != nil {
+				return err
+			}
+			err = writeSupportBundle(ctx, daemon.NewDaemonClient(conn), &rq, f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(outputFile)
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Support bundle has been exported to %s\n", outputFile)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&outputFile, "output-file", "o", "", "The file you want to output the support bundle to.")
+	flags.BoolVar(&rq.Scrub, "scrub", false, "Replace IP addresses, and values that look like secrets, with placeholders")
+	return cmd
+}
+
+// writeSupportBundle writes the support bundle that the daemon streams to out.
+func writeSupportBundle(ctx context.Context, d daemon.DaemonClient, rq *daemon.SupportBundleRequest, out io.Writer) error {
+	stream, err := d.SupportBundle(ctx, rq)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err = out.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+}
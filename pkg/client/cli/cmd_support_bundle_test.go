@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// bundleDaemonClient is a daemon.DaemonClient that only implements SupportBundle. It streams chunks and then
+// ends the stream with err, or with io.EOF when err is nil.
+type bundleDaemonClient struct {
+	daemon.DaemonClient
+	chunks  []string
+	err     error
+	request *daemon.SupportBundleRequest
+}
+
+type bundleStream struct {
+	grpc.ClientStream
+	d *bundleDaemonClient
+}
+
+func (d *bundleDaemonClient) SupportBundle(_ context.Context, rq *daemon.SupportBundleRequest, _ ...grpc.CallOption) (daemon.Daemon_SupportBundleClient, error) {
+	d.request = rq
+	return &bundleStream{d: d}, nil
+}
+
+func (s *bundleStream) Recv() (*daemon.SupportBundleChunk, error) {
+	if len(s.d.chunks) == 0 {
+		if s.d.err != nil {
+			return nil, s.d.err
+		}
+		return nil, io.EOF
+	}
+	c := s.d.chunks[0]
+	s.d.chunks = s.d.chunks[1:]
+	return &daemon.SupportBundleChunk{Data: []byte(c)}, nil
+}
+
+func Test_writeSupportBundle(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &bundleDaemonClient{chunks: []string{"first ", "second"}}
+	var out bytes.Buffer
+	require.NoError(t, writeSupportBundle(ctx, d, &daemon.SupportBundleRequest{Scrub: true}, &out))
+	assert.Equal(t, "first second", out.String())
+	assert.True(t, d.request.Scrub)
+
+	d = &bundleDaemonClient{chunks: []string{"first "}, err: errors.New("broken")}
+	out.Reset()
+	assert.EqualError(t, writeSupportBundle(ctx, d, &daemon.SupportBundleRequest{}, &out), "broken")
+}
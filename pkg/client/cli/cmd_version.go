@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
@@ -40,6 +41,9 @@ func printVersion(cmd *cobra.Command, _ []string) error {
 	ctx := cmd.Context()
 	version, err := daemonVersion(ctx)
 	switch {
+	case err == nil && version.Uptime != nil:
+		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: %s (api v%d, uptime %s)\n",
+			version.Version, version.ApiVersion, version.Uptime.AsDuration().Round(time.Second))
 	case err == nil:
 		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: %s (api v%d)\n", version.Version, version.ApiVersion)
 	case err == cliutil.ErrNoRootDaemon:
@@ -3,10 +3,14 @@ package cli
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
@@ -16,6 +20,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/ann"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
@@ -144,6 +149,8 @@ func quitCommand() *cobra.Command {
 	quitDaemons := false
 	quitRootDaemon := false
 	quitUserDaemon := false
+	graceful := false
+	gracefulTimeout := time.Duration(0)
 	cmd := &cobra.Command{
 		Use:  "quit",
 		Args: cobra.NoArgs,
@@ -162,6 +169,14 @@ func quitCommand() *cobra.Command {
 				fmt.Fprintln(os.Stderr, "--root-daemon (-r) is deprecated, please use --stop-daemons (-s)")
 				quitDaemons = true
 			}
+			if graceful {
+				if !quitDaemons {
+					return errcat.User.New("--graceful can only be used together with --stop-daemons")
+				}
+				if err := quitGracefully(cmd, gracefulTimeout); err != nil {
+					return err
+				}
+			}
 			ctx := cmd.Context()
 			if quitDaemons && cliutil.GetUserDaemon(ctx) == nil {
 				// User daemon isn't running. If the root daemon is running, we must
@@ -177,9 +192,35 @@ func quitCommand() *cobra.Command {
 	flags.BoolVarP(&quitDaemons, "stop-daemons", "s", false, "stop the traffic-manager and network daemons")
 	flags.BoolVarP(&quitRootDaemon, "root-daemon", "r", false, "stop daemons")
 	flags.BoolVarP(&quitUserDaemon, "user-daemon", "u", false, "stop daemons")
+	flags.BoolVar(&graceful, "graceful", false,
+		"don't stop the daemons while the user daemon is running commands (see telepresence commands --running)")
+	flags.DurationVar(&gracefulTimeout, "graceful-timeout", 0,
+		"how long --graceful waits for the running commands to end before it gives up")
 
 	// retained for backward compatibility but hidden from now on
 	flags.Lookup("root-daemon").Hidden = true
 	flags.Lookup("user-daemon").Hidden = true
 	return cmd
 }
+
+// quitGracefully makes the user daemon quit unless it's running commands that don't end within the given timeout,
+// in which case those commands are listed on stderr and an error is returned.
+func quitGracefully(cmd *cobra.Command, timeout time.Duration) error {
+	ctx := cmd.Context()
+	userD := cliutil.GetUserDaemon(ctx)
+	if userD == nil {
+		return nil
+	}
+	rsp, err := userD.QuitGraceful(ctx, &connector.QuitGracefulRequest{Timeout: durationpb.New(timeout)})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return errcat.User.New("the user daemon doesn't support --graceful")
+		}
+		return err
+	}
+	if len(rsp.Running) > 0 {
+		_ = printRunningCommands(cmd.ErrOrStderr(), rsp.Running, false)
+		return errcat.User.Newf("not quitting, because the user daemon is still running %d command(s)", len(rsp.Running))
+	}
+	return nil
+}
@@ -2,24 +2,39 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	empty "google.golang.org/protobuf/types/known/emptypb"
+	"github.com/spf13/pflag"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/ann"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/output"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/commands"
-	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+var (
+	// remoteCommands are the commands provided by the user daemon.
+	remoteCommands cliutil.CommandGroups
+
+	// remoteCommandsRPC is the ListCommands result that remoteCommands were created from.
+	remoteCommandsRPC *connector.CommandGroups
+
+	// remoteCommandsErr is the reason why the commands provided by the user daemon are unavailable. It's
+	// reported by "telepresence commands --debug".
+	remoteCommandsErr error
 )
 
 func getRemoteCommands(cmd *cobra.Command, forceStart bool) (groups cliutil.CommandGroups, err error) {
@@ -30,26 +45,123 @@ func getRemoteCommands(cmd *cobra.Command, forceStart bool) (groups cliutil.Comm
 	}
 	cmd.Annotations = map[string]string{ann.UserDaemon: av}
 	if err := cliutil.InitCommand(cmd); err != nil {
+		remoteCommandsErr = err
 		return nil, err
 	}
 	ctx := cmd.Context()
 	if userD := cliutil.GetUserDaemon(ctx); userD != nil {
-		remote, err := userD.ListCommands(ctx, &empty.Empty{})
-		if err != nil {
-			return nil, fmt.Errorf("unable to call ListCommands: %w", err)
-		}
-		funcBundle := cliutil.CommandFuncBundle{
-			RunE:              runRemote,
-			ValidArgsFunction: validArgsFuncRemote,
-		}
-		if groups, err = cliutil.RPCToCommands(remote, funcBundle); err != nil {
-			groups = commands.GetCommandsForLocal(ctx, err)
+		if groups, err = userDaemonCommands(ctx, userD, IsCommand("--refresh-commands"), cmd.ErrOrStderr()); err != nil {
+			return nil, err
 		}
 		userDaemonRunning = true
 	}
 	return groups, err
 }
 
+// userDaemonCommands returns the commands provided by the given user daemon. A daemon that doesn't list
+// them within the configured timeout is treated like one that provides commands that the client can't use,
+// see remoteCommandGroups, so that a hung daemon doesn't stall every command and every shell completion.
+func userDaemonCommands(ctx context.Context, userD connector.ConnectorClient, refresh bool, stderr io.Writer) (cliutil.CommandGroups, error) {
+	remote, err := listRemoteCommands(ctx, userD, refresh)
+	if err != nil {
+		remoteCommandsErr = err
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		dlog.Errorf(ctx, "unable to use the commands provided by the user daemon: %v", err)
+		if !IsCommand(cobra.ShellCompRequestCmd) {
+			warnCommandsFallback(ctx, stderr)
+		}
+		return commands.GetCommandsForLocal(ctx, err), nil
+	}
+	return remoteCommandGroups(ctx, remote, stderr), nil
+}
+
+// remoteCommandGroups converts the commands provided by the user daemon into cobra commands. If that fails,
+// a warning is written to stderr and the commands known to the client are returned instead. They will
+// report the error when they are run.
+func remoteCommandGroups(ctx context.Context, remote *connector.CommandGroups, stderr io.Writer) cliutil.CommandGroups {
+	funcBundle := cliutil.CommandFuncBundle{
+		RunE:              runRemote,
+		ValidArgsFunction: validArgsFuncRemote,
+	}
+	groups, err := cliutil.RPCToCommands(remote, funcBundle)
+	if err != nil {
+		remoteCommandsErr = err
+		dlog.Errorf(ctx, "unable to use the commands provided by the user daemon: %v", err)
+		if !IsCommand(cobra.ShellCompRequestCmd) {
+			// The stderr of a completion request is discarded, so don't spend the warning on it.
+			warnCommandsFallback(ctx, stderr)
+		}
+		return commands.GetCommandsForLocal(ctx, err)
+	}
+	addClientFlags(groups)
+	remoteCommands = groups
+	remoteCommandsRPC = remote
+	return groups
+}
+
+// CommandsWarningInterval is the minimum time between two warnings about unavailable remote commands.
+// The time of the last warning is kept in the user cache, so the limit applies across invocations.
+var CommandsWarningInterval = time.Minute
+
+const commandsWarningFile = "commands-warning.json"
+
+// warnCommandsFallback writes a one-line warning to stderr unless a warning was written within the
+// last CommandsWarningInterval.
+func warnCommandsFallback(ctx context.Context, stderr io.Writer) {
+	var last time.Time
+	if err := cache.LoadFromUserCache(ctx, &last, commandsWarningFile); err == nil && time.Since(last) < CommandsWarningInterval {
+		return
+	}
+	fmt.Fprintln(stderr, `Warning: the commands provided by the user daemon are unavailable; run "telepresence commands --debug" for details`)
+	if err := cache.SaveToUserCache(ctx, time.Now(), commandsWarningFile); err != nil {
+		dlog.Debugf(ctx, "unable to save the time of the commands warning: %v", err)
+	}
+}
+
+// RemoteCommandsCacheTTL is the maximum age of a cached list of remote commands.
+var RemoteCommandsCacheTTL = 30 * time.Second
+
+// listRemoteCommands returns the commands provided by the user daemon. The list is cached in the
+// user cache so that shell completion, which creates a new CLI process for each completion, doesn't
+// need to call the daemon every time. The cache is bypassed when refresh is true. The call to the
+// daemon fails when it doesn't answer within the "timeouts.listCommands" of the config.
+func listRemoteCommands(ctx context.Context, userD connector.ConnectorClient, refresh bool) (*connector.CommandGroups, error) {
+	key := remoteCommandsCacheKey()
+	if key != "" && !refresh {
+		remote, err := cache.LoadCommandsFromUserCache(ctx, key, RemoteCommandsCacheTTL)
+		if err != nil {
+			dlog.Debugf(ctx, "unable to load cached commands: %v", err)
+		} else if remote != nil {
+			return remote, nil
+		}
+	}
+	tCtx, cancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutListCommands)
+	defer cancel()
+	remote, err := userD.ListCommands(tCtx, &connector.ListCommandsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to call ListCommands: %w", client.CheckTimeout(tCtx, err))
+	}
+	if key != "" {
+		if err := cache.SaveCommandsToUserCache(ctx, key, remote); err != nil {
+			dlog.Debugf(ctx, "unable to cache commands: %v", err)
+		}
+	}
+	return remote, nil
+}
+
+// remoteCommandsCacheKey returns a key that identifies the running user daemon, or an empty string
+// when no such key can be determined, in which case no caching takes place. The key changes when
+// the client is upgraded or the daemon is restarted.
+func remoteCommandsCacheKey() string {
+	st, err := os.Stat(client.ConnectorSocketName)
+	if err != nil || st.ModTime().IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", client.Version(), st.ModTime().UnixNano())
+}
+
 func initRemoteCommand(cmd *cobra.Command) error {
 	ca := cmd.Annotations
 	if ca == nil {
@@ -65,8 +177,17 @@ func validArgsFuncRemote(cmd *cobra.Command, args []string, toComplete string) (
 	if err := initRemoteCommand(cmd); err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
+	return validArgsRemote(cmd, cliutil.GetUserDaemon(cmd.Context()), args, toComplete)
+}
+
+// validArgsRemote asks the given user daemon for the completions of a remote command. The completions that
+// the client can produce on its own are returned when userD is nil.
+func validArgsRemote(cmd *cobra.Command, userD connector.ConnectorClient, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if userD == nil {
+		return localCompletions(cmd, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
 	ctx := cmd.Context()
-	resp, err := cliutil.GetUserDaemon(ctx).ValidArgsForCommand(ctx, &connector.ValidArgsForCommandRequest{
+	resp, err := userD.ValidArgsForCommand(ctx, &connector.ValidArgsForCommandRequest{
 		CmdName:    cmd.Name(),
 		OsArgs:     args,
 		ToComplete: toComplete,
@@ -74,136 +195,180 @@ func validArgsFuncRemote(cmd *cobra.Command, args []string, toComplete string) (
 	if err != nil {
 		return []string{}, 0
 	}
-	return resp.Completions, cobra.ShellCompDirective(resp.ShellCompDirective)
+	completions, directive := resp.Completions, cobra.ShellCompDirective(resp.ShellCompDirective)
+	if ff := resp.FileFilter; ff != nil {
+		completions, directive = mergeFileCompletions(completions, directive, ff.Extensions, ff.DirsOnly, toComplete)
+	}
+	return completions, directive
 }
 
-func stdinPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cmd *cobra.Command) {
-	buf := make([]byte, 1024)
-	stdin := cmd.InOrStdin()
-	for ctx.Err() == nil {
-		n, err := stdin.Read(buf)
-		if n > 0 {
-			if err = cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Data{Data: buf[:n]}}); err != nil {
-				if ctx.Err() == nil {
-					dlog.Errorf(ctx, "failed to forward to stdin: %v\n", err)
-				}
-				return
-			}
-		}
-		if err != nil {
-			if !(errors.Is(err, io.EOF) || ctx.Err() != nil) {
-				dlog.Errorf(ctx, "failed to read from stdin: %v\n", err)
-			}
-			return
+// localCompletions returns the names of the flags of cmd that start with toComplete. Only flags are completed,
+// because the arguments of a remote command are known to the user daemon only.
+func localCompletions(cmd *cobra.Command, toComplete string) []string {
+	completions := []string{}
+	if !strings.HasPrefix(toComplete, "-") {
+		return completions
+	}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if name := "--" + f.Name; !f.Hidden && strings.HasPrefix(name, toComplete) {
+			completions = append(completions, name)
 		}
+	})
+	return completions
+}
+
+func runRemote(cmd *cobra.Command, args []string) error {
+	args, err := extractClientFlags(cmd, args)
+	if err != nil {
+		return err
+	}
+	if err := initRemoteCommand(cmd); err != nil {
+		return err
 	}
+	return runRemoteWith(cmd, args, cliutil.GetUserDaemon(cmd.Context()))
 }
 
-func interruptPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cancel context.CancelFunc) {
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, proc.SignalsToForward...)
-	defer func() {
-		signal.Stop(sigCh)
-		close(sigCh)
-	}()
-
-	select {
-	case <-ctx.Done():
-	case sig := <-sigCh:
-		if sig == nil {
-			return
-		}
-		err := cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_SoftCancel{SoftCancel: true}})
-		if err != nil {
-			if ctx.Err() != nil {
-				dlog.Errorf(ctx, "failed to send soft cancel: %v\n", err)
-			}
-			return
+// runRemoteWith runs the remote command that cmd represents on the given user daemon. The args are those
+// that remain when the client flags have been extracted.
+func runRemoteWith(cmd *cobra.Command, args []string, userD connector.ConnectorClient) (err error) {
+	cwd := remoteCwd(os.Getwd, os.LookupEnv, cmd.ErrOrStderr(), getwdTimeout)
+	ctx := cmd.Context()
+
+	// FlagParsing is disabled on the local-side cmd so args is actually going to hold flags and args both
+	// Thus command_name + args is the entire command line (except for the "telepresence" string in os.Args[0])
+	rc := RemoteCommand{
+		Args:              append([]string{cliutil.RemoteName(cmd)}, args...),
+		Cwd:               cwd,
+		Locale:            localeEnv(os.LookupEnv),
+		Stdin:             cmd.InOrStdin(),
+		Stdout:            cmd.OutOrStdout(),
+		Stderr:            cmd.ErrOrStderr(),
+		HandleInterrupts:  true,
+		ForwardWindowSize: true,
+	}
+	if f := clientFlag(cmd, "expand-env"); f != nil && f.Value.String() == "true" {
+		rc.Args = append(rc.Args[:1], expandEnv(args, os.LookupEnv)...)
+	}
+	if f := clientFlag(cmd, "tty"); f != nil {
+		rc.TTY = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "raw"); f != nil {
+		rc.Raw = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "detach"); f != nil {
+		rc.Detach = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "no-interrupt"); f != nil && f.Value.String() == "true" {
+		// The default signal handling terminates the client, and with it the stream to the command
+		rc.HandleInterrupts = false
+	}
+	if f := clientFlag(cmd, "output"); f != nil {
+		switch strings.ToLower(f.Value.String()) {
+		case "json":
+			rc.JSONOutput = true
+			rc.Stdout, _ = output.Structured(ctx)
+		case "raw":
+			// The structured output only wraps the writers of a command when JSON is requested
+			rc.RawOutput = true
 		}
-		// Trigger "hard" cancel if needed.
-		select {
-		case <-ctx.Done():
-		case <-time.After(5 * time.Second):
-			cancel()
+	}
+	if f := clientFlag(cmd, "retries"); f != nil {
+		if rc.Retries, err = remoteRetries(cmd, f.Value.String()); err != nil {
+			return err
 		}
 	}
-}
-
-func stdoutAndStderrPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cmd *cobra.Command) error {
-	// We don't use structured output here because that's being taking care of remotely.
-	stdout, stderr := cmd.OutOrStdout(), cmd.ErrOrStderr()
-	defer cmdStream.CloseSend()
-	for ctx.Err() == nil {
-		sr, err := cmdStream.Recv()
-		if err != nil {
-			if errors.Is(err, io.EOF) || ctx.Err() != nil {
-				// Normal command termination
-				return nil
-			}
-			return fmt.Errorf("failed to read stdout/stderr stream: %w\n", err)
+	if f := clientFlag(cmd, "timeout"); f != nil {
+		if rc.Timeout, err = time.ParseDuration(f.Value.String()); err != nil {
+			return errcat.User.New(err)
 		}
-		r := sr.Data
-		if sr.Final {
-			// Command execution ended with an error
-			if r != nil {
-				err = errcat.FromResult(r)
+	}
+	if f := clientFlag(cmd, "wait"); f != nil {
+		rc.Wait = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "remote-cwd"); f != nil {
+		if dir := f.Value.String(); dir != "" {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(cwd, dir)
 			}
-			return err
+			rc.Cwd = dir
 		}
-
-		// Normal output from the command
-		var w io.Writer
-		if r.ErrorCategory == 0 {
-			w = stdout
-		} else {
-			w = stderr
+	}
+	if f := clientFlag(cmd, "stdin-file"); f != nil {
+		var closeStdin func()
+		if rc.Stdin, closeStdin, err = openStdinFile(f.Value.String(), rc.Stdin); err != nil {
+			return err
 		}
-		if _, err = w.Write(r.Data); err != nil {
-			if ctx.Err() != nil {
-				return nil
+		defer closeStdin()
+	}
+	if f := clientFlag(cmd, "quiet"); f != nil {
+		rc.Quiet = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "no-diagnostics"); f != nil {
+		rc.NoDiagnostics = f.Value.String() == "true"
+	}
+	if f := clientFlag(cmd, "env"); f != nil {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			if rc.Env, err = forwardedEnv(sv.GetSlice(), os.LookupEnv); err != nil {
+				return err
 			}
-			return fmt.Errorf("failed to write stdout/stderr: %w\n", err)
+			rc.Env = sanitizeEnv(cmd, rc.Env, cmd.ErrOrStderr())
 		}
 	}
-	return nil
-}
-
-func runRemote(cmd *cobra.Command, args []string) error {
-	if err := initRemoteCommand(cmd); err != nil {
-		return err
+	if f := clientFlag(cmd, "keepalive"); f != nil {
+		if rc.KeepAlive, err = time.ParseDuration(f.Value.String()); err != nil {
+			return errcat.User.New(err)
+		}
 	}
-	ctx := cmd.Context()
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
+	if f := clientFlag(cmd, "compress"); f != nil {
+		rc.Compress = f.Value.String() == "true"
 	}
-	userD := cliutil.GetUserDaemon(ctx)
-	// Use a graceful termination period
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	_, stderr := output.Structured(ctx)
-
-	cmdStream, err := userD.RunCommand(ctx)
-	if err != nil {
-		fmt.Fprintf(stderr, "failed start command: %v\n", err)
-		return err
+	if f := clientFlag(cmd, "on-error"); f != nil {
+		rc.OnError = f.Value.String()
 	}
-
-	// FlagParsing is disabled on the local-side cmd so args is actually going to hold flags and args both
-	// Thus command_name + args is the entire command line (except for the "telepresence" string in os.Args[0])
-	err = cmdStream.Send(&connector.RunCommandRequest{
-		COrD: &connector.RunCommandRequest_Command_{Command: &connector.RunCommandRequest_Command{
-			OsArgs: append([]string{cmd.CalledAs()}, args...),
-			Cwd:    cwd,
-		}},
-	})
-	if err != nil {
-		fmt.Fprintf(stderr, "failed to send: %v\n", err)
-		return err
+	if f := clientFlag(cmd, "coalesce-output"); f != nil {
+		if rc.CoalesceOutput, err = time.ParseDuration(f.Value.String()); err != nil {
+			return errcat.User.New(err)
+		}
 	}
-
-	// Start all pumps, wait for the stdout/stderr pump to finish
-	go stdinPump(ctx, cmdStream, cmd)
-	go interruptPump(ctx, cmdStream, cancel)
-	return stdoutAndStderrPump(ctx, cmdStream, cmd)
+	if f := clientFlag(cmd, "color"); f != nil {
+		if rc.Color, err = parseColorMode(f.Value.String()); err != nil {
+			return err
+		}
+	}
+	if f := clientFlag(cmd, "max-output"); f != nil {
+		if rc.MaxOutput, err = strconv.ParseInt(f.Value.String(), 10, 64); err != nil || rc.MaxOutput < 0 {
+			return errcat.User.Newf("invalid --max-output %q, must be a number of bytes", f.Value.String())
+		}
+	}
+	if f := clientFlag(cmd, "rune-aligned"); f != nil {
+		rc.RuneAligned = f.Value.String() == "true"
+	}
+	var tee, teeOut, teeErr string
+	if f := clientFlag(cmd, "tee"); f != nil {
+		tee = f.Value.String()
+	}
+	if f := clientFlag(cmd, "tee-out"); f != nil {
+		teeOut = f.Value.String()
+	}
+	if f := clientFlag(cmd, "tee-err"); f != nil {
+		teeErr = f.Value.String()
+	}
+	if tee != "" || teeOut != "" || teeErr != "" {
+		var closeTee func() error
+		if rc.TeeStdout, rc.TeeStderr, closeTee, err = openTeeFiles(tee, teeOut, teeErr); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := closeTee(); err == nil && cerr != nil {
+				err = errcat.User.Newf("unable to close tee file: %w", cerr)
+			}
+		}()
+	}
+	if f := clientFlag(cmd, "timing"); f != nil && f.Value.String() == "true" {
+		rc.Timing = &Timing{}
+		defer func() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "timing: %s\n", rc.Timing)
+		}()
+	}
+	return rc.Run(ctx, userD)
 }
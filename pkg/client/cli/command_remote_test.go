@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func Test_remoteCommandGroups(t *testing.T) {
+	remote := func(flagType string) *connector.CommandGroups {
+		return &connector.CommandGroups{
+			CommandGroups: map[string]*connector.CommandGroups_Commands{
+				"Traffic Commands": {
+					Commands: []*connector.CommandGroups_Command{{
+						Name:      "intercept",
+						ShortHelp: "Intercept a service",
+						Flags: []*connector.CommandGroups_Flag{{
+							Type: flagType,
+							Flag: "port",
+						}},
+					}},
+				},
+			},
+		}
+	}
+	defer func() {
+		remoteCommands = nil
+		remoteCommandsErr = nil
+	}()
+
+	t.Run("success", func(t *testing.T) {
+		remoteCommandsErr = nil
+		ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+		stderr := &bytes.Buffer{}
+		groups := remoteCommandGroups(ctx, remote("string"), stderr)
+		require.Len(t, groups["Traffic Commands"], 1)
+		assert.Equal(t, "Intercept a service", groups["Traffic Commands"][0].Short)
+		assert.Empty(t, stderr.String())
+		assert.NoError(t, remoteCommandsErr)
+	})
+
+	t.Run("fallback warns", func(t *testing.T) {
+		remoteCommandsErr = nil
+		ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+		stderr := &bytes.Buffer{}
+		groups := remoteCommandGroups(ctx, remote("no-such-type"), stderr)
+		assert.NotEmpty(t, groups)
+		assert.Contains(t, stderr.String(), "telepresence commands --debug")
+		assert.Equal(t, 1, bytes.Count(stderr.Bytes(), []byte("\n")))
+		assert.Error(t, remoteCommandsErr)
+
+		// The warning is rate limited
+		stderr.Reset()
+		remoteCommandGroups(ctx, remote("no-such-type"), stderr)
+		assert.Empty(t, stderr.String())
+
+		// and repeated when the interval has passed
+		saved := CommandsWarningInterval
+		CommandsWarningInterval = 0
+		defer func() { CommandsWarningInterval = saved }()
+		remoteCommandGroups(ctx, remote("no-such-type"), stderr)
+		assert.Contains(t, stderr.String(), "telepresence commands --debug")
+	})
+}
+
+// slowConnector is a user daemon that doesn't answer ListCommands until the call is cancelled.
+type slowConnector struct {
+	connector.ConnectorClient
+}
+
+func (slowConnector) ListCommands(ctx context.Context, _ *connector.ListCommandsRequest, _ ...grpc.CallOption) (*connector.CommandGroups, error) {
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func Test_userDaemonCommands_slowDaemon(t *testing.T) {
+	defer func() {
+		remoteCommands = nil
+		remoteCommandsErr = nil
+	}()
+	remoteCommandsErr = nil
+	cfg := client.GetDefaultConfig()
+	cfg.Timeouts.PrivateListCommands = 50 * time.Millisecond
+	ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+	ctx = client.WithConfig(ctx, &cfg)
+
+	// The listing fails fast, and says how to configure the timeout
+	start := time.Now()
+	_, err := listRemoteCommands(ctx, slowConnector{}, true)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "timeouts.listCommands")
+
+	// The client falls back to the commands that it knows, which report the error when they run
+	stderr := &bytes.Buffer{}
+	groups, err := userDaemonCommands(ctx, slowConnector{}, true, stderr)
+	require.NoError(t, err)
+	assert.NotEmpty(t, groups)
+	assert.Contains(t, stderr.String(), "telepresence commands --debug")
+	assert.ErrorIs(t, remoteCommandsErr, context.DeadlineExceeded)
+	assert.Nil(t, remoteCommands)
+}
+
+func Test_validArgsRemote_noUserDaemon(t *testing.T) {
+	cmd := &cobra.Command{Use: "intercept"}
+	cmd.SetContext(dlog.NewTestContext(t, false))
+	cmd.Flags().String("port", "", "")
+	cmd.Flags().Bool("preview-url", false, "")
+	cmd.Flags().Bool("hidden", false, "")
+	require.NoError(t, cmd.Flags().MarkHidden("hidden"))
+	cmd.Flags().AddFlagSet(remoteClientFlags())
+
+	completions, directive := validArgsRemote(cmd, nil, nil, "--p")
+	assert.Equal(t, []string{"--port", "--preview-url"}, completions)
+	assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+
+	completions, _ = validArgsRemote(cmd, nil, nil, "--ti")
+	assert.Equal(t, []string{"--timeout", "--timing"}, completions)
+
+	completions, _ = validArgsRemote(cmd, nil, nil, "--h")
+	assert.Empty(t, completions)
+
+	// Arguments are only known to the user daemon
+	completions, directive = validArgsRemote(cmd, nil, []string{"--port", "8080"}, "ec")
+	assert.Empty(t, completions)
+	assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+}
+
+func Test_runRemote_alias(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	remote := &connector.CommandGroups{
+		CommandGroups: map[string]*connector.CommandGroups_Commands{
+			"Traffic Commands": {
+				Commands: []*connector.CommandGroups_Command{{
+					Name:    "intercept [flags] <name>",
+					Aliases: []string{"ic"},
+				}},
+			},
+		},
+	}
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+	userD := &fakeConnector{stream: cs}
+	groups, err := cliutil.RPCToCommands(remote, cliutil.CommandFuncBundle{
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRemoteWith(cmd, args, userD)
+		},
+	})
+	require.NoError(t, err)
+
+	root := &cobra.Command{Use: "telepresence"}
+	root.AddCommand(groups["Traffic Commands"]...)
+	root.SetArgs([]string{"ic", "echo"})
+	root.SetIn(&bytes.Buffer{})
+	root.SetOut(&bytes.Buffer{})
+	root.SetErr(&bytes.Buffer{})
+	require.NoError(t, root.ExecuteContext(ctx))
+
+	// The user daemon gets the name of the command, not the alias that it was invoked by
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	assert.Equal(t, []string{"intercept", "echo"}, rqs[0].GetCommand().GetOsArgs())
+}
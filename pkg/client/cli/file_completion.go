@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// mergeFileCompletions merges completions obtained from the user daemon with completions of local file names
+// that match the given extensions, or directories when dirsOnly is true. The file names are left to the shell
+// when there's nothing to merge with, so that its native file completion is used. When there is, the files are
+// listed here, because the shell completion scripts don't combine completions with file filtering.
+func mergeFileCompletions(
+	completions []string,
+	directive cobra.ShellCompDirective,
+	exts []string,
+	dirsOnly bool,
+	toComplete string,
+) ([]string, cobra.ShellCompDirective) {
+	directive &^= cobra.ShellCompDirectiveFilterFileExt | cobra.ShellCompDirectiveFilterDirs
+	if len(completions) == 0 {
+		switch {
+		case dirsOnly:
+			return nil, directive&^cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveFilterDirs
+		case len(exts) > 0:
+			return exts, directive&^cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveFilterFileExt
+		default:
+			return nil, directive &^ cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	files, hasDirs := localFileCompletions(exts, dirsOnly, toComplete)
+	if hasDirs {
+		// Allow the user to continue into the directory
+		directive |= cobra.ShellCompDirectiveNoSpace
+	}
+	return append(completions, files...), directive | cobra.ShellCompDirectiveNoFileComp
+}
+
+// localFileCompletions returns the names of the files in the directory denoted by toComplete that start with
+// its last element and match the given extensions. Directories are always included, with a trailing separator.
+func localFileCompletions(exts []string, dirsOnly bool, toComplete string) (files []string, hasDirs bool) {
+	dir, prefix := filepath.Split(toComplete)
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	}
+	entries, err := os.ReadDir(listDir)
+	if err != nil {
+		return nil, false
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		switch {
+		case e.IsDir():
+			files = append(files, dir+name+string(filepath.Separator))
+			hasDirs = true
+		case !dirsOnly && hasExtension(name, exts):
+			files = append(files, dir+name)
+		}
+	}
+	return files, hasDirs
+}
+
+func hasExtension(name string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	for _, e := range exts {
+		if ext == strings.TrimPrefix(e, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeFileCompletions(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.yaml", "b.yml", "c.json", ".hidden.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+	toComplete := dir + string(filepath.Separator)
+	in := func(names ...string) []string {
+		for i, n := range names {
+			names[i] = toComplete + n
+		}
+		return names
+	}
+	sep := string(filepath.Separator)
+
+	tests := []struct {
+		name            string
+		completions     []string
+		directive       cobra.ShellCompDirective
+		exts            []string
+		dirsOnly        bool
+		wantCompletions []string
+		wantDirective   cobra.ShellCompDirective
+	}{
+		{
+			name:            "extensions only, shell completes files",
+			directive:       cobra.ShellCompDirectiveNoFileComp,
+			exts:            []string{"yaml", "yml"},
+			wantCompletions: []string{"yaml", "yml"},
+			wantDirective:   cobra.ShellCompDirectiveFilterFileExt,
+		},
+		{
+			name:          "dirs only, shell completes directories",
+			directive:     cobra.ShellCompDirectiveNoSpace,
+			dirsOnly:      true,
+			wantDirective: cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveFilterDirs,
+		},
+		{
+			name:          "no filter, shell completes all files",
+			directive:     cobra.ShellCompDirectiveNoFileComp,
+			wantDirective: cobra.ShellCompDirectiveDefault,
+		},
+		{
+			name:            "merged with extensions",
+			completions:     []string{"svc"},
+			directive:       cobra.ShellCompDirectiveDefault,
+			exts:            []string{"yaml", ".yml"},
+			wantCompletions: append([]string{"svc"}, in("a.yaml", "b.yml", "sub"+sep)...),
+			wantDirective:   cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace,
+		},
+		{
+			name:            "merged with dirs only",
+			completions:     []string{"svc"},
+			directive:       cobra.ShellCompDirectiveNoFileComp,
+			dirsOnly:        true,
+			wantCompletions: append([]string{"svc"}, in("sub"+sep)...),
+			wantDirective:   cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace,
+		},
+		{
+			name:            "merged with all files",
+			completions:     []string{"svc"},
+			directive:       cobra.ShellCompDirectiveNoSpace,
+			wantCompletions: append([]string{"svc"}, in("a.yaml", "b.yml", "c.json", "sub"+sep)...),
+			wantDirective:   cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace,
+		},
+		{
+			name:            "filter directives from the daemon are dropped",
+			completions:     []string{"svc"},
+			directive:       cobra.ShellCompDirectiveFilterFileExt,
+			exts:            []string{"json"},
+			wantCompletions: append([]string{"svc"}, in("c.json", "sub"+sep)...),
+			wantDirective:   cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completions, directive := mergeFileCompletions(tt.completions, tt.directive, tt.exts, tt.dirsOnly, toComplete)
+			assert.Equal(t, tt.wantCompletions, completions)
+			assert.Equal(t, tt.wantDirective, directive)
+		})
+	}
+}
+
+func Test_localFileCompletions(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"app.yaml", "api.yaml", "other.yaml", ".hidden.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o600))
+	}
+	prefix := dir + string(filepath.Separator)
+
+	files, hasDirs := localFileCompletions([]string{"yaml"}, false, prefix+"ap")
+	assert.False(t, hasDirs)
+	assert.Equal(t, []string{prefix + "api.yaml", prefix + "app.yaml"}, files)
+
+	files, _ = localFileCompletions([]string{"yaml"}, false, prefix+".h")
+	assert.Equal(t, []string{prefix + ".hidden.yaml"}, files)
+
+	files, _ = localFileCompletions(nil, false, filepath.Join(dir, "missing", "x"))
+	assert.Empty(t, files)
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxCoalescedOutput is the number of buffered bytes that makes an outputCoalescer write them right away.
+const maxCoalescedOutput = 32 * 1024
+
+// outputCoalescer buffers what's written to several writers, typically stdout and stderr, and writes it to
+// them in larger chunks. Buffered output is written within the interval, and the order of the writes
+// across the writers is retained, because the buffer is written as soon as another writer is used.
+type outputCoalescer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	buf      bytes.Buffer
+	target   io.Writer
+	timer    *time.Timer
+	err      error
+}
+
+func newOutputCoalescer(interval time.Duration) *outputCoalescer {
+	return &outputCoalescer{interval: interval}
+}
+
+// writer returns an io.Writer that writes to w through the coalescer.
+func (oc *outputCoalescer) writer(w io.Writer) io.Writer {
+	return &coalescedWriter{oc: oc, w: w}
+}
+
+// flush writes what's buffered, and returns the first error that a write has returned.
+func (oc *outputCoalescer) flush() error {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	return oc.flushLocked()
+}
+
+func (oc *outputCoalescer) flushLocked() error {
+	if oc.timer != nil {
+		oc.timer.Stop()
+		oc.timer = nil
+	}
+	if oc.err == nil && oc.buf.Len() > 0 {
+		_, oc.err = oc.target.Write(oc.buf.Bytes())
+	}
+	oc.buf.Reset()
+	return oc.err
+}
+
+type coalescedWriter struct {
+	oc *outputCoalescer
+	w  io.Writer
+}
+
+// Write buffers p. An error returned by an earlier write of buffered output is returned, because that
+// write happens after the Write call that buffered the output has returned.
+func (cw *coalescedWriter) Write(p []byte) (int, error) {
+	oc := cw.oc
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.target != cw.w {
+		if err := oc.flushLocked(); err != nil {
+			return 0, err
+		}
+		oc.target = cw.w
+	}
+	if oc.err != nil {
+		return 0, oc.err
+	}
+	oc.buf.Write(p)
+	if oc.buf.Len() >= maxCoalescedOutput {
+		if err := oc.flushLocked(); err != nil {
+			return 0, err
+		}
+	} else if oc.timer == nil {
+		oc.timer = time.AfterFunc(oc.interval, func() { _ = oc.flush() })
+	}
+	return len(p), nil
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// writeLog records the writes made to several streamWriters, in order.
+type writeLog struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (l *writeLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.writes...)
+}
+
+type streamWriter struct {
+	log  *writeLog
+	name string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	w.log.writes = append(w.log.writes, w.name+":"+string(p))
+	w.log.mu.Unlock()
+	return len(p), nil
+}
+
+func TestOutputCoalescer_order(t *testing.T) {
+	l := &writeLog{}
+	oc := newOutputCoalescer(time.Hour)
+	stdout, stderr := oc.writer(streamWriter{l, "out"}), oc.writer(streamWriter{l, "err"})
+	for _, w := range []struct {
+		w    io.Writer
+		data string
+	}{{stdout, "a"}, {stdout, "b"}, {stderr, "c"}, {stderr, "d"}, {stdout, "e"}, {stderr, "f"}} {
+		n, err := w.w.Write([]byte(w.data))
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+	assert.Equal(t, []string{"out:ab", "err:cd", "out:e"}, l.get())
+	require.NoError(t, oc.flush())
+	assert.Equal(t, []string{"out:ab", "err:cd", "out:e", "err:f"}, l.get())
+	require.NoError(t, oc.flush())
+	assert.Len(t, l.get(), 4)
+}
+
+func TestOutputCoalescer_interval(t *testing.T) {
+	l := &writeLog{}
+	oc := newOutputCoalescer(20 * time.Millisecond)
+	w := oc.writer(streamWriter{l, "out"})
+	for i := 0; i < 100; i++ {
+		_, err := w.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return len(l.get()) == 1 }, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, "out:"+strings.Repeat("x", 100), l.get()[0])
+}
+
+func TestOutputCoalescer_size(t *testing.T) {
+	l := &writeLog{}
+	oc := newOutputCoalescer(time.Hour)
+	w := oc.writer(streamWriter{l, "out"})
+	chunk := strings.Repeat("x", maxCoalescedOutput/2)
+	for i := 0; i < 3; i++ {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"out:" + chunk + chunk}, l.get())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestOutputCoalescer_error(t *testing.T) {
+	oc := newOutputCoalescer(time.Hour)
+	w := oc.writer(failingWriter{})
+	_, err := w.Write([]byte("lost"))
+	require.NoError(t, err)
+	require.EqualError(t, oc.flush(), "broken pipe")
+	_, err = w.Write([]byte("more"))
+	require.EqualError(t, err, "broken pipe")
+}
+
+func TestRunRemoteCommand_coalesceOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results = make(chan *connector.StreamResult, 100)
+	for i := 0; i < 10; i++ {
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("o")}}
+	}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("e"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("last\n")}}
+	close(cs.results)
+
+	l := &writeLog{}
+	rc := RemoteCommand{
+		Args:           []string{"flood"},
+		Stdout:         streamWriter{l, "out"},
+		Stderr:         streamWriter{l, "err"},
+		CoalesceOutput: time.Hour,
+	}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, []string{"out:oooooooooo", "err:e", "out:last\n"}, l.get())
+}
+
+func BenchmarkOutputCoalescer(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(b, err)
+	defer devNull.Close()
+	line := []byte("a line of output from the remote command\n")
+
+	b.Run("direct", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = devNull.Write(line)
+		}
+	})
+	b.Run("coalesced", func(b *testing.B) {
+		oc := newOutputCoalescer(50 * time.Millisecond)
+		w := oc.writer(devNull)
+		for i := 0; i < b.N; i++ {
+			_, _ = w.Write(line)
+		}
+		_ = oc.flush()
+	})
+}
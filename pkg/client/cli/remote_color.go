@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"io"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// ColorMode governs the colors of the output that a remote command writes to stdout.
+type ColorMode string
+
+const (
+	// ColorAuto strips the colors unless stdout is a terminal and the NO_COLOR environment variable is empty.
+	ColorAuto ColorMode = "auto"
+
+	// ColorAlways keeps the colors, regardless of NO_COLOR.
+	ColorAlways ColorMode = "always"
+
+	// ColorNever strips the colors.
+	ColorNever ColorMode = "never"
+)
+
+// parseColorMode returns the ColorMode with the given name.
+func parseColorMode(s string) (ColorMode, error) {
+	switch m := ColorMode(strings.ToLower(s)); m {
+	case ColorAuto, ColorAlways, ColorNever:
+		return m, nil
+	default:
+		return "", errcat.User.Newf("invalid --color %q, must be 'auto', 'always', or 'never'", s)
+	}
+}
+
+// strips returns true if the colors must be stripped from stdout. The empty mode keeps them.
+func (m ColorMode) strips(stdoutIsTerminal, noColor bool) bool {
+	switch m {
+	case ColorNever:
+		return true
+	case ColorAuto:
+		return !stdoutIsTerminal || noColor
+	default:
+		return false
+	}
+}
+
+// noColor returns true if the remote command must be told not to use colors, given the NO_COLOR of the
+// environment. ColorAlways overrides NO_COLOR.
+func (m ColorMode) noColor(noColor bool) bool {
+	switch m {
+	case ColorNever:
+		return true
+	case ColorAlways:
+		return false
+	default:
+		return noColor
+	}
+}
+
+const (
+	asciiEsc = 0x1b
+
+	// maxEscapeSequence is the number of bytes of an escape sequence that colorStripper holds back. Longer
+	// sequences are written as is.
+	maxEscapeSequence = 64
+)
+
+// colorStripper is an io.Writer that removes the SGR escape sequences, which select colors and other graphic
+// renditions, from what's written to it. Other escape sequences, such as those that move the cursor, are
+// kept. An escape sequence that is split between two writes is held back until it's complete, or until
+// flush is called.
+type colorStripper struct {
+	w   io.Writer
+	seq []byte
+	buf []byte
+}
+
+func (cs *colorStripper) Write(p []byte) (int, error) {
+	out := cs.buf[:0]
+	plain := func(b byte) {
+		if b == asciiEsc {
+			cs.seq = append(cs.seq, b)
+		} else {
+			out = append(out, b)
+		}
+	}
+	for _, b := range p {
+		switch {
+		case len(cs.seq) == 0:
+			plain(b)
+		case len(cs.seq) == 1 && b == '[':
+			cs.seq = append(cs.seq, b)
+		case len(cs.seq) > 1 && b >= 0x20 && b <= 0x3f && len(cs.seq) < maxEscapeSequence:
+			// Parameter or intermediate byte of a control sequence
+			cs.seq = append(cs.seq, b)
+		case len(cs.seq) > 1 && b == 'm':
+			// The SGR sequence is dropped
+			cs.seq = cs.seq[:0]
+		case len(cs.seq) > 1 && b >= 0x40 && b <= 0x7e:
+			out = append(append(out, cs.seq...), b)
+			cs.seq = cs.seq[:0]
+		default:
+			// Not a control sequence, or one that is too long
+			out = append(out, cs.seq...)
+			cs.seq = cs.seq[:0]
+			plain(b)
+		}
+	}
+	cs.buf = out[:0]
+	if len(out) > 0 {
+		if _, err := cs.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush writes what's held back, even though it isn't a complete escape sequence.
+func (cs *colorStripper) flush() error {
+	if len(cs.seq) == 0 {
+		return nil
+	}
+	_, err := cs.w.Write(cs.seq)
+	cs.seq = cs.seq[:0]
+	return err
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_colorStripper(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"plain", []string{"hello\n"}, "hello\n"},
+		{"colors", []string{"\x1b[31mred\x1b[0m and \x1b[1;4;38;5;208mbold\x1b[m\n"}, "red and bold\n"},
+		{"split", []string{"\x1b", "[3", "2mgreen\x1b[", "0m"}, "green"},
+		{"cursor movement kept", []string{"\x1b[2J\x1b[1;1H\x1b[31mx"}, "\x1b[2J\x1b[1;1Hx"},
+		{"not a control sequence", []string{"\x1b(B\x1b\x1b[31mx"}, "\x1b(B\x1bx"},
+		{"incomplete at end", []string{"x\x1b[31"}, "x\x1b[31"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cs := &colorStripper{w: &buf}
+			for _, w := range tt.writes {
+				n, err := cs.Write([]byte(w))
+				require.NoError(t, err)
+				assert.Equal(t, len(w), n)
+			}
+			require.NoError(t, cs.flush())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestColorMode(t *testing.T) {
+	tests := []struct {
+		mode        ColorMode
+		terminal    bool
+		noColor     bool
+		wantStrips  bool
+		wantNoColor bool
+	}{
+		{ColorAuto, true, false, false, false},
+		{ColorAuto, true, true, true, true},
+		{ColorAuto, false, false, true, false},
+		{ColorAlways, false, true, false, false},
+		{ColorNever, true, false, true, true},
+		{"", false, true, false, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.wantStrips, tt.mode.strips(tt.terminal, tt.noColor), "%+v", tt)
+		assert.Equal(t, tt.wantNoColor, tt.mode.noColor(tt.noColor), "%+v", tt)
+	}
+}
+
+func Test_parseColorMode(t *testing.T) {
+	m, err := parseColorMode("NEVER")
+	require.NoError(t, err)
+	assert.Equal(t, ColorNever, m)
+	for _, m := range []ColorMode{ColorAuto, ColorAlways, ColorNever} {
+		got, err := parseColorMode(string(m))
+		require.NoError(t, err)
+		assert.Equal(t, m, got)
+	}
+	_, err = parseColorMode("sometimes")
+	assert.ErrorContains(t, err, "must be 'auto', 'always', or 'never'")
+}
@@ -0,0 +1,1116 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// RemoteCommand is a command that is executed by the user daemon. Its stdin, stdout, and stderr
+// are streamed between the caller and the daemon.
+type RemoteCommand struct {
+	// Args is the command line of the command, starting with the name of the command.
+	Args []string
+
+	// Cwd is the working directory that the command will use.
+	Cwd string
+
+	// Env contains the environment variables that are forwarded to the command. No other variables
+	// of the caller's environment are forwarded.
+	Env map[string]string
+
+	// Locale contains the locale and time zone variables of the caller's environment, see localeEnv. They're
+	// added to the environment of the command, unless Env has variables with the same names.
+	Locale map[string]string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// HandleInterrupts enables the forwarding of interrupt signals to the remote command. Library
+	// callers that manage their own signals should leave this false and cancel the context instead.
+	HandleInterrupts bool
+
+	// ForwardWindowSize enables the forwarding of the size of the terminal that Stdout is attached
+	// to, so that the remote command can adapt its output to it.
+	ForwardWindowSize bool
+
+	// TTY makes the command run in a pseudo-terminal allocated by the user daemon. Stdin must be a
+	// terminal. It's put in raw mode while the command runs.
+	TTY bool
+
+	// Raw puts the terminal that Stdin is attached to into raw mode while the command runs, so that all
+	// input, including control characters such as <CTRL>-C and <CTRL>-D, is forwarded to the command
+	// unmodified. It has no effect when Stdin isn't a terminal.
+	Raw bool
+
+	// Timeout is the maximum time that the command is allowed to run. When it expires, the command is
+	// cancelled the same way as when it's interrupted, and Run returns an errcat.Timeout error. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// Retries is the number of times that the start of the command is retried when it fails due to
+	// a transient error, such as a restart of the user daemon.
+	Retries int
+
+	// KeepAlive is the interval at which a keepalive message is sent to the remote command when no other
+	// message has been sent, so that an idle stream isn't dropped by intermediate timeouts. Zero means that
+	// no keepalive messages are sent.
+	KeepAlive time.Duration
+
+	// Quiet suppresses the diagnostics that the client itself writes to Stderr. The errors are still
+	// returned, and the output of the remote command is not affected.
+	Quiet bool
+
+	// NoDiagnostics suppresses the diagnostics, such as progress updates, that the remote command writes
+	// on the diagnostic channel. They are written to Stderr otherwise.
+	NoDiagnostics bool
+
+	// JSONOutput makes Run capture the output of the command and write it, together with the command's
+	// exit code and error, as a single JSON object to Stdout.
+	JSONOutput bool
+
+	// RawOutput guarantees that the output of the command is written to Stdout and Stderr byte for byte, as
+	// it arrives. The client writes no diagnostics of its own, and the error that Run returns once the command
+	// has started is silent (see errcat.Silent), so that the outcome of the command is conveyed by the exit
+	// code only. It can't be combined with JSONOutput or RuneAligned.
+	RawOutput bool
+
+	// Timing, when set, receives the durations measured while the command runs.
+	Timing *Timing
+
+	// Detach makes Run return as soon as the user daemon has started the command, leaving it running without
+	// a client. The id of the command, which can be used to attach to it or cancel it, is written to Stdout.
+	// The command's stdin is closed, and the last part of its output is kept until a client attaches.
+	Detach bool
+
+	// AttachID is the id of a detached command that Run attaches to instead of starting a new command. The
+	// output that the command produced while it was detached is written first, and Run then behaves as if it
+	// had started the command, except that Stdin isn't forwarded. Args, Cwd, and Env are ignored.
+	AttachID string
+
+	// Compress makes the messages of the stream between the client and the user daemon gzip compressed. It
+	// saves bandwidth for commands that produce a lot of textual output, but adds latency to interactive ones.
+	Compress bool
+
+	// RuneAligned makes Run hold back a trailing partial UTF-8 encoded character in the output of the command
+	// until the rest of it arrives, so that each write to Stdout and Stderr contains whole characters. It's off
+	// by default, because it can delay interactive output that isn't UTF-8 encoded.
+	RuneAligned bool
+
+	// OnError is a command line that is run locally when the command fails, e.g. to clean up after it. The
+	// exit code of the command is passed in the TELEPRESENCE_EXIT_CODE environment variable, and the output
+	// of the hook is written to Stderr. It's killed if it doesn't finish within OnErrorTimeout.
+	OnError string
+
+	// CoalesceOutput makes Run buffer the output of the command, and write it to Stdout and Stderr in fewer
+	// and larger writes, no later than CoalesceOutput after it arrived. The order of the output across Stdout
+	// and Stderr is retained. It speeds up commands that flood a slow pipe or file with small writes. It has no
+	// effect when Stdout is a terminal, where each part of the output is written as it arrives, so that a prompt
+	// without a trailing newline, such as "Password: ", is visible right away.
+	CoalesceOutput time.Duration
+
+	// Color governs the ANSI colors of the output that the command writes to Stdout, see ColorMode. The colors
+	// are kept when it's empty. It has no effect on RawOutput.
+	Color ColorMode
+
+	// TeeStdout and TeeStderr, unless nil, receive a copy of what's written to Stdout and Stderr respectively,
+	// e.g. to keep a log of a long-running command while its output is shown. Progress isn't copied.
+	TeeStdout io.Writer
+	TeeStderr io.Writer
+
+	// Wait makes the command wait for a slot when the user daemon already runs its maximum number of commands,
+	// see Grpc.MaxConcurrentCommands. The command fails right away otherwise. The wait counts toward Timeout.
+	Wait bool
+
+	// MaxOutput is the maximum number of bytes that the command may write to Stdout and Stderr combined. The
+	// output is truncated, and the command is cancelled the same way as when it's interrupted, once it writes
+	// more. Zero means no limit.
+	MaxOutput int64
+}
+
+// RunRemoteCommand runs the command described by args (starting with the name of the command) using
+// the given user daemon and waits for it to finish. Signals are not handled. Cancelling the context
+// will terminate the command.
+func RunRemoteCommand(ctx context.Context, userD connector.ConnectorClient, args []string, in io.Reader, out, errw io.Writer, cwd string) error {
+	rc := RemoteCommand{
+		Args:   args,
+		Cwd:    cwd,
+		Stdin:  in,
+		Stdout: out,
+		Stderr: errw,
+	}
+	return rc.Run(ctx, userD)
+}
+
+// CaptureRemoteCommand runs the command described by args like RunRemoteCommand does, and returns what the
+// command wrote to stdout and stderr together with the error that it ended with. The exit code of the command
+// is conveyed by that error, see errcat.ExitCode. It's intended for tests and other programs that embed
+// remote commands.
+func CaptureRemoteCommand(ctx context.Context, userD connector.ConnectorClient, args []string, in io.Reader, cwd string) (stdout, stderr []byte, exitErr error) {
+	var outBuf, errBuf bytes.Buffer
+	exitErr = RunRemoteCommand(ctx, userD, args, in, &outBuf, &errBuf, cwd)
+	return outBuf.Bytes(), errBuf.Bytes(), exitErr
+}
+
+// Run executes the remote command using the given user daemon and waits for it to finish.
+func (rc *RemoteCommand) Run(ctx context.Context, userD connector.ConnectorClient) error {
+	if rc.RawOutput && (rc.JSONOutput || rc.RuneAligned) {
+		return errcat.User.New("raw output cannot be combined with JSON output or rune alignment")
+	}
+	if rc.AttachID != "" && (rc.Detach || rc.TTY) {
+		return errcat.User.New("an attached command cannot be detached or use a pseudo-terminal")
+	}
+	if rc.Detach {
+		if rc.TTY || rc.JSONOutput {
+			return errcat.User.New("a detached command cannot use a pseudo-terminal or JSON output")
+		}
+		return rc.runDetached(ctx, userD)
+	}
+	if rc.JSONOutput {
+		if rc.TTY {
+			return errcat.User.New("a pseudo-terminal cannot be used together with JSON output")
+		}
+		return rc.runWithJSONOutput(ctx, userD)
+	}
+	chunkSize, err := stdinChunkSize(ctx)
+	if err != nil {
+		return err
+	}
+	stdin := rc.Stdin
+	if stdin == nil || rc.AttachID != "" {
+		// The stdin of an attached command was closed when it was detached
+		stdin = eofReader{}
+	}
+	stdout, stderr := rc.Stdout, rc.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	if rc.TTY || (rc.Raw && isTerminal(rc.Stdin)) {
+		// The deferred restore also runs when something panics, so the terminal is never left in raw mode
+		restore, err := makeRaw(rc.Stdin)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	// The hook runs even when the command was cancelled
+	hookCtx, hookStderr := ctx, stderr
+
+	// Use a graceful termination period
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rc.Timing.begin()
+	defer rc.Timing.end()
+	cmdStream, err := rc.startStream(ctx, userD, stderr)
+	rc.Timing.started()
+	if err != nil {
+		return err
+	}
+
+	// Writes to a closed stdout or stderr must fail with EPIPE rather than kill the process, see
+	// stdoutAndStderrPump. The signal is caught rather than ignored so that the disposition isn't
+	// inherited by the processes that the client starts.
+	pipeCh := make(chan os.Signal, 1)
+	signal.Notify(pipeCh, syscall.SIGPIPE)
+	defer signal.Stop(pipeCh)
+
+	// Start all pumps, wait for the stdout/stderr pump to finish
+	window := newStdinWindow(StdinWindowSize)
+	if rc.AttachID == "" {
+		// Stdin is held back until the command has started
+		window.started = make(chan struct{})
+		window.queued = make(chan struct{})
+	}
+	prompts := &stdinPrompts{}
+	go stdinPump(ctx, cmdStream, stdin, chunkSize, window, prompts)
+	// The output pump tells the interrupt pump that the command is still producing output
+	activity := make(chan struct{}, 1)
+	if rc.HandleInterrupts {
+		go interruptPump(ctx, cmdStream, rc.escalatedCancel(cancel, stderr, HardCancelGrace), HardCancelGrace, activity)
+	}
+	if rc.ForwardWindowSize {
+		go windowSizePump(ctx, cmdStream, stdout)
+	}
+	if rc.KeepAlive > 0 {
+		go keepAlivePump(ctx, cmdStream, rc.KeepAlive)
+	}
+	var expired chan struct{}
+	if rc.Timeout > 0 {
+		expired = make(chan struct{})
+		go timeoutPump(ctx, cmdStream, cancel, rc.Timeout, HardCancelGrace, expired, window)
+	}
+	// The copies receive exactly what is written to stdout and stderr
+	if rc.TeeStdout != nil {
+		stdout = io.MultiWriter(stdout, rc.TeeStdout)
+	}
+	if rc.TeeStderr != nil {
+		stderr = io.MultiWriter(stderr, rc.TeeStderr)
+	}
+	// The output that is held back is written once the command has ended, in the order of the functions
+	var flushOutput []func() error
+	if rc.CoalesceOutput > 0 && !isTerminal(rc.Stdout) {
+		oc := newOutputCoalescer(rc.CoalesceOutput)
+		stdout, stderr = oc.writer(stdout), oc.writer(stderr)
+		flushOutput = append(flushOutput, oc.flush)
+	}
+	if rc.RuneAligned {
+		// Whatever the rune writers hold back is written as is
+		ro, re := &runeWriter{w: stdout}, &runeWriter{w: stderr}
+		flushOutput = append([]func() error{ro.flush, re.flush}, flushOutput...)
+		stdout, stderr = ro, re
+	}
+	if !rc.RawOutput && rc.Color.strips(isTerminal(rc.Stdout), noColor(ctx)) {
+		// The colors are stripped before anything is held back
+		cs := &colorStripper{w: stdout}
+		flushOutput = append([]func() error{cs.flush}, flushOutput...)
+		stdout = cs
+	}
+	// The diagnostics aren't written by the command, so they don't count toward its output limit
+	diagnostics := stderr
+	var limit *outputLimit
+	if rc.MaxOutput > 0 {
+		// The output is counted as it arrives, before anything is stripped or held back
+		limit = &outputLimit{max: rc.MaxOutput}
+		stdout, stderr = limit.writer(stdout), limit.writer(stderr)
+	}
+	var progress *progressRenderer
+	if rc.NoDiagnostics {
+		diagnostics = io.Discard
+	} else {
+		// The progress is written as is, because it's replaced rather than added to
+		progress = newProgressRenderer(hookStderr, isTerminal(rc.Stderr))
+	}
+	onQueued := func() {
+		rc.diagnostic(hookStderr, "waiting for an available slot...\n")
+	}
+	err = stdoutAndStderrPump(ctx, cmdStream, cancel, stdout, stderr, diagnostics, window, prompts, progress, rc.Timing, onQueued, activity)
+	for _, flush := range flushOutput {
+		_ = flush()
+	}
+	if limit != nil && limit.exceeded() {
+		rc.diagnostic(hookStderr, "output truncated: the command wrote more than %d bytes\n", rc.MaxOutput)
+	}
+	select {
+	case <-expired:
+		err = errcat.Timeout.Newf("the command did not finish within %s", rc.Timeout)
+	default:
+	}
+	if err != nil && rc.OnError != "" {
+		runOnErrorHook(hookCtx, rc.OnError, errcat.ExitCode(err), hookStderr)
+	}
+	if rc.RawOutput {
+		err = errcat.Silent(err)
+	}
+	return err
+}
+
+// runDetached starts the command and writes its id to Stdout once the user daemon has detached it.
+func (rc *RemoteCommand) runDetached(ctx context.Context, userD connector.ConnectorClient) error {
+	stdout, stderr := rc.Stdout, rc.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+
+	// Cancelling the context terminates a command that wasn't detached
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmdStream, err := rc.startStream(ctx, userD, stderr)
+	if err != nil {
+		return err
+	}
+	defer cmdStream.CloseSend()
+	for {
+		sr, err := cmdStream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errcat.Unknown.New("the user daemon ended the command without detaching it")
+			}
+			return err
+		}
+		switch {
+		case sr.DetachedId != "":
+			_, err = fmt.Fprintln(stdout, sr.DetachedId)
+			return err
+		case sr.Final:
+			if err = errcat.FromResult(sr.Data); err == nil {
+				err = errcat.Unknown.New("the user daemon ended the command without detaching it")
+			}
+			return err
+		case sr.StdinAck != nil:
+		case sr.Queued:
+			rc.diagnostic(stderr, "waiting for an available slot...\n")
+		default:
+			// Output arrives before the id when the user daemon doesn't know how to detach a command
+			return errcat.User.New("the user daemon doesn't support detached commands")
+		}
+	}
+}
+
+// diagnostic writes a message that originates from the client, as opposed to the remote command, to stderr
+// unless the command is quiet or its output is raw.
+func (rc *RemoteCommand) diagnostic(stderr io.Writer, format string, args ...any) {
+	if !(rc.Quiet || rc.RawOutput) {
+		fmt.Fprintf(stderr, format, args...)
+	}
+}
+
+// escalatedCancel returns the cancel function that interruptPump calls when the remote command hasn't terminated
+// within the grace period of an interrupt. It tells the user why the command is terminated abruptly before it
+// calls cancel. The cancel function is returned as is when the hard cancel is immediate, because there's nothing
+// to escalate.
+func (rc *RemoteCommand) escalatedCancel(cancel context.CancelFunc, stderr io.Writer, grace time.Duration) context.CancelFunc {
+	if grace <= 0 {
+		return cancel
+	}
+	return func() {
+		rc.diagnostic(stderr, "remote command did not respond to interrupt within %s; forcing termination\n", grace)
+		cancel()
+	}
+}
+
+// RetryBackoff is the delay before the first retry of a failed command start. The delay is doubled
+// for each subsequent retry.
+var RetryBackoff = 200 * time.Millisecond
+
+// startStream starts the RunCommand stream and sends the command on it. A start that fails due to a
+// transient error is retried. Retrying is safe because no stdin has been sent at this point.
+func (rc *RemoteCommand) startStream(ctx context.Context, userD connector.ConnectorClient, stderr io.Writer) (*lockedSendStream, error) {
+	backoff := RetryBackoff
+	for attempt := 0; ; attempt++ {
+		cmdStream, msg, err := rc.tryStartStream(ctx, userD)
+		if err == nil {
+			return cmdStream, nil
+		}
+		if attempt >= rc.Retries || !isTransient(err) {
+			rc.diagnostic(stderr, "%s: %v\n", msg, err)
+			return nil, err
+		}
+		dlog.Debugf(ctx, "%s: %v, retrying in %s", msg, err, backoff)
+		select {
+		case <-ctx.Done():
+			rc.diagnostic(stderr, "%s: %v\n", msg, err)
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (rc *RemoteCommand) tryStartStream(ctx context.Context, userD connector.ConnectorClient) (*lockedSendStream, string, error) {
+	var opts []grpc.CallOption
+	if rc.Compress {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	rcc, err := userD.RunCommand(ctx, opts...)
+	if err != nil {
+		return nil, "failed start command", err
+	}
+	rq := &connector.RunCommandRequest{
+		COrD: &connector.RunCommandRequest_Command_{Command: &connector.RunCommandRequest_Command{
+			OsArgs:             rc.Args,
+			Cwd:                rc.Cwd,
+			StdinFlowControl:   !rc.Detach,
+			Tty:                rc.TTY,
+			Env:                rc.Env,
+			Locale:             rc.Locale,
+			StdoutIsTerminal:   isTerminal(rc.Stdout),
+			NoColor:            rc.Color.noColor(noColor(ctx)),
+			Detach:             rc.Detach,
+			Prompts:            !rc.Detach,
+			Progress:           !rc.Detach,
+			StartAck:           !rc.Detach,
+			Wait:               rc.Wait,
+			ClientVersion:      client.Version(),
+			ClientCapabilities: clientCapabilities,
+		}},
+	}
+	if rc.AttachID != "" {
+		rq = &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Attach{Attach: rc.AttachID}}
+	}
+	err = rcc.Send(rq)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// The stream was terminated. The real cause is obtained from Recv
+			if _, rErr := rcc.Recv(); rErr != nil && !errors.Is(rErr, io.EOF) {
+				err = rErr
+			}
+		}
+		return nil, "failed to send", err
+	}
+	return &lockedSendStream{Connector_RunCommandClient: rcc, lastSend: time.Now()}, "", nil
+}
+
+// isTransient returns true if err indicates that the user daemon is temporarily unavailable.
+func isTransient(err error) bool {
+	return status.Code(err) == codes.Unavailable || errors.Is(err, io.EOF)
+}
+
+// lockedSendStream serializes the calls to Send. Several pumps send on the same stream, and
+// a gRPC stream doesn't permit concurrent sends.
+type lockedSendStream struct {
+	connector.Connector_RunCommandClient
+	mu       sync.Mutex
+	lastSend time.Time
+}
+
+func (s *lockedSendStream) Send(rq *connector.RunCommandRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSend = time.Now()
+	return s.Connector_RunCommandClient.Send(rq)
+}
+
+// idle returns the time since the last message was sent.
+func (s *lockedSendStream) idle() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastSend)
+}
+
+// keepAlivePump sends a keepalive message on the stream each time it has been idle for the given interval.
+func keepAlivePump(ctx context.Context, cmdStream *lockedSendStream, interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if idle := cmdStream.idle(); idle < interval {
+			// Something was sent since the last check
+			timer.Reset(interval - idle)
+			continue
+		}
+		if err := cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_KeepAlive{KeepAlive: true}}); err != nil {
+			if ctx.Err() == nil {
+				dlog.Errorf(ctx, "failed to send keepalive: %v\n", err)
+			}
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+// eofReader is used as stdin when no stdin is provided.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// StdinChunkSize is the maximum number of bytes that will be forwarded to a remote command
+// in one message. It can be overridden using the TELEPRESENCE_STDIN_CHUNK environment variable.
+var StdinChunkSize = 1024
+
+// maxStdinChunkSize is the upper limit for the stdin chunk size. Larger values are capped.
+const maxStdinChunkSize = 1024 * 1024
+
+// stdinChunkSize returns the validated size of the buffer used when forwarding stdin.
+func stdinChunkSize(ctx context.Context) (int, error) {
+	size := StdinChunkSize
+	if env := client.GetEnv(ctx); env != nil {
+		if s := env.Get("TELEPRESENCE_STDIN_CHUNK"); s != "" {
+			var err error
+			if size, err = strconv.Atoi(s); err != nil {
+				return 0, errcat.User.Newf("invalid value for TELEPRESENCE_STDIN_CHUNK %q: %w", s, err)
+			}
+		}
+	}
+	if size <= 0 {
+		return 0, errcat.User.Newf("stdin chunk size must be greater than zero, was %d", size)
+	}
+	if size > maxStdinChunkSize {
+		size = maxStdinChunkSize
+	}
+	return size, nil
+}
+
+// StdinWindowSize is the maximum number of bytes that can be sent to a remote command's stdin
+// without being consumed by that command. Flow control is only in effect when the server
+// acknowledges consumed stdin.
+var StdinWindowSize = 1024 * 1024
+
+// stdinWindow keeps track of the number of stdin bytes that have been sent to a remote command
+// but not yet consumed by it.
+type stdinWindow struct {
+	sync.Mutex
+	size     uint64
+	sent     uint64
+	consumed uint64
+	enabled  bool
+	acked    chan struct{}
+
+	// started, unless nil, is closed when the command has started. No stdin is sent before that.
+	started   chan struct{}
+	startOnce sync.Once
+
+	// queued, unless nil, is closed when the command waits for a slot in the user daemon. The start is awaited
+	// without a timeout from then on, because the user daemon evidently acknowledges it.
+	queued    chan struct{}
+	queueOnce sync.Once
+}
+
+// clientCapabilities are the capabilities that RemoteCommand tells the user daemon about, so that it can adapt
+// what it sends to the client.
+const clientCapabilities = uint64(connector.RunCommandRequest_Command_CAPABILITY_OUTPUT_CHANNELS |
+	connector.RunCommandRequest_Command_CAPABILITY_DIAGNOSTIC_CHANNEL)
+
+// StartAckTimeout is the maximum time that stdin is held back while waiting for the user daemon to tell that
+// the command has started. Stdin is sent when it expires, because user daemons that predate the start
+// acknowledgement never send it.
+var StartAckTimeout = 5 * time.Second
+
+// awaitStart blocks until the command has started, the timeout expires, or the context is cancelled.
+func (w *stdinWindow) awaitStart(ctx context.Context, timeout time.Duration) error {
+	if w == nil || w.started == nil {
+		return nil
+	}
+	tm := time.NewTimer(timeout)
+	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.started:
+	case <-w.queued:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.started:
+		}
+	case <-tm.C:
+		dlog.Debugf(ctx, "the user daemon didn't acknowledge the start of the command within %s", timeout)
+	}
+	return nil
+}
+
+// start records that the command has started.
+func (w *stdinWindow) start() {
+	if w != nil && w.started != nil {
+		w.startOnce.Do(func() { close(w.started) })
+	}
+}
+
+// queue records that the command waits for a slot.
+func (w *stdinWindow) queue() {
+	if w != nil && w.queued != nil {
+		w.queueOnce.Do(func() { close(w.queued) })
+	}
+}
+
+// isQueued returns true if the command waits for a slot and hasn't started yet.
+func (w *stdinWindow) isQueued() bool {
+	if w == nil || w.queued == nil {
+		return false
+	}
+	select {
+	case <-w.started:
+		return false
+	case <-w.queued:
+		return true
+	default:
+		return false
+	}
+}
+
+func newStdinWindow(size int) *stdinWindow {
+	return &stdinWindow{size: uint64(size), acked: make(chan struct{}, 1)}
+}
+
+func (w *stdinWindow) full() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.enabled && w.sent > w.consumed && w.sent-w.consumed >= w.size
+}
+
+// wait blocks until the window has room for more data or the context is cancelled.
+func (w *stdinWindow) wait(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	for w.full() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.acked:
+		}
+	}
+	return nil
+}
+
+func (w *stdinWindow) addSent(n int) {
+	if w != nil {
+		w.Lock()
+		w.sent += uint64(n)
+		w.Unlock()
+	}
+}
+
+// ack records the number of bytes consumed by the remote command. The first call enables the
+// flow control.
+func (w *stdinWindow) ack(consumed uint64) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.enabled = true
+	if consumed > w.consumed {
+		w.consumed = consumed
+	}
+	w.Unlock()
+	select {
+	case w.acked <- struct{}{}:
+	default:
+	}
+}
+
+// stdinPump forwards stdin to the command in chunks of at most chunkSize bytes, and tells the command when
+// stdin has reached its end. Input that answers the pending prompts is sent as replies to them instead.
+func stdinPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, stdin io.Reader, chunkSize int, window *stdinWindow, prompts *stdinPrompts) {
+	if window.awaitStart(ctx, StartAckTimeout) != nil {
+		return
+	}
+	rd := newCtxReader(ctx, stdin)
+	buf := make([]byte, chunkSize)
+	for ctx.Err() == nil {
+		if window.wait(ctx) != nil {
+			return
+		}
+		n, err := rd.Read(ctx, buf)
+		if n > 0 {
+			replies, data := prompts.route(buf[:n])
+			for _, reply := range replies {
+				if err := cmdStream.Send(reply); err != nil {
+					if ctx.Err() == nil {
+						dlog.Errorf(ctx, "failed to send the answer to a prompt: %v\n", err)
+					}
+					return
+				}
+			}
+			if len(data) > 0 {
+				if err = cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Data{Data: data}}); err != nil {
+					if ctx.Err() == nil {
+						dlog.Errorf(ctx, "failed to forward to stdin: %v\n", err)
+					}
+					return
+				}
+				window.addSent(len(data))
+			}
+		}
+		if err != nil {
+			for _, reply := range prompts.end() {
+				_ = cmdStream.Send(reply)
+			}
+			if errors.Is(err, io.EOF) {
+				// Let the command know that no more input will arrive
+				if err = cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_StdinClosed{StdinClosed: true}}); err != nil && ctx.Err() == nil {
+					dlog.Errorf(ctx, "failed to forward the end of stdin: %v\n", err)
+				}
+			} else if ctx.Err() == nil {
+				dlog.Errorf(ctx, "failed to read from stdin: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// ctxReader performs the reads of an io.Reader in a separate goroutine, so that a read can be abandoned when
+// a context is cancelled. An abandoned read is interrupted if the reader supports read deadlines. If it
+// doesn't, the goroutine remains blocked until the read completes.
+type ctxReader struct {
+	sync.Mutex
+	r           io.Reader
+	reqs        chan []byte
+	results     chan readResult
+	reading     bool
+	interrupted bool
+}
+
+type deadlineReader interface {
+	SetReadDeadline(time.Time) error
+}
+
+func newCtxReader(ctx context.Context, r io.Reader) *ctxReader {
+	cr := &ctxReader{r: r, reqs: make(chan []byte), results: make(chan readResult, 1)}
+	go cr.readLoop(ctx)
+	return cr
+}
+
+func (cr *ctxReader) readLoop(ctx context.Context) {
+	for {
+		var p []byte
+		select {
+		case <-ctx.Done():
+			return
+		case p = <-cr.reqs:
+		}
+		cr.Lock()
+		if ctx.Err() != nil {
+			cr.Unlock()
+			return
+		}
+		cr.reading = true
+		cr.Unlock()
+
+		n, err := cr.r.Read(p)
+
+		cr.Lock()
+		cr.reading = false
+		if cr.interrupted {
+			// Don't leave the deadline that interrupted the read behind
+			_ = cr.r.(deadlineReader).SetReadDeadline(time.Time{})
+		}
+		cr.Unlock()
+		cr.results <- readResult{n: n, err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads into p and returns when the read completes or when the context is cancelled. The
+// buffer must not be reused after a read that was abandoned.
+func (cr *ctxReader) Read(ctx context.Context, p []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case cr.reqs <- p:
+	}
+	select {
+	case r := <-cr.results:
+		return r.n, r.err
+	case <-ctx.Done():
+		cr.Lock()
+		if d, ok := cr.r.(deadlineReader); ok && cr.reading {
+			cr.interrupted = d.SetReadDeadline(time.Now()) == nil
+		}
+		cr.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// HardCancelGrace is the time that a remote command is given to terminate after it has been soft cancelled
+// by an interrupt. The command is hard cancelled when the grace period expires. A zero value means that the
+// hard cancel happens immediately and a negative value means that it never happens.
+var HardCancelGrace = 5 * time.Second
+
+// HardCancelMaxGrace is the longest time that a remote command is given to terminate after an interrupt, when
+// the grace period is restarted because the command still produces output.
+var HardCancelMaxGrace = 30 * time.Second
+
+// interruptPump forwards an interrupt to the remote command, see forwardInterrupt. A value that is received on
+// activity tells that the command still produces output.
+func interruptPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cancel context.CancelFunc, grace time.Duration, activity <-chan struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, remoteSignals...)
+	defer func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}()
+	forwardInterrupt(ctx, cmdStream, cancel, sigCh, grace, activity)
+}
+
+// remoteSignals are the signals that are forwarded to the remote command.
+var remoteSignals = append([]os.Signal{syscall.SIGHUP, syscall.SIGQUIT}, proc.SignalsToForward...)
+
+// forwardInterrupt waits for a signal on sigCh and forwards it to the remote command when it arrives. The
+// signal is followed by a hard cancel unless the command terminates within the grace period. The grace period
+// restarts each time a value is received on activity, because a command that still produces output is likely
+// shutting down cleanly, but it never extends beyond HardCancelMaxGrace.
+func forwardInterrupt(
+	ctx context.Context,
+	cmdStream connector.Connector_RunCommandClient,
+	cancel context.CancelFunc,
+	sigCh <-chan os.Signal,
+	grace time.Duration,
+	activity <-chan struct{},
+) {
+	select {
+	case <-ctx.Done():
+	case sig := <-sigCh:
+		if sig != nil {
+			sendSignal(ctx, cmdStream, cancel, grace, sig, activity)
+		}
+	}
+}
+
+// signalRequest returns the request that forwards the given signal to the remote command. An interrupt, or
+// a signal that has no corresponding RunCommandRequest_Signal, is forwarded as a soft cancel.
+func signalRequest(sig os.Signal) *connector.RunCommandRequest {
+	var rs connector.RunCommandRequest_Signal
+	switch sig {
+	case syscall.SIGHUP:
+		rs = connector.RunCommandRequest_SIGHUP
+	case syscall.SIGQUIT:
+		rs = connector.RunCommandRequest_SIGQUIT
+	case syscall.SIGTERM:
+		rs = connector.RunCommandRequest_SIGTERM
+	default:
+		return &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_SoftCancel{SoftCancel: true}}
+	}
+	return &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Signal_{Signal: rs}}
+}
+
+// timeoutPump closes expired and cancels the remote command when the given timeout expires before
+// the command terminates. A command that still waits for a slot is cancelled right away, because it has
+// nothing to terminate gracefully.
+func timeoutPump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cancel context.CancelFunc, timeout, grace time.Duration, expired chan<- struct{}, window *stdinWindow) {
+	tc, tCancel := context.WithTimeout(ctx, timeout)
+	defer tCancel()
+	<-tc.Done()
+	if ctx.Err() != nil {
+		// The command terminated before the timeout
+		return
+	}
+	close(expired)
+	if window.isQueued() {
+		cancel()
+		return
+	}
+	softCancel(ctx, cmdStream, cancel, grace)
+}
+
+// softCancel sends a soft cancel to the remote command. It's followed by a hard cancel unless the command
+// terminates within the grace period.
+func softCancel(ctx context.Context, cmdStream connector.Connector_RunCommandClient, cancel context.CancelFunc, grace time.Duration) {
+	sendSignal(ctx, cmdStream, cancel, grace, os.Interrupt, nil)
+}
+
+// sendSignal forwards the given signal to the remote command. It's followed by a hard cancel unless the command
+// terminates within the grace period. The grace period restarts on activity, unless activity is nil.
+func sendSignal(
+	ctx context.Context,
+	cmdStream connector.Connector_RunCommandClient,
+	cancel context.CancelFunc,
+	grace time.Duration,
+	sig os.Signal,
+	activity <-chan struct{},
+) {
+	// Only the output that the command produces after the signal counts
+	select {
+	case <-activity:
+	default:
+	}
+	err := cmdStream.Send(signalRequest(sig))
+	if err != nil {
+		if ctx.Err() == nil {
+			dlog.Errorf(ctx, "failed to send %s: %v\n", sig, err)
+		}
+		return
+	}
+	// Trigger "hard" cancel if needed.
+	switch {
+	case grace == 0:
+		cancel()
+	case grace > 0:
+		awaitTermination(ctx, cancel, grace, activity)
+	}
+}
+
+// awaitTermination calls cancel unless the context is cancelled within the grace period. The grace period
+// restarts each time a value is received on activity, but it never extends beyond HardCancelMaxGrace.
+func awaitTermination(ctx context.Context, cancel context.CancelFunc, grace time.Duration, activity <-chan struct{}) {
+	maxGrace := HardCancelMaxGrace
+	if maxGrace < grace {
+		maxGrace = grace
+	}
+	deadline := time.NewTimer(maxGrace)
+	defer deadline.Stop()
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(grace)
+		case <-timer.C:
+			cancel()
+			return
+		case <-deadline.C:
+			cancel()
+			return
+		}
+	}
+}
+
+// stdoutAndStderrPump writes the output from the remote command to stdout, stderr, and diagnostics, see
+// outputWriter. Structured output is handled by RemoteCommand.runWithJSONOutput, which captures what's
+// written here. The text of a prompt is written to stdout, and the prompt is handed to the stdinPump through
+// prompts. Progress is shown by the progress renderer, and cleared before other output is written. The
+// arrival of the first output is recorded in timing. The onQueued function, unless nil, is called when the user
+// daemon tells that the command waits for a slot. A value is sent on activity, unless it already holds one,
+// for each message that is received from the user daemon.
+//
+// A broken pipe, e.g. when the output is piped to "head", is a clean termination, and so is output that exceeds
+// the limit of the writers, see outputLimit. The remote command is soft cancelled so that it stops producing,
+// and the output that it produces in the meantime is discarded.
+func stdoutAndStderrPump(
+	ctx context.Context,
+	cmdStream connector.Connector_RunCommandClient,
+	cancel context.CancelFunc,
+	stdout, stderr, diagnostics io.Writer,
+	window *stdinWindow,
+	prompts *stdinPrompts,
+	progress *progressRenderer,
+	timing *Timing,
+	onQueued func(),
+	activity chan<- struct{},
+) error {
+	defer cmdStream.CloseSend()
+	defer func() {
+		_ = progress.clear()
+	}()
+	outputStopped := false
+	stopOutput := func(err error) bool {
+		if !isBrokenPipe(err) && !errors.Is(err, errOutputLimit) {
+			return false
+		}
+		if !outputStopped {
+			outputStopped = true
+			stdout, stderr, diagnostics, progress = io.Discard, io.Discard, io.Discard, nil
+			go softCancel(ctx, cmdStream, cancel, HardCancelGrace)
+		}
+		return true
+	}
+	for ctx.Err() == nil {
+		sr, err := cmdStream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				// Normal command termination
+				return nil
+			}
+			return fmt.Errorf("failed to read stdout/stderr stream: %w\n", err)
+		}
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+		if sr.Queued {
+			// The command waits for a slot, and hasn't started
+			window.queue()
+			if onQueued != nil {
+				onQueued()
+			}
+			continue
+		}
+		if ack := sr.StdinAck; ack != nil {
+			// The initial acknowledgement is sent before the command starts, or even waits for a slot, so only
+			// consumed input tells that it has started
+			if ack.Consumed > 0 {
+				window.start()
+			}
+			window.ack(ack.Consumed)
+			continue
+		}
+		// Everything else that the user daemon sends tells that the command has started, also when the user
+		// daemon is too old to send Started
+		window.start()
+		if sr.Started {
+			continue
+		}
+		if p := sr.Progress; p != nil {
+			if err = progress.show(p); err != nil && ctx.Err() == nil && !stopOutput(err) {
+				return fmt.Errorf("failed to write progress: %w\n", err)
+			}
+			continue
+		}
+		_ = progress.clear()
+		if p := sr.Prompt; p != nil {
+			// The answer is read by the stdinPump, unless stdin has ended. The prompt is pending before
+			// its text is shown, so that all input that the text leads to is taken as the answer.
+			reply := prompts.add(p)
+			if _, err = io.WriteString(stdout, p.Text); err != nil && ctx.Err() == nil && !stopOutput(err) {
+				return fmt.Errorf("failed to write prompt: %w\n", err)
+			}
+			if reply != nil {
+				_ = cmdStream.Send(reply)
+			}
+			continue
+		}
+		r := sr.Data
+		if sr.Final {
+			// Command execution ended with an error
+			if outputStopped {
+				// Most likely caused by the soft cancel
+				return nil
+			}
+			if r != nil {
+				err = errcat.FromResult(r)
+			}
+			return err
+		}
+
+		// Normal output from the command
+		timing.output()
+		if _, err = outputWriter(r, stdout, stderr, diagnostics).Write(r.Data); err != nil {
+			if ctx.Err() != nil || stopOutput(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to write stdout/stderr: %w\n", err)
+		}
+	}
+	return nil
+}
+
+// isBrokenPipe returns true if the given error is the result of a write to a pipe or file that the reader
+// has closed.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// outputWriter returns the writer for the channel of the given output. Output on the default channel, sent
+// by user daemons that don't know about channels, goes to stdout unless it has an error category. Output on
+// a channel that this client doesn't know goes to stderr.
+func outputWriter(r *connector.Result, stdout, stderr, diagnostics io.Writer) io.Writer {
+	switch r.Channel {
+	case connector.Result_CHANNEL_DEFAULT:
+		if r.ErrorCategory == 0 {
+			return stdout
+		}
+		return stderr
+	case connector.Result_CHANNEL_STDOUT:
+		return stdout
+	case connector.Result_CHANNEL_DIAGNOSTIC:
+		return diagnostics
+	default:
+		return stderr
+	}
+}
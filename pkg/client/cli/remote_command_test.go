@@ -0,0 +1,1511 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// fakeCmdStream is a connector.Connector_RunCommandClient that records everything that is
+// sent to it and returns results from a channel.
+type fakeCmdStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	mu      sync.Mutex
+	sent    []*connector.RunCommandRequest
+	results chan *connector.StreamResult
+	onSend  func(*connector.RunCommandRequest)
+
+	// Like a user daemon, the stream sends the initial stdin acknowledgement as soon as it has a command that
+	// asks for flow control, and then acknowledges the start of a command that asks for it, unless noStartAck
+	// is set.
+	noStartAck   bool
+	ackPending   bool
+	startPending bool
+}
+
+func newFakeCmdStream(ctx context.Context) *fakeCmdStream {
+	return &fakeCmdStream{ctx: ctx, results: make(chan *connector.StreamResult, 10)}
+}
+
+func (f *fakeCmdStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeCmdStream) Send(rq *connector.RunCommandRequest) error {
+	f.mu.Lock()
+	f.sent = append(f.sent, rq)
+	if rq.GetCommand().GetStdinFlowControl() {
+		f.ackPending = true
+	}
+	if rq.GetCommand().GetStartAck() && !f.noStartAck {
+		f.startPending = true
+	}
+	onSend := f.onSend
+	f.mu.Unlock()
+	if onSend != nil {
+		onSend(rq)
+	}
+	return nil
+}
+
+func (f *fakeCmdStream) Recv() (*connector.StreamResult, error) {
+	f.mu.Lock()
+	acked := f.ackPending
+	f.ackPending = false
+	started := false
+	if !acked {
+		started = f.startPending
+		f.startPending = false
+	}
+	f.mu.Unlock()
+	if acked {
+		return &connector.StreamResult{StdinAck: &connector.StdinAck{}}, nil
+	}
+	if started {
+		return &connector.StreamResult{Started: true}, nil
+	}
+	select {
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	case r, ok := <-f.results:
+		if !ok {
+			return nil, io.EOF
+		}
+		return r, nil
+	}
+}
+
+func (f *fakeCmdStream) CloseSend() error {
+	return nil
+}
+
+func (f *fakeCmdStream) sentRequests() []*connector.RunCommandRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*connector.RunCommandRequest{}, f.sent...)
+}
+
+// sentData returns the concatenation of all data messages and the number of such messages.
+func (f *fakeCmdStream) sentData() ([]byte, int) {
+	var buf bytes.Buffer
+	count := 0
+	for _, rq := range f.sentRequests() {
+		if data := rq.GetData(); data != nil {
+			buf.Write(data)
+			count++
+		}
+	}
+	return buf.Bytes(), count
+}
+
+// fakeConnector is a connector.ConnectorClient that only implements RunCommand. The calls to RunCommand
+// are delayed by startDelay, and fail with the errors in startErrs, in order, before the stream is returned.
+type fakeConnector struct {
+	connector.ConnectorClient
+	stream     *fakeCmdStream
+	startErrs  []error
+	startDelay time.Duration
+	calls      int
+}
+
+func (f *fakeConnector) RunCommand(ctx context.Context, _ ...grpc.CallOption) (connector.Connector_RunCommandClient, error) {
+	f.calls++
+	time.Sleep(f.startDelay)
+	if len(f.startErrs) > 0 {
+		err := f.startErrs[0]
+		f.startErrs = f.startErrs[1:]
+		return nil, err
+	}
+	// Like a real stream, this one ends when the context of the call is cancelled
+	f.stream.ctx = ctx
+	return f.stream, nil
+}
+
+func TestRunRemoteCommand(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("warning\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	close(cs.results)
+
+	var stdout, stderr bytes.Buffer
+	err := RunRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"echo", "hello"}, nil, &stdout, &stderr, "/home/me")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Equal(t, "warning\n", stderr.String())
+
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	cmd := rqs[0].GetCommand()
+	require.NotNil(t, cmd)
+	assert.Equal(t, []string{"echo", "hello"}, cmd.OsArgs)
+	assert.Equal(t, "/home/me", cmd.Cwd)
+}
+
+func TestCaptureRemoteCommand(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("warning\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("bye\n")}}
+	cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "sh", Code: 2})
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+
+	stdout, stderr, err := CaptureRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"intercept", "foo", "--", "sh"}, nil, "/")
+	assert.Equal(t, "hello\nbye\n", string(stdout))
+	assert.Equal(t, "warning\n", string(stderr))
+	require.Error(t, err)
+	assert.Equal(t, 2, errcat.ExitCode(err))
+	assert.Equal(t, errcat.NoDaemonLogs, errcat.GetCategory(err))
+
+	cs = newFakeCmdStream(ctx)
+	close(cs.results)
+	stdout, stderr, err = CaptureRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"list"}, strings.NewReader("ignored"), "/")
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+	assert.Empty(t, stderr)
+}
+
+func TestRunRemoteCommand_detach(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{DetachedId: "7"}
+
+	var stdout bytes.Buffer
+	rc := RemoteCommand{Args: []string{"intercept", "foo", "--", "sleep", "1000"}, Stdin: strings.NewReader("ignored"), Stdout: &stdout, Detach: true}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "7\n", stdout.String())
+
+	// Nothing but the command is sent
+	rqs := cs.sentRequests()
+	require.Len(t, rqs, 1)
+	assert.True(t, rqs[0].GetCommand().GetDetach())
+	assert.False(t, rqs[0].GetCommand().GetStdinFlowControl())
+
+	// A command that fails before it's detached reports its error
+	cs = newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Final: true, Data: &connector.Result{Data: []byte("unknown command"), ErrorCategory: connector.Result_USER}}
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.Equal(t, "unknown command", err.Error())
+
+	// A user daemon that doesn't detach the command
+	cs = newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("output")}}
+	err = rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	rc.TTY = true
+	err = rc.Run(ctx, &fakeConnector{stream: newFakeCmdStream(ctx)})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestRunRemoteCommand_attach(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("while detached\n")}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("after attach\n")}}
+	cs.results <- &connector.StreamResult{Final: true}
+
+	var stdout bytes.Buffer
+	rc := RemoteCommand{AttachID: "7", Stdin: strings.NewReader("ignored"), Stdout: &stdout}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "while detached\nafter attach\n", stdout.String())
+
+	// The command is identified by its id, and no stdin is forwarded to it
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	assert.Equal(t, "7", rqs[0].GetAttach())
+	assert.Nil(t, rqs[0].GetCommand())
+	data, _ := cs.sentData()
+	assert.Empty(t, data)
+
+	rc.Detach = true
+	err := rc.Run(ctx, &fakeConnector{stream: newFakeCmdStream(ctx)})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+// echoConnector is a connector.ConnectorServer whose RunCommand writes the stdin that it receives to stdout.
+type echoConnector struct {
+	connector.UnimplementedConnectorServer
+}
+
+func (echoConnector) RunCommand(stream connector.Connector_RunCommandServer) error {
+	for {
+		rq, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch {
+		case rq.GetCommand().GetStartAck():
+			if err = stream.Send(&connector.StreamResult{Started: true}); err != nil {
+				return err
+			}
+		case rq.GetData() != nil:
+			if err = stream.Send(&connector.StreamResult{Data: &connector.Result{Data: rq.GetData()}}); err != nil {
+				return err
+			}
+		case rq.GetStdinClosed():
+			return stream.Send(&connector.StreamResult{Final: true})
+		}
+	}
+}
+
+// payloadStats is a stats.Handler that sums the sizes of the messages that a server receives, before and
+// after decompression.
+type payloadStats struct {
+	length     int64
+	wireLength int64
+}
+
+func (p *payloadStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (p *payloadStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if in, ok := s.(*stats.InPayload); ok {
+		atomic.AddInt64(&p.length, int64(in.Length))
+		atomic.AddInt64(&p.wireLength, int64(in.WireLength))
+	}
+}
+
+func (p *payloadStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (p *payloadStats) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestRunRemoteCommand_compress(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ps := &payloadStats{}
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.StatsHandler(ps))
+	connector.RegisterConnectorServer(srv, echoConnector{})
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	userD := connector.NewConnectorClient(conn)
+
+	input := bytes.Repeat([]byte("a line of log output that compresses well\n"), 500)
+	roundTrip := func(compress bool) (out []byte, length, wireLength int64) {
+		atomic.StoreInt64(&ps.length, 0)
+		atomic.StoreInt64(&ps.wireLength, 0)
+		var stdout bytes.Buffer
+		rc := RemoteCommand{Args: []string{"echo"}, Stdin: bytes.NewReader(input), Stdout: &stdout, Compress: compress}
+		require.NoError(t, rc.Run(ctx, userD))
+		return stdout.Bytes(), atomic.LoadInt64(&ps.length), atomic.LoadInt64(&ps.wireLength)
+	}
+
+	out, length, wireLength := roundTrip(false)
+	assert.Equal(t, input, out)
+	assert.GreaterOrEqual(t, wireLength, length)
+
+	out, length, wireLength = roundTrip(true)
+	assert.Equal(t, input, out)
+	assert.Less(t, wireLength, length/2)
+}
+
+func TestRunRemoteCommand_env(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+
+	rc := RemoteCommand{Args: []string{"intercept"}, Env: map[string]string{"NO_COLOR": "1"}}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	assert.Equal(t, map[string]string{"NO_COLOR": "1"}, rqs[0].GetCommand().GetEnv())
+}
+
+func TestRunRemoteCommand_clientInfo(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+
+	rc := RemoteCommand{Args: []string{"version"}}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	c := rqs[0].GetCommand()
+	assert.Equal(t, client.Version(), c.GetClientVersion())
+	assert.Equal(t, clientCapabilities, c.GetClientCapabilities())
+	assert.NotZero(t, c.GetClientCapabilities()&uint64(connector.RunCommandRequest_Command_CAPABILITY_OUTPUT_CHANNELS))
+}
+
+func TestRunRemoteCommand_error(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Final: true, Data: &connector.Result{Data: []byte("bad flag"), ErrorCategory: connector.Result_USER}}
+
+	err := RunRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"echo", "--bad"}, nil, io.Discard, io.Discard, "/")
+	require.Error(t, err)
+	assert.Equal(t, "bad flag", err.Error())
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestRunRemoteCommand_exitCode(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "docker run", Code: 17})
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+
+	err := RunRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"intercept", "foo", "--", "docker", "run"}, nil, io.Discard, io.Discard, "/")
+	require.Error(t, err)
+	assert.Equal(t, "docker run: exited with 17", err.Error())
+	assert.Equal(t, errcat.NoDaemonLogs, errcat.GetCategory(err))
+	assert.Equal(t, 17, errcat.ExitCode(err))
+}
+
+func TestRunRemoteCommand_tee(t *testing.T) {
+	run := func(t *testing.T, tee, teeOut, teeErr string) (string, string) {
+		ctx := dlog.NewTestContext(t, false)
+		cs := newFakeCmdStream(ctx)
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("warning\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("bye\n")}}
+		close(cs.results)
+
+		var stdout, stderr bytes.Buffer
+		rc := RemoteCommand{Args: []string{"echo"}, Stdout: &stdout, Stderr: &stderr}
+		var closeTee func() error
+		var err error
+		rc.TeeStdout, rc.TeeStderr, closeTee, err = openTeeFiles(tee, teeOut, teeErr)
+		require.NoError(t, err)
+		require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+		require.NoError(t, closeTee())
+		return stdout.String(), stderr.String()
+	}
+
+	dir := t.TempDir()
+	readFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// One file receives both stdout and stderr, in the order they were written
+	stdout, stderr := run(t, filepath.Join(dir, "both.log"), "", "")
+	assert.Equal(t, "hello\nbye\n", stdout)
+	assert.Equal(t, "warning\n", stderr)
+	assert.Equal(t, "hello\nwarning\nbye\n", readFile("both.log"))
+
+	// Separate files receive what's written to stdout and stderr
+	stdout, stderr = run(t, "", filepath.Join(dir, "out.log"), filepath.Join(dir, "err.log"))
+	assert.Equal(t, stdout, readFile("out.log"))
+	assert.Equal(t, stderr, readFile("err.log"))
+}
+
+func TestRunRemoteCommand_timeout(t *testing.T) {
+	defer func(grace time.Duration) { HardCancelGrace = grace }(HardCancelGrace)
+	HardCancelGrace = 10 * time.Millisecond
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx) // never returns any results
+
+	rc := RemoteCommand{Args: []string{"sleep"}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), rc.Timeout)
+	assert.Equal(t, errcat.Timeout, errcat.GetCategory(err))
+	assert.Equal(t, 124, errcat.ExitCode(err))
+
+	softCancelled := false
+	for _, rq := range cs.sentRequests() {
+		if rq.GetSoftCancel() {
+			softCancelled = true
+		}
+	}
+	assert.True(t, softCancelled, "no soft cancel was sent")
+}
+
+func TestRunRemoteCommand_timeoutSoftCancelHonored(t *testing.T) {
+	defer func(grace time.Duration) { HardCancelGrace = grace }(HardCancelGrace)
+	HardCancelGrace = -1
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetSoftCancel() {
+			close(cs.results)
+		}
+	}
+	rc := RemoteCommand{Args: []string{"sleep"}, Timeout: 50 * time.Millisecond}
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	assert.Equal(t, errcat.Timeout, errcat.GetCategory(err))
+}
+
+func TestRunRemoteCommand_noTimeout(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+	rc := RemoteCommand{Args: []string{"echo"}, Timeout: time.Minute}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+}
+
+func TestRunRemoteCommand_retries(t *testing.T) {
+	defer func(backoff time.Duration) { RetryBackoff = backoff }(RetryBackoff)
+	RetryBackoff = time.Millisecond
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name      string
+		retries   int
+		startErrs []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"no retries", 0, []error{unavailable}, 1, true},
+		{"succeeds after retries", 2, []error{unavailable, unavailable}, 3, false},
+		{"retries exhausted", 1, []error{unavailable, unavailable}, 2, true},
+		{"not transient", 2, []error{status.Error(codes.InvalidArgument, "bad")}, 1, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			cs := newFakeCmdStream(ctx)
+			close(cs.results)
+			fc := &fakeConnector{stream: cs, startErrs: tt.startErrs}
+
+			var stderr bytes.Buffer
+			rc := RemoteCommand{Args: []string{"echo"}, Stdin: strings.NewReader("input"), Stderr: &stderr, Retries: tt.retries}
+			err := rc.Run(ctx, fc)
+			assert.Equal(t, tt.wantCalls, fc.calls)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, stderr.String(), "failed start command")
+				assert.Empty(t, cs.sentRequests())
+			} else {
+				require.NoError(t, err)
+				rqs := cs.sentRequests()
+				require.NotEmpty(t, rqs)
+				assert.NotNil(t, rqs[0].GetCommand())
+			}
+		})
+	}
+}
+
+func TestRunRemoteCommand_quiet(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+	fc := &fakeConnector{stream: cs, startErrs: []error{status.Error(codes.Unavailable, "connection refused")}}
+
+	var stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"echo"}, Stderr: &stderr, Quiet: true}
+	err := rc.Run(ctx, fc)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Empty(t, stderr.String())
+
+	// The remote command's own stderr is still written
+	cs = newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("warning\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	close(cs.results)
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "warning\n", stderr.String())
+}
+
+func TestRunRemoteCommand_channels(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	send := func(cs *fakeCmdStream) {
+		output := func(data string, ec connector.Result_ErrorCategory, ch connector.Result_Channel) {
+			cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte(data), ErrorCategory: ec, Channel: ch}}
+		}
+		output("legacy out\n", 0, connector.Result_CHANNEL_DEFAULT)
+		output("legacy err\n", connector.Result_NO_DAEMON_LOGS, connector.Result_CHANNEL_DEFAULT)
+		output("out\n", 0, connector.Result_CHANNEL_STDOUT)
+		output("err\n", connector.Result_NO_DAEMON_LOGS, connector.Result_CHANNEL_STDERR)
+		output("progress\n", connector.Result_NO_DAEMON_LOGS, connector.Result_CHANNEL_DIAGNOSTIC)
+		output("unknown\n", 0, connector.Result_Channel(42))
+		close(cs.results)
+	}
+
+	cs := newFakeCmdStream(ctx)
+	send(cs)
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"echo"}, Stdout: &stdout, Stderr: &stderr}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "legacy out\nout\n", stdout.String())
+	assert.Equal(t, "legacy err\nerr\nprogress\nunknown\n", stderr.String())
+
+	// Diagnostics are suppressed independently of the other output
+	cs = newFakeCmdStream(ctx)
+	send(cs)
+	stdout.Reset()
+	stderr.Reset()
+	rc.NoDiagnostics = true
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "legacy out\nout\n", stdout.String())
+	assert.Equal(t, "legacy err\nerr\nunknown\n", stderr.String())
+}
+
+func TestRunRemoteCommand_progress(t *testing.T) {
+	defer func(interval time.Duration) { ProgressInterval = interval }(ProgressInterval)
+	ProgressInterval = time.Hour
+
+	ctx := dlog.NewTestContext(t, false)
+	send := func(cs *fakeCmdStream) {
+		progress := func(current uint64, done bool) {
+			cs.results <- &connector.StreamResult{Progress: &connector.Progress{Label: "copying", Current: current, Total: 4, Done: done}}
+		}
+		progress(1, false)
+		progress(2, false)
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("skipped a\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+		progress(4, true)
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("copied\n")}}
+		close(cs.results)
+	}
+
+	cs := newFakeCmdStream(ctx)
+	send(cs)
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"cp"}, Stdout: &stdout, Stderr: &stderr}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.True(t, cs.sentRequests()[0].GetCommand().Progress)
+
+	// Stderr isn't a terminal, so the progress is shown as lines
+	assert.Equal(t, "copied\n", stdout.String())
+	assert.Equal(t, "copying: 25% (1/4)\nskipped a\ncopying: 100% (4/4)\n", stderr.String())
+
+	// Progress is a diagnostic
+	cs = newFakeCmdStream(ctx)
+	send(cs)
+	stdout.Reset()
+	stderr.Reset()
+	rc.NoDiagnostics = true
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "copied\n", stdout.String())
+	assert.Equal(t, "skipped a\n", stderr.String())
+}
+
+// closingWriter is a writer whose reader goes away after the given number of writes.
+type closingWriter struct {
+	bytes.Buffer
+	writes int
+	err    error
+}
+
+func (w *closingWriter) Write(p []byte) (int, error) {
+	if w.writes == 0 {
+		return 0, w.err
+	}
+	w.writes--
+	return w.Buffer.Write(p)
+}
+
+func TestRunRemoteCommand_brokenPipe(t *testing.T) {
+	closedFile, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	require.NoError(t, closedFile.Close())
+
+	tests := []struct {
+		name   string
+		stdout func() io.Writer
+		want   string
+	}{
+		{"EPIPE", func() io.Writer {
+			return &closingWriter{writes: 1, err: &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}}
+		}, "line 1\n"},
+		{"closed pipe", func() io.Writer { return &closingWriter{writes: 2, err: io.ErrClosedPipe} }, "line 1\nline 2\n"},
+		{"closed file", func() io.Writer { return closedFile }, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			cs := newFakeCmdStream(ctx)
+			for i := 1; i <= 3; i++ {
+				cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte(fmt.Sprintf("line %d\n", i))}}
+			}
+			cs.onSend = func(rq *connector.RunCommandRequest) {
+				if rq.GetSoftCancel() {
+					// The remote command is interrupted and says so on stderr
+					cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("interrupted\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+					cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "seq", Code: 130})
+					cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+				}
+			}
+
+			stdout := tt.stdout()
+			var stderr bytes.Buffer
+			rc := RemoteCommand{Args: []string{"seq"}, Stdout: stdout, Stderr: &stderr}
+			require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+			if cw, ok := stdout.(*closingWriter); ok {
+				assert.Equal(t, tt.want, cw.String())
+			}
+			assert.Empty(t, stderr.String())
+
+			softCancels := 0
+			for _, rq := range cs.sentRequests() {
+				if rq.GetSoftCancel() {
+					softCancels++
+				}
+			}
+			assert.Equal(t, 1, softCancels)
+		})
+	}
+}
+
+func TestRunRemoteCommand_rawOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	frames := [][]byte{
+		{'{', '"', 0xe2, 0x82},
+		{0xac, '"', '}', '\r', '\n', 0x00, 0xff},
+		[]byte("\x1b[31mred\x1b[0m"),
+	}
+	for _, f := range frames {
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: f}}
+	}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte{0xfe, '\n'}, ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "jq", Code: 5})
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+
+	stdout, stderr := &writeRecorder{}, &writeRecorder{}
+	rc := RemoteCommand{Args: []string{"intercept"}, Stdout: stdout, Stderr: stderr, RawOutput: true}
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+
+	// Each frame is written verbatim as a write of its own
+	assert.Equal(t, frames, stdout.writes)
+	assert.Equal(t, [][]byte{{0xfe, '\n'}}, stderr.writes)
+
+	// The error is only conveyed by the exit code
+	require.Error(t, err)
+	assert.True(t, errcat.IsSilent(err))
+	assert.Equal(t, 5, errcat.ExitCode(err))
+	assert.Equal(t, errcat.NoDaemonLogs, errcat.GetCategory(err))
+
+	// Successful commands return no error
+	cs = newFakeCmdStream(ctx)
+	close(cs.results)
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+
+	// A command that can't be started isn't silent, but the client doesn't write a diagnostic of its own
+	stderr.writes = nil
+	err = rc.Run(ctx, &fakeConnector{stream: cs, startErrs: []error{status.Error(codes.PermissionDenied, "denied")}})
+	require.Error(t, err)
+	assert.False(t, errcat.IsSilent(err))
+	assert.Empty(t, stderr.writes)
+}
+
+func TestRunRemoteCommand_rawOutputExclusive(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	for _, rc := range []RemoteCommand{
+		{Args: []string{"intercept"}, RawOutput: true, JSONOutput: true},
+		{Args: []string{"intercept"}, RawOutput: true, RuneAligned: true},
+	} {
+		err := rc.Run(ctx, &fakeConnector{stream: newFakeCmdStream(ctx)})
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.False(t, errcat.IsSilent(err))
+	}
+}
+
+func TestRunRemoteCommand_jsonOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("warning\n"), ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+	close(cs.results)
+
+	var stdout bytes.Buffer
+	rc := RemoteCommand{Args: []string{"echo", "hello"}, Stdout: &stdout, JSONOutput: true}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+
+	var ro map[string]any
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &ro), stdout.String())
+	assert.Equal(t, map[string]any{
+		"cmd":      "echo",
+		"stdout":   "hello\n",
+		"stderr":   "warning\n",
+		"exitCode": float64(0),
+	}, ro)
+}
+
+func TestRunRemoteCommand_jsonOutputBinaryAndError(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	binary := []byte{0xff, 0xfe, 0x00, 0x01}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: binary}}
+	cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "cat", Code: 3})
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+
+	var stdout bytes.Buffer
+	rc := RemoteCommand{Args: []string{"intercept"}, Stdout: &stdout, JSONOutput: true}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+
+	var ro remoteOutput
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &ro), stdout.String())
+	assert.Equal(t, "base64", ro.StdoutEncoding)
+	data, err := base64.StdEncoding.DecodeString(ro.Stdout)
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+	assert.Empty(t, ro.StderrEncoding)
+	assert.Equal(t, 3, ro.ExitCode)
+	assert.Equal(t, "cat: exited with 3", ro.Err)
+}
+
+// goroutineCount returns the number of goroutines that are executing the given function.
+func goroutineCount(fn string) int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), fn+"(")
+}
+
+func TestRunRemoteCommand_noInterrupt(t *testing.T) {
+	cmd := newRemoteTestCommand(nil)
+	remain, err := extractClientFlags(cmd, []string{"foo", "--no-interrupt"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, remain)
+	f := clientFlag(cmd, "no-interrupt")
+	require.NotNil(t, f)
+	assert.Equal(t, "true", f.Value.String())
+
+	ctx := dlog.NewTestContext(t, false)
+	for _, handleInterrupts := range []bool{true, false} {
+		cs := newFakeCmdStream(ctx)
+		errCh := make(chan error, 1)
+		go func(handleInterrupts bool) {
+			rc := RemoteCommand{Args: []string{"echo"}, HandleInterrupts: handleInterrupts}
+			errCh <- rc.Run(ctx, &fakeConnector{stream: cs})
+		}(handleInterrupts)
+
+		// The pumps are started once the command has been sent
+		require.Eventually(t, func() bool { return len(cs.sentRequests()) > 0 }, 5*time.Second, time.Millisecond)
+		if handleInterrupts {
+			require.Eventually(t, func() bool { return goroutineCount("cli.interruptPump") == 1 }, 5*time.Second, time.Millisecond)
+		} else {
+			time.Sleep(10 * time.Millisecond)
+			assert.Zero(t, goroutineCount("cli.interruptPump"))
+		}
+		close(cs.results)
+		require.NoError(t, <-errCh)
+		require.Eventually(t, func() bool { return goroutineCount("cli.interruptPump") == 0 }, 5*time.Second, time.Millisecond)
+	}
+}
+
+func TestRunRemoteCommand_noStdinLeakOnError(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+
+	// Nothing is written to stdin, so the read blocks until it's interrupted
+	stdinR, stdinW, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() {
+		_ = stdinR.Close()
+		_ = stdinW.Close()
+	}()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- RunRemoteCommand(ctx, &fakeConnector{stream: cs}, []string{"echo"}, stdinR, io.Discard, io.Discard, "/")
+	}()
+
+	// Let the pump fail once stdin is blocked in a read
+	require.Eventually(t, func() bool {
+		return goroutineCount("cli.(*ctxReader).readLoop") == 1
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	cs.results <- &connector.StreamResult{Final: true, Data: &connector.Result{Data: []byte("boom"), ErrorCategory: connector.Result_UNKNOWN}}
+	require.Error(t, <-errCh)
+
+	require.Eventually(t, func() bool {
+		return goroutineCount("cli.stdinPump") == 0 && goroutineCount("cli.(*ctxReader).readLoop") == 0
+	}, 5*time.Second, 10*time.Millisecond, "stdin goroutines leaked")
+
+	// The deadline that interrupted the read must not affect subsequent reads
+	_, err = stdinW.Write([]byte("x"))
+	require.NoError(t, err)
+	n, err := stdinR.Read(make([]byte, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+// blockingReader is an io.Reader without support for deadlines that blocks until it's released.
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) Read([]byte) (int, error) {
+	close(r.started)
+	<-r.release
+	return 0, io.EOF
+}
+
+func TestStdinPump_abandonsBlockedRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	stream := newFakeCmdStream(ctx)
+	rd := &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(rd.release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stdinPump(ctx, stream, rd, 16, nil, nil)
+	}()
+	<-rd.started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stdinPump is blocked in read")
+	}
+}
+
+func TestStdinPump_chunkSize(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1 MiB
+	counts := make(map[int]int)
+	for _, chunkSize := range []int{1024, 64 * 1024, maxStdinChunkSize} {
+		ctx := dlog.NewTestContext(t, false)
+		cs := newFakeCmdStream(ctx)
+		stdinPump(ctx, cs, bytes.NewReader(payload), chunkSize, nil, nil)
+		data, count := cs.sentData()
+		require.Equal(t, payload, data)
+		assert.Equal(t, (len(payload)+chunkSize-1)/chunkSize, count)
+		counts[chunkSize] = count
+	}
+	assert.Greater(t, counts[1024], counts[64*1024])
+	assert.Greater(t, counts[64*1024], counts[maxStdinChunkSize])
+}
+
+func TestStdinPump_closed(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	stdinPump(ctx, cs, strings.NewReader("b\na\n"), 1, nil, nil)
+
+	rqs := cs.sentRequests()
+	closes := 0
+	for _, rq := range rqs {
+		if rq.GetStdinClosed() {
+			closes++
+		}
+	}
+	assert.Equal(t, 1, closes)
+	require.NotEmpty(t, rqs)
+	assert.True(t, rqs[len(rqs)-1].GetStdinClosed(), "the close isn't the last request")
+	data, _ := cs.sentData()
+	assert.Equal(t, "b\na\n", string(data))
+
+	// A stdin that isn't closed before the context is cancelled isn't reported as closed
+	ctx, cancel := context.WithCancel(ctx)
+	cs = newFakeCmdStream(ctx)
+	br := &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(br.release)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stdinPump(ctx, cs, br, 1, nil, nil)
+	}()
+	<-br.started
+	cancel()
+	<-done
+	assert.Empty(t, cs.sentRequests())
+}
+
+func TestStdinPump_slowConsumer(t *testing.T) {
+	const (
+		chunkSize  = 1024
+		windowSize = 16 * 1024
+	)
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	window := newStdinWindow(windowSize)
+	window.ack(0)
+
+	var sent, consumed, maxOutstanding uint64
+	var mu sync.Mutex
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		mu.Lock()
+		sent += uint64(len(rq.GetData()))
+		if outstanding := sent - consumed; outstanding > maxOutstanding {
+			maxOutstanding = outstanding
+		}
+		mu.Unlock()
+	}
+
+	// The consumer is much slower than the producer.
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+			mu.Lock()
+			if consumed < sent {
+				consumed += 512
+				if consumed > sent {
+					consumed = sent
+				}
+			}
+			c := consumed
+			mu.Unlock()
+			window.ack(c)
+		}
+	}()
+
+	payload := bytes.Repeat([]byte{'x'}, 256*1024)
+	stdinPump(ctx, cs, bytes.NewReader(payload), chunkSize, window, nil)
+	close(done)
+
+	data, _ := cs.sentData()
+	require.Equal(t, payload, data)
+	assert.LessOrEqual(t, maxOutstanding, uint64(windowSize+chunkSize))
+}
+
+func TestRunRemoteCommand_startAck(t *testing.T) {
+	defer func(timeout time.Duration) { StartAckTimeout = timeout }(StartAckTimeout)
+	StartAckTimeout = time.Minute
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.noStartAck = true
+	dataSent := make(chan struct{})
+	var once sync.Once
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetData() != nil || rq.GetStdinClosed() {
+			once.Do(func() { close(dataSent) })
+		}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		rc := RemoteCommand{Args: []string{"cat"}, Stdin: strings.NewReader("early input")}
+		errCh <- rc.Run(ctx, &fakeConnector{stream: cs})
+	}()
+
+	// Nothing but the command is sent until the start is acknowledged, although stdin is acknowledged before that
+	require.Eventually(t, func() bool { return len(cs.sentRequests()) == 1 }, time.Second, time.Millisecond)
+	assert.True(t, cs.sentRequests()[0].GetCommand().GetStartAck())
+	select {
+	case <-dataSent:
+		t.Fatal("stdin was sent before the start was acknowledged")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cs.results <- &connector.StreamResult{Started: true}
+	select {
+	case <-dataSent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stdin wasn't sent after the start was acknowledged")
+	}
+	require.Eventually(t, func() bool {
+		data, _ := cs.sentData()
+		return string(data) == "early input"
+	}, 5*time.Second, time.Millisecond)
+	close(cs.results)
+	require.NoError(t, <-errCh)
+}
+
+func TestRunRemoteCommand_startAckTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { StartAckTimeout = timeout }(StartAckTimeout)
+	StartAckTimeout = 20 * time.Millisecond
+
+	// A user daemon that doesn't acknowledge the start still gets its stdin
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.noStartAck = true
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetStdinClosed() {
+			close(cs.results)
+		}
+	}
+	rc := RemoteCommand{Args: []string{"cat"}, Stdin: strings.NewReader("input")}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	data, _ := cs.sentData()
+	assert.Equal(t, "input", string(data))
+}
+
+func TestRunRemoteCommand_queued(t *testing.T) {
+	defer func(timeout time.Duration) { StartAckTimeout = timeout }(StartAckTimeout)
+	StartAckTimeout = 20 * time.Millisecond
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.noStartAck = true
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetStdinClosed() {
+			close(cs.results)
+		}
+	}
+	cs.results <- &connector.StreamResult{Queued: true}
+	var stdout, stderr bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() {
+		rc := RemoteCommand{Args: []string{"cat"}, Stdin: strings.NewReader("input"), Stdout: &stdout, Stderr: &stderr, Wait: true}
+		errCh <- rc.Run(ctx, &fakeConnector{stream: cs})
+	}()
+
+	// A queued command gets no stdin, not even when the start acknowledgement times out. The stream sends the
+	// initial stdin acknowledgement before Queued, like a user daemon does.
+	require.Eventually(t, func() bool { return len(cs.sentRequests()) == 1 }, time.Second, time.Millisecond)
+	assert.True(t, cs.sentRequests()[0].GetCommand().GetWait())
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, cs.sentRequests(), 1)
+
+	cs.results <- &connector.StreamResult{Started: true}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("output"), Channel: connector.Result_CHANNEL_STDOUT}}
+	require.NoError(t, <-errCh)
+	data, _ := cs.sentData()
+	assert.Equal(t, "input", string(data))
+	assert.Equal(t, "output", stdout.String())
+	assert.Equal(t, "waiting for an available slot...\n", stderr.String())
+}
+
+func TestRunRemoteCommand_queuedTimeout(t *testing.T) {
+	defer func(grace time.Duration) { HardCancelGrace = grace }(HardCancelGrace)
+	HardCancelGrace = time.Minute
+
+	// A command that times out while it's queued is cancelled without a grace period
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.noStartAck = true
+	cs.results <- &connector.StreamResult{Queued: true}
+	rc := RemoteCommand{Args: []string{"cat"}, Wait: true, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.Equal(t, errcat.Timeout, errcat.GetCategory(err))
+	assert.Less(t, time.Since(start), 10*time.Second)
+	for _, rq := range cs.sentRequests() {
+		assert.False(t, rq.GetSoftCancel())
+	}
+}
+
+func TestStdinWindow_disabledUntilAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), time.Second)
+	defer cancel()
+	w := newStdinWindow(10)
+	w.addSent(100)
+	// Servers that don't acknowledge stdin must not block the client
+	require.NoError(t, w.wait(ctx))
+
+	w.ack(0)
+	assert.True(t, w.full())
+	w.ack(95)
+	assert.False(t, w.full())
+	require.NoError(t, w.wait(ctx))
+}
+
+func TestStdinChunkSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", want: 1024},
+		{name: "custom", env: "8192", want: 8192},
+		{name: "capped", env: "10000000", want: maxStdinChunkSize},
+		{name: "zero", env: "0", wantErr: true},
+		{name: "negative", env: "-5", wantErr: true},
+		{name: "garbage", env: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := client.LoadEnvWith(func(key string) (string, bool) {
+				if key == "TELEPRESENCE_STDIN_CHUNK" && tt.env != "" {
+					return tt.env, true
+				}
+				return "", false
+			})
+			require.NoError(t, err)
+			ctx := client.WithEnv(dlog.NewTestContext(t, false), env)
+			got, err := stdinChunkSize(ctx)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestForwardInterrupt_grace(t *testing.T) {
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		wantCancel bool
+	}{
+		{"immediate", 0, true},
+		{"delayed", 50 * time.Millisecond, true},
+		{"never", -1, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			stream := newFakeCmdStream(ctx)
+			sigCh := make(chan os.Signal, 1)
+			cancelled := make(chan struct{})
+			cancel := func() { close(cancelled) }
+
+			done := make(chan struct{})
+			start := time.Now()
+			go func() {
+				defer close(done)
+				forwardInterrupt(ctx, stream, cancel, sigCh, tt.grace, nil)
+			}()
+			sigCh <- os.Interrupt
+
+			select {
+			case <-cancelled:
+				require.True(t, tt.wantCancel, "unexpected hard cancel")
+				assert.GreaterOrEqual(t, time.Since(start), tt.grace)
+				<-done
+			case <-time.After(200 * time.Millisecond):
+				require.False(t, tt.wantCancel, "no hard cancel within 200ms")
+			}
+			sent := stream.sentRequests()
+			require.Len(t, sent, 1)
+			assert.True(t, sent[0].GetSoftCancel())
+		})
+	}
+}
+
+func TestSignalRequest(t *testing.T) {
+	tests := []struct {
+		sig        os.Signal
+		want       connector.RunCommandRequest_Signal
+		softCancel bool
+	}{
+		{os.Interrupt, connector.RunCommandRequest_SIGNAL_UNSPECIFIED, true},
+		{syscall.SIGINT, connector.RunCommandRequest_SIGNAL_UNSPECIFIED, true},
+		{syscall.SIGHUP, connector.RunCommandRequest_SIGHUP, false},
+		{syscall.SIGQUIT, connector.RunCommandRequest_SIGQUIT, false},
+		{syscall.SIGTERM, connector.RunCommandRequest_SIGTERM, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.sig.String(), func(t *testing.T) {
+			rq := signalRequest(tt.sig)
+			assert.Equal(t, tt.softCancel, rq.GetSoftCancel())
+			assert.Equal(t, tt.want, rq.GetSignal())
+		})
+	}
+}
+
+func TestForwardInterrupt_signal(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	stream := newFakeCmdStream(ctx)
+	sigCh := make(chan os.Signal, 1)
+	cancelled := make(chan struct{})
+	sigCh <- syscall.SIGTERM
+	forwardInterrupt(ctx, stream, func() { close(cancelled) }, sigCh, 0, nil)
+
+	<-cancelled
+	sent := stream.sentRequests()
+	require.Len(t, sent, 1)
+	assert.False(t, sent[0].GetSoftCancel())
+	assert.Equal(t, connector.RunCommandRequest_SIGTERM, sent[0].GetSignal())
+}
+
+func TestForwardInterrupt_terminatedWithinGrace(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(dlog.NewTestContext(t, false))
+	stream := newFakeCmdStream(ctx)
+	sigCh := make(chan os.Signal, 1)
+	hardCancelled := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		forwardInterrupt(ctx, stream, func() { hardCancelled = true }, sigCh, time.Minute, nil)
+	}()
+	sigCh <- os.Interrupt
+	require.Eventually(t, func() bool { return len(stream.sentRequests()) == 1 }, time.Second, time.Millisecond)
+	cancelCtx()
+	<-done
+	assert.False(t, hardCancelled)
+}
+
+func TestForwardInterrupt_outputDefersHardCancel(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	stream := newFakeCmdStream(ctx)
+	sigCh := make(chan os.Signal, 1)
+	activity := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	go forwardInterrupt(ctx, stream, func() { close(cancelled) }, sigCh, 100*time.Millisecond, activity)
+
+	// Output that arrived before the interrupt doesn't count
+	activity <- struct{}{}
+	sigCh <- os.Interrupt
+	require.Eventually(t, func() bool { return len(stream.sentRequests()) == 1 }, time.Second, time.Millisecond)
+
+	// The command keeps producing output for three times the grace period
+	start := time.Now()
+	for time.Since(start) < 300*time.Millisecond {
+		select {
+		case <-cancelled:
+			t.Fatal("the command was hard cancelled although it still produced output")
+		case <-time.After(25 * time.Millisecond):
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	// The hard cancel follows once the output ends
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command wasn't hard cancelled when its output ended")
+	}
+}
+
+func TestForwardInterrupt_outputDefersHardCancelAtMost(t *testing.T) {
+	defer func(maxGrace time.Duration) { HardCancelMaxGrace = maxGrace }(HardCancelMaxGrace)
+	HardCancelMaxGrace = 200 * time.Millisecond
+
+	ctx, cancelCtx := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancelCtx()
+	stream := newFakeCmdStream(ctx)
+	sigCh := make(chan os.Signal, 1)
+	activity := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	go forwardInterrupt(ctx, stream, func() { close(cancelled) }, sigCh, 50*time.Millisecond, activity)
+	sigCh <- os.Interrupt
+
+	// A command that ignores the interrupt and never stops producing output is hard cancelled eventually
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-cancelled:
+			return
+		case <-deadline:
+			t.Fatal("the command wasn't hard cancelled within the maximum grace period")
+		case <-time.After(10 * time.Millisecond):
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func TestStdoutAndStderrPump_activity(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("stopping")}}
+	activity := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- stdoutAndStderrPump(ctx, cs, cancel, io.Discard, io.Discard, io.Discard, nil, &stdinPrompts{}, nil, nil, nil, activity)
+	}()
+
+	// Each frame tells that the command is active
+	select {
+	case <-activity:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a frame didn't count as activity")
+	}
+	close(cs.results)
+	require.NoError(t, <-done)
+}
+
+func TestStdoutAndStderrPump_queuedAfterInitialAck(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// A user daemon acknowledges stdin before it tells that the command is queued, and starts it later
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{StdinAck: &connector.StdinAck{}}
+	cs.results <- &connector.StreamResult{Queued: true}
+	window := newStdinWindow(10)
+	window.started = make(chan struct{})
+	window.queued = make(chan struct{})
+	queued := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- stdoutAndStderrPump(ctx, cs, cancel, io.Discard, io.Discard, io.Discard, window, &stdinPrompts{}, nil, nil,
+			func() { close(queued) }, nil)
+	}()
+
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command wasn't queued")
+	}
+	assert.True(t, window.isQueued())
+	select {
+	case <-window.started:
+		t.Fatal("the initial stdin acknowledgement started the command")
+	default:
+	}
+
+	cs.results <- &connector.StreamResult{Started: true}
+	select {
+	case <-window.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command wasn't started")
+	}
+	assert.False(t, window.isQueued())
+	close(cs.results)
+	require.NoError(t, <-done)
+}
+
+func TestRemoteCommand_escalatedCancel(t *testing.T) {
+	const msg = "remote command did not respond to interrupt within 20ms; forcing termination\n"
+	interrupt := func(t *testing.T, rc *RemoteCommand, terminate bool) string {
+		ctx, cancelCtx := context.WithCancel(dlog.NewTestContext(t, false))
+		defer cancelCtx()
+		stream := newFakeCmdStream(ctx)
+		if terminate {
+			// The command terminates when it receives the interrupt
+			stream.onSend = func(*connector.RunCommandRequest) { cancelCtx() }
+		}
+		var stderr bytes.Buffer
+		sigCh := make(chan os.Signal, 1)
+		sigCh <- os.Interrupt
+		forwardInterrupt(ctx, stream, rc.escalatedCancel(cancelCtx, &stderr, 20*time.Millisecond), sigCh, 20*time.Millisecond, nil)
+		return stderr.String()
+	}
+
+	// Escalation
+	assert.Equal(t, msg, interrupt(t, &RemoteCommand{}, false))
+	assert.Empty(t, interrupt(t, &RemoteCommand{Quiet: true}, false))
+
+	// Clean soft cancel
+	assert.Empty(t, interrupt(t, &RemoteCommand{}, true))
+
+	// Nothing to escalate
+	var stderr bytes.Buffer
+	cancelled := false
+	(&RemoteCommand{}).escalatedCancel(func() { cancelled = true }, &stderr, 0)()
+	assert.True(t, cancelled)
+	assert.Empty(t, stderr.String())
+}
+
+func TestRunRemoteCommand_timing(t *testing.T) {
+	const (
+		startDelay  = 50 * time.Millisecond
+		outputDelay = 100 * time.Millisecond
+		endDelay    = 50 * time.Millisecond
+	)
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	go func() {
+		time.Sleep(startDelay + outputDelay)
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+		time.Sleep(endDelay)
+		close(cs.results)
+	}()
+
+	timing := &Timing{}
+	rc := RemoteCommand{Args: []string{"echo", "hello"}, Stdout: io.Discard, Timing: timing}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs, startDelay: startDelay}))
+	assert.GreaterOrEqual(t, timing.Start, startDelay)
+	assert.Less(t, timing.Start, timing.FirstOutput)
+	assert.GreaterOrEqual(t, timing.FirstOutput, startDelay+outputDelay)
+	assert.GreaterOrEqual(t, timing.Total, timing.FirstOutput+endDelay)
+	assert.Contains(t, timing.String(), "first output: ")
+}
+
+func TestRunRemoteCommand_timingNoOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(cs.results)
+	}()
+
+	timing := &Timing{}
+	rc := RemoteCommand{Args: []string{"true"}, Timing: timing}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Zero(t, timing.FirstOutput)
+	assert.GreaterOrEqual(t, timing.Total, 50*time.Millisecond)
+	assert.Contains(t, timing.String(), "first output: none")
+}
+
+func TestKeepAlivePump(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cs := newFakeCmdStream(ctx)
+	ls := &lockedSendStream{Connector_RunCommandClient: cs, lastSend: time.Now()}
+	keepAlives := func(rqs []*connector.RunCommandRequest) int {
+		count := 0
+		for _, rq := range rqs {
+			if rq.GetKeepAlive() {
+				count++
+			}
+		}
+		return count
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		keepAlivePump(ctx, ls, interval)
+	}()
+
+	// Keepalives are sent while the stream is idle
+	require.Eventually(t, func() bool { return keepAlives(cs.sentRequests()) >= 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// No keepalives are sent while data flows
+	start := len(cs.sentRequests())
+	for i := 0; i < 20; i++ {
+		require.NoError(t, ls.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Data{Data: []byte("x")}}))
+		time.Sleep(interval / 10)
+	}
+	rqs := cs.sentRequests()[start:]
+	first, last := -1, -1
+	for i, rq := range rqs {
+		if rq.GetData() != nil {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	assert.Zero(t, keepAlives(rqs[first:last+1]))
+
+	// Keepalives resume when the stream is idle again, and stop when the context is cancelled
+	require.Eventually(t, func() bool { return keepAlives(cs.sentRequests()[start:]) >= 1 }, 2*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keepAlivePump didn't return when the context was cancelled")
+	}
+}
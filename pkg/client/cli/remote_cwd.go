@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// getwdTimeout is the time that the lookup of the current directory may take before a remote command falls
+// back to the PWD environment variable. A lookup can block for a long time when the directory is on a network
+// filesystem that is unreachable.
+const getwdTimeout = 5 * time.Second
+
+// remoteCwd returns the directory that getwd returns. When getwd fails, or doesn't return within timeout, a
+// warning is written to stderr, and the value of the PWD environment variable that lookupEnv returns is used
+// instead, provided that it's an absolute path. The root directory is used when it isn't.
+func remoteCwd(getwd func() (string, error), lookupEnv func(string) (string, bool), stderr io.Writer, timeout time.Duration) string {
+	type result struct {
+		dir string
+		err error
+	}
+	// Buffered, so that a getwd that returns after the timeout doesn't leak the goroutine
+	rc := make(chan result, 1)
+	go func() {
+		dir, err := getwd()
+		rc <- result{dir: dir, err: err}
+	}()
+
+	var err error
+	select {
+	case r := <-rc:
+		if r.err == nil {
+			return r.dir
+		}
+		err = r.err
+	case <-time.After(timeout):
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	dir := string(filepath.Separator)
+	if pwd, ok := lookupEnv("PWD"); ok && filepath.IsAbs(pwd) {
+		dir = pwd
+	}
+	fmt.Fprintf(stderr, "warning: unable to determine the current directory: %v; using %s\n", err, dir)
+	return dir
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_remoteCwd(t *testing.T) {
+	root := string(filepath.Separator)
+	home := filepath.Join(root, "home", "me")
+	failing := func() (string, error) { return "", errors.New("stale file handle") }
+	unblock := make(chan struct{})
+	defer close(unblock)
+	blocking := func() (string, error) {
+		<-unblock
+		return root, nil
+	}
+	env := func(vars map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			v, ok := vars[key]
+			return v, ok
+		}
+	}
+	tests := []struct {
+		name    string
+		getwd   func() (string, error)
+		env     map[string]string
+		want    string
+		warning string
+	}{
+		{
+			name:  "getwd",
+			getwd: func() (string, error) { return filepath.Join(root, "work"), nil },
+			env:   map[string]string{"PWD": home},
+			want:  filepath.Join(root, "work"),
+		},
+		{
+			name:    "PWD when getwd fails",
+			getwd:   failing,
+			env:     map[string]string{"PWD": home},
+			want:    home,
+			warning: "stale file handle",
+		},
+		{
+			name:    "PWD when getwd times out",
+			getwd:   blocking,
+			env:     map[string]string{"PWD": home},
+			want:    home,
+			warning: "timed out",
+		},
+		{
+			name:    "root without PWD",
+			getwd:   failing,
+			want:    root,
+			warning: "stale file handle",
+		},
+		{
+			name:    "root when PWD is relative",
+			getwd:   failing,
+			env:     map[string]string{"PWD": "me"},
+			want:    root,
+			warning: "stale file handle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr strings.Builder
+			assert.Equal(t, tt.want, remoteCwd(tt.getwd, env(tt.env), &stderr, 10*time.Millisecond))
+			if tt.warning == "" {
+				assert.Empty(t, stderr.String())
+			} else {
+				assert.Contains(t, stderr.String(), tt.warning)
+				assert.Contains(t, stderr.String(), "using "+tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// clientFlagAnnotation marks the flags of a remote command that are consumed by the client
+// instead of being forwarded to the user daemon.
+const clientFlagAnnotation = "telepresence.io/client-flag"
+
+// remoteClientFlags returns the flags that the client adds to all remote commands.
+func remoteClientFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	flags.BoolP("tty", "t", false, "Allocate a pseudo-terminal for the command. Stdin must be a terminal")
+	flags.Bool("raw", false, "Put the terminal into raw mode, so that all input, including control characters, is forwarded to the command unmodified")
+	flags.Bool("detach", false, "Start the command and return right away, leaving it running in the user daemon. The id of the command is printed")
+	flags.Bool("no-interrupt", false, "Don't forward interrupts to the command. An interrupt terminates the client immediately instead")
+	flags.Duration("timeout", 0, "Cancel the command if it doesn't finish within the given duration, e.g. 30s or 5m")
+	flags.Bool("wait", false, "Wait for an available slot when the user daemon is busy running its maximum number of commands, instead of failing. The wait counts toward --timeout")
+	flags.String("output", "default", "set the output format, supported values are 'json', 'raw', and 'default'")
+	flags.Int("retries", 0, "Number of times to retry the start of the command when the user daemon is temporarily unavailable. Only idempotent commands can be retried")
+	flags.Duration("keepalive", 30*time.Second, "Interval at which keepalive messages are sent to the command while no stdin is sent. Zero disables them")
+	flags.StringArray("env", nil, "Forward an environment variable to the command. Use KEY to forward its current value or KEY=VALUE to set it. Can be repeated")
+	flags.String("remote-cwd", "", "Working directory of the command in the user daemon. Defaults to the current directory")
+	flags.String("stdin-file", "", "Read the command's stdin from the given file instead of from stdin. Use - for stdin")
+	flags.Bool("quiet", false, "Don't print the client's own diagnostics to stderr. The output of the command is not affected")
+	flags.Bool("no-diagnostics", false, "Don't print the diagnostics, such as progress updates, that the command writes in addition to its output")
+	flags.Bool("compress", false, "Compress the stream to and from the user daemon. Saves bandwidth for large textual output, but adds latency")
+	flags.Bool("rune-aligned", false, "Never split a UTF-8 encoded character of the command's output between two writes. May delay output that isn't UTF-8")
+	flags.String("on-error", "", "Run the given local command if the command fails, with its exit code in $TELEPRESENCE_EXIT_CODE. The output goes to stderr")
+	flags.Duration("coalesce-output", 0, "Buffer the command's output and write it in fewer writes, at most this long after it arrives, e.g. 50ms. Ignored when stdout is a terminal")
+	flags.String("color", string(ColorAuto), "When to keep the colors of the command's output: 'auto' keeps them when stdout is a terminal and NO_COLOR isn't set, 'always', or 'never'")
+	flags.Bool("expand-env", false, "Expand $VAR and ${VAR} in the command's arguments using the local environment, for callers that don't expand them")
+	flags.String("tee", "", "Copy the command's output to the given file, in addition to showing it")
+	flags.String("tee-out", "", "Copy the command's stdout to the given file. Takes precedence over --tee")
+	flags.String("tee-err", "", "Copy the command's stderr to the given file. Takes precedence over --tee")
+	flags.Int64("max-output", 0, "Truncate the output and cancel the command once it has written more than this many bytes to stdout and stderr combined. Zero means no limit")
+	flags.Bool("timing", false, "Print the time it took to start the command, to receive its first output, and to run it, to stderr")
+	return flags
+}
+
+// addClientFlags adds the client flags to all commands in the given groups. A flag is skipped when
+// the command declares a flag with the same name, and its shorthand is dropped when the command
+// declares the same shorthand. The default of the timeout flag is the command's default timeout,
+// if it has one.
+func addClientFlags(groups cliutil.CommandGroups) {
+	for _, cmds := range groups {
+		for _, cmd := range cmds {
+			own := cmd.Flags()
+			remoteClientFlags().VisitAll(func(f *pflag.Flag) {
+				if own.Lookup(f.Name) != nil {
+					return
+				}
+				if f.Shorthand != "" && own.ShorthandLookup(f.Shorthand) != nil {
+					f.Shorthand = ""
+				}
+				if f.Name == "timeout" {
+					if d, ok := cliutil.DefaultTimeout(cmd); ok {
+						_ = f.Value.Set(d.String())
+						f.DefValue = f.Value.String()
+					}
+				}
+				f.Annotations = map[string][]string{clientFlagAnnotation: {"true"}}
+				own.AddFlag(f)
+			})
+		}
+	}
+}
+
+// clientFlag returns the client flag with the given name, or nil if cmd has no such client flag.
+func clientFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	if f := cmd.Flags().Lookup(name); f != nil && f.Annotations[clientFlagAnnotation] != nil {
+		return f
+	}
+	return nil
+}
+
+// extractClientFlags assigns the client flags of cmd that are found in args and returns the remaining
+// args, which are forwarded to the remote command. The forms --name, --name=value, --name value, -x,
+// and -x value are recognized. The search ends at "--".
+func extractClientFlags(cmd *cobra.Command, args []string) ([]string, error) {
+	flags := cmd.Flags()
+	var remain []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			remain = append(remain, args[i:]...)
+			break
+		}
+		var f *pflag.Flag
+		var val string
+		hasVal := false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := arg[2:]
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				val = name[eq+1:]
+				name = name[:eq]
+				hasVal = true
+			}
+			f = clientFlag(cmd, name)
+		case len(arg) == 2 && arg[0] == '-' && arg[1] != '-':
+			if sf := flags.ShorthandLookup(arg[1:]); sf != nil {
+				f = clientFlag(cmd, sf.Name)
+			}
+		}
+		if f == nil {
+			remain = append(remain, arg)
+			continue
+		}
+		if !hasVal {
+			if f.NoOptDefVal != "" {
+				val = f.NoOptDefVal
+			} else {
+				if i+1 >= len(args) {
+					return nil, errcat.User.Newf("flag needs an argument: %s", arg)
+				}
+				i++
+				val = args[i]
+			}
+		}
+		if err := f.Value.Set(val); err != nil {
+			return nil, errcat.User.Newf("invalid argument %q for %q flag: %v", val, arg, err)
+		}
+		f.Changed = true
+	}
+	return remain, nil
+}
+
+// forwardedEnv returns the environment variables described by the given values of the --env flag. A value on
+// the form KEY=VALUE sets KEY to VALUE, and a value on the form KEY forwards the value that lookup returns
+// for KEY. A KEY that lookup doesn't find isn't forwarded.
+func forwardedEnv(values []string, lookup func(string) (string, bool)) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(values))
+	for _, kv := range values {
+		k, v, hasValue := strings.Cut(kv, "=")
+		if k == "" {
+			return nil, errcat.User.Newf("invalid --env %q: the name of the environment variable is empty", kv)
+		}
+		if !hasValue {
+			var ok bool
+			if v, ok = lookup(k); !ok {
+				continue
+			}
+		}
+		env[k] = v
+	}
+	return env, nil
+}
+
+// sanitizeEnv returns the environment variables of env that the given remote command accepts, see
+// cliutil.AcceptsEnv. A warning about each of the others is written to stderr, so that a local variable is
+// never sent to a command that has declared that it doesn't need it.
+func sanitizeEnv(cmd *cobra.Command, env map[string]string, stderr io.Writer) map[string]string {
+	var dropped []string
+	for k := range env {
+		if !cliutil.AcceptsEnv(cmd, k) {
+			dropped = append(dropped, k)
+		}
+	}
+	if len(dropped) == 0 {
+		return env
+	}
+	sort.Strings(dropped)
+	for _, k := range dropped {
+		fmt.Fprintf(stderr, "Warning: %s isn't forwarded, because %q doesn't accept it\n", k, cliutil.RemoteName(cmd))
+		delete(env, k)
+	}
+	return env
+}
+
+// remoteRetries returns the number of retries that the --retries flag with the given value asks for. Only
+// commands that the user daemon has declared idempotent are retried, because a start that fails with a
+// transient error might still have reached the user daemon and started the command.
+func remoteRetries(cmd *cobra.Command, value string) (int, error) {
+	retries, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errcat.User.New(err)
+	}
+	if retries > 0 && !cliutil.IsIdempotent(cmd) {
+		return 0, errcat.User.Newf("--retries can't be used with %q, because it isn't idempotent and might run twice",
+			cliutil.RemoteName(cmd))
+	}
+	return retries, nil
+}
+
+// expandEnv returns a copy of args where $VAR and ${VAR} are replaced with the value that lookup returns for
+// VAR, or with the empty string when lookup doesn't find it. Each arg is expanded once, so a value that
+// contains a $ is forwarded as is rather than being expanded again.
+func expandEnv(args []string, lookup func(string) (string, bool)) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = os.Expand(arg, func(k string) string {
+			v, _ := lookup(k)
+			return v
+		})
+	}
+	return expanded
+}
+
+// openTeeFiles creates the files that the --tee, --tee-out, and --tee-err flags name, and returns the writers
+// that receive the copies of stdout and stderr. The --tee file receives the output that isn't copied to a
+// --tee-out or --tee-err file. A writer is nil when its output isn't copied. The returned function closes the
+// files.
+func openTeeFiles(tee, teeOut, teeErr string) (io.Writer, io.Writer, func() error, error) {
+	if teeOut == "" {
+		teeOut = tee
+	}
+	if teeErr == "" {
+		teeErr = tee
+	}
+	files := make(map[string]*os.File, 2)
+	closeFiles := func() error {
+		var err error
+		for _, f := range files {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	open := func(path string) (io.Writer, error) {
+		if path == "" {
+			return nil, nil
+		}
+		if f, ok := files[path]; ok {
+			// Both stdout and stderr are copied to this file
+			return f, nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, errcat.User.Newf("unable to create tee file: %w", err)
+		}
+		files[path] = f
+		return f, nil
+	}
+	stdout, err := open(teeOut)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stderr, err := open(teeErr)
+	if err != nil {
+		_ = closeFiles()
+		return nil, nil, nil, err
+	}
+	return stdout, stderr, closeFiles, nil
+}
+
+// openStdinFile returns the reader that a remote command uses as its stdin when the --stdin-file flag has the
+// given value, together with a function that closes it. An empty value and "-" both mean stdin, which is never
+// closed.
+func openStdinFile(path string, stdin io.Reader) (io.Reader, func(), error) {
+	if path == "" || path == "-" {
+		return stdin, func() {}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errcat.User.Newf("unable to open --stdin-file: %w", err)
+	}
+	return f, func() { _ = f.Close() }, nil
+}
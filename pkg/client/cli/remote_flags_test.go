@@ -0,0 +1,325 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func newRemoteTestCommand(ownFlags func(*cobra.Command)) *cobra.Command {
+	cmd := &cobra.Command{Use: "intercept", DisableFlagParsing: true}
+	if ownFlags != nil {
+		ownFlags(cmd)
+	}
+	addClientFlags(cliutil.CommandGroups{"Test": {cmd}})
+	return cmd
+}
+
+func TestExtractClientFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		remain  []string
+		wantTTY bool
+	}{
+		{"none", []string{"foo", "--port", "8080"}, []string{"foo", "--port", "8080"}, false},
+		{"long", []string{"foo", "--tty"}, []string{"foo"}, true},
+		{"long with value", []string{"--tty=false", "foo"}, []string{"foo"}, false},
+		{"shorthand", []string{"-t", "foo"}, []string{"foo"}, true},
+		{"after dashes", []string{"foo", "--", "docker", "run", "-t"}, []string{"foo", "--", "docker", "run", "-t"}, false},
+		{"combined shorthands", []string{"foo", "-it"}, []string{"foo", "-it"}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newRemoteTestCommand(nil)
+			remain, err := extractClientFlags(cmd, tt.args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.remain, remain)
+			f := clientFlag(cmd, "tty")
+			require.NotNil(t, f)
+			assert.Equal(t, tt.wantTTY, f.Value.String() == "true")
+		})
+	}
+}
+
+func TestExtractClientFlags_invalid(t *testing.T) {
+	cmd := newRemoteTestCommand(nil)
+	_, err := extractClientFlags(cmd, []string{"--tty=maybe"})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestExtractClientFlags_conflicts(t *testing.T) {
+	// The remote command's own flags take precedence and must be forwarded
+	cmd := newRemoteTestCommand(func(cmd *cobra.Command) {
+		cmd.Flags().StringP("tag", "t", "", "")
+	})
+	f := clientFlag(cmd, "tty")
+	require.NotNil(t, f)
+	assert.Empty(t, f.Shorthand)
+
+	remain, err := extractClientFlags(cmd, []string{"-t", "x", "--tty"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-t", "x"}, remain)
+	assert.Equal(t, "true", f.Value.String())
+
+	cmd = newRemoteTestCommand(func(cmd *cobra.Command) {
+		cmd.Flags().Bool("tty", false, "")
+	})
+	assert.Nil(t, clientFlag(cmd, "tty"))
+	remain, err = extractClientFlags(cmd, []string{"--tty"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--tty"}, remain)
+}
+
+func TestAddClientFlags_defaultTimeout(t *testing.T) {
+	newCmd := func() *cobra.Command {
+		return newRemoteTestCommand(func(cmd *cobra.Command) {
+			cmd.Annotations = map[string]string{cliutil.CommandDefaultTimeout: "5m"}
+		})
+	}
+
+	// The default timeout that the user daemon advertises is applied
+	cmd := newCmd()
+	f := clientFlag(cmd, "timeout")
+	require.NotNil(t, f)
+	assert.Equal(t, "5m0s", f.Value.String())
+	assert.Equal(t, "5m0s", f.DefValue)
+
+	// and can be overridden, also to no timeout at all
+	_, err := extractClientFlags(cmd, []string{"--timeout", "10s"})
+	require.NoError(t, err)
+	assert.Equal(t, "10s", f.Value.String())
+	cmd = newCmd()
+	_, err = extractClientFlags(cmd, []string{"--timeout=0"})
+	require.NoError(t, err)
+	assert.Equal(t, "0s", clientFlag(cmd, "timeout").Value.String())
+
+	// Commands without a default timeout have none
+	f = clientFlag(newRemoteTestCommand(nil), "timeout")
+	require.NotNil(t, f)
+	assert.Equal(t, "0s", f.Value.String())
+}
+
+func TestMakeRaw_notATerminal(t *testing.T) {
+	_, err := makeRaw(strings.NewReader(""))
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestForwardedEnv(t *testing.T) {
+	local := map[string]string{"NO_COLOR": "1", "KUBECONFIG": "/home/me/.kube/config", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := local[k]
+		return v, ok
+	}
+	tests := []struct {
+		name   string
+		values []string
+		want   map[string]string
+	}{
+		{"none", nil, nil},
+		{"pass-through", []string{"NO_COLOR", "EMPTY"}, map[string]string{"NO_COLOR": "1", "EMPTY": ""}},
+		{"override", []string{"KUBECONFIG=/tmp/config"}, map[string]string{"KUBECONFIG": "/tmp/config"}},
+		{"override with empty value", []string{"NO_COLOR="}, map[string]string{"NO_COLOR": ""}},
+		{"value containing =", []string{"OPTS=a=b"}, map[string]string{"OPTS": "a=b"}},
+		{"last one wins", []string{"NO_COLOR", "NO_COLOR=0"}, map[string]string{"NO_COLOR": "0"}},
+		{"unset is skipped", []string{"UNSET", "NO_COLOR"}, map[string]string{"NO_COLOR": "1"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := forwardedEnv(tt.values, lookup)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, env)
+		})
+	}
+
+	_, err := forwardedEnv([]string{"=value"}, lookup)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func Test_sanitizeEnv(t *testing.T) {
+	env := func() map[string]string {
+		return map[string]string{"KUBECONFIG": "/tmp/config", "NO_COLOR": "1", "AWS_PROFILE": "dev", "AWS_SECRET_ACCESS_KEY": "key"}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{"no lists", nil, []string{"AWS_PROFILE", "AWS_SECRET_ACCESS_KEY", "KUBECONFIG", "NO_COLOR"}},
+		{"allow", map[string]string{cliutil.CommandEnvAllow: "KUBECONFIG, AWS_*"}, []string{"AWS_PROFILE", "AWS_SECRET_ACCESS_KEY", "KUBECONFIG"}},
+		{"deny", map[string]string{cliutil.CommandEnvDeny: "*_SECRET_*"}, []string{"AWS_PROFILE", "KUBECONFIG", "NO_COLOR"}},
+		{"deny wins", map[string]string{cliutil.CommandEnvAllow: "AWS_*", cliutil.CommandEnvDeny: "AWS_SECRET_ACCESS_KEY"}, []string{"AWS_PROFILE"}},
+		{"malformed pattern", map[string]string{cliutil.CommandEnvAllow: "[", cliutil.CommandEnvDeny: "["}, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{Use: "intercept", Annotations: tt.annotations}
+			stderr := &bytes.Buffer{}
+			got := sanitizeEnv(cmd, env(), stderr)
+			var names []string
+			for k := range got {
+				names = append(names, k)
+			}
+			assert.ElementsMatch(t, tt.want, names)
+
+			// Each dropped variable is warned about, but its value is never shown
+			assert.Equal(t, 4-len(tt.want), strings.Count(stderr.String(), "Warning: "))
+			assert.NotContains(t, stderr.String(), "key")
+		})
+	}
+	assert.Nil(t, sanitizeEnv(&cobra.Command{Annotations: map[string]string{cliutil.CommandEnvAllow: "X"}}, nil, &bytes.Buffer{}))
+}
+
+func TestExtractClientFlags_env(t *testing.T) {
+	cmd := newRemoteTestCommand(nil)
+	remain, err := extractClientFlags(cmd, []string{"foo", "--env", "NO_COLOR", "--env=KUBECONFIG=/tmp/config"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, remain)
+	f := clientFlag(cmd, "env")
+	require.NotNil(t, f)
+	assert.Equal(t, []string{"NO_COLOR", "KUBECONFIG=/tmp/config"}, f.Value.(pflag.SliceValue).GetSlice())
+}
+
+func TestRemoteRetries(t *testing.T) {
+	idempotent := &cobra.Command{Use: "gather-traces", Annotations: map[string]string{cliutil.CommandIdempotent: "true"}}
+	mutating := &cobra.Command{Use: "intercept"}
+
+	retries, err := remoteRetries(idempotent, "3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, retries)
+
+	retries, err = remoteRetries(mutating, "0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, retries)
+
+	_, err = remoteRetries(mutating, "3")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "isn't idempotent")
+
+	_, err = remoteRetries(idempotent, "many")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestExpandEnv(t *testing.T) {
+	local := map[string]string{"HOME": "/home/me", "PRICE": "$5", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := local[k]
+		return v, ok
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"none", []string{}, []string{}},
+		{"plain", []string{"--namespace", "default"}, []string{"--namespace", "default"}},
+		{"dollar", []string{"$HOME/config"}, []string{"/home/me/config"}},
+		{"braces", []string{"--kubeconfig=${HOME}/.kube/config"}, []string{"--kubeconfig=/home/me/.kube/config"}},
+		{"unset and empty", []string{"a${UNSET}b$EMPTY"}, []string{"ab"}},
+		{"value is not expanded again", []string{"$PRICE", "/home/me$5"}, []string{"$5", "/home/me"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, expandEnv(tt.args, lookup))
+		})
+	}
+}
+
+func TestExtractClientFlags_expandEnv(t *testing.T) {
+	cmd := newRemoteTestCommand(nil)
+	remain, err := extractClientFlags(cmd, []string{"$HOME/config"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$HOME/config"}, remain)
+	f := clientFlag(cmd, "expand-env")
+	require.NotNil(t, f)
+	assert.Equal(t, "false", f.Value.String(), "expansion is opt-in")
+
+	remain, err = extractClientFlags(cmd, []string{"--expand-env", "$HOME/config"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$HOME/config"}, remain, "args are expanded when the command runs")
+	assert.Equal(t, "true", f.Value.String())
+}
+
+func TestOpenStdinFile(t *testing.T) {
+	stdin := strings.NewReader("from stdin")
+	for _, path := range []string{"", "-"} {
+		rd, closeStdin, err := openStdinFile(path, stdin)
+		require.NoError(t, err)
+		assert.Same(t, stdin, rd)
+		closeStdin()
+	}
+
+	path := filepath.Join(t.TempDir(), "input.txt")
+	payload := bytes.Repeat([]byte("line of input\n"), 10000)
+	require.NoError(t, os.WriteFile(path, payload, 0o600))
+	cmd := newRemoteTestCommand(nil)
+	_, err := extractClientFlags(cmd, []string{"--stdin-file", path})
+	require.NoError(t, err)
+	rd, closeStdin, err := openStdinFile(clientFlag(cmd, "stdin-file").Value.String(), stdin)
+	require.NoError(t, err)
+
+	// The whole file is forwarded to the command
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	stdinPump(ctx, cs, rd, 4096, nil, nil)
+	data, _ := cs.sentData()
+	assert.Equal(t, payload, data)
+
+	// The file is closed by the returned function
+	closeStdin()
+	_, err = rd.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestOpenTeeFiles(t *testing.T) {
+	stdout, stderr, closeTee, err := openTeeFiles("", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, stdout)
+	assert.Nil(t, stderr)
+	require.NoError(t, closeTee())
+
+	dir := t.TempDir()
+	both, out := filepath.Join(dir, "both.log"), filepath.Join(dir, "out.log")
+	stdout, stderr, closeTee, err = openTeeFiles(both, "", "")
+	require.NoError(t, err)
+	assert.Same(t, stdout, stderr, "the file is opened once")
+	require.NoError(t, closeTee())
+
+	// --tee-out takes precedence over --tee
+	stdout, stderr, closeTee, err = openTeeFiles(both, out, "")
+	require.NoError(t, err)
+	assert.Equal(t, out, stdout.(*os.File).Name())
+	assert.Equal(t, both, stderr.(*os.File).Name())
+	require.NoError(t, closeTee())
+
+	_, _, _, err = openTeeFiles("", filepath.Join(dir, "missing", "out.log"), "")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestOpenStdinFile_missing(t *testing.T) {
+	_, _, err := openStdinFile(filepath.Join(t.TempDir(), "missing.txt"), nil)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
+)
+
+// OnErrorTimeout is the time that the --on-error hook of a remote command gets to finish before it's killed.
+var OnErrorTimeout = time.Minute
+
+// onErrorExitCodeEnv is the environment variable that holds the exit code of the failed remote command
+// when the --on-error hook runs.
+const onErrorExitCodeEnv = "TELEPRESENCE_EXIT_CODE"
+
+// hookStdio gives a hook no stdin, and writes both its stdout and its stderr to the given writer.
+type hookStdio struct {
+	out io.Writer
+}
+
+func (hookStdio) InOrStdin() io.Reader {
+	return eofReader{}
+}
+
+func (h hookStdio) OutOrStdout() io.Writer {
+	return h.out
+}
+
+func (h hookStdio) ErrOrStderr() io.Writer {
+	return h.out
+}
+
+// runOnErrorHook runs the given command line locally, with the given exit code of the remote command in the
+// TELEPRESENCE_EXIT_CODE environment variable. The hook's output, and the reason why it failed, if it does,
+// are written to stderr. The hook's failure isn't returned, because the error of the remote command is what
+// the client ends with.
+func runOnErrorHook(ctx context.Context, hook string, exitCode int, stderr io.Writer) {
+	args, err := shellquote.Split(hook)
+	if err == nil && len(args) == 0 {
+		err = errors.New("no command")
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --on-error hook %q: %v\n", hook, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, OnErrorTimeout)
+	defer cancel()
+	env := map[string]string{onErrorExitCodeEnv: strconv.Itoa(exitCode)}
+	if err = proc.Run(ctx, env, hookStdio{out: stderr}, args[0], args[1:]...); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			fmt.Fprintf(stderr, "the --on-error hook did not finish within %s\n", OnErrorTimeout)
+		} else {
+			fmt.Fprintf(stderr, "the --on-error hook failed: %v\n", err)
+		}
+	}
+}
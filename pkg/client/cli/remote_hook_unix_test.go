@@ -0,0 +1,89 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func TestRunRemoteCommand_onError(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "make", Code: 17})
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{
+		Args:    []string{"intercept", "foo", "--", "make"},
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+		OnError: `sh -c 'echo "cleanup after $TELEPRESENCE_EXIT_CODE"; echo oops >&2'`,
+	}
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.Equal(t, 17, errcat.ExitCode(err), "the hook doesn't change the result")
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "cleanup after 17\noops\n", stderr.String())
+}
+
+func TestRunRemoteCommand_onErrorNotRunOnSuccess(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("hello\n")}}
+	close(cs.results)
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	rc := RemoteCommand{
+		Args:    []string{"echo", "hello"},
+		Stdout:  io.Discard,
+		Stderr:  io.Discard,
+		OnError: "touch " + marker,
+	}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	_, err := os.Stat(marker)
+	assert.True(t, os.IsNotExist(err), "the hook must not run when the command succeeds")
+}
+
+func TestRunRemoteCommand_onErrorTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { OnErrorTimeout = timeout }(OnErrorTimeout)
+	OnErrorTimeout = 50 * time.Millisecond
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(errcat.User.New("bad flag"))}
+
+	var stderr bytes.Buffer
+	rc := RemoteCommand{
+		Args:    []string{"echo", "--bad"},
+		Stdout:  io.Discard,
+		Stderr:  &stderr,
+		OnError: "sleep 10",
+	}
+	start := time.Now()
+	err := rc.Run(ctx, &fakeConnector{stream: cs})
+	require.Error(t, err)
+	assert.Equal(t, "bad flag", err.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Contains(t, stderr.String(), "the --on-error hook did not finish within 50ms")
+}
+
+func TestRunOnErrorHook_invalid(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var stderr bytes.Buffer
+	runOnErrorHook(ctx, "  ", 1, &stderr)
+	assert.Equal(t, "invalid --on-error hook \"  \": no command\n", stderr.String())
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"errors"
+	"io"
+)
+
+// errOutputLimit is returned by the writers of an outputLimit once the limit has been exceeded.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// outputLimit counts the output that is written to its writers, and makes them fail with errOutputLimit once
+// the total exceeds the limit. The part of a write that fits within the limit is written.
+type outputLimit struct {
+	max     int64
+	written int64
+}
+
+// writer returns an io.Writer that writes to w and counts toward the limit.
+func (ol *outputLimit) writer(w io.Writer) io.Writer {
+	return &limitedWriter{w: w, ol: ol}
+}
+
+// exceeded returns true if the output has exceeded the limit.
+func (ol *outputLimit) exceeded() bool {
+	return ol.written > ol.max
+}
+
+type limitedWriter struct {
+	w  io.Writer
+	ol *outputLimit
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	ol := lw.ol
+	if ol.exceeded() {
+		return 0, errOutputLimit
+	}
+	room := ol.max - ol.written
+	ol.written += int64(len(p))
+	if int64(len(p)) <= room {
+		return lw.w.Write(p)
+	}
+	n, err := lw.w.Write(p[:room])
+	if err == nil {
+		err = errOutputLimit
+	}
+	return n, err
+}
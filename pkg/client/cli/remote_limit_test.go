@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func Test_outputLimit(t *testing.T) {
+	var out, errOut bytes.Buffer
+	ol := &outputLimit{max: 10}
+	stdout, stderr := ol.writer(&out), ol.writer(&errOut)
+
+	// The writes to stdout and stderr count toward the same limit
+	n, err := stdout.Write([]byte("12345"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	n, err = stderr.Write([]byte("abcde"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.False(t, ol.exceeded(), "output at the limit is within it")
+
+	// One byte more exceeds it
+	n, err = stdout.Write([]byte("6"))
+	assert.ErrorIs(t, err, errOutputLimit)
+	assert.Equal(t, 0, n)
+	assert.True(t, ol.exceeded())
+	assert.Equal(t, "12345", out.String())
+	assert.Equal(t, "abcde", errOut.String())
+
+	// Nothing more is written
+	_, err = stderr.Write([]byte("f"))
+	assert.ErrorIs(t, err, errOutputLimit)
+	assert.Equal(t, "abcde", errOut.String())
+}
+
+func Test_outputLimit_partialWrite(t *testing.T) {
+	var out bytes.Buffer
+	ol := &outputLimit{max: 4}
+	n, err := ol.writer(&out).Write([]byte("123456"))
+	assert.ErrorIs(t, err, errOutputLimit)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "1234", out.String())
+	assert.True(t, ol.exceeded())
+}
+
+func TestRunRemoteCommand_maxOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("out 1\n"), Channel: connector.Result_CHANNEL_STDOUT}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("err 1\n"), Channel: connector.Result_CHANNEL_STDERR}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("out 2\n"), Channel: connector.Result_CHANNEL_STDOUT}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("out 3\n"), Channel: connector.Result_CHANNEL_STDOUT}}
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetSoftCancel() {
+			cmdErr := errcat.NoDaemonLogs.New(&proc.ExitError{Cmd: "yes", Code: 130})
+			cs.results <- &connector.StreamResult{Final: true, Data: errcat.ToResult(cmdErr)}
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"yes"}, Stdout: &stdout, Stderr: &stderr, MaxOutput: 15}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+
+	// The output is cut at the boundary, and the command is cancelled
+	assert.Equal(t, "out 1\nout", stdout.String())
+	assert.Equal(t, "err 1\noutput truncated: the command wrote more than 15 bytes\n", stderr.String())
+	softCancels := 0
+	for _, rq := range cs.sentRequests() {
+		if rq.GetSoftCancel() {
+			softCancels++
+		}
+	}
+	assert.Equal(t, 1, softCancels)
+}
+
+func TestRunRemoteCommand_maxOutputNotExceeded(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("0123456789"), Channel: connector.Result_CHANNEL_STDOUT}}
+	close(cs.results)
+
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"echo"}, Stdout: &stdout, Stderr: &stderr, MaxOutput: 10}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "0123456789", stdout.String())
+	assert.Empty(t, stderr.String())
+	for _, rq := range cs.sentRequests() {
+		assert.False(t, rq.GetSoftCancel())
+	}
+}
+
+func TestRunRemoteCommand_maxOutputExcludesDiagnostics(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("retrying\n"), Channel: connector.Result_CHANNEL_DIAGNOSTIC}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("0123456789"), Channel: connector.Result_CHANNEL_STDOUT}}
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("slow\n"), Channel: connector.Result_CHANNEL_DIAGNOSTIC}}
+	close(cs.results)
+
+	var stdout, stderr bytes.Buffer
+	rc := RemoteCommand{Args: []string{"echo"}, Stdout: &stdout, Stderr: &stderr, MaxOutput: 10}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+
+	// The output of the command is exactly at the limit, and the diagnostics don't push it over
+	assert.Equal(t, "0123456789", stdout.String())
+	assert.Equal(t, "retrying\nslow\n", stderr.String())
+	for _, rq := range cs.sentRequests() {
+		assert.False(t, rq.GetSoftCancel())
+	}
+}
@@ -0,0 +1,36 @@
+package cli
+
+// localeVariables are the environment variables that describe the locale and time zone of the client. They're
+// forwarded to remote commands so that they format dates, times, and numbers like local commands do.
+var localeVariables = []string{
+	"LANG",
+	"LANGUAGE",
+	"LC_ALL",
+	"LC_ADDRESS",
+	"LC_COLLATE",
+	"LC_CTYPE",
+	"LC_IDENTIFICATION",
+	"LC_MEASUREMENT",
+	"LC_MESSAGES",
+	"LC_MONETARY",
+	"LC_NAME",
+	"LC_NUMERIC",
+	"LC_PAPER",
+	"LC_TELEPHONE",
+	"LC_TIME",
+	"TZ",
+}
+
+// localeEnv returns the locale variables that lookup finds, or nil if it finds none.
+func localeEnv(lookup func(string) (string, bool)) map[string]string {
+	var env map[string]string
+	for _, k := range localeVariables {
+		if v, ok := lookup(k); ok {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[k] = v
+		}
+	}
+	return env
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func Test_localeEnv(t *testing.T) {
+	environ := map[string]string{
+		"LANG":        "sv_SE.UTF-8",
+		"LC_TIME":     "en_GB.UTF-8",
+		"LC_MONETARY": "",
+		"TZ":          "Europe/Stockholm",
+		"HOME":        "/home/me",
+		"LC_BOGUS":    "x",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := environ[k]
+		return v, ok
+	}
+	assert.Equal(t, map[string]string{
+		"LANG":        "sv_SE.UTF-8",
+		"LC_TIME":     "en_GB.UTF-8",
+		"LC_MONETARY": "",
+		"TZ":          "Europe/Stockholm",
+	}, localeEnv(lookup))
+
+	assert.Nil(t, localeEnv(func(string) (string, bool) { return "", false }))
+}
+
+func TestRunRemoteCommand_locale(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	close(cs.results)
+	rc := RemoteCommand{
+		Args:   []string{"date"},
+		Env:    map[string]string{"TZ": "UTC"},
+		Locale: map[string]string{"LANG": "de_DE.UTF-8", "TZ": "Europe/Berlin"},
+		Stdout: &bytes.Buffer{},
+	}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	c := cs.sentRequests()[0].GetCommand()
+	require.NotNil(t, c)
+	assert.Equal(t, map[string]string{"LANG": "de_DE.UTF-8", "TZ": "Europe/Berlin"}, c.Locale)
+	assert.Equal(t, map[string]string{"TZ": "UTC"}, c.Env)
+
+	// No locale is sent when there is none
+	cs = newFakeCmdStream(ctx)
+	close(cs.results)
+	rc.Locale = nil
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Empty(t, cs.sentRequests()[0].GetCommand().Locale)
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// remoteOutput is the JSON object that is written to stdout when a remote command is run with
+// --output=json. Output that isn't valid UTF-8 is base64 encoded, which is declared using the
+// corresponding encoding field.
+type remoteOutput struct {
+	Cmd            string `json:"cmd"`
+	Stdout         string `json:"stdout"`
+	StdoutEncoding string `json:"stdoutEncoding,omitempty"`
+	Stderr         string `json:"stderr"`
+	StderrEncoding string `json:"stderrEncoding,omitempty"`
+	ExitCode       int    `json:"exitCode"`
+	Err            string `json:"err,omitempty"`
+}
+
+// runWithJSONOutput runs the command with its output captured, and then writes it to rc.Stdout as
+// a remoteOutput. Like other commands that produce JSON, the result of the command is conveyed in
+// that output, so no error is returned unless the output can't be written.
+func (rc *RemoteCommand) runWithJSONOutput(ctx context.Context, userD connector.ConnectorClient) error {
+	var stdout, stderr bytes.Buffer
+	cc := *rc
+	cc.JSONOutput = false
+	cc.Stdout = &stdout
+	cc.Stderr = &stderr
+	err := cc.Run(ctx, userD)
+
+	ro := remoteOutput{ExitCode: errcat.ExitCode(err)}
+	if len(rc.Args) > 0 {
+		ro.Cmd = rc.Args[0]
+	}
+	ro.Stdout, ro.StdoutEncoding = encodeOutput(stdout.Bytes())
+	ro.Stderr, ro.StderrEncoding = encodeOutput(stderr.Bytes())
+	if err != nil {
+		ro.Err = err.Error()
+	}
+
+	out := rc.Stdout
+	if out == nil {
+		out = io.Discard
+	}
+	return json.NewEncoder(out).Encode(&ro)
+}
+
+// encodeOutput returns data as a string, and the encoding used if data isn't valid UTF-8.
+func encodeOutput(data []byte) (string, string) {
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
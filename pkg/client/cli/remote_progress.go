@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// ProgressInterval is the minimum time between the lines that show the progress of a remote command when
+// stderr isn't a terminal.
+var ProgressInterval = time.Second
+
+// spinnerFrames are shown, one per update, in front of progress that has no known total.
+var spinnerFrames = []string{"|", "/", "-", `\`}
+
+// clearLine moves the cursor of a terminal to the start of the line and erases the line.
+const clearLine = "\r\x1b[K"
+
+// progressRenderer shows the progress of a remote command. A terminal gets a line that is updated in place,
+// with a spinner when the total is unknown. Other writers get a line per ProgressInterval, and a line when the
+// progress is done. A nil progressRenderer discards the progress.
+type progressRenderer struct {
+	w        io.Writer
+	terminal bool
+	now      func() time.Time
+	spin     int
+
+	// shown is true while a line that must be cleared before other output is shown on the terminal
+	shown bool
+
+	// written is when the last line was written to a writer that isn't a terminal
+	written time.Time
+}
+
+func newProgressRenderer(w io.Writer, terminal bool) *progressRenderer {
+	return &progressRenderer{w: w, terminal: terminal, now: time.Now}
+}
+
+// show writes the given progress.
+func (pr *progressRenderer) show(p *connector.Progress) error {
+	if pr == nil {
+		return nil
+	}
+	text := client.ProgressText(p)
+	if !pr.terminal {
+		now := pr.now()
+		if !p.Done && now.Sub(pr.written) < ProgressInterval {
+			return nil
+		}
+		pr.written = now
+		_, err := fmt.Fprintln(pr.w, text)
+		return err
+	}
+	if p.Done {
+		pr.shown = false
+		_, err := fmt.Fprint(pr.w, clearLine+text+"\n")
+		return err
+	}
+	if p.Total == 0 {
+		text = spinnerFrames[pr.spin%len(spinnerFrames)] + " " + text
+		pr.spin++
+	}
+	pr.shown = true
+	_, err := fmt.Fprint(pr.w, clearLine+text)
+	return err
+}
+
+// clear erases the progress that is shown on the terminal, so that other output can be written.
+func (pr *progressRenderer) clear() error {
+	if pr == nil || !pr.shown {
+		return nil
+	}
+	pr.shown = false
+	_, err := io.WriteString(pr.w, clearLine)
+	return err
+}
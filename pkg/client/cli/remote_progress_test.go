@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func TestProgressRenderer_terminal(t *testing.T) {
+	var buf bytes.Buffer
+	pr := newProgressRenderer(&buf, true)
+	require.NoError(t, pr.show(&connector.Progress{Label: "pulling", Current: 1, Total: 4}))
+	require.NoError(t, pr.show(&connector.Progress{Label: "pulling", Current: 2, Total: 4}))
+	assert.Equal(t, "\r\x1b[Kpulling: 25% (1/4)\r\x1b[Kpulling: 50% (2/4)", buf.String())
+
+	// The line is cleared once before other output, and a new progress starts on a clean line
+	buf.Reset()
+	require.NoError(t, pr.clear())
+	require.NoError(t, pr.clear())
+	require.NoError(t, pr.show(&connector.Progress{Label: "scanning"}))
+	require.NoError(t, pr.show(&connector.Progress{Label: "scanning", Current: 3}))
+	assert.Equal(t, "\r\x1b[K\r\x1b[K| scanning\r\x1b[K/ scanning: 3", buf.String())
+
+	// Done progress is kept
+	buf.Reset()
+	require.NoError(t, pr.show(&connector.Progress{Label: "scanning", Current: 5, Done: true}))
+	require.NoError(t, pr.clear())
+	assert.Equal(t, "\r\x1b[Kscanning: 5 done\n", buf.String())
+}
+
+func TestProgressRenderer_lines(t *testing.T) {
+	defer func(interval time.Duration) { ProgressInterval = interval }(ProgressInterval)
+	ProgressInterval = time.Second
+
+	var buf bytes.Buffer
+	now := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	pr := newProgressRenderer(&buf, false)
+	pr.now = func() time.Time { return now }
+	show := func(current uint64, done bool, elapsed time.Duration) {
+		now = now.Add(elapsed)
+		require.NoError(t, pr.show(&connector.Progress{Label: "pulling", Current: current, Total: 10, Done: done}))
+	}
+	show(1, false, 0)
+	show(2, false, 500*time.Millisecond)
+	show(3, false, 500*time.Millisecond)
+	show(4, false, 100*time.Millisecond)
+	show(10, true, 100*time.Millisecond)
+	require.NoError(t, pr.clear())
+	assert.Equal(t, "pulling: 10% (1/10)\npulling: 30% (3/10)\npulling: 100% (10/10)\n", buf.String())
+}
+
+func TestProgressRenderer_nil(t *testing.T) {
+	var pr *progressRenderer
+	assert.NoError(t, pr.show(&connector.Progress{Label: "pulling"}))
+	assert.NoError(t, pr.clear())
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// stdinPrompts routes the input that the stdinPump reads to the prompts of the command while any are pending,
+// so that the answers are sent as prompt replies rather than as stdin data. What the command reads from its
+// stdin can therefore never contain an answer, and an answer never contains input that was meant for stdin.
+type stdinPrompts struct {
+	mu      sync.Mutex
+	pending []*connector.Prompt
+	answer  []byte
+	ended   bool
+}
+
+func promptReply(p *connector.Prompt, answer []byte, eof bool) *connector.RunCommandRequest {
+	return &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_PromptReply{
+		PromptReply: &connector.PromptReply{Id: p.Id, Text: string(answer), Eof: eof},
+	}}
+}
+
+// add queues the given prompt. A reply is returned right away if stdin has ended.
+func (sp *stdinPrompts) add(p *connector.Prompt) *connector.RunCommandRequest {
+	if sp == nil {
+		return promptReply(p, nil, true)
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.ended {
+		return promptReply(p, nil, true)
+	}
+	sp.pending = append(sp.pending, p)
+	return nil
+}
+
+// route returns the replies to the pending prompts that the given input completes, and the rest of the input,
+// which is stdin. A line is ended by a LF, a CR, or a CR LF. A line ending that directly follows a single
+// character answer is considered a part of it, so that a "y" followed by return doesn't leave an empty line
+// for stdin.
+func (sp *stdinPrompts) route(data []byte) (replies []*connector.RunCommandRequest, rest []byte) {
+	if sp == nil {
+		return nil, data
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	skipEOL := false
+	for len(data) > 0 {
+		if skipEOL {
+			skipEOL = false
+			if n := eolLen(data); n > 0 {
+				data = data[n:]
+				continue
+			}
+		}
+		if len(sp.pending) == 0 {
+			break
+		}
+		p := sp.pending[0]
+		if p.SingleChar {
+			sp.answer = append(sp.answer, data[0])
+			data = data[1:]
+			if !utf8.FullRune(sp.answer) {
+				continue
+			}
+			skipEOL = true
+		} else {
+			i := 0
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+			sp.answer = append(sp.answer, data[:i]...)
+			data = data[i:]
+			if len(data) == 0 {
+				break
+			}
+			data = data[eolLen(data):]
+		}
+		replies = append(replies, promptReply(p, sp.answer, false))
+		sp.answer = nil
+		sp.pending = sp.pending[1:]
+	}
+	return replies, data
+}
+
+// end marks the end of stdin, and returns the replies to the prompts that are still pending.
+func (sp *stdinPrompts) end() []*connector.RunCommandRequest {
+	if sp == nil {
+		return nil
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.ended = true
+	replies := make([]*connector.RunCommandRequest, len(sp.pending))
+	for i, p := range sp.pending {
+		replies[i] = promptReply(p, sp.answer, true)
+		sp.answer = nil
+	}
+	sp.pending = nil
+	return replies
+}
+
+// eolLen returns the length of the line ending that data starts with, or zero if it doesn't start with one.
+func eolLen(data []byte) int {
+	switch {
+	case data[0] == '\n':
+		return 1
+	case data[0] == '\r':
+		if len(data) > 1 && data[1] == '\n' {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func replyTexts(rqs []*connector.RunCommandRequest) []string {
+	var texts []string
+	for _, rq := range rqs {
+		if pr := rq.GetPromptReply(); pr != nil {
+			text := pr.Text
+			if pr.Eof {
+				text += "<EOF>"
+			}
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+func Test_stdinPrompts_route(t *testing.T) {
+	line := &connector.Prompt{Id: 1}
+	char := &connector.Prompt{Id: 2, SingleChar: true}
+	tests := []struct {
+		name    string
+		prompts []*connector.Prompt
+		chunks  []string
+		replies []string
+		stdin   string
+	}{
+		{"no prompt", nil, []string{"y\n"}, nil, "y\n"},
+		{"line", []*connector.Prompt{line}, []string{"yes\nrest\n"}, []string{"yes"}, "rest\n"},
+		{"line in chunks", []*connector.Prompt{line}, []string{"y", "es", "\nrest"}, []string{"yes"}, "rest"},
+		{"line with CR LF", []*connector.Prompt{line}, []string{"yes\r\nrest"}, []string{"yes"}, "rest"},
+		{"line in raw mode", []*connector.Prompt{line}, []string{"yes\rrest"}, []string{"yes"}, "rest"},
+		{"empty line", []*connector.Prompt{line}, []string{"\n"}, []string{""}, ""},
+		{"char", []*connector.Prompt{char}, []string{"yes"}, []string{"y"}, "es"},
+		{"char and return", []*connector.Prompt{char}, []string{"y\nrest\n"}, []string{"y"}, "rest\n"},
+		{"char and separate return", []*connector.Prompt{char}, []string{"y", "\n"}, []string{"y"}, "\n"},
+		{"multibyte char", []*connector.Prompt{char}, []string{"\xc3", "\xa5\n"}, []string{"å"}, ""},
+		{"two prompts", []*connector.Prompt{char, line}, []string{"y\nmy name\nrest"}, []string{"y", "my name"}, "rest"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			sp := &stdinPrompts{}
+			for _, p := range tt.prompts {
+				require.Nil(t, sp.add(p))
+			}
+			var replies []*connector.RunCommandRequest
+			var stdin []byte
+			for _, chunk := range tt.chunks {
+				rs, rest := sp.route([]byte(chunk))
+				replies = append(replies, rs...)
+				stdin = append(stdin, rest...)
+			}
+			assert.Equal(t, tt.replies, replyTexts(replies))
+			assert.Equal(t, tt.stdin, string(stdin))
+		})
+	}
+}
+
+func Test_stdinPrompts_end(t *testing.T) {
+	sp := &stdinPrompts{}
+	require.Nil(t, sp.add(&connector.Prompt{Id: 1}))
+	require.Nil(t, sp.add(&connector.Prompt{Id: 2}))
+	replies, rest := sp.route([]byte("incompl"))
+	assert.Empty(t, replies)
+	assert.Empty(t, rest)
+
+	replies = sp.end()
+	assert.Equal(t, []string{"incompl<EOF>", "<EOF>"}, replyTexts(replies))
+	assert.Equal(t, uint32(2), replies[1].GetPromptReply().Id)
+
+	// Prompts that arrive after the end of stdin are answered right away
+	assert.Equal(t, []string{"<EOF>"}, replyTexts([]*connector.RunCommandRequest{sp.add(&connector.Prompt{Id: 3})}))
+}
+
+// promptStdout is a writer that writes the given answer to stdin when a prompt has been written.
+type promptStdout struct {
+	bytes.Buffer
+	once   sync.Once
+	prompt string
+	answer string
+	stdin  *io.PipeWriter
+}
+
+func (w *promptStdout) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if strings.HasSuffix(w.String(), w.prompt) {
+		w.once.Do(func() {
+			go func() { _, _ = io.WriteString(w.stdin, w.answer) }()
+		})
+	}
+	return n, err
+}
+
+func TestRunRemoteCommand_prompt(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte("deleting everything\n")}}
+	cs.results <- &connector.StreamResult{Prompt: &connector.Prompt{Id: 7, Text: "are you sure? [y/N] ", SingleChar: true}}
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetPromptReply() != nil {
+			cs.results <- &connector.StreamResult{Final: true}
+		}
+	}
+
+	stdinRd, stdinWr := io.Pipe()
+	defer stdinWr.Close()
+	stdout := &promptStdout{prompt: "are you sure? [y/N] ", answer: "y\nmore input\n", stdin: stdinWr}
+	rc := RemoteCommand{
+		Args:   []string{"confirm"},
+		Stdin:  stdinRd,
+		Stdout: stdout,
+	}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "deleting everything\nare you sure? [y/N] ", stdout.String())
+
+	rqs := cs.sentRequests()
+	require.NotEmpty(t, rqs)
+	assert.True(t, rqs[0].GetCommand().GetPrompts())
+	var reply *connector.PromptReply
+	for _, rq := range rqs {
+		if pr := rq.GetPromptReply(); pr != nil {
+			reply = pr
+		}
+	}
+	require.NotNil(t, reply)
+	assert.Equal(t, uint32(7), reply.Id)
+	assert.Equal(t, "y", reply.Text)
+	assert.False(t, reply.Eof)
+}
+
+func TestRunRemoteCommand_promptWithoutStdin(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Prompt: &connector.Prompt{Id: 1, Text: "name: "}}
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if pr := rq.GetPromptReply(); pr != nil && pr.Eof {
+			cs.results <- &connector.StreamResult{Final: true}
+		}
+	}
+	var stdout bytes.Buffer
+	rc := RemoteCommand{Args: []string{"ask"}, Stdout: &stdout}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	assert.Equal(t, "name: ", stdout.String())
+	assert.Equal(t, []string{"<EOF>"}, replyTexts(cs.sentRequests()))
+}
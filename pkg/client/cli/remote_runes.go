@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// runeWriter is an io.Writer that never splits a UTF-8 encoded character between two writes to the
+// underlying writer. A trailing partial character is held back until the next write completes it, or
+// until flush is called.
+type runeWriter struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (rw *runeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(rw.pending) > 0 {
+		p = append(rw.pending, p...)
+		rw.pending = nil
+	}
+	if keep := partialRuneLen(p); keep > 0 {
+		rw.pending = append([]byte(nil), p[len(p)-keep:]...)
+		p = p[:len(p)-keep]
+	}
+	if len(p) > 0 {
+		if _, err := rw.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// flush writes what's held back, even though it isn't a complete character.
+func (rw *runeWriter) flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	p := rw.pending
+	rw.pending = nil
+	_, err := rw.w.Write(p)
+	return err
+}
+
+// partialRuneLen returns the length of the incomplete UTF-8 encoded character at the end of p, or
+// zero if p ends with a complete character or with bytes that can never become one.
+func partialRuneLen(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
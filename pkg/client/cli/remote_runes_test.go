@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// writeRecorder records each write separately.
+type writeRecorder struct {
+	writes [][]byte
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (w *writeRecorder) String() string {
+	return string(bytes.Join(w.writes, nil))
+}
+
+func Test_partialRuneLen(t *testing.T) {
+	euro := []byte("€") // e2 82 ac
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 0},
+		{"ascii", []byte("abc"), 0},
+		{"complete", []byte("a€"), 0},
+		{"first byte", append([]byte("a"), euro[:1]...), 1},
+		{"two bytes", append([]byte("a"), euro[:2]...), 2},
+		{"three of four", []byte("😀")[:3], 3},
+		{"lone continuation", []byte{'a', 0x82}, 0},
+		{"invalid start", []byte{'a', 0xff}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, partialRuneLen(tt.data))
+		})
+	}
+}
+
+func Test_runeWriter(t *testing.T) {
+	var out writeRecorder
+	rw := &runeWriter{w: &out}
+	text := []byte("å€😀 done")
+	for i := range text {
+		n, err := rw.Write(text[i : i+1])
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+	require.NoError(t, rw.flush())
+	assert.Equal(t, string(text), out.String())
+	for _, w := range out.writes {
+		assert.True(t, utf8.Valid(w), "write %q splits a character", w)
+	}
+
+	// An incomplete character at the end is written as is by flush
+	out.writes = nil
+	_, err := rw.Write([]byte("x\xe2\x82"))
+	require.NoError(t, err)
+	assert.Equal(t, "x", out.String())
+	require.NoError(t, rw.flush())
+	assert.Equal(t, "x\xe2\x82", out.String())
+}
+
+func TestRunRemoteCommand_runeAligned(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	euro := []byte("€")
+	run := func(runeAligned bool) (*writeRecorder, *writeRecorder) {
+		cs := newFakeCmdStream(ctx)
+		// A character split across two frames on stdout and on stderr
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: append([]byte("price: "), euro[:1]...)}}
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: euro[:2], ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: append(euro[1:], "10\n"...)}}
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: euro[2:], ErrorCategory: connector.Result_NO_DAEMON_LOGS}}
+		// An incomplete character at the end of the output
+		cs.results <- &connector.StreamResult{Data: &connector.Result{Data: euro[:1]}}
+		close(cs.results)
+		stdout, stderr := &writeRecorder{}, &writeRecorder{}
+		rc := RemoteCommand{Args: []string{"echo"}, Stdout: stdout, Stderr: stderr, RuneAligned: runeAligned}
+		require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+		return stdout, stderr
+	}
+
+	stdout, stderr := run(true)
+	assert.Equal(t, "price: €10\n\xe2", stdout.String())
+	assert.Equal(t, [][]byte{[]byte("price: "), []byte("€10\n"), {0xe2}}, stdout.writes)
+	assert.Equal(t, [][]byte{euro}, stderr.writes)
+
+	// Without it, the output is written as it arrives
+	stdout, stderr = run(false)
+	assert.Equal(t, "price: €10\n\xe2", stdout.String())
+	assert.Len(t, stdout.writes, 3)
+	assert.Len(t, stderr.writes, 2)
+	assert.Equal(t, "€", stderr.String())
+}
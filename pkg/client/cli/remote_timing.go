@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing contains the durations measured while running a remote command.
+type Timing struct {
+	// Start is the time it took to establish the stream and send the command to the user daemon,
+	// including the time spent on retries.
+	Start time.Duration
+
+	// FirstOutput is the time from the start of the command until its first output was received.
+	// It's zero when the command produced no output.
+	FirstOutput time.Duration
+
+	// Total is the time it took to run the command.
+	Total time.Duration
+
+	began time.Time
+}
+
+// String returns a one-line summary of the timing.
+func (t *Timing) String() string {
+	firstOutput := "none"
+	if t.FirstOutput > 0 {
+		firstOutput = t.FirstOutput.String()
+	}
+	return fmt.Sprintf("start: %s, first output: %s, total: %s", t.Start, firstOutput, t.Total)
+}
+
+// The methods below are no-ops on a nil Timing, so that they can be called unconditionally.
+
+func (t *Timing) begin() {
+	if t != nil {
+		*t = Timing{began: time.Now()}
+	}
+}
+
+func (t *Timing) started() {
+	if t != nil {
+		t.Start = time.Since(t.began)
+	}
+}
+
+func (t *Timing) output() {
+	if t != nil && t.FirstOutput == 0 {
+		t.FirstOutput = time.Since(t.began)
+	}
+}
+
+func (t *Timing) end() {
+	if t != nil {
+		t.Total = time.Since(t.began)
+	}
+}
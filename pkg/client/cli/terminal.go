@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+
+	"golang.org/x/term"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// terminalFd returns the file descriptor of f and true if f is a terminal.
+func terminalFd(f any) (int, bool) {
+	if ff, ok := f.(interface{ Fd() uintptr }); ok {
+		fd := int(ff.Fd())
+		if term.IsTerminal(fd) {
+			return fd, true
+		}
+	}
+	return 0, false
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f any) bool {
+	_, ok := terminalFd(f)
+	return ok
+}
+
+// noColor returns true if the NO_COLOR environment variable is set to a non-empty value, which means that
+// the output mustn't contain ANSI colors.
+func noColor(ctx context.Context) bool {
+	if env := client.GetEnv(ctx); env != nil {
+		return env.Get("NO_COLOR") != ""
+	}
+	return false
+}
+
+// makeRaw puts the terminal that stdin is attached to into raw mode and returns a function that
+// restores its previous state.
+func makeRaw(stdin any) (func(), error) {
+	fd, ok := terminalFd(stdin)
+	if !ok {
+		return nil, errcat.User.New("the --tty flag requires that stdin is a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, errcat.NoDaemonLogs.Newf("unable to put the terminal in raw mode: %v", err)
+	}
+	return func() {
+		_ = term.Restore(fd, state)
+	}, nil
+}
@@ -0,0 +1,212 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/term"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestRunRemoteCommand_outputHints(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer func() {
+		_ = tty.Close()
+		_ = ptm.Close()
+	}()
+
+	tests := []struct {
+		name         string
+		stdout       io.Writer
+		noColor      string
+		setNoColor   bool
+		wantTerminal bool
+		wantNoColor  bool
+	}{
+		{name: "buffer", stdout: &bytes.Buffer{}},
+		{name: "terminal", stdout: tty, wantTerminal: true},
+		{name: "NO_COLOR set", stdout: tty, noColor: "1", setNoColor: true, wantTerminal: true, wantNoColor: true},
+		{name: "NO_COLOR empty", stdout: &bytes.Buffer{}, setNoColor: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := client.LoadEnvWith(func(key string) (string, bool) {
+				if key == "NO_COLOR" && tt.setNoColor {
+					return tt.noColor, true
+				}
+				return "", false
+			})
+			require.NoError(t, err)
+			ctx := client.WithEnv(dlog.NewTestContext(t, false), env)
+			cs := newFakeCmdStream(ctx)
+			close(cs.results)
+
+			rc := RemoteCommand{Args: []string{"list"}, Stdout: tt.stdout}
+			require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+			rqs := cs.sentRequests()
+			require.NotEmpty(t, rqs)
+			cmd := rqs[0].GetCommand()
+			require.NotNil(t, cmd)
+			assert.Equal(t, tt.wantTerminal, cmd.StdoutIsTerminal)
+			assert.Equal(t, tt.wantNoColor, cmd.NoColor)
+		})
+	}
+}
+
+func TestRunRemoteCommand_raw(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer func() {
+		_ = tty.Close()
+		_ = ptm.Close()
+	}()
+	initial, err := term.GetState(int(tty.Fd()))
+	require.NoError(t, err)
+
+	// Control characters, CR and LF, and bytes with the high bit set would all be modified or consumed
+	// by the line discipline of a terminal in its default mode.
+	payload := []byte{0x00, 0x01, 0x03, 0x04, '\r', '\n', 0x11, 0x13, 0x1a, 0x1c, 0x7f, 0xff}
+
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	var closeOnce sync.Once
+	cs.onSend = func(rq *connector.RunCommandRequest) {
+		if rq.GetCommand() != nil {
+			// The terminal is in raw mode once the command is sent
+			_, err := ptm.Write(payload)
+			assert.NoError(t, err)
+			return
+		}
+		if data, _ := cs.sentData(); len(data) >= len(payload) {
+			closeOnce.Do(func() { close(cs.results) })
+		}
+	}
+
+	rc := RemoteCommand{Args: []string{"cat"}, Stdin: tty, Raw: true}
+	require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+	data, _ := cs.sentData()
+	assert.Equal(t, payload, data)
+
+	// The terminal is restored when the command ends
+	restored, err := term.GetState(int(tty.Fd()))
+	require.NoError(t, err)
+	assert.Equal(t, initial, restored)
+}
+
+func TestRunRemoteCommand_color(t *testing.T) {
+	const colored = "\x1b[1;31mred\x1b[0m \x1b[2Kplain"
+	const stripped = "red \x1b[2Kplain"
+	tests := []struct {
+		name        string
+		color       ColorMode
+		terminal    bool
+		noColor     bool
+		want        string
+		wantNoColor bool
+	}{
+		{name: "auto terminal", color: ColorAuto, terminal: true, want: colored},
+		{name: "auto pipe", color: ColorAuto, want: stripped},
+		{name: "auto terminal NO_COLOR", color: ColorAuto, terminal: true, noColor: true, want: stripped, wantNoColor: true},
+		{name: "always terminal", color: ColorAlways, terminal: true, want: colored},
+		{name: "always pipe", color: ColorAlways, want: colored},
+		{name: "always pipe NO_COLOR", color: ColorAlways, noColor: true, want: colored},
+		{name: "never terminal", color: ColorNever, terminal: true, want: stripped, wantNoColor: true},
+		{name: "never pipe", color: ColorNever, want: stripped, wantNoColor: true},
+		{name: "unset pipe", want: colored},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := client.LoadEnvWith(func(key string) (string, bool) {
+				if key == "NO_COLOR" && tt.noColor {
+					return "1", true
+				}
+				return "", false
+			})
+			require.NoError(t, err)
+			ctx := client.WithEnv(dlog.NewTestContext(t, false), env)
+			cs := newFakeCmdStream(ctx)
+			// The sequences are split between writes
+			cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte(colored[:3])}}
+			cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte(colored[3:])}}
+			close(cs.results)
+
+			var stdout io.Writer
+			var got func() string
+			if tt.terminal {
+				ptm, tty, err := pty.Open()
+				require.NoError(t, err)
+				defer func() {
+					_ = tty.Close()
+					_ = ptm.Close()
+				}()
+				stdout = tty
+				got = func() string {
+					buf := make([]byte, len(tt.want))
+					_, err := io.ReadFull(ptm, buf)
+					require.NoError(t, err)
+					return string(buf)
+				}
+			} else {
+				var buf bytes.Buffer
+				stdout = &buf
+				got = buf.String
+			}
+
+			rc := RemoteCommand{Args: []string{"list"}, Stdout: stdout, Color: tt.color}
+			require.NoError(t, rc.Run(ctx, &fakeConnector{stream: cs}))
+			assert.Equal(t, tt.want, got())
+			assert.Equal(t, tt.wantNoColor, cs.sentRequests()[0].GetCommand().NoColor)
+		})
+	}
+}
+
+func TestRunRemoteCommand_partialLine(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer func() {
+		_ = tty.Close()
+		_ = ptm.Close()
+	}()
+
+	// A prompt without a trailing newline is visible in a terminal while the command waits for the answer,
+	// although the output would be coalesced for much longer otherwise.
+	const prompt = "Password: "
+	ctx := dlog.NewTestContext(t, false)
+	cs := newFakeCmdStream(ctx)
+	cs.results <- &connector.StreamResult{Data: &connector.Result{Data: []byte(prompt), Channel: connector.Result_CHANNEL_STDOUT}}
+	errCh := make(chan error, 1)
+	go func() {
+		rc := RemoteCommand{Args: []string{"login"}, Stdout: tty, CoalesceOutput: time.Hour}
+		errCh <- rc.Run(ctx, &fakeConnector{stream: cs})
+	}()
+
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, len(prompt))
+		_, _ = io.ReadFull(ptm, buf)
+		got <- string(buf)
+	}()
+	select {
+	case s := <-got:
+		assert.Equal(t, prompt, s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the prompt wasn't written to the terminal")
+	}
+	close(cs.results)
+	require.NoError(t, <-errCh)
+}
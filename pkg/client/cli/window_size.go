@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"os"
+
+	"golang.org/x/term"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// forwardWindowSize sends the size of the terminal with the given file descriptor to the remote command, first
+// when called and then each time a signal arrives on resizeCh. It returns when the context is done or when
+// resizeCh is closed.
+func forwardWindowSize(ctx context.Context, cmdStream connector.Connector_RunCommandClient, fd int, resizeCh <-chan os.Signal) {
+	sendSize := func() bool {
+		cols, rows, err := term.GetSize(fd)
+		if err != nil {
+			dlog.Debugf(ctx, "unable to get terminal size: %v", err)
+			return true
+		}
+		err = cmdStream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_WindowSize_{
+			WindowSize: &connector.RunCommandRequest_WindowSize{Rows: uint32(rows), Cols: uint32(cols)},
+		}})
+		if err != nil {
+			if ctx.Err() == nil {
+				dlog.Errorf(ctx, "failed to send window size: %v", err)
+			}
+			return false
+		}
+		return true
+	}
+
+	if !sendSize() {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-resizeCh:
+			if !ok || !sendSize() {
+				return
+			}
+		}
+	}
+}
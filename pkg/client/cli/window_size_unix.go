@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// windowSizePump forwards the size of the terminal that stdout is attached to, and all subsequent
+// changes to it, to the remote command. It does nothing when stdout isn't a terminal.
+func windowSizePump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, stdout io.Writer) {
+	fd, ok := terminalFd(stdout)
+	if !ok {
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, unix.SIGWINCH)
+	defer signal.Stop(sigCh)
+	forwardWindowSize(ctx, cmdStream, fd, sigCh)
+}
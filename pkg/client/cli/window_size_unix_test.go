@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func sentWindowSizes(stream *fakeCmdStream) []*connector.RunCommandRequest_WindowSize {
+	var sizes []*connector.RunCommandRequest_WindowSize
+	for _, rq := range stream.sentRequests() {
+		if ws := rq.GetWindowSize(); ws != nil {
+			sizes = append(sizes, ws)
+		}
+	}
+	return sizes
+}
+
+func TestWindowSizePump_resize(t *testing.T) {
+	ptm, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer func() {
+		_ = tty.Close()
+		_ = ptm.Close()
+	}()
+	require.NoError(t, pty.Setsize(ptm, &pty.Winsize{Rows: 24, Cols: 80}))
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	stream := newFakeCmdStream(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		windowSizePump(ctx, stream, tty)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return len(sentWindowSizes(stream)) == 1 }, 5*time.Second, time.Millisecond)
+	ws := sentWindowSizes(stream)[0]
+	assert.Equal(t, uint32(24), ws.Rows)
+	assert.Equal(t, uint32(80), ws.Cols)
+
+	// Resize the terminal mid-command. The kernel only signals the foreground process group of the
+	// terminal, so the SIGWINCH must be sent explicitly.
+	require.NoError(t, pty.Setsize(ptm, &pty.Winsize{Rows: 50, Cols: 132}))
+	require.Eventually(t, func() bool {
+		_ = unix.Kill(unix.Getpid(), unix.SIGWINCH)
+		sizes := sentWindowSizes(stream)
+		last := sizes[len(sizes)-1]
+		return last.Rows == 50 && last.Cols == 132
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWindowSizePump_notATerminal(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	stream := newFakeCmdStream(ctx)
+	windowSizePump(ctx, stream, &bytes.Buffer{})
+	assert.Empty(t, stream.sentRequests())
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"context"
+	"io"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// windowSizePump forwards the size of the terminal that stdout is attached to. Windows has no SIGWINCH
+// so only the initial size is sent. It does nothing when stdout isn't a terminal.
+func windowSizePump(ctx context.Context, cmdStream connector.Connector_RunCommandClient, stdout io.Writer) {
+	if fd, ok := terminalFd(stdout); ok {
+		forwardWindowSize(ctx, cmdStream, fd, nil)
+	}
+}
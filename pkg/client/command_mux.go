@@ -0,0 +1,400 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// streamQueue is an unbounded queue of the messages of one of the streams that share a RunCommands stream,
+// so that the demultiplexer never has to wait for a stream whose receiver is slow.
+type streamQueue[T any] struct {
+	mu     sync.Mutex
+	items  []T
+	err    error
+	closed bool
+	ready  chan struct{}
+}
+
+func newStreamQueue[T any]() *streamQueue[T] {
+	return &streamQueue[T]{ready: make(chan struct{}, 1)}
+}
+
+func (q *streamQueue[T]) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// put adds an item to the queue. It's a no-op once the queue is closed.
+func (q *streamQueue[T]) put(item T) {
+	q.mu.Lock()
+	if !q.closed {
+		q.items = append(q.items, item)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close makes get return err, or io.EOF when err is nil, once the queue is drained. Only the first close
+// has an effect.
+func (q *streamQueue[T]) close(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.err = err
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// get returns the next item of the queue. It blocks until there is one, the queue is closed and drained,
+// or the context is done.
+func (q *streamQueue[T]) get(ctx context.Context) (item T, err error) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item = q.items[0]
+			q.items = q.items[1:]
+			more := len(q.items) > 0 || q.closed
+			q.mu.Unlock()
+			if more {
+				// Let the next get through without waiting
+				q.signal()
+			}
+			return item, nil
+		}
+		if q.closed {
+			err = q.err
+			q.mu.Unlock()
+			q.signal()
+			return item, err
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return item, ctx.Err()
+		}
+	}
+}
+
+// ServeMuxedCommands demultiplexes the streams of the given RunCommands stream, and runs each one of them
+// using runCommand, which is typically the server's RunCommand. It returns when the client is done sending
+// and all commands have ended, or when the RunCommands stream breaks.
+func ServeMuxedCommands(mux connector.Connector_RunCommandsServer, runCommand func(connector.Connector_RunCommandServer) error) error {
+	ctx := mux.Context()
+	var sendMu sync.Mutex
+	send := func(r *connector.MuxedStreamResult) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return mux.Send(r)
+	}
+
+	var mu sync.Mutex
+	streams := make(map[uint64]*muxedServerStream)
+	var wg sync.WaitGroup
+	defer func() {
+		// A broken RunCommands stream cancels all streams, a client that is done sending leaves them running
+		mu.Lock()
+		for _, ms := range streams {
+			ms.reqs.close(nil)
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	var lastID uint64
+	for {
+		mr, err := mux.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		id := mr.StreamId
+		mu.Lock()
+		ms := streams[id]
+		if ms == nil {
+			if id <= lastID || mr.Request == nil {
+				// The stream has ended, or never started
+				mu.Unlock()
+				continue
+			}
+			lastID = id
+			ms = newMuxedServerStream(ctx, id, send)
+			streams[id] = ms
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := runCommand(ms)
+				mu.Lock()
+				delete(streams, ms.id)
+				mu.Unlock()
+				ms.cancel()
+				end := &connector.MuxedStreamResult{StreamId: ms.id, End: true}
+				if err != nil {
+					st := status.Convert(err)
+					end.Code, end.Message = uint32(st.Code()), st.Message()
+				}
+				_ = send(end)
+			}()
+		}
+		mu.Unlock()
+		if mr.Request != nil {
+			ms.reqs.put(mr.Request)
+		}
+		if mr.CloseSend {
+			ms.reqs.close(nil)
+		}
+		if mr.Cancel {
+			ms.cancel()
+		}
+	}
+}
+
+// muxedServerStream is one of the streams that share a RunCommands stream.
+type muxedServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	id     uint64
+	send   func(*connector.MuxedStreamResult) error
+	reqs   *streamQueue[*connector.RunCommandRequest]
+}
+
+func newMuxedServerStream(ctx context.Context, id uint64, send func(*connector.MuxedStreamResult) error) *muxedServerStream {
+	ctx, cancel := context.WithCancel(ctx)
+	return &muxedServerStream{
+		ctx:    ctx,
+		cancel: cancel,
+		id:     id,
+		send:   send,
+		reqs:   newStreamQueue[*connector.RunCommandRequest](),
+	}
+}
+
+func (ms *muxedServerStream) Context() context.Context {
+	return ms.ctx
+}
+
+func (ms *muxedServerStream) Send(sr *connector.StreamResult) error {
+	if err := ms.ctx.Err(); err != nil {
+		return contextStatus(err)
+	}
+	return ms.send(&connector.MuxedStreamResult{StreamId: ms.id, Result: sr})
+}
+
+func (ms *muxedServerStream) Recv() (*connector.RunCommandRequest, error) {
+	rq, err := ms.reqs.get(ms.ctx)
+	return rq, contextStatus(err)
+}
+
+// contextStatus converts a context error into the status error that gRPC uses for it. Other errors are
+// returned as is.
+func contextStatus(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return err
+}
+
+// CommandMux is a connector.ConnectorClient whose RunCommand streams share a single RunCommands stream, so
+// that many concurrent commands can be run without the overhead of a gRPC stream per command. Call options
+// that are passed to RunCommand are ignored. The options given to NewCommandMux apply to all streams.
+type CommandMux struct {
+	connector.ConnectorClient
+	mux    connector.Connector_RunCommandsClient
+	cancel context.CancelFunc
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*muxedClientStream
+	err     error
+	done    chan struct{}
+}
+
+// NewCommandMux starts a RunCommands stream using the given client. The stream ends when the given context
+// is cancelled, or when Close is called.
+func NewCommandMux(ctx context.Context, userD connector.ConnectorClient, opts ...grpc.CallOption) (*CommandMux, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	mux, err := userD.RunCommands(ctx, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	m := &CommandMux{
+		ConnectorClient: userD,
+		mux:             mux,
+		cancel:          cancel,
+		streams:         make(map[uint64]*muxedClientStream),
+		done:            make(chan struct{}),
+	}
+	go m.demux()
+	return m, nil
+}
+
+// Close tells the user daemon that no more commands will be started, and waits for the running commands to
+// end.
+func (m *CommandMux) Close() error {
+	m.sendMu.Lock()
+	err := m.mux.CloseSend()
+	m.sendMu.Unlock()
+	<-m.done
+	m.cancel()
+	return err
+}
+
+// demux hands the results that arrive on the RunCommands stream to the streams that they're tagged with.
+func (m *CommandMux) demux() {
+	defer close(m.done)
+	for {
+		mr, err := m.mux.Recv()
+		if err != nil {
+			m.mu.Lock()
+			m.err = err
+			streams := m.streams
+			m.streams = nil
+			m.mu.Unlock()
+			if err == io.EOF {
+				err = status.Error(codes.Unavailable, "the stream of multiplexed commands ended")
+			}
+			for _, ms := range streams {
+				ms.end(err)
+			}
+			return
+		}
+		m.mu.Lock()
+		ms := m.streams[mr.StreamId]
+		if mr.End {
+			delete(m.streams, mr.StreamId)
+		}
+		m.mu.Unlock()
+		if ms == nil {
+			continue
+		}
+		if mr.Result != nil {
+			ms.results.put(mr.Result)
+		}
+		if mr.End {
+			var err error
+			if mr.Code != 0 {
+				err = status.Error(codes.Code(mr.Code), mr.Message)
+			}
+			ms.end(err)
+		}
+	}
+}
+
+func (m *CommandMux) send(mr *connector.MuxedRunCommandRequest) error {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+	return m.mux.Send(mr)
+}
+
+// RunCommand returns a new stream that shares the RunCommands stream with the other streams of m. The
+// stream is cancelled when the given context is cancelled.
+func (m *CommandMux) RunCommand(ctx context.Context, _ ...grpc.CallOption) (connector.Connector_RunCommandClient, error) {
+	m.mu.Lock()
+	if m.streams == nil {
+		err := m.err
+		m.mu.Unlock()
+		if err == io.EOF {
+			err = status.Error(codes.Unavailable, "the stream of multiplexed commands ended")
+		}
+		return nil, err
+	}
+	m.nextID++
+	ms := &muxedClientStream{
+		ctx:     ctx,
+		mux:     m,
+		id:      m.nextID,
+		results: newStreamQueue[*connector.StreamResult](),
+		ended:   make(chan struct{}),
+	}
+	m.streams[ms.id] = ms
+	m.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = m.send(&connector.MuxedRunCommandRequest{StreamId: ms.id, Cancel: true})
+		case <-ms.ended:
+		}
+	}()
+	return ms, nil
+}
+
+// muxedClientStream is one of the streams of a CommandMux.
+type muxedClientStream struct {
+	ctx     context.Context
+	mux     *CommandMux
+	id      uint64
+	results *streamQueue[*connector.StreamResult]
+
+	// ended is closed when the stream has ended
+	ended chan struct{}
+}
+
+// end closes the stream's results with the given error.
+func (ms *muxedClientStream) end(err error) {
+	ms.results.close(err)
+	close(ms.ended)
+}
+
+func (ms *muxedClientStream) Send(rq *connector.RunCommandRequest) error {
+	if err := ms.ctx.Err(); err != nil {
+		return contextStatus(err)
+	}
+	return ms.mux.send(&connector.MuxedRunCommandRequest{StreamId: ms.id, Request: rq})
+}
+
+func (ms *muxedClientStream) Recv() (*connector.StreamResult, error) {
+	sr, err := ms.results.get(ms.ctx)
+	return sr, contextStatus(err)
+}
+
+func (ms *muxedClientStream) CloseSend() error {
+	return ms.mux.send(&connector.MuxedRunCommandRequest{StreamId: ms.id, CloseSend: true})
+}
+
+func (ms *muxedClientStream) Context() context.Context {
+	return ms.ctx
+}
+
+func (ms *muxedClientStream) Header() (metadata.MD, error) {
+	return nil, nil
+}
+
+func (ms *muxedClientStream) Trailer() metadata.MD {
+	return nil
+}
+
+func (ms *muxedClientStream) SendMsg(m any) error {
+	return ms.Send(m.(*connector.RunCommandRequest))
+}
+
+func (ms *muxedClientStream) RecvMsg(m any) error {
+	sr, err := ms.Recv()
+	if err != nil {
+		return err
+	}
+	dst := m.(*connector.StreamResult)
+	proto.Reset(dst)
+	proto.Merge(dst, sr)
+	return nil
+}
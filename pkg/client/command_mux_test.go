@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// muxConnector is a connector server whose commands echo their stdin, prefixed with the command's first
+// argument. A command named "block" waits until it's cancelled.
+type muxConnector struct {
+	connector.UnimplementedConnectorServer
+}
+
+func (muxConnector) RunCommand(stream connector.Connector_RunCommandServer) error {
+	rq, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	cmd := rq.GetCommand()
+	if cmd == nil {
+		return status.Error(codes.InvalidArgument, "RunCommand got incorrect initial stream message")
+	}
+	name := cmd.OsArgs[0]
+	if name == "block" {
+		<-stream.Context().Done()
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+	for {
+		rq, err = stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch {
+		case rq.GetData() != nil:
+			out := append([]byte(name+":"), rq.GetData()...)
+			if err = stream.Send(&connector.StreamResult{Data: &connector.Result{Data: out}}); err != nil {
+				return err
+			}
+		case rq.GetStdinClosed():
+			return stream.Send(&connector.StreamResult{Final: true})
+		}
+	}
+}
+
+func (c muxConnector) RunCommands(mux connector.Connector_RunCommandsServer) error {
+	return ServeMuxedCommands(mux, c.RunCommand)
+}
+
+func startMuxConnector(ctx context.Context, t testing.TB) connector.ConnectorClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	connector.RegisterConnectorServer(srv, muxConnector{})
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return connector.NewConnectorClient(conn)
+}
+
+// echoLines runs the named echo command on the given client, sends it the given lines, and returns what
+// it echoes.
+func echoLines(ctx context.Context, userD connector.ConnectorClient, name string, lines ...string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := userD.RunCommand(ctx)
+	if err != nil {
+		return "", err
+	}
+	rqs := []*connector.RunCommandRequest{{COrD: &connector.RunCommandRequest_Command_{Command: &connector.RunCommandRequest_Command{OsArgs: []string{name}}}}}
+	for _, line := range lines {
+		rqs = append(rqs, &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Data{Data: []byte(line)}})
+	}
+	rqs = append(rqs, &connector.RunCommandRequest{COrD: &connector.RunCommandRequest_StdinClosed{StdinClosed: true}})
+	for _, rq := range rqs {
+		if err = stream.Send(rq); err != nil {
+			return "", err
+		}
+	}
+	out := ""
+	for {
+		sr, err := stream.Recv()
+		if err != nil {
+			return out, err
+		}
+		if sr.Final {
+			return out, nil
+		}
+		out += string(sr.Data.Data)
+	}
+}
+
+func TestCommandMux_demux(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	mux, err := NewCommandMux(ctx, startMuxConnector(ctx, t))
+	require.NoError(t, err)
+
+	const commands = 20
+	var wg sync.WaitGroup
+	wg.Add(commands)
+	for i := 0; i < commands; i++ {
+		name := fmt.Sprintf("cmd%d", i)
+		go func() {
+			defer wg.Done()
+			out, err := echoLines(ctx, mux, name, "a ", "b ", "c")
+			assert.NoError(t, err)
+			assert.Equal(t, name+":a "+name+":b "+name+":c", out)
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, mux.Close())
+
+	// No commands can be started on a closed mux
+	_, err = mux.RunCommand(ctx)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestCommandMux_error(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	mux, err := NewCommandMux(ctx, startMuxConnector(ctx, t))
+	require.NoError(t, err)
+	defer mux.Close()
+
+	stream, err := mux.RunCommand(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_StdinClosed{StdinClosed: true}}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The mux is still usable
+	out, err := echoLines(ctx, mux, "after", "ok")
+	require.NoError(t, err)
+	assert.Equal(t, "after:ok", out)
+}
+
+func TestCommandMux_cancel(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	mux, err := NewCommandMux(ctx, startMuxConnector(ctx, t))
+	require.NoError(t, err)
+	defer mux.Close()
+
+	cmdCtx, cancel := context.WithCancel(ctx)
+	stream, err := mux.RunCommand(cmdCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&connector.RunCommandRequest{COrD: &connector.RunCommandRequest_Command_{Command: &connector.RunCommandRequest_Command{OsArgs: []string{"block"}}}}))
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := stream.Recv()
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-errCh:
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Recv didn't return when the stream was cancelled")
+	}
+
+	// The server's end of the stream is cancelled too, so Close doesn't wait for it
+	closed := make(chan error, 1)
+	go func() { closed <- mux.Close() }()
+	select {
+	case err = <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked command wasn't cancelled")
+	}
+}
+
+func benchmarkRunCommands(b *testing.B, useMux bool) {
+	ctx := dlog.NewTestContext(b, false)
+	userD := startMuxConnector(ctx, b)
+	if useMux {
+		mux, err := NewCommandMux(ctx, userD)
+		require.NoError(b, err)
+		defer mux.Close()
+		userD = mux
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := echoLines(ctx, userD, "bench", "line"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRunCommand_streamPerCommand(b *testing.B) {
+	benchmarkRunCommands(b, false)
+}
+
+func BenchmarkRunCommand_mux(b *testing.B) {
+	benchmarkRunCommands(b, true)
+}
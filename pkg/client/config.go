@@ -161,6 +161,8 @@ type Timeouts struct {
 	PrivateHelm time.Duration `json:"helm,omitempty" yaml:"helm,omitempty"`
 	// PrivateIntercept is the time to wait for an intercept after the agents has been installed
 	PrivateIntercept time.Duration `json:"intercept,omitempty" yaml:"intercept,omitempty"`
+	// PrivateListCommands is how long the CLI waits for the user daemon to list the commands that it provides
+	PrivateListCommands time.Duration `json:"listCommands,omitempty" yaml:"listCommands,omitempty"`
 	// PrivateRoundtripLatency is how much to add  to the EndpointDial timeout when establishing a remote connection.
 	PrivateRoundtripLatency time.Duration `json:"roundtripLatency,omitempty" yaml:"roundtripLatency,omitempty"`
 	// PrivateProxyDial is how long to wait for the proxy to establish an outbound connection
@@ -181,12 +183,56 @@ const (
 	TimeoutEndpointDial
 	TimeoutHelm
 	TimeoutIntercept
+	TimeoutListCommands
 	TimeoutProxyDial
 	TimeoutRoundtripLatency
 	TimeoutTrafficManagerAPI
 	TimeoutTrafficManagerConnect
+
+	timeoutIDCount
 )
 
+// TimeoutIDs returns all timeout IDs in the order that they are declared.
+func TimeoutIDs() []TimeoutID {
+	ids := make([]TimeoutID, timeoutIDCount)
+	for i := range ids {
+		ids[i] = TimeoutID(i)
+	}
+	return ids
+}
+
+// YAMLName returns the name of the timeout in the "timeouts" object of the config.yml file.
+func (id TimeoutID) YAMLName() string {
+	switch id {
+	case TimeoutAgentInstall:
+		return "agentInstall"
+	case TimeoutApply:
+		return "apply"
+	case TimeoutClusterConnect:
+		return "clusterConnect"
+	case TimeoutConnectivityCheck:
+		return "connectivityCheck"
+	case TimeoutEndpointDial:
+		return "endpointDial"
+	case TimeoutHelm:
+		return "helm"
+	case TimeoutIntercept:
+		return "intercept"
+	case TimeoutListCommands:
+		return "listCommands"
+	case TimeoutProxyDial:
+		return "proxyDial"
+	case TimeoutRoundtripLatency:
+		return "roundtripLatency"
+	case TimeoutTrafficManagerAPI:
+		return "trafficManagerAPI"
+	case TimeoutTrafficManagerConnect:
+		return "trafficManagerConnect"
+	default:
+		panic("should not happen")
+	}
+}
+
 type timeoutContext struct {
 	context.Context
 	timeoutID  TimeoutID
@@ -223,6 +269,8 @@ func (t *Timeouts) Get(timeoutID TimeoutID) time.Duration {
 		timeoutVal = t.PrivateHelm
 	case TimeoutIntercept:
 		timeoutVal = t.PrivateIntercept
+	case TimeoutListCommands:
+		timeoutVal = t.PrivateListCommands
 	case TimeoutProxyDial:
 		timeoutVal = t.PrivateProxyDial
 	case TimeoutRoundtripLatency:
@@ -256,46 +304,37 @@ type timeoutError struct {
 }
 
 func (e timeoutError) Error() string {
-	var yamlName, humanName string
+	var humanName string
 	switch e.timeoutID {
 	case TimeoutAgentInstall:
-		yamlName = "agentInstall"
 		humanName = "agent install"
 	case TimeoutApply:
-		yamlName = "apply"
 		humanName = "apply"
 	case TimeoutClusterConnect:
-		yamlName = "clusterConnect"
 		humanName = "cluster connect"
 	case TimeoutConnectivityCheck:
-		yamlName = "connectivityCheck"
 		humanName = "connectivity check"
 	case TimeoutEndpointDial:
-		yamlName = "endpointDial"
 		humanName = "tunnel endpoint dial with known IP"
 	case TimeoutHelm:
-		yamlName = "helm"
 		humanName = "helm operation"
 	case TimeoutIntercept:
-		yamlName = "intercept"
 		humanName = "intercept"
+	case TimeoutListCommands:
+		humanName = "listing of the commands provided by the user daemon"
 	case TimeoutProxyDial:
-		yamlName = "proxyDial"
 		humanName = "proxy dial"
 	case TimeoutRoundtripLatency:
-		yamlName = "roundtripDelay"
 		humanName = "additional delay for tunnel roundtrip"
 	case TimeoutTrafficManagerAPI:
-		yamlName = "trafficManagerAPI"
 		humanName = "traffic manager gRPC API"
 	case TimeoutTrafficManagerConnect:
-		yamlName = "trafficManagerConnect"
 		humanName = "port-forward connection to the traffic manager"
 	default:
 		panic("should not happen")
 	}
 	return fmt.Sprintf("the %s timed out.  The current timeout %s can be configured as %q in %q",
-		humanName, e.timeoutVal, "timeouts."+yamlName, e.configFile)
+		humanName, e.timeoutVal, "timeouts."+e.timeoutID.YAMLName(), e.configFile)
 }
 
 func (e timeoutError) Unwrap() error {
@@ -337,6 +376,8 @@ func (t *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
 			dp = &t.PrivateHelm
 		case "intercept":
 			dp = &t.PrivateIntercept
+		case "listCommands":
+			dp = &t.PrivateListCommands
 		case "proxyDial":
 			dp = &t.PrivateProxyDial
 		case "roundtripLatency":
@@ -379,6 +420,7 @@ const (
 	defaultTimeoutsEndpointDial          = 3 * time.Second
 	defaultTimeoutsHelm                  = 30 * time.Second
 	defaultTimeoutsIntercept             = 5 * time.Second
+	defaultTimeoutsListCommands          = 3 * time.Second
 	defaultTimeoutsProxyDial             = 5 * time.Second
 	defaultTimeoutsRoundtripLatency      = 2 * time.Second
 	defaultTimeoutsTrafficManagerAPI     = 15 * time.Second
@@ -393,6 +435,7 @@ var defaultTimeouts = Timeouts{
 	PrivateEndpointDial:          defaultTimeoutsEndpointDial,
 	PrivateHelm:                  defaultTimeoutsHelm,
 	PrivateIntercept:             defaultTimeoutsIntercept,
+	PrivateListCommands:          defaultTimeoutsListCommands,
 	PrivateProxyDial:             defaultTimeoutsProxyDial,
 	PrivateRoundtripLatency:      defaultTimeoutsRoundtripLatency,
 	PrivateTrafficManagerAPI:     defaultTimeoutsTrafficManagerAPI,
@@ -428,6 +471,9 @@ func (t Timeouts) MarshalYAML() (any, error) {
 	if t.PrivateIntercept != 0 && t.PrivateIntercept != defaultTimeoutsIntercept {
 		tm["intercept"] = t.PrivateIntercept.String()
 	}
+	if t.PrivateListCommands != 0 && t.PrivateListCommands != defaultTimeoutsListCommands {
+		tm["listCommands"] = t.PrivateListCommands.String()
+	}
 	if t.PrivateProxyDial != 0 && t.PrivateProxyDial != defaultTimeoutsProxyDial {
 		tm["proxyDial"] = t.PrivateProxyDial.String()
 	}
@@ -466,6 +512,9 @@ func (t *Timeouts) merge(o *Timeouts) {
 	if o.PrivateIntercept != defaultTimeoutsIntercept {
 		t.PrivateIntercept = o.PrivateIntercept
 	}
+	if o.PrivateListCommands != defaultTimeoutsListCommands {
+		t.PrivateListCommands = o.PrivateListCommands
+	}
 	if o.PrivateProxyDial != defaultTimeoutsProxyDial {
 		t.PrivateProxyDial = o.PrivateProxyDial
 	}
@@ -717,12 +766,37 @@ type Grpc struct {
 	// MaxReceiveSize is the maximum message size in bytes the client can receive in a gRPC call or stream message.
 	// Overrides the gRPC default of 4MB.
 	MaxReceiveSize resource.Quantity `json:"maxReceiveSize,omitempty" yaml:"maxReceiveSize,omitempty"`
+
+	// ShutdownTimeout is the maximum time that the root daemon waits for ongoing gRPC calls to finish when it
+	// shuts down. Calls that are still running when it expires are forcefully terminated.
+	ShutdownTimeout time.Duration `json:"shutdownTimeout,omitempty" yaml:"shutdownTimeout,omitempty"`
+
+	// CommandIdleTimeout is the time that the user daemon lets a command run without hearing from the client
+	// that runs it. The command is cancelled when it expires. Clients that have nothing to send keep their
+	// commands alive using keep-alive requests. There's no such timeout when it's zero.
+	CommandIdleTimeout time.Duration `json:"commandIdleTimeout,omitempty" yaml:"commandIdleTimeout,omitempty"`
+
+	// MaxConcurrentCommands is the maximum number of commands that the user daemon runs at the same time. A
+	// command that is started when it's reached either waits for a running command to end, or fails, depending
+	// on what its client asks for. There's no such limit when it's zero.
+	MaxConcurrentCommands int `json:"maxConcurrentCommands,omitempty" yaml:"maxConcurrentCommands,omitempty"`
 }
 
+const defaultGrpcShutdownTimeout = 10 * time.Second
+
 func (g *Grpc) merge(o *Grpc) {
 	if !o.MaxReceiveSize.IsZero() {
 		g.MaxReceiveSize = o.MaxReceiveSize
 	}
+	if o.ShutdownTimeout != 0 && o.ShutdownTimeout != defaultGrpcShutdownTimeout {
+		g.ShutdownTimeout = o.ShutdownTimeout
+	}
+	if o.CommandIdleTimeout != 0 {
+		g.CommandIdleTimeout = o.CommandIdleTimeout
+	}
+	if o.MaxConcurrentCommands != 0 {
+		g.MaxConcurrentCommands = o.MaxConcurrentCommands
+	}
 }
 
 // UnmarshalYAML parses the images YAML.
@@ -747,6 +821,27 @@ func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
 			} else {
 				g.MaxReceiveSize = val
 			}
+		case "shutdownTimeout":
+			duration, err := time.ParseDuration(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), ms[i]))
+			} else {
+				g.ShutdownTimeout = duration
+			}
+		case "commandIdleTimeout":
+			duration, err := time.ParseDuration(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), ms[i]))
+			} else {
+				g.CommandIdleTimeout = duration
+			}
+		case "maxConcurrentCommands":
+			var n int
+			if err := v.Decode(&n); err != nil || n < 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("non-negative integer expected for key %q", kv), ms[i]))
+			} else {
+				g.MaxConcurrentCommands = n
+			}
 		default:
 			if parseContext != nil {
 				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
@@ -762,6 +857,15 @@ func (g Grpc) MarshalYAML() (any, error) {
 	if !g.MaxReceiveSize.IsZero() {
 		cm["maxReceiveSize"] = g.MaxReceiveSize.String()
 	}
+	if g.ShutdownTimeout != 0 && g.ShutdownTimeout != defaultGrpcShutdownTimeout {
+		cm["shutdownTimeout"] = g.ShutdownTimeout.String()
+	}
+	if g.CommandIdleTimeout != 0 {
+		cm["commandIdleTimeout"] = g.CommandIdleTimeout.String()
+	}
+	if g.MaxConcurrentCommands != 0 {
+		cm["maxConcurrentCommands"] = g.MaxConcurrentCommands
+	}
 	return cm, nil
 }
 
@@ -777,12 +881,79 @@ func (g *TelepresenceAPI) merge(o *TelepresenceAPI) {
 
 type Daemons struct {
 	UserDaemonBinary string `json:"userDaemonBinary,omitempty" yaml:"userDaemonBinary,omitempty"`
+
+	// RootDaemonSocketMode is the file mode, in octal notation, of the root daemon's socket. The
+	// mode defaults to 0660 when the socket has a group, and 0777 otherwise.
+	RootDaemonSocketMode string `json:"rootDaemonSocketMode,omitempty" yaml:"rootDaemonSocketMode,omitempty"`
+
+	// RootDaemonSocketGroup is the name or id of the group that owns the root daemon's socket. It
+	// defaults to the group of the user that started the root daemon using sudo.
+	RootDaemonSocketGroup string `json:"rootDaemonSocketGroup,omitempty" yaml:"rootDaemonSocketGroup,omitempty"`
+
+	// RootDaemonPrometheusPort is the localhost port where the root daemon serves Prometheus metrics. The
+	// metrics are not served when it's zero.
+	RootDaemonPrometheusPort uint16 `json:"rootDaemonPrometheusPort,omitempty" yaml:"rootDaemonPrometheusPort,omitempty"`
+
+	// UserDaemonAuditLog is the file that the user daemon appends a JSON line to for each command that it
+	// runs on behalf of a client. No audit log is written when it's empty.
+	UserDaemonAuditLog string `json:"userDaemonAuditLog,omitempty" yaml:"userDaemonAuditLog,omitempty"`
+
+	// UserDaemonAuditRedact is a list of regular expressions. An argument that matches one of them is
+	// replaced by "***" in the audit log.
+	UserDaemonAuditRedact []string `json:"userDaemonAuditRedact,omitempty" yaml:"userDaemonAuditRedact,omitempty"`
+
+	// RootDaemonTLSAddress is the host:port of a TCP listener where the root daemon serves gRPC, in addition
+	// to its socket, using TLS with client certificates. There's no such listener when it's empty.
+	RootDaemonTLSAddress string `json:"rootDaemonTLSAddress,omitempty" yaml:"rootDaemonTLSAddress,omitempty"`
+
+	// RootDaemonTLSCert and RootDaemonTLSKey are the PEM files with the certificate and private key that
+	// the root daemon's TCP listener presents to clients.
+	RootDaemonTLSCert string `json:"rootDaemonTLSCert,omitempty" yaml:"rootDaemonTLSCert,omitempty"`
+	RootDaemonTLSKey  string `json:"rootDaemonTLSKey,omitempty" yaml:"rootDaemonTLSKey,omitempty"`
+
+	// RootDaemonTLSClientCA is the PEM file with the certificates of the authorities that sign the
+	// certificates of the clients that the root daemon's TCP listener accepts.
+	RootDaemonTLSClientCA string `json:"rootDaemonTLSClientCA,omitempty" yaml:"rootDaemonTLSClientCA,omitempty"`
+
+	// RootDaemonDNSFallbackIPs are the addresses of the DNS servers that the root daemon's overriding resolver
+	// falls back to, in order. They replace the fallback IPs that the user daemon gives when it connects.
+	RootDaemonDNSFallbackIPs []string `json:"rootDaemonDNSFallbackIPs,omitempty" yaml:"rootDaemonDNSFallbackIPs,omitempty"`
 }
 
 func (d *Daemons) merge(o *Daemons) {
 	if o.UserDaemonBinary != "" {
 		d.UserDaemonBinary = o.UserDaemonBinary
 	}
+	if o.RootDaemonSocketMode != "" {
+		d.RootDaemonSocketMode = o.RootDaemonSocketMode
+	}
+	if o.RootDaemonSocketGroup != "" {
+		d.RootDaemonSocketGroup = o.RootDaemonSocketGroup
+	}
+	if o.RootDaemonPrometheusPort != 0 {
+		d.RootDaemonPrometheusPort = o.RootDaemonPrometheusPort
+	}
+	if o.UserDaemonAuditLog != "" {
+		d.UserDaemonAuditLog = o.UserDaemonAuditLog
+	}
+	if len(o.UserDaemonAuditRedact) > 0 {
+		d.UserDaemonAuditRedact = o.UserDaemonAuditRedact
+	}
+	if o.RootDaemonTLSAddress != "" {
+		d.RootDaemonTLSAddress = o.RootDaemonTLSAddress
+	}
+	if o.RootDaemonTLSCert != "" {
+		d.RootDaemonTLSCert = o.RootDaemonTLSCert
+	}
+	if o.RootDaemonTLSKey != "" {
+		d.RootDaemonTLSKey = o.RootDaemonTLSKey
+	}
+	if o.RootDaemonTLSClientCA != "" {
+		d.RootDaemonTLSClientCA = o.RootDaemonTLSClientCA
+	}
+	if len(o.RootDaemonDNSFallbackIPs) > 0 {
+		d.RootDaemonDNSFallbackIPs = o.RootDaemonDNSFallbackIPs
+	}
 }
 
 const defaultInterceptDefaultPort = 8080
@@ -880,6 +1051,7 @@ func GetDefaultConfig() Config {
 			PrivateEndpointDial:          defaultTimeoutsEndpointDial,
 			PrivateHelm:                  defaultTimeoutsHelm,
 			PrivateIntercept:             defaultTimeoutsIntercept,
+			PrivateListCommands:          defaultTimeoutsListCommands,
 			PrivateProxyDial:             defaultTimeoutsProxyDial,
 			PrivateRoundtripLatency:      defaultTimeoutsRoundtripLatency,
 			PrivateTrafficManagerAPI:     defaultTimeoutsTrafficManagerAPI,
@@ -895,7 +1067,9 @@ func GetDefaultConfig() Config {
 			SystemaHost:     defaultCloudSystemAHost,
 			SystemaPort:     defaultCloudSystemAPort,
 		},
-		Grpc:            Grpc{},
+		Grpc: Grpc{
+			ShutdownTimeout: defaultGrpcShutdownTimeout,
+		},
 		TelepresenceAPI: TelepresenceAPI{},
 		Daemons:         Daemons{},
 		Intercept: Intercept{
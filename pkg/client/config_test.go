@@ -37,6 +37,7 @@ logLevels:
 timeouts:
   clusterConnect: 25
   proxyDial: 17.0
+  listCommands: 1500ms
 logLevels:
   rootDaemon: trace
 images:
@@ -77,6 +78,7 @@ intercept:
 	assert.Equal(t, 33*time.Second, to.PrivateApply)                      // from sys2
 	assert.Equal(t, 25*time.Second, to.PrivateClusterConnect)             // from user
 	assert.Equal(t, 17*time.Second, to.PrivateProxyDial)                  // from user
+	assert.Equal(t, 1500*time.Millisecond, to.PrivateListCommands)        // from user
 	assert.Equal(t, time.Duration(0), to.PrivateConnectivityCheck)        // from sys2
 
 	assert.Equal(t, logrus.DebugLevel, cfg.LogLevels.UserDaemon) // from sys2
@@ -101,6 +103,9 @@ func Test_ConfigMarshalYAML(t *testing.T) {
 	cfg.Cloud.RefreshMessages += 10 * time.Minute
 	cfg.LogLevels.UserDaemon = logrus.TraceLevel
 	cfg.Grpc.MaxReceiveSize, _ = resource.ParseQuantity("20Mi")
+	cfg.Grpc.ShutdownTimeout = 3 * time.Second
+	cfg.Grpc.CommandIdleTimeout = 2 * time.Minute
+	cfg.Grpc.MaxConcurrentCommands = 4
 	cfg.TelepresenceAPI.Port = 4567
 	cfg.Intercept.AppProtocolStrategy = k8sapi.PortName
 	cfg.Intercept.DefaultPort = 9080
@@ -123,3 +128,12 @@ func Test_ConfigMarshalYAMLDefaults(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "{}\n", string(cfgBytes))
 }
+
+func TestTimeoutID_YAMLName(t *testing.T) {
+	for i, id := range TimeoutIDs() {
+		var tos Timeouts
+		doc := "{" + id.YAMLName() + ": " + time.Duration(i+1).String() + "}"
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &tos), doc)
+		assert.Equal(t, time.Duration(i+1), tos.Get(id), doc)
+	}
+}
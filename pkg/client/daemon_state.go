@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// DaemonState is the state of the root daemon, as seen by a client.
+type DaemonState int
+
+const (
+	// DaemonStateUnknown is returned together with an error when the state can't be determined.
+	DaemonStateUnknown DaemonState = iota
+
+	// DaemonNotRunning means that the root daemon isn't running.
+	DaemonNotRunning
+
+	// DaemonIdle means that the root daemon is running without a session, so no traffic is routed to a cluster.
+	DaemonIdle
+
+	// DaemonConnected means that the root daemon has a session, and that its network is healthy.
+	DaemonConnected
+
+	// DaemonDegraded means that the root daemon has a session, but that its DNS resolver is unable to resolve
+	// names in the cluster.
+	DaemonDegraded
+)
+
+func (s DaemonState) String() string {
+	switch s {
+	case DaemonNotRunning:
+		return "not running"
+	case DaemonIdle:
+		return "idle"
+	case DaemonConnected:
+		return "connected"
+	case DaemonDegraded:
+		return "degraded"
+	default:
+		return "unknown"
+	}
+}
+
+// GetDaemonState dials the root daemon and returns its state together with a detail, such as the id of its
+// session or the reason why it's degraded. The detail might be empty.
+func GetDaemonState(ctx context.Context) (DaemonState, string, error) {
+	running, err := SocketExists(DaemonSocketName)
+	if err != nil {
+		return DaemonStateUnknown, "", err
+	}
+	if !running {
+		return DaemonNotRunning, "", nil
+	}
+	conn, err := DialSocket(ctx, DaemonSocketName)
+	if err != nil {
+		return DaemonStateUnknown, "", err
+	}
+	defer conn.Close()
+	return daemonState(ctx, daemon.NewDaemonClient(conn))
+}
+
+// daemonState calls the Status of the given root daemon and maps the result to a DaemonState.
+func daemonState(ctx context.Context, d daemon.DaemonClient) (DaemonState, string, error) {
+	st, err := d.Status(ctx, &empty.Empty{})
+	if err != nil {
+		return DaemonStateUnknown, "", err
+	}
+	if st.OutboundConfig == nil {
+		return DaemonIdle, "", nil
+	}
+	if h := st.DnsHealth; h != nil && h.State == daemon.DNSHealth_DNS_FAILURE {
+		return DaemonDegraded, h.Error, nil
+	}
+	return DaemonConnected, st.OutboundConfig.GetSession().GetSessionId(), nil
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// statusDaemonClient is a daemon.DaemonClient that only implements Status.
+type statusDaemonClient struct {
+	daemon.DaemonClient
+	status *daemon.DaemonStatus
+	err    error
+}
+
+func (d *statusDaemonClient) Status(context.Context, *empty.Empty, ...grpc.CallOption) (*daemon.DaemonStatus, error) {
+	return d.status, d.err
+}
+
+func Test_daemonState(t *testing.T) {
+	session := &daemon.OutboundInfo{Session: &manager.SessionInfo{SessionId: "abc123"}}
+	tests := []struct {
+		name       string
+		status     *daemon.DaemonStatus
+		wantState  DaemonState
+		wantDetail string
+	}{
+		{
+			name:      "idle",
+			status:    &daemon.DaemonStatus{},
+			wantState: DaemonIdle,
+		},
+		{
+			name:       "connected",
+			status:     &daemon.DaemonStatus{OutboundConfig: session, DnsHealth: &daemon.DNSHealth{State: daemon.DNSHealth_OK}},
+			wantState:  DaemonConnected,
+			wantDetail: "abc123",
+		},
+		{
+			name:       "connected without health",
+			status:     &daemon.DaemonStatus{OutboundConfig: session},
+			wantState:  DaemonConnected,
+			wantDetail: "abc123",
+		},
+		{
+			name: "degraded",
+			status: &daemon.DaemonStatus{OutboundConfig: session, DnsHealth: &daemon.DNSHealth{
+				State: daemon.DNSHealth_DNS_FAILURE,
+				Error: "unable to resolve kubernetes.default.svc.cluster.local.: timeout",
+			}},
+			wantState:  DaemonDegraded,
+			wantDetail: "unable to resolve kubernetes.default.svc.cluster.local.: timeout",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			state, detail, err := daemonState(context.Background(), &statusDaemonClient{status: tt.status})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantState, state)
+			assert.Equal(t, tt.wantDetail, detail)
+		})
+	}
+
+	state, _, err := daemonState(context.Background(), &statusDaemonClient{err: errors.New("unavailable")})
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, DaemonStateUnknown, state)
+}
+
+func TestDaemonState_String(t *testing.T) {
+	assert.Equal(t, "unknown", DaemonStateUnknown.String())
+	assert.Equal(t, "not running", DaemonNotRunning.String())
+	assert.Equal(t, "idle", DaemonIdle.String())
+	assert.Equal(t, "connected", DaemonConnected.String())
+	assert.Equal(t, "degraded", DaemonDegraded.String())
+}
@@ -22,6 +22,7 @@ const (
 	Config       // Errors in config.yml, extensions, or kubeconfig
 	NoDaemonLogs // Other error generated in the CLI process, so no use pointing the user to logs
 	Unknown      // Something else. Consult the logs
+	Timeout      // A command was cancelled because it didn't finish in time
 )
 
 // New creates a new categorized error based in its argument. The argument
@@ -78,7 +79,11 @@ func FromResult(r *connector.Result) error {
 	if c == OK {
 		return nil
 	}
-	return &categorized{error: errors.New(string(r.Data)), category: c}
+	var err error = &categorized{error: errors.New(string(r.Data)), category: c}
+	if r.ExitCode != 0 {
+		err = &exitCoded{error: err, code: int(r.ExitCode)}
+	}
+	return err
 }
 
 func ToResult(err error) *connector.Result {
@@ -86,6 +91,71 @@ func ToResult(err error) *connector.Result {
 	if err != nil {
 		r.Data = []byte(err.Error())
 		r.ErrorCategory = connector.Result_ErrorCategory(GetCategory(err))
+		var ec exitCoder
+		if errors.As(err, &ec) {
+			r.ExitCode = int32(ec.ExitCode())
+		}
 	}
 	return r
 }
+
+// exitCoder is implemented by errors that stem from a process that terminated unsuccessfully.
+type exitCoder interface {
+	ExitCode() int
+}
+
+// exitCoded is an error that carries the exit code of a remote process.
+type exitCoded struct {
+	error
+	code int
+}
+
+func (e *exitCoded) Unwrap() error {
+	return e.error
+}
+
+func (e *exitCoded) ExitCode() int {
+	return e.code
+}
+
+// ExitCode returns the exit code that the CLI should use when terminating with the given error. This is
+// the exit code of the process that caused the error, if any, 0 for nil, 124 for a Timeout, and 1 for
+// all other errors.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ec exitCoder
+	if errors.As(err, &ec) && ec.ExitCode() != 0 {
+		return ec.ExitCode()
+	}
+	if GetCategory(err) == Timeout {
+		// Same exit code as the timeout(1) command uses
+		return 124
+	}
+	return 1
+}
+
+// silent is an error that the CLI conveys through its exit code only.
+type silent struct {
+	error
+}
+
+func (e *silent) Unwrap() error {
+	return e.error
+}
+
+// Silent returns an error with the same message, category, and exit code as err, but that the CLI
+// doesn't print. It returns nil when err is nil.
+func Silent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &silent{error: err}
+}
+
+// IsSilent returns true if err, or an error that it wraps, was created by Silent.
+func IsSilent(err error) bool {
+	var s *silent
+	return errors.As(err, &s)
+}
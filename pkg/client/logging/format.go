@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	tlog "github.com/telepresenceio/telepresence/v2/pkg/log"
+)
+
+// Format is the format of the lines that a background process logs.
+type Format string
+
+const (
+	// FormatText is the human-readable format.
+	FormatText Format = "text"
+
+	// FormatJSON logs each line as a JSON object with the timestamp, level, worker, and message.
+	FormatJSON Format = "json"
+)
+
+// FormatEnv is the environment variable that selects the format of the logs, unless the context has one,
+// see WithFormat.
+const FormatEnv = "TELEPRESENCE_LOG_FORMAT"
+
+// ParseFormat returns the Format with the given name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid log format %q, must be 'text' or 'json'", s)
+	}
+}
+
+type formatKey struct{}
+
+// WithFormat returns a context that makes InitContext use the given format.
+func WithFormat(ctx context.Context, format Format) context.Context {
+	return context.WithValue(ctx, formatKey{}, format)
+}
+
+// getFormat returns the format of the given context, or of the FormatEnv environment variable. The
+// default is FormatText.
+func getFormat(ctx context.Context) Format {
+	if f, ok := ctx.Value(formatKey{}).(Format); ok {
+		return f
+	}
+	if f, err := ParseFormat(os.Getenv(FormatEnv)); err == nil {
+		return f
+	}
+	return FormatText
+}
+
+// newFormatter returns the formatter of the given format. The timestamp format applies to FormatText only.
+func newFormatter(format Format, timestampFormat string) logrus.Formatter {
+	if format == FormatJSON {
+		return tlog.NewJSONFormatter()
+	}
+	return tlog.NewFormatter(timestampFormat)
+}
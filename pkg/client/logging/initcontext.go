@@ -3,6 +3,7 @@ package logging
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -21,7 +22,8 @@ import (
 // loggerForTest exposes internals to initcontext_test.go.
 var loggerForTest *logrus.Logger
 
-// InitContext sets up standard Telepresence logging for a background process.
+// InitContext sets up standard Telepresence logging for a background process. The lines are logged in the
+// format of the context, see WithFormat.
 func InitContext(ctx context.Context, name string, strategy RotationStrategy, captureStd bool) (context.Context, error) {
 	logger := logrus.New()
 	loggerForTest = logger
@@ -30,10 +32,11 @@ func InitContext(ctx context.Context, name string, strategy RotationStrategy, ca
 	logger.SetLevel(logrus.InfoLevel)
 	logger.ReportCaller = false // turned on when level >= logrus.TraceLevel
 
+	format := getFormat(ctx)
 	if captureStd && IsTerminal(int(os.Stdout.Fd())) {
-		logger.Formatter = tlog.NewFormatter("15:04:05.0000")
+		logger.Formatter = newFormatter(format, "15:04:05.0000")
 	} else {
-		logger.Formatter = tlog.NewFormatter("2006-01-02 15:04:05.0000")
+		logger.Formatter = newFormatter(format, "2006-01-02 15:04:05.0000")
 		dir, err := filelocation.AppUserLogDir(ctx)
 		if err != nil {
 			return ctx, err
@@ -100,11 +103,24 @@ func SummarizeLog(ctx context.Context, name string) (string, error) {
 	for scanner.Scan() {
 		// XXX: is there a better way to detect error lines?
 		txt := scanner.Text()
-		parts := strings.Fields(txt)
-		if len(parts) < 3 {
-			continue
+		var level string
+		if strings.HasPrefix(txt, "{") {
+			// Logged using FormatJSON
+			var entry struct {
+				Level string `json:"level"`
+			}
+			if json.Unmarshal([]byte(txt), &entry) != nil {
+				continue
+			}
+			level = entry.Level
+		} else {
+			parts := strings.Fields(txt)
+			if len(parts) < 3 {
+				continue
+			}
+			level = parts[2]
 		}
-		switch parts[2] {
+		switch level {
 		case "error":
 			errorCount++
 		case "info":
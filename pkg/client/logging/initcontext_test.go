@@ -2,17 +2,20 @@ package logging
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
+	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
@@ -150,6 +153,40 @@ func TestInitContext(t *testing.T) {
 		check.Contains(string(bs), fmt.Sprintf("info    stdlog : %s\n", msg))
 	})
 
+	t.Run("JSON format", func(t *testing.T) {
+		ctx, _, logFile := testSetup(t)
+		check := require.New(t)
+
+		c, err := InitContext(WithFormat(ctx, FormatJSON), logName, NewRotateOnce(), true)
+		loggerForTest.AddHook(&dtimeHook{})
+		check.NoError(err)
+		check.NotNil(c)
+		defer closeLog(t)
+
+		dlog.Info(dgroup.WithGoroutineName(c, "/worker"), "info message")
+		dlog.Error(dlog.WithField(c, "key", "value"), "error message")
+		log.Print("standard message")
+		time.Sleep(100 * time.Millisecond)
+
+		bs, err := os.ReadFile(logFile)
+		check.NoError(err)
+		var entries []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(string(bs)), "\n") {
+			var entry map[string]any
+			check.NoError(json.Unmarshal([]byte(line), &entry), line)
+			check.Contains(entry, "time")
+			entries = append(entries, entry)
+		}
+		check.Len(entries, 3)
+		check.Equal("info", entries[0]["level"])
+		check.Equal("worker", entries[0]["worker"])
+		check.Equal("info message", entries[0]["message"])
+		check.Equal("error", entries[1]["level"])
+		check.Equal("error message", entries[1]["message"])
+		check.Equal(map[string]any{"key": "value"}, entries[1]["fields"])
+		check.Equal("stdlog : standard message", entries[2]["message"])
+	})
+
 	t.Run("next session rotates on write", func(t *testing.T) {
 		ctx, logDir, logFile := testSetup(t)
 		check := require.New(t)
@@ -257,3 +294,25 @@ func TestInitContext(t *testing.T) {
 		check.Equal(maxFiles, len(files))
 	})
 }
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("JSON")
+	require.NoError(t, err)
+	require.Equal(t, FormatJSON, f)
+	f, err = ParseFormat("text")
+	require.NoError(t, err)
+	require.Equal(t, FormatText, f)
+	_, err = ParseFormat("xml")
+	require.Error(t, err)
+}
+
+func Test_getFormat(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv(FormatEnv, "")
+	require.Equal(t, FormatText, getFormat(ctx))
+	t.Setenv(FormatEnv, "json")
+	require.Equal(t, FormatJSON, getFormat(ctx))
+	require.Equal(t, FormatText, getFormat(WithFormat(ctx, FormatText)), "the context takes precedence")
+	t.Setenv(FormatEnv, "xml")
+	require.Equal(t, FormatText, getFormat(ctx))
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TailPollInterval is the interval used by TailFile when it polls a followed file for new lines.
+var TailPollInterval = 250 * time.Millisecond //nolint:gochecknoglobals // can be changed by tests
+
+// TailFile calls send with the last n lines of the named file, or with all its lines if n is negative. When follow is
+// true, TailFile then continues to call send with each line that is appended to the file until the context is
+// cancelled. A followed file that is rotated is reopened, so that lines written to its replacement are sent too.
+func TailFile(ctx context.Context, name string, n int, follow bool, send func(string) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r := bufio.NewReader(f)
+	var last []string
+	partial := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			if follow {
+				// A final line without a newline is completed by what's appended to the file.
+				partial = line
+			} else if line != "" {
+				last = appendLast(last, line, n)
+			}
+			break
+		}
+		last = appendLast(last, strings.TrimSuffix(line, "\n"), n)
+	}
+	for _, line := range last {
+		if err = send(line); err != nil {
+			return err
+		}
+	}
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(TailPollInterval)
+	defer ticker.Stop()
+	for {
+		line, err := r.ReadString('\n')
+		if err == nil {
+			if err = send(partial + strings.TrimSuffix(line, "\n")); err != nil {
+				return err
+			}
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if nf, ok := reopenIfRotated(f, name); ok {
+			// Everything in the old file has been read, so what remains of a partial line is lost.
+			_ = f.Close()
+			f = nf
+			r.Reset(f)
+			partial = ""
+		}
+	}
+}
+
+// appendLast appends line to last and drops the first line when that makes last longer than n lines.
+func appendLast(last []string, line string, n int) []string {
+	if n == 0 {
+		return last
+	}
+	last = append(last, line)
+	if n > 0 && len(last) > n {
+		last = last[1:]
+	}
+	return last
+}
+
+// reopenIfRotated opens the named file and returns it when it's no longer the same file as f.
+func reopenIfRotated(f *os.File, name string) (*os.File, bool) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+	ni, err := os.Stat(name)
+	if err != nil || os.SameFile(fi, ni) {
+		return nil, false
+	}
+	nf, err := os.Open(name)
+	if err != nil {
+		return nil, false
+	}
+	return nf, true
+}
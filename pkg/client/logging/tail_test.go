@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func writeLines(t *testing.T, name string, lines string) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(lines)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestTailFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+	writeLines(t, name, "one\ntwo\nthree\nfour")
+
+	tail := func(n int) []string {
+		var lines []string
+		require.NoError(t, TailFile(dlog.NewTestContext(t, false), name, n, false, func(line string) error {
+			lines = append(lines, line)
+			return nil
+		}))
+		return lines
+	}
+	assert.Equal(t, []string{"three", "four"}, tail(2))
+	assert.Equal(t, []string{"one", "two", "three", "four"}, tail(10))
+	assert.Equal(t, []string{"one", "two", "three", "four"}, tail(-1))
+	assert.Empty(t, tail(0))
+
+	err := TailFile(dlog.NewTestContext(t, false), name+".missing", 10, false, func(string) error { return nil })
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestTailFile_follow(t *testing.T) {
+	saved := TailPollInterval
+	TailPollInterval = 10 * time.Millisecond
+	defer func() { TailPollInterval = saved }()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "test.log")
+	writeLines(t, name, "one\ntwo\nthr")
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	linesCh := make(chan string, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- TailFile(ctx, name, 1, true, func(line string) error {
+			linesCh <- line
+			return nil
+		})
+	}()
+
+	next := func() string {
+		select {
+		case line := <-linesCh:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for line")
+			return ""
+		}
+	}
+
+	// The incomplete last line is completed by what's appended
+	assert.Equal(t, "two", next())
+	writeLines(t, name, "ee\nfour\n")
+	assert.Equal(t, "three", next())
+	assert.Equal(t, "four", next())
+
+	// Lines written to a rotated file are followed
+	require.NoError(t, os.Rename(name, filepath.Join(dir, "test-1.log")))
+	writeLines(t, name, "five\n")
+	assert.Equal(t, "five", next())
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("TailFile didn't return when the context was cancelled")
+	}
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// ProgressText returns the given progress as text, e.g. "pulling: 42% (21/50)". The text has no line ending.
+func ProgressText(p *connector.Progress) string {
+	var text string
+	switch {
+	case p.Total > 0:
+		text = fmt.Sprintf("%s: %d%% (%d/%d)", p.Label, p.Current*100/p.Total, p.Current, p.Total)
+	case p.Current > 0:
+		text = fmt.Sprintf("%s: %d", p.Label, p.Current)
+	default:
+		text = p.Label
+	}
+	if p.Done && p.Total == 0 {
+		text += " done"
+	}
+	return text
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func TestProgressText(t *testing.T) {
+	tests := []struct {
+		p    *connector.Progress
+		want string
+	}{
+		{&connector.Progress{Label: "pulling", Current: 21, Total: 50}, "pulling: 42% (21/50)"},
+		{&connector.Progress{Label: "pulling", Current: 50, Total: 50, Done: true}, "pulling: 100% (50/50)"},
+		{&connector.Progress{Label: "scanning", Current: 7}, "scanning: 7"},
+		{&connector.Progress{Label: "scanning", Current: 7, Done: true}, "scanning: 7 done"},
+		{&connector.Progress{Label: "waiting"}, "waiting"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ProgressText(tt.p))
+	}
+}
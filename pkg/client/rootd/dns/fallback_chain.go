@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fallbackChain is a FallbackPool that sends a query to each of its pools in order, until one of them
+// responds with something other than SERVFAIL. The response of the last pool is used when none of them does.
+type fallbackChain []FallbackPool
+
+func (fc fallbackChain) Exchange(ctx context.Context, client *dns.Client, msg *dns.Msg) (r *dns.Msg, rtt time.Duration, err error) {
+	for _, pool := range fc {
+		r, rtt, err = pool.Exchange(ctx, client, msg)
+		if err == nil && r.Rcode != dns.RcodeServerFailure || ctx.Err() != nil {
+			break
+		}
+	}
+	return r, rtt, err
+}
+
+func (fc fallbackChain) RemoteAddr() string {
+	addrs := make([]string, len(fc))
+	for i, pool := range fc {
+		addrs[i] = pool.RemoteAddr()
+	}
+	return strings.Join(addrs, ",")
+}
+
+func (fc fallbackChain) LocalAddrs() []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	for _, pool := range fc {
+		addrs = append(addrs, pool.LocalAddrs()...)
+	}
+	return addrs
+}
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+
+type fakePool struct {
+	addr  string
+	rcode int
+	err   error
+	calls int
+}
+
+func (p *fakePool) Exchange(_ context.Context, _ *dns.Client, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, 0, p.err
+	}
+	r := new(dns.Msg)
+	r.SetRcode(msg, p.rcode)
+	return r, time.Millisecond, nil
+}
+
+func (p *fakePool) RemoteAddr() string {
+	return p.addr
+}
+
+func (p *fakePool) LocalAddrs() []*net.UDPAddr {
+	return []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}, Port: 50000 + len(p.addr)}}
+}
+
+func TestFallbackChain_Exchange(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	client := &dns.Client{Net: "udp"}
+
+	tests := []struct {
+		name      string
+		first     *fakePool
+		second    *fakePool
+		wantRcode int
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name:      "first succeeds",
+			first:     &fakePool{addr: "10.0.0.1", rcode: dns.RcodeSuccess},
+			second:    &fakePool{addr: "10.0.0.2", rcode: dns.RcodeSuccess},
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "first says NXDOMAIN",
+			first:     &fakePool{addr: "10.0.0.1", rcode: dns.RcodeNameError},
+			second:    &fakePool{addr: "10.0.0.2", rcode: dns.RcodeSuccess},
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "first times out",
+			first:     &fakePool{addr: "10.0.0.1", err: timeoutError{}},
+			second:    &fakePool{addr: "10.0.0.2", rcode: dns.RcodeSuccess},
+			wantRcode: dns.RcodeSuccess,
+			wantCalls: 1,
+		},
+		{
+			name:      "first says SERVFAIL",
+			first:     &fakePool{addr: "10.0.0.1", rcode: dns.RcodeServerFailure},
+			second:    &fakePool{addr: "10.0.0.2", rcode: dns.RcodeSuccess},
+			wantRcode: dns.RcodeSuccess,
+			wantCalls: 1,
+		},
+		{
+			name:      "both fail",
+			first:     &fakePool{addr: "10.0.0.1", rcode: dns.RcodeServerFailure},
+			second:    &fakePool{addr: "10.0.0.2", err: timeoutError{}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := fallbackChain{tt.first, tt.second}
+			r, _, err := fc.Exchange(context.Background(), client, query)
+			assert.Equal(t, 1, tt.first.calls)
+			assert.Equal(t, tt.wantCalls, tt.second.calls)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRcode, r.Rcode)
+		})
+	}
+}
+
+func TestFallbackChain_single(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	pool := &fakePool{addr: "10.0.0.1", rcode: dns.RcodeServerFailure}
+	fc := fallbackChain{pool}
+	r, _, err := fc.Exchange(context.Background(), &dns.Client{Net: "udp"}, query)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeServerFailure, r.Rcode)
+	assert.Equal(t, "10.0.0.1", fc.RemoteAddr())
+	assert.Len(t, fc.LocalAddrs(), 1)
+}
+
+func TestFallbackChain_addrs(t *testing.T) {
+	fc := fallbackChain{&fakePool{addr: "10.0.0.1"}, &fakePool{addr: "10.0.0.22"}}
+	assert.Equal(t, "10.0.0.1,10.0.0.22", fc.RemoteAddr())
+	assert.Len(t, fc.LocalAddrs(), 2)
+}
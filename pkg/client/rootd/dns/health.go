@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// healthProbeName is the name that is resolved when the health of the resolver is probed. The
+// "kubernetes" service in the "default" namespace exists in all clusters.
+const healthProbeName = "kubernetes.default.svc."
+
+// healthProbeTimeout is the maximum time that a health probe is allowed to take.
+const healthProbeTimeout = 2 * time.Second
+
+// HealthTTL is the time that the result of a health probe is cached, so that repeated calls to
+// Health don't hammer the cluster's DNS.
+var HealthTTL = 5 * time.Second
+
+type health struct {
+	sync.Mutex
+	probed time.Time
+	err    error
+
+	// healthy is when a probe last succeeded
+	healthy time.Time
+}
+
+// Health returns nil if the resolver is able to resolve a name that is known to exist in the
+// cluster, or an error describing why it isn't. The result is cached for HealthTTL. Concurrent
+// callers wait for an ongoing probe instead of starting a new one.
+func (s *Server) Health(ctx context.Context) error {
+	s.health.Lock()
+	defer s.health.Unlock()
+	if !s.health.probed.IsZero() && time.Since(s.health.probed) < HealthTTL {
+		return s.health.err
+	}
+	s.health.err = s.probe(ctx)
+	s.health.probed = time.Now()
+	if s.health.err == nil {
+		s.health.healthy = s.health.probed
+	}
+	return s.health.err
+}
+
+// LastHealthy returns when a probe made by Health last succeeded, or the zero time if none has.
+func (s *Server) LastHealthy() time.Time {
+	s.health.Lock()
+	defer s.health.Unlock()
+	return s.health.healthy
+}
+
+func (s *Server) probe(ctx context.Context) error {
+	q := &dns.Question{Name: healthProbeName + s.clusterDomain, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	timeout := s.config.LookupTimeout.AsDuration()
+	if timeout <= 0 || timeout > healthProbeTimeout {
+		timeout = healthProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	rrs, rCode, err := s.clusterLookup(ctx, q)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to resolve %s: %w", q.Name, err)
+	case rCode != dns.RcodeSuccess:
+		return fmt.Errorf("unable to resolve %s: %s", q.Name, dns.RcodeToString[rCode])
+	case len(rrs) == 0:
+		return fmt.Errorf("unable to resolve %s: no records found", q.Name)
+	}
+	return nil
+}
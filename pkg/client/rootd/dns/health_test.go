@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/dnsproxy"
+)
+
+func TestServer_Health(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	var fail atomic.Value
+	fail.Store(false)
+	resolver := func(_ context.Context, q *dns.Question) (dnsproxy.RRs, int, error) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "kubernetes.default.svc.cluster.local.", q.Name)
+		if fail.Load().(bool) {
+			return nil, dns.RcodeServerFailure, errors.New("connection refused")
+		}
+		return dnsproxy.RRs{&dns.A{Hdr: dns.RR_Header{Name: q.Name}, A: net.IP{10, 0, 0, 1}}}, dns.RcodeSuccess, nil
+	}
+	saved := HealthTTL
+	defer func() { HealthTTL = saved }()
+	HealthTTL = time.Hour
+
+	s := NewServer(nil, resolver, false)
+	require.NoError(t, s.Health(ctx))
+	require.NoError(t, s.Health(ctx))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "result is cached")
+
+	fail.Store(true)
+	HealthTTL = 0
+	err := s.Health(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestServer_LastHealthy(t *testing.T) {
+	ctx := context.Background()
+	var fail atomic.Value
+	fail.Store(false)
+	s := NewServer(nil, func(_ context.Context, q *dns.Question) (dnsproxy.RRs, int, error) {
+		if fail.Load().(bool) {
+			return nil, dns.RcodeServerFailure, errors.New("connection refused")
+		}
+		return dnsproxy.RRs{&dns.A{Hdr: dns.RR_Header{Name: q.Name}, A: net.IP{10, 0, 0, 1}}}, dns.RcodeSuccess, nil
+	}, false)
+	saved := HealthTTL
+	defer func() { HealthTTL = saved }()
+	HealthTTL = 0
+
+	assert.True(t, s.LastHealthy().IsZero(), "no probe has been made")
+
+	require.NoError(t, s.Health(ctx))
+	first := s.LastHealthy()
+	assert.False(t, first.IsZero())
+
+	// The timestamp advances with each healthy probe
+	time.Sleep(time.Millisecond)
+	require.NoError(t, s.Health(ctx))
+	second := s.LastHealthy()
+	assert.True(t, second.After(first))
+
+	// and stays put while the probes fail
+	fail.Store(true)
+	time.Sleep(time.Millisecond)
+	require.Error(t, s.Health(ctx))
+	assert.Equal(t, second, s.LastHealthy())
+}
+
+func TestServer_Health_notFound(t *testing.T) {
+	s := NewServer(nil, func(context.Context, *dns.Question) (dnsproxy.RRs, int, error) {
+		return nil, dns.RcodeNameError, nil
+	}, false)
+	err := s.Health(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NXDOMAIN")
+}
@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// queryLogBufferSize is the number of entries that a watcher of the query log can lag behind before
+// entries are dropped.
+const queryLogBufferSize = 64
+
+// queryLog distributes a DNSQuery for each served query to the watchers of the log. The entries are
+// only created while there are watchers, so that serving queries doesn't pay for a log that nobody reads.
+type queryLog struct {
+	sync.Mutex
+	count    int32
+	watchers map[chan *rpc.DNSQuery]struct{}
+}
+
+// watched returns true if someone watches the log.
+func (ql *queryLog) watched() bool {
+	return atomic.LoadInt32(&ql.count) > 0
+}
+
+// publish sends the entry to all watchers. A watcher that isn't keeping up misses the entry.
+func (ql *queryLog) publish(q *rpc.DNSQuery) {
+	ql.Lock()
+	defer ql.Unlock()
+	for ch := range ql.watchers {
+		select {
+		case ch <- q:
+		default:
+		}
+	}
+}
+
+// WatchQueries returns a channel that receives an entry for each query that the server serves. The
+// channel is closed when the context is cancelled.
+func (s *Server) WatchQueries(ctx context.Context) <-chan *rpc.DNSQuery {
+	ql := &s.queryLog
+	ch := make(chan *rpc.DNSQuery, queryLogBufferSize)
+	ql.Lock()
+	if ql.watchers == nil {
+		ql.watchers = make(map[chan *rpc.DNSQuery]struct{})
+	}
+	ql.watchers[ch] = struct{}{}
+	atomic.AddInt32(&ql.count, 1)
+	ql.Unlock()
+	go func() {
+		<-ctx.Done()
+		ql.Lock()
+		delete(ql.watchers, ch)
+		atomic.AddInt32(&ql.count, -1)
+		close(ch)
+		ql.Unlock()
+	}()
+	return ch
+}
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/dnsproxy"
+)
+
+// msgRecorder is a dns.ResponseWriter that records the written message.
+type msgRecorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *msgRecorder) WriteMsg(msg *dns.Msg) error {
+	r.msg = msg
+	return nil
+}
+
+func (r *msgRecorder) Close() error {
+	return nil
+}
+
+func TestServer_WatchQueries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(nil, nil, false)
+	s.ctx = ctx
+	s.cacheResolve = func(q *dns.Question) (dnsproxy.RRs, int, error) {
+		if q.Name != "web.default." {
+			return nil, dns.RcodeNameError, nil
+		}
+		return dnsproxy.RRs{&dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: dnsTTL},
+			A:   net.IP{10, 0, 0, 1},
+		}}, dns.RcodeSuccess, nil
+	}
+	query := func(name string) {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		w := &msgRecorder{}
+		s.ServeDNS(w, r)
+		require.NotNil(t, w.msg)
+	}
+
+	// Nothing is logged unless someone watches
+	query("web.default.")
+	assert.False(t, s.queryLog.watched())
+
+	wCtx, wCancel := context.WithCancel(ctx)
+	queries := s.WatchQueries(wCtx)
+	assert.True(t, s.queryLog.watched())
+
+	query("web.default.")
+	q := <-queries
+	assert.Equal(t, "web.default.", q.Name)
+	assert.Equal(t, "A", q.Qtype)
+	assert.Equal(t, "cluster", q.Resolver)
+	assert.Equal(t, "NOERROR", q.Result)
+	assert.Contains(t, q.Answer, "10.0.0.1")
+	assert.GreaterOrEqual(t, q.Latency.AsDuration(), time.Duration(0))
+
+	query("missing.default.")
+	q = <-queries
+	assert.Equal(t, "missing.default.", q.Name)
+	assert.Equal(t, "NXDOMAIN", q.Result)
+
+	// The channel is closed when the watcher is done
+	wCancel()
+	_, ok := <-queries
+	assert.False(t, ok)
+	assert.False(t, s.queryLog.watched())
+}
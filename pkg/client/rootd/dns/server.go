@@ -83,6 +83,15 @@ type Server struct {
 
 	// ready is closed when the DNS server is fully configured
 	ready chan error
+
+	// health is the cached result of the last health probe
+	health health
+
+	// queryObserver, unless nil, is called with the time it took to serve each query
+	queryObserver func(time.Duration)
+
+	// queryLog distributes an entry for each served query to those who watch it
+	queryLog queryLog
 }
 
 type cacheEntry struct {
@@ -236,6 +245,7 @@ func (s *Server) GetConfig() *rpc.DNSConfig {
 	dnsConfig := &rpc.DNSConfig{}
 	if s.config != nil {
 		dnsConfig.LocalIp = s.config.LocalIp
+		dnsConfig.FallbackIps = s.config.FallbackIps
 		dnsConfig.ExcludeSuffixes = s.config.ExcludeSuffixes
 		dnsConfig.IncludeSuffixes = s.config.IncludeSuffixes
 		dnsConfig.LookupTimeout = s.config.LookupTimeout
@@ -361,6 +371,12 @@ func splitToUDPAddr(netAddr net.Addr) (*net.UDPAddr, error) {
 	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
 }
 
+// SetQueryObserver sets a function that is called with the time it took to serve each query. It must be
+// called before the server is started.
+func (s *Server) SetQueryObserver(f func(time.Duration)) {
+	s.queryObserver = f
+}
+
 // RequestCount returns the number of requests that this server has received.
 func (s *Server) RequestCount() int {
 	return int(atomic.LoadInt64(&s.requestCount))
@@ -504,6 +520,12 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	dlog.Debugf(c, "ServeDNS %5d %-6s %s", r.Id, qts, q.Name)
 
 	atomic.AddInt64(&s.requestCount, 1)
+	start := time.Now()
+	if s.queryObserver != nil {
+		defer func() {
+			s.queryObserver(time.Since(start))
+		}()
+	}
 
 	var err error
 	var rCode int
@@ -533,12 +555,23 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	var pfx dfs = func() string { return "" }
 	var txt dfs = func() string { return "" }
 	var rct dfs = func() string { return dns.RcodeToString[rCode] }
+	resolver := "cluster"
 
 	var msg *dns.Msg
 
 	defer func() {
 		dlog.Debugf(c, "%s%5d %-6s %s -> %s %s", pfx, r.Id, qts, q.Name, rct, txt)
 		_ = w.WriteMsg(msg)
+		if s.queryLog.watched() {
+			s.queryLog.publish(&rpc.DNSQuery{
+				Name:     q.Name,
+				Qtype:    qts,
+				Resolver: resolver,
+				Result:   rct(),
+				Answer:   txt(),
+				Latency:  durationpb.New(time.Since(start)),
+			})
+		}
 	}()
 
 	if err == nil && rCode == dns.RcodeSuccess {
@@ -573,7 +606,8 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
-	pfx = func() string { return fmt.Sprintf("(%s) ", s.fallbackPool.RemoteAddr()) }
+	resolver = s.fallbackPool.RemoteAddr()
+	pfx = func() string { return fmt.Sprintf("(%s) ", resolver) }
 	dc := &dns.Client{Net: "udp", Timeout: s.config.LookupTimeout.AsDuration()}
 	msg, _, err = s.fallbackPool.Exchange(c, dc, r)
 	if err != nil {
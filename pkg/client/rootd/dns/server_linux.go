@@ -98,33 +98,58 @@ func (s *Server) resolveInSearch(c context.Context, q *dns.Question) (dnsproxy.R
 	return s.resolveInCluster(c, q)
 }
 
-func (s *Server) runOverridingServer(c context.Context, dev vif.Device) error {
-	if s.config.LocalIp == nil {
-		dat, err := os.ReadFile("/etc/resolv.conf")
+// newFallbackPools creates a connection pool for the DNS server at the local IP, followed by one for each of the
+// fallback IPs.
+func (s *Server) newFallbackPools() ([]*ConnPool, error) {
+	ips := append([][]byte{s.config.LocalIp}, s.config.FallbackIps...)
+	pools := make([]*ConnPool, 0, len(ips))
+	for _, ip := range ips {
+		pool, err := NewConnPool(net.IP(ip).String(), 10)
 		if err != nil {
-			return err
+			for _, pool := range pools {
+				pool.Close()
+			}
+			return nil, err
 		}
-		for _, line := range strings.Split(string(dat), "\n") {
-			if s.config.LocalIp == nil && strings.HasPrefix(strings.TrimSpace(line), "nameserver") {
-				fields := strings.Fields(line)
-				ip := net.ParseIP(fields[1])
-				if ip.To4() != nil {
-					s.config.LocalIp = ip.To4()
-					dlog.Infof(c, "Automatically set -dns=%s", net.IP(s.config.LocalIp))
-				}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// readResolvConf sets the local IP to the first IPv4 nameserver in the given content of /etc/resolv.conf,
+// and excludes its search domains. Other nameservers are never used, only the fallback IPs that are
+// configured explicitly.
+func (s *Server) readResolvConf(c context.Context, dat string) {
+	for _, line := range strings.Split(dat, "\n") {
+		if s.config.LocalIp == nil && strings.HasPrefix(strings.TrimSpace(line), "nameserver") {
+			fields := strings.Fields(line)
+			ip := net.ParseIP(fields[1])
+			if ip.To4() != nil {
+				s.config.LocalIp = ip.To4()
+				dlog.Infof(c, "Automatically set -dns=%s", net.IP(s.config.LocalIp))
 			}
+		}
 
-			// The search entry in /etc/resolv.conf is not intended for this resolver so
-			// ensure that we just forward such queries without sending them to the cluster
-			// by adding corresponding entries to excludeSuffixes
-			if strings.HasPrefix(strings.TrimSpace(line), "search") {
-				fields := strings.Fields(line)
-				for _, field := range fields[1:] {
-					s.config.ExcludeSuffixes = append(s.config.ExcludeSuffixes, "."+field)
-				}
+		// The search entry in /etc/resolv.conf is not intended for this resolver so
+		// ensure that we just forward such queries without sending them to the cluster
+		// by adding corresponding entries to excludeSuffixes
+		if strings.HasPrefix(strings.TrimSpace(line), "search") {
+			fields := strings.Fields(line)
+			for _, field := range fields[1:] {
+				s.config.ExcludeSuffixes = append(s.config.ExcludeSuffixes, "."+field)
 			}
 		}
 	}
+}
+
+func (s *Server) runOverridingServer(c context.Context, dev vif.Device) error {
+	if s.config.LocalIp == nil {
+		dat, err := os.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			return err
+		}
+		s.readResolvConf(c, string(dat))
+	}
 	if s.config.LocalIp == nil {
 		return errors.New("couldn't determine dns ip from /etc/resolv.conf")
 	}
@@ -142,13 +167,19 @@ func (s *Server) runOverridingServer(c context.Context, dev vif.Device) error {
 	// Create the connection pool later used for fallback. We need to create this before the firewall
 	// rule because the rule must exclude the local address of this connection in order to
 	// let it reach the original destination and not cause an endless loop.
-	pool, err := NewConnPool(net.IP(s.config.LocalIp).String(), 10)
+	pools, err := s.newFallbackPools()
 	if err != nil {
 		return err
 	}
 	defer func() {
-		pool.Close()
+		for _, pool := range pools {
+			pool.Close()
+		}
 	}()
+	fallback := make(fallbackChain, len(pools))
+	for i, pool := range pools {
+		fallback[i] = pool
+	}
 
 	serverStarted := make(chan struct{})
 	serverDone := make(chan struct{})
@@ -173,7 +204,7 @@ func (s *Server) runOverridingServer(c context.Context, dev vif.Device) error {
 			s.flushDNS()
 			return nil
 		}, dev)
-		return s.Run(c, serverStarted, listeners, pool, s.resolveInSearch)
+		return s.Run(c, serverStarted, listeners, fallback, s.resolveInSearch)
 	})
 
 	g.Go("NAT-redirect", func(c context.Context) error {
@@ -183,7 +214,7 @@ func (s *Server) runOverridingServer(c context.Context, dev vif.Device) error {
 			// Give DNS server time to start before rerouting NAT
 			dtime.SleepWithContext(c, time.Millisecond)
 
-			err := routeDNS(c, s.config.LocalIp, dnsResolverAddr, pool.LocalAddrs())
+			err := routeDNS(c, s.config.LocalIp, dnsResolverAddr, fallback.LocalAddrs())
 			if err != nil {
 				return err
 			}
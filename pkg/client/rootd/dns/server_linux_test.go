@@ -0,0 +1,51 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+const testResolvConf = `# Generated
+nameserver 127.0.0.53
+nameserver 127.0.0.54
+search example.com
+`
+
+func TestServer_newFallbackPools(t *testing.T) {
+	tests := []struct {
+		name        string
+		fallbackIps [][]byte
+		want        []string
+	}{
+		{"none", nil, []string{"127.0.0.53"}},
+		{"single", [][]byte{net.IP{127, 0, 0, 55}}, []string{"127.0.0.53", "127.0.0.55"}},
+		{"chain", [][]byte{net.IP{127, 0, 0, 55}, net.IP{127, 0, 0, 56}}, []string{"127.0.0.53", "127.0.0.55", "127.0.0.56"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			s := NewServer(&rpc.DNSConfig{FallbackIps: tt.fallbackIps}, nil, false)
+			s.readResolvConf(ctx, testResolvConf)
+			assert.Equal(t, []byte(net.IP{127, 0, 0, 53}), s.config.LocalIp)
+			assert.Contains(t, s.config.ExcludeSuffixes, ".example.com")
+
+			// The other nameservers of resolv.conf are never added to the chain
+			assert.Equal(t, tt.fallbackIps, s.config.FallbackIps)
+			pools, err := s.newFallbackPools()
+			require.NoError(t, err)
+			var got []string
+			for _, pool := range pools {
+				got = append(got, pool.RemoteAddr())
+				pool.Close()
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
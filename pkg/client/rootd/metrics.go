@@ -0,0 +1,83 @@
+package rootd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// metrics are counters that describe the traffic that a session routes to the cluster.
+type metrics struct {
+	activeConnections int64
+	totalConnections  int64
+	bytesSent         int64
+	bytesReceived     int64
+
+	// dnsQueriesBase is the request count of the DNS server when the counters were last reset
+	dnsQueriesBase int64
+
+	// prom receives the same updates as the counters, unless it's nil
+	prom *promMetrics
+}
+
+// get returns the current counters, and resets them when reset is true. The given dnsQueries is the total
+// number of queries that the DNS server has served.
+func (m *metrics) get(dnsQueries int64, reset bool) *rpc.SessionMetrics {
+	sm := &rpc.SessionMetrics{ActiveConnections: atomic.LoadInt64(&m.activeConnections)}
+	if reset {
+		sm.TotalConnections = atomic.SwapInt64(&m.totalConnections, 0)
+		sm.BytesSent = atomic.SwapInt64(&m.bytesSent, 0)
+		sm.BytesReceived = atomic.SwapInt64(&m.bytesReceived, 0)
+		sm.DnsQueries = dnsQueries - atomic.SwapInt64(&m.dnsQueriesBase, dnsQueries)
+	} else {
+		sm.TotalConnections = atomic.LoadInt64(&m.totalConnections)
+		sm.BytesSent = atomic.LoadInt64(&m.bytesSent)
+		sm.BytesReceived = atomic.LoadInt64(&m.bytesReceived)
+		sm.DnsQueries = dnsQueries - atomic.LoadInt64(&m.dnsQueriesBase)
+	}
+	return sm
+}
+
+// countStream returns a stream that counts the connection and the bytes that pass through the given stream.
+func (m *metrics) countStream(s tunnel.Stream) tunnel.Stream {
+	atomic.AddInt64(&m.activeConnections, 1)
+	atomic.AddInt64(&m.totalConnections, 1)
+	m.prom.connectionOpened()
+	return &countingStream{Stream: s, metrics: m}
+}
+
+// countingStream is a tunnel.Stream that counts the payload of the normal messages that it sends and receives.
+// The connection is no longer active when the stream fails to receive, which is how a tunnel ends.
+type countingStream struct {
+	tunnel.Stream
+	metrics *metrics
+	ended   sync.Once
+}
+
+func (s *countingStream) Send(ctx context.Context, m tunnel.Message) error {
+	err := s.Stream.Send(ctx, m)
+	if err == nil && m.Code() == tunnel.Normal {
+		atomic.AddInt64(&s.metrics.bytesSent, int64(len(m.Payload())))
+		s.metrics.prom.sent(len(m.Payload()))
+	}
+	return err
+}
+
+func (s *countingStream) Receive(ctx context.Context) (tunnel.Message, error) {
+	m, err := s.Stream.Receive(ctx)
+	if err != nil {
+		s.ended.Do(func() {
+			atomic.AddInt64(&s.metrics.activeConnections, -1)
+			s.metrics.prom.connectionClosed()
+		})
+		return m, err
+	}
+	if m != nil && m.Code() == tunnel.Normal {
+		atomic.AddInt64(&s.metrics.bytesReceived, int64(len(m.Payload())))
+		s.metrics.prom.received(len(m.Payload()))
+	}
+	return m, nil
+}
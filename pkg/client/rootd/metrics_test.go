@@ -0,0 +1,84 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func Test_metrics_countStream(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m := &metrics{}
+	newStream := func() (tunnel.Stream, tunnel.Stream) {
+		id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4000, 8080)
+		local, remote := tunnel.NewPipe(id, "session-1")
+		return m.countStream(local), remote
+	}
+
+	s1, r1 := newStream()
+	s2, r2 := newStream()
+	assert.Equal(t, &rpc.SessionMetrics{ActiveConnections: 2, TotalConnections: 2}, m.get(0, false))
+
+	// Only the payload of normal messages is counted
+	require.NoError(t, s1.Send(ctx, tunnel.NewMessage(tunnel.Normal, []byte("hello"))))
+	_, _ = r1.Receive(ctx)
+	require.NoError(t, s1.Send(ctx, tunnel.NewMessage(tunnel.KeepAlive, nil)))
+	_, _ = r1.Receive(ctx)
+	require.NoError(t, r2.Send(ctx, tunnel.NewMessage(tunnel.Normal, []byte("hello, world"))))
+	_, err := s2.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &rpc.SessionMetrics{
+		ActiveConnections: 2,
+		TotalConnections:  2,
+		BytesSent:         5,
+		BytesReceived:     12,
+		DnsQueries:        3,
+	}, m.get(3, false))
+
+	// A connection ends when its stream can't receive
+	require.NoError(t, r1.CloseSend(ctx))
+	_, err = s1.Receive(ctx)
+	require.Error(t, err)
+	_, err = s1.Receive(ctx)
+	require.Error(t, err)
+	assert.Equal(t, int64(1), m.get(3, false).ActiveConnections)
+
+	// A reset returns the counters and then sets them to zero, except for the active connections
+	assert.Equal(t, &rpc.SessionMetrics{
+		ActiveConnections: 1,
+		TotalConnections:  2,
+		BytesSent:         5,
+		BytesReceived:     12,
+		DnsQueries:        4,
+	}, m.get(4, true))
+	assert.Equal(t, &rpc.SessionMetrics{ActiveConnections: 1, DnsQueries: 2}, m.get(6, false))
+}
+
+func TestService_Metrics(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &service{}
+	_, err := d.Metrics(ctx, &rpc.MetricsRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	s := &session{dnsServer: dns.NewServer(nil, nil, false)}
+	s.metrics.countStream(nil)
+	d.session, d.sessionContext = s, context.Background()
+	sm, err := d.Metrics(ctx, &rpc.MetricsRequest{ResetCounters: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), sm.TotalConnections)
+	sm, err = d.Metrics(ctx, &rpc.MetricsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), sm.TotalConnections)
+	assert.Equal(t, int64(1), sm.ActiveConnections)
+}
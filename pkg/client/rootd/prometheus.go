@@ -0,0 +1,130 @@
+package rootd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// promMetrics are the metrics that the daemon serves in the Prometheus exposition format. All methods
+// are no-ops on a nil *promMetrics, which is what the daemon uses when the metrics aren't served.
+type promMetrics struct {
+	registry          *prometheus.Registry
+	connections       prometheus.Counter
+	activeConnections prometheus.Gauge
+	bytesSent         prometheus.Counter
+	bytesReceived     prometheus.Counter
+	dnsQueryDuration  prometheus.Histogram
+	grpcCalls         *prometheus.CounterVec
+}
+
+func newPromMetrics() *promMetrics {
+	p := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "telepresence_daemon_connections_total",
+			Help: "Number of connections tunneled to the cluster",
+		}),
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "telepresence_daemon_active_connections",
+			Help: "Number of connections currently tunneled to the cluster",
+		}),
+		bytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "telepresence_daemon_sent_bytes_total",
+			Help: "Number of bytes sent to the cluster",
+		}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "telepresence_daemon_received_bytes_total",
+			Help: "Number of bytes received from the cluster",
+		}),
+		dnsQueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "telepresence_daemon_dns_query_duration_seconds",
+			Help:    "Time it took to serve a DNS query",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+		grpcCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "telepresence_daemon_grpc_calls_total",
+			Help: "Number of gRPC calls handled by the daemon",
+		}, []string{"method", "code"}),
+	}
+	p.registry.MustRegister(p.connections, p.activeConnections, p.bytesSent, p.bytesReceived, p.dnsQueryDuration, p.grpcCalls)
+	return p
+}
+
+func (p *promMetrics) connectionOpened() {
+	if p != nil {
+		p.connections.Inc()
+		p.activeConnections.Inc()
+	}
+}
+
+func (p *promMetrics) connectionClosed() {
+	if p != nil {
+		p.activeConnections.Dec()
+	}
+}
+
+func (p *promMetrics) sent(n int) {
+	if p != nil {
+		p.bytesSent.Add(float64(n))
+	}
+}
+
+func (p *promMetrics) received(n int) {
+	if p != nil {
+		p.bytesReceived.Add(float64(n))
+	}
+}
+
+func (p *promMetrics) dnsQueryServed(d time.Duration) {
+	if p != nil {
+		p.dnsQueryDuration.Observe(d.Seconds())
+	}
+}
+
+func (p *promMetrics) grpcCallDone(method string, err error) {
+	if p != nil {
+		p.grpcCalls.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+}
+
+func (p *promMetrics) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	rsp, err := handler(ctx, req)
+	p.grpcCallDone(info.FullMethod, err)
+	return rsp, err
+}
+
+func (p *promMetrics) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	p.grpcCallDone(info.FullMethod, err)
+	return err
+}
+
+func (p *promMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// servePrometheus serves the Prometheus metrics on the loopback interface if the port is configured.
+func (d *service) servePrometheus(ctx context.Context) error {
+	port := client.GetConfig(ctx).Daemons.RootDaemonPrometheusPort
+	if port == 0 || d.prom == nil {
+		dlog.Info(ctx, "Prometheus metrics server not started")
+		return nil
+	}
+	sc := &dhttp.ServerConfig{
+		Handler: d.prom.handler(),
+	}
+	dlog.Infof(ctx, "Prometheus metrics server started on port: %d", port)
+	return sc.ListenAndServe(ctx, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+}
@@ -0,0 +1,122 @@
+package rootd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func freeLoopbackPort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func scrape(t *testing.T, url string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var rsp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		rsp, err = http.Get(url) //nolint:gosec,noctx // test code
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(rsp.Body)
+	require.NoError(t, err)
+	return mfs
+}
+
+func TestService_servePrometheus(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	port := freeLoopbackPort(t)
+	cfg := client.GetDefaultConfig()
+	cfg.Daemons.RootDaemonPrometheusPort = port
+	ctx = client.WithConfig(ctx, &cfg)
+
+	d := &service{prom: newPromMetrics()}
+	served := make(chan error, 1)
+	go func() {
+		served <- d.servePrometheus(ctx)
+	}()
+
+	// Some activity
+	m := &metrics{prom: d.prom}
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 4000, 8080)
+	local, remote := tunnel.NewPipe(id, "session-1")
+	s := m.countStream(local)
+	require.NoError(t, s.Send(ctx, tunnel.NewMessage(tunnel.Normal, []byte("hello"))))
+	_, _ = remote.Receive(ctx)
+	require.NoError(t, remote.Send(ctx, tunnel.NewMessage(tunnel.Normal, []byte("hi"))))
+	_, err := s.Receive(ctx)
+	require.NoError(t, err)
+	d.prom.dnsQueryServed(3 * time.Millisecond)
+	d.prom.dnsQueryServed(time.Second)
+	info := &grpc.UnaryServerInfo{FullMethod: "/telepresence.daemon.Daemon/Status"}
+	_, _ = d.prom.unaryInterceptor(ctx, nil, info, func(context.Context, any) (any, error) { return nil, nil })
+	_, _ = d.prom.unaryInterceptor(ctx, nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.Unavailable, "no active session")
+	})
+
+	mfs := scrape(t, fmt.Sprintf("http://127.0.0.1:%d/metrics", port))
+	value := func(name string) float64 {
+		mf, ok := mfs[name]
+		require.True(t, ok, "metric %s is missing", name)
+		m := mf.Metric[0]
+		switch {
+		case m.Counter != nil:
+			return m.Counter.GetValue()
+		case m.Gauge != nil:
+			return m.Gauge.GetValue()
+		default:
+			return float64(m.Histogram.GetSampleCount())
+		}
+	}
+	assert.Equal(t, 1.0, value("telepresence_daemon_connections_total"))
+	assert.Equal(t, 1.0, value("telepresence_daemon_active_connections"))
+	assert.Equal(t, 5.0, value("telepresence_daemon_sent_bytes_total"))
+	assert.Equal(t, 2.0, value("telepresence_daemon_received_bytes_total"))
+	assert.Equal(t, 2.0, value("telepresence_daemon_dns_query_duration_seconds"))
+	assert.Len(t, mfs["telepresence_daemon_grpc_calls_total"].Metric, 2)
+
+	// The server stops when the context is cancelled
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("servePrometheus didn't return when the context was cancelled")
+	}
+}
+
+func TestService_servePrometheus_disabled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	d := &service{}
+	assert.NoError(t, d.servePrometheus(ctx))
+
+	// A nil promMetrics ignores all updates
+	d.prom.connectionOpened()
+	d.prom.sent(10)
+	d.prom.dnsQueryServed(time.Millisecond)
+}
@@ -8,21 +8,27 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dgroup"
-	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
@@ -31,6 +37,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
@@ -67,49 +74,124 @@ type service struct {
 	sessionLock     sync.RWMutex
 	sessionCancel   context.CancelFunc
 	sessionContext  context.Context
-	sessionQuitting int32 // atomic boolean. True if non-zero.
+	sessionQuitting int32         // atomic boolean. True if non-zero.
+	sessionDone     chan struct{} // closed when the current session has ended
 	session         *session
 	timedLogLevel   log.TimedLevel
 
+	// startedAt is the time when the daemon started
+	startedAt time.Time
+
+	// logFile is the file that the daemon logs to
+	logFile string
+
+	// socketName is the socket that the daemon listens on
+	socketName string
+
+	// connectorSocketName is the socket of the connector that the daemon serves. It differs from
+	// the default when several daemons run in isolation from each other, e.g. in tests.
+	connectorSocketName string
+
+	// serverCtx is cancelled when the gRPC server stops, so that streaming calls can end
+	serverCtx context.Context
+
+	// health reports SERVING while the network of a session is ready, and NOT_SERVING otherwise
+	health *health.Server
+
+	// prom is nil unless Prometheus metrics are served
+	prom *promMetrics
+
 	scout *scout.Reporter
 }
 
 // Command returns the telepresence sub-command "daemon-foreground".
 func Command() *cobra.Command {
-	return &cobra.Command{
+	var socketName, connectorSocketName, logFormat string
+	cmd := &cobra.Command{
 		Use:    ProcessName + "-foreground <logging dir> <config dir>",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
 		Args:   cobra.ExactArgs(2),
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), args[0], args[1])
+			ctx := cmd.Context()
+			if logFormat != "" {
+				format, err := logging.ParseFormat(logFormat)
+				if err != nil {
+					return err
+				}
+				ctx = logging.WithFormat(ctx, format)
+			}
+			return run(ctx, args[0], args[1], socketName, connectorSocketName)
 		},
 	}
+	flags := cmd.Flags()
+	flags.StringVar(&socketName, "socket", client.DaemonSocketName, "the socket that the daemon listens on")
+	flags.StringVar(&connectorSocketName, "connector-socket", client.ConnectorSocketName, "the socket of the connector that the daemon serves")
+	flags.StringVar(&logFormat, "log-format", "", "the format of the log, 'text' or 'json'. Defaults to $"+logging.FormatEnv+", or 'text'")
+	return cmd
 }
 
 func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
 	return &common.VersionInfo{
 		ApiVersion: client.APIVersion,
 		Version:    client.Version(),
+		StartTime:  timestamppb.New(d.startedAt),
+		Uptime:     durationpb.New(time.Since(d.startedAt)),
 	}, nil
 }
 
-func (d *service) Status(_ context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
-	d.sessionLock.RLock()
-	defer d.sessionLock.RUnlock()
+func (d *service) Ping(_ context.Context, rq *rpc.PingRequest) (*rpc.PingResponse, error) {
+	return &rpc.PingResponse{
+		Nonce:    rq.Nonce,
+		Sent:     rq.Sent,
+		Received: timestamppb.Now(),
+	}, nil
+}
+
+func (d *service) Status(ctx context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
 	r := &rpc.DaemonStatus{
 		Version: &common.VersionInfo{
 			ApiVersion: client.APIVersion,
 			Version:    client.Version(),
 		},
 	}
-	if d.session != nil {
-		r.OutboundConfig = d.session.getInfo()
+	d.sessionLock.RLock()
+	session := d.session
+	if session != nil {
+		r.OutboundConfig = session.getInfo()
+	}
+	d.sessionLock.RUnlock()
+
+	// The DNS probe can take seconds, so it must not hold up those that wait for the lock
+	if session != nil {
+		r.DnsHealth = session.dnsHealth(ctx)
+		if t := session.dnsServer.LastHealthy(); !t.IsZero() {
+			r.LastHealthyAt = timestamppb.New(t)
+		}
 	}
 	return r, nil
 }
 
+func (d *service) GetConfig(ctx context.Context, _ *empty.Empty) (*rpc.DaemonConfig, error) {
+	dc := &rpc.DaemonConfig{
+		SocketName:          d.socketName,
+		ConnectorSocketName: d.connectorSocketName,
+		LogFile:             d.logFile,
+		Timeouts:            make(map[string]*durationpb.Duration),
+	}
+	tos := &client.GetConfig(ctx).Timeouts
+	for _, id := range client.TimeoutIDs() {
+		dc.Timeouts[id.YAMLName()] = durationpb.New(tos.Get(id))
+	}
+	d.sessionLock.RLock()
+	if d.session != nil {
+		dc.Dns = d.session.getInfo().Dns
+	}
+	d.sessionLock.RUnlock()
+	return dc, nil
+}
+
 func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
 	dlog.Debug(ctx, "Received gRPC Quit")
 	d.quit()
@@ -119,6 +201,35 @@ func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error
 	return &empty.Empty{}, nil
 }
 
+// PreviewNetwork connects to the traffic-manager and returns the network configuration that a session created
+// with the given OutboundInfo would apply, without creating the session.
+func (d *service) PreviewNetwork(ctx context.Context, oi *rpc.OutboundInfo) (*rpc.NetworkPreview, error) {
+	dlog.Debug(ctx, "Received gRPC PreviewNetwork")
+	if err := validateDNSConfig(oi.Dns); err != nil {
+		return nil, err
+	}
+	conn, mc, _, err := connectToManager(ctx, d.connectorSocketName)
+	if err != nil {
+		return nil, err
+	}
+	if mc == nil {
+		return nil, status.Error(codes.Unavailable, "the user daemon is not running")
+	}
+	defer conn.Close()
+
+	tCtx, tCancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutTrafficManagerAPI)
+	defer tCancel()
+	infoStream, err := mc.WatchClusterInfo(tCtx, oi.Session)
+	if err != nil {
+		return nil, err
+	}
+	mgrInfo, err := infoStream.Recv()
+	if err != nil {
+		return nil, client.CheckTimeout(tCtx, err)
+	}
+	return previewNetwork(oi, mgrInfo), nil
+}
+
 func (d *service) SetDnsSearchPath(ctx context.Context, paths *rpc.Paths) (*empty.Empty, error) {
 	err := d.withSession(func(ctx context.Context, session *session) error {
 		session.SetSearchPath(ctx, paths.Paths, paths.Namespaces)
@@ -158,6 +269,32 @@ func (d *service) WaitForNetwork(ctx context.Context, e *empty.Empty) (*empty.Em
 	return &empty.Empty{}, err
 }
 
+// Reconnect tears down the current session and creates a new one using the same OutboundInfo. The
+// new session isn't created until the old one has ended, so that the network configuration of the
+// two never overlap.
+func (d *service) Reconnect(ctx context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
+	dlog.Debug(ctx, "Received gRPC Reconnect")
+	var oi *rpc.OutboundInfo
+	var done <-chan struct{}
+	err := d.withSession(func(_ context.Context, session *session) error {
+		oi = session.info
+		done = d.sessionDone
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.cancelSession()
+	if done != nil {
+		select {
+		case <-ctx.Done():
+			return nil, status.Error(codes.Canceled, ctx.Err().Error())
+		case <-done:
+		}
+	}
+	return d.Connect(ctx, oi)
+}
+
 func (d *service) cancelSession() {
 	if !atomic.CompareAndSwapInt32(&d.sessionQuitting, 0, 1) {
 		return
@@ -225,12 +362,130 @@ func (d *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 	return &empty.Empty{}, logging.SetAndStoreTimedLevel(ctx, d.timedLogLevel, request.LogLevel, duration, ProcessName)
 }
 
+func (d *service) Metrics(ctx context.Context, request *rpc.MetricsRequest) (sm *rpc.SessionMetrics, err error) {
+	err = d.withSession(func(ctx context.Context, session *session) error {
+		sm = session.metrics.get(int64(session.dnsServer.RequestCount()), request.ResetCounters)
+		return nil
+	})
+	return sm, err
+}
+
+func (d *service) TailLog(request *rpc.TailLogRequest, stream rpc.Daemon_TailLogServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	if d.serverCtx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-d.serverCtx.Done():
+				cancel()
+			}
+		}()
+	}
+	err := logging.TailFile(ctx, d.logFile, int(request.Lines), request.Follow, func(line string) error {
+		return stream.Send(&rpc.LogLine{Text: line})
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// DNSQueryLog streams the queries that the DNS server of the current session serves, until the client
+// disconnects, the session ends, or the server stops.
+func (d *service) DNSQueryLog(_ *empty.Empty, stream rpc.Daemon_DNSQueryLogServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var queries <-chan *rpc.DNSQuery
+	err := d.withSession(func(sCtx context.Context, session *session) error {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-sCtx.Done():
+				cancel()
+			}
+		}()
+		queries = session.dnsServer.WatchQueries(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if d.serverCtx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-d.serverCtx.Done():
+				cancel()
+			}
+		}()
+	}
+	for q := range queries {
+		if err := stream.Send(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *service) configReload(c context.Context) error {
 	return client.Watch(c, func(c context.Context) error {
 		return logging.ReloadDaemonConfig(c, true)
 	})
 }
 
+// hangupReload reloads the configuration each time the daemon receives a SIGHUP, see reloadOnSignal.
+func (d *service) hangupReload(c context.Context) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	return d.reloadOnSignal(c, sigCh)
+}
+
+// reloadOnSignal reloads the configuration each time a signal arrives on the given channel. A configuration
+// that fails to load is logged, and the current configuration is retained. The DNS of the current session is
+// rebuilt when the reloaded configuration changes it, see reloadDNS.
+func (d *service) reloadOnSignal(c context.Context, sigCh <-chan os.Signal) error {
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case sig := <-sigCh:
+			dlog.Infof(c, "Received %s, reloading configuration", sig)
+			if err := logging.ReloadDaemonConfig(c, true); err != nil {
+				dlog.Errorf(c, "failed to reload configuration: %v", err)
+				continue
+			}
+			d.reloadDNS(c)
+		}
+	}
+}
+
+// reloadDNS compares the DNS configuration that the current session would get from the current config with
+// the one that it runs with. The session is reconnected when they differ, so that its DNS server and the
+// routing of the fallback servers are set up again with the new configuration.
+func (d *service) reloadDNS(c context.Context) {
+	var dc *rpc.DNSConfig
+	changed := false
+	err := d.withSession(func(_ context.Context, session *session) (err error) {
+		if dc, err = configuredDNS(c, session.info.Dns); err == nil {
+			changed = !equalIPs(dc.GetFallbackIps(), session.dnsServer.GetConfig().FallbackIps)
+		}
+		return err
+	})
+	switch {
+	case status.Code(err) == codes.Unavailable || status.Code(err) == codes.Canceled:
+		// No session, so nothing to rebuild
+	case err != nil:
+		dlog.Errorf(c, "failed to reload the DNS configuration: %v", err)
+	case changed:
+		dlog.Infof(c, "DNS fallback servers changed to %v, reconnecting the session", iputil.IPsFromBytesSlice(dc.GetFallbackIps()))
+		if _, err = d.Reconnect(c, &empty.Empty{}); err != nil {
+			dlog.Errorf(c, "failed to reconnect the session: %v", err)
+		}
+	}
+}
+
 // manageSessions is the counterpart to the Connect method. It reads the connectCh, creates
 // a session and writes a reply to the connectErrCh. The session is then started if it was
 // successfully created.
@@ -266,11 +521,14 @@ nextSession:
 				reply.status.OutboundConfig = d.session.getInfo()
 			} else {
 				sCtx, sCancel := context.WithCancel(c)
-				session, reply.err = newSession(sCtx, d.scout, oi)
+				session, reply.err = newSession(sCtx, d.scout, oi, d.connectorSocketName)
 				if reply.err == nil {
+					session.metrics.prom = d.prom
+					session.dnsServer.SetQueryObserver(d.prom.dnsQueryServed)
 					d.session = session
 					d.sessionContext = sCtx
 					d.sessionCancel = sCancel
+					d.sessionDone = make(chan struct{})
 					reply.status.OutboundConfig = d.session.getInfo()
 				} else {
 					sCancel()
@@ -289,21 +547,33 @@ nextSession:
 			d.cancelSession()
 			continue
 		}
-		if reply.err != nil {
+		if reply.err != nil || session == nil {
+			// Failed, or replied with the info of an already running session
 			continue
 		}
 
 		// Run the session asynchronously. We must be able to respond to connect (with getInfo) while
 		// the session is running. The d.session.cancel is called from Disconnect
 		wg.Add(1)
+		d.sessionLock.RLock()
+		sCtx, done := d.sessionContext, d.sessionDone
+		d.sessionLock.RUnlock()
+		go d.reportHealth(sCtx, session)
 		go func() {
 			defer func() {
 				d.sessionLock.Lock()
-				d.session = nil
+				if d.session == session {
+					// Don't clear a session that replaced this one
+					d.session = nil
+				}
+				if d.session == nil {
+					d.setServing(false)
+				}
 				d.sessionLock.Unlock()
+				close(done)
 				wg.Done()
 			}()
-			if err := d.session.run(d.sessionContext); err != nil {
+			if err := session.run(sCtx); err != nil {
 				dlog.Error(c, err)
 			}
 		}()
@@ -312,7 +582,36 @@ nextSession:
 	return nil
 }
 
-func (d *service) serveGrpc(c context.Context, l net.Listener, tracer common.TracingServer) error {
+// reportHealth sets the health status to SERVING when the network of the given session is ready.
+func (d *service) reportHealth(ctx context.Context, session *session) {
+	if err, ok := <-session.networkReady(ctx); ok {
+		dlog.Errorf(ctx, "network of session not ready: %v", err)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	d.sessionLock.RLock()
+	if d.session == session {
+		d.setServing(true)
+	}
+	d.sessionLock.RUnlock()
+}
+
+func (d *service) setServing(serving bool) {
+	if d.health == nil {
+		return
+	}
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	d.health.SetServingStatus("", st)
+}
+
+// serveGrpc serves the daemon's gRPC services on the given listeners until the context is cancelled or one
+// of the listeners fails.
+func (d *service) serveGrpc(c context.Context, tracer common.TracingServer, listeners ...net.Listener) error {
 	defer func() {
 		// Error recovery.
 		if perr := derror.PanicToError(recover()); perr != nil {
@@ -323,6 +622,17 @@ func (d *service) serveGrpc(c context.Context, l net.Listener, tracer common.Tra
 	opts := []grpc.ServerOption{
 		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
 		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			return handler(callContext{Context: ctx, serverCtx: c}, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, callContextStream{ServerStream: ss, ctx: callContext{Context: ss.Context(), serverCtx: c}})
+		}),
+	}
+	if d.prom != nil {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(d.prom.unaryInterceptor),
+			grpc.ChainStreamInterceptor(d.prom.streamInterceptor))
 	}
 	cfg := client.GetConfig(c)
 	if !cfg.Grpc.MaxReceiveSize.IsZero() {
@@ -331,14 +641,33 @@ func (d *service) serveGrpc(c context.Context, l net.Listener, tracer common.Tra
 		}
 	}
 	svc := grpc.NewServer(opts...)
+	d.serverCtx = c
 	rpc.RegisterDaemonServer(svc, d)
 	common.RegisterTracingServer(svc, tracer)
+	healthpb.RegisterHealthServer(svc, d.health)
 
-	sc := &dhttp.ServerConfig{
-		Handler: svc,
-	}
 	dlog.Info(c, "gRPC server started")
-	err := sc.Serve(c, l)
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errCh <- svc.Serve(l)
+		}(l)
+	}
+	var err error
+	select {
+	case err = <-errCh:
+		// The other listeners are of no use without this one
+		svc.Stop()
+	case <-c.Done():
+		d.health.Shutdown()
+		stopGrpcServer(c, svc, cfg.Grpc.ShutdownTimeout)
+		err = <-errCh
+	}
+	for i := 1; i < len(listeners); i++ {
+		if lErr := <-errCh; err == nil {
+			err = lErr
+		}
+	}
 	if err != nil {
 		dlog.Errorf(c, "gRPC server ended with: %v", err)
 	} else {
@@ -347,8 +676,95 @@ func (d *service) serveGrpc(c context.Context, l net.Listener, tracer common.Tra
 	return err
 }
 
+// callContext is the context of a gRPC call. It's cancelled when the call ends, and it has the values of the
+// context that the server was started with in addition to its own, so that the call uses the daemon's logger,
+// log-level setter, and configuration.
+type callContext struct {
+	context.Context
+	serverCtx context.Context
+}
+
+func (c callContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.serverCtx.Value(key)
+}
+
+// callContextStream is a grpc.ServerStream with a callContext.
+type callContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s callContextStream) Context() context.Context {
+	return s.ctx
+}
+
+type grpcStopper interface {
+	GracefulStop()
+	Stop()
+}
+
+// stopGrpcServer stops the server gracefully, letting ongoing calls finish. Calls that are still running
+// when the timeout expires are forcefully terminated, so that a stuck call can't prevent the shutdown.
+func stopGrpcServer(c context.Context, svc grpcStopper, timeout time.Duration) {
+	drained := make(chan struct{})
+	go func() {
+		svc.GracefulStop()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		dlog.Debug(c, "gRPC server drained")
+	case <-time.After(timeout):
+		dlog.Warnf(c, "gRPC server not drained within %s, forcing stop", timeout)
+		svc.Stop()
+	}
+}
+
+// socketPermissions returns the mode and group of the daemon socket. Unless configured, the group is the
+// one of the user that started the daemon using sudo, and only that group can access the socket. The socket
+// is accessible to everyone when no group is known, or when the configured mode says so.
+func socketPermissions(d *client.Daemons) (os.FileMode, string, error) {
+	group := d.RootDaemonSocketGroup
+	if group == "" {
+		group = os.Getenv("SUDO_GID")
+	}
+	if d.RootDaemonSocketMode == "" {
+		if group == "" {
+			return 0o777, "", nil
+		}
+		return 0o660, group, nil
+	}
+	mode, err := strconv.ParseUint(d.RootDaemonSocketMode, 8, 32)
+	if err != nil || mode&^uint64(os.ModePerm) != 0 {
+		return 0, "", fmt.Errorf("invalid rootDaemonSocketMode %q, must be an octal file mode such as 0660", d.RootDaemonSocketMode)
+	}
+	return os.FileMode(mode), group, nil
+}
+
+// newService returns a service that logs to the given file, listens on the given socket, and serves the
+// connector that listens on the given connector socket.
+func newService(cfg *client.Config, logFile, socketName, connectorSocketName string) *service {
+	d := &service{
+		startedAt:           time.Now(),
+		logFile:             logFile,
+		socketName:          socketName,
+		connectorSocketName: connectorSocketName,
+		connectCh:           make(chan *rpc.OutboundInfo),
+		connectReplyCh:      make(chan sessionReply),
+		health:              health.NewServer(),
+	}
+	d.setServing(false)
+	if cfg.Daemons.RootDaemonPrometheusPort != 0 {
+		d.prom = newPromMetrics()
+	}
+	return d
+}
+
 // run is the main function when executing as the daemon.
-func run(c context.Context, loggingDir, configDir string) error {
+func run(c context.Context, loggingDir, configDir, socketName, connectorSocketName string) error {
 	if !proc.IsAdmin() {
 		return fmt.Errorf("telepresence %s must run with elevated privileges", ProcessName)
 	}
@@ -386,7 +802,11 @@ func run(c context.Context, loggingDir, configDir string) error {
 	// Listen on domain unix domain socket or windows named pipe. The listener must be opened
 	// before other tasks because the CLI client will only wait for a short period of time for
 	// the socket/pipe to appear before it gives up.
-	grpcListener, err := client.ListenSocket(c, ProcessName, client.DaemonSocketName)
+	socketMode, socketGroup, err := socketPermissions(&cfg.Daemons)
+	if err != nil {
+		return err
+	}
+	grpcListener, err := client.ListenSocketWithPermissions(c, ProcessName, socketName, socketMode, socketGroup)
 	if err != nil {
 		return err
 	}
@@ -394,13 +814,19 @@ func run(c context.Context, loggingDir, configDir string) error {
 		_ = client.RemoveSocket(grpcListener)
 	}()
 	dlog.Debug(c, "Listener opened")
-
-	d := &service{
-		scout:          scout.NewReporter(c, "daemon"),
-		timedLogLevel:  log.NewTimedLevel(cfg.LogLevels.RootDaemon.String(), log.SetLevel),
-		connectCh:      make(chan *rpc.OutboundInfo),
-		connectReplyCh: make(chan sessionReply),
+	listeners := []net.Listener{grpcListener}
+	tlsListener, err := listenTLS(c, &cfg.Daemons)
+	if err != nil {
+		return err
 	}
+	if tlsListener != nil {
+		dlog.Infof(c, "Listening on %s using mutual TLS", tlsListener.Addr())
+		listeners = append(listeners, tlsListener)
+	}
+
+	d := newService(cfg, filepath.Join(loggingDir, ProcessName+".log"), socketName, connectorSocketName)
+	d.scout = scout.NewReporter(c, "daemon")
+	d.timedLogLevel = log.NewTimedLevel(cfg.LogLevels.RootDaemon.String(), log.SetLevel)
 	if err = logging.LoadTimedLevelFromCache(c, d.timedLogLevel, ProcessName); err != nil {
 		return err
 	}
@@ -414,9 +840,11 @@ func run(c context.Context, loggingDir, configDir string) error {
 
 	// Add a reload function that triggers on create and write of the config.yml file.
 	g.Go("config-reload", d.configReload)
+	g.Go("hangup-reload", d.hangupReload)
 	g.Go("session", d.manageSessions)
-	g.Go("server-grpc", func(c context.Context) error { return d.serveGrpc(c, grpcListener, tracer) })
+	g.Go("server-grpc", func(c context.Context) error { return d.serveGrpc(c, tracer, listeners...) })
 	g.Go("metriton", d.scout.Run)
+	g.Go("prometheus", d.servePrometheus)
 	err = g.Wait()
 	if err != nil {
 		dlog.Error(c, err)
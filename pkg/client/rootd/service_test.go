@@ -0,0 +1,522 @@
+package rootd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/log"
+)
+
+func TestService_Reconnect(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	oi := &rpc.OutboundInfo{Session: &manager.SessionInfo{SessionId: "session-1"}}
+	d := &service{
+		connectCh:      make(chan *rpc.OutboundInfo),
+		connectReplyCh: make(chan sessionReply),
+	}
+
+	// A session whose network failed to start
+	degraded := &session{info: oi, vifReady: make(chan error, 1)}
+	degraded.vifReady <- errors.New("unable to configure the TUN device")
+	sCtx, sCancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	d.session, d.sessionContext, d.sessionCancel, d.sessionDone = degraded, sCtx, sCancel, done
+	go func() {
+		// Simulate the time it takes to tear down the network
+		<-sCtx.Done()
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+
+	// Act as manageSessions
+	go func() {
+		got := <-d.connectCh
+		select {
+		case <-done:
+		default:
+			t.Error("a new session was requested before the old session ended")
+		}
+		assert.True(t, proto.Equal(oi, got))
+		d.connectReplyCh <- sessionReply{status: &rpc.DaemonStatus{OutboundConfig: got}}
+	}()
+
+	st, err := d.Reconnect(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(oi, st.OutboundConfig))
+	assert.Error(t, sCtx.Err(), "the old session is cancelled")
+}
+
+func TestService_Reconnect_noSession(t *testing.T) {
+	d := &service{}
+	_, err := d.Reconnect(dlog.NewTestContext(t, false), &empty.Empty{})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestService_reportHealth(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &service{health: health.NewServer()}
+	d.setServing(false)
+	check := func() healthpb.HealthCheckResponse_ServingStatus {
+		rsp, err := d.health.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		return rsp.Status
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+
+	// SERVING when the network is ready
+	vifReady := make(chan error)
+	s := &session{vifReady: vifReady, dnsServer: dns.NewServer(nil, nil, false)}
+	d.session = s
+	reported := make(chan struct{})
+	go func() {
+		d.reportHealth(ctx, s)
+		close(reported)
+	}()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+	close(vifReady)
+	s.dnsServer.Stop()
+	<-reported
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check())
+
+	// NOT_SERVING when the network fails to start
+	d.setServing(false)
+	failing := &session{vifReady: make(chan error, 1)}
+	failing.vifReady <- errors.New("unable to configure the TUN device")
+	d.session = failing
+	d.reportHealth(ctx, failing)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+
+	// A session that has been replaced doesn't report its health
+	d.session = failing
+	d.reportHealth(ctx, s)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check())
+}
+
+func TestService_Version(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	startedAt := time.Now().Add(-time.Minute)
+	d := &service{startedAt: startedAt}
+
+	v1, err := d.Version(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.True(t, v1.StartTime.AsTime().Equal(startedAt))
+	assert.GreaterOrEqual(t, v1.Uptime.AsDuration(), time.Minute)
+
+	time.Sleep(10 * time.Millisecond)
+	v2, err := d.Version(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.True(t, v2.StartTime.AsTime().Equal(startedAt))
+	assert.Greater(t, v2.Uptime.AsDuration(), v1.Uptime.AsDuration())
+}
+
+func TestService_Ping(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &service{}
+	sent := timestamppb.New(time.Now().Add(-time.Millisecond))
+	rsp, err := d.Ping(ctx, &rpc.PingRequest{Nonce: 4711, Sent: sent})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4711), rsp.Nonce)
+	assert.True(t, proto.Equal(sent, rsp.Sent))
+	assert.False(t, rsp.Received.AsTime().Before(sent.AsTime()))
+}
+
+func TestService_GetConfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	cfg.Timeouts = client.Timeouts{
+		PrivateAgentInstall:          1 * time.Second,
+		PrivateApply:                 2 * time.Second,
+		PrivateClusterConnect:        3 * time.Second,
+		PrivateConnectivityCheck:     4 * time.Second,
+		PrivateEndpointDial:          5 * time.Second,
+		PrivateHelm:                  6 * time.Second,
+		PrivateIntercept:             7 * time.Second,
+		PrivateListCommands:          8 * time.Second,
+		PrivateProxyDial:             9 * time.Second,
+		PrivateRoundtripLatency:      10 * time.Second,
+		PrivateTrafficManagerAPI:     11 * time.Second,
+		PrivateTrafficManagerConnect: 12 * time.Second,
+	}
+	ctx = client.WithConfig(ctx, &cfg)
+	d := newService(&cfg, "/logs/daemon.log", "/run/daemon.socket", "/run/connector.socket")
+
+	dc, err := d.GetConfig(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "/run/daemon.socket", dc.SocketName)
+	assert.Equal(t, "/run/connector.socket", dc.ConnectorSocketName)
+	assert.Equal(t, "/logs/daemon.log", dc.LogFile)
+	assert.Nil(t, dc.Dns)
+	timeouts := make(map[string]time.Duration, len(dc.Timeouts))
+	for name, d := range dc.Timeouts {
+		timeouts[name] = d.AsDuration()
+	}
+	assert.Equal(t, map[string]time.Duration{
+		"agentInstall":          1 * time.Second,
+		"apply":                 2 * time.Second,
+		"clusterConnect":        3 * time.Second,
+		"connectivityCheck":     4 * time.Second,
+		"endpointDial":          5 * time.Second,
+		"helm":                  6 * time.Second,
+		"intercept":             7 * time.Second,
+		"listCommands":          8 * time.Second,
+		"proxyDial":             9 * time.Second,
+		"roundtripLatency":      10 * time.Second,
+		"trafficManagerAPI":     11 * time.Second,
+		"trafficManagerConnect": 12 * time.Second,
+	}, timeouts)
+
+	// The DNS configuration of the session, including its fallback servers
+	d.session = &session{dnsServer: dns.NewServer(&rpc.DNSConfig{
+		LocalIp:       net.IP{192, 168, 1, 1},
+		FallbackIps:   [][]byte{net.IP{8, 8, 8, 8}, net.IP{1, 1, 1, 1}},
+		LookupTimeout: durationpb.New(4 * time.Second),
+	}, nil, false)}
+	dc, err = d.GetConfig(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	require.NotNil(t, dc.Dns)
+	assert.Equal(t, []byte(net.IP{192, 168, 1, 1}), dc.Dns.LocalIp)
+	assert.Equal(t, [][]byte{net.IP{8, 8, 8, 8}, net.IP{1, 1, 1, 1}}, dc.Dns.FallbackIps)
+	assert.Equal(t, 4*time.Second, dc.Dns.LookupTimeout.AsDuration())
+}
+
+func TestService_reloadOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	configDir := t.TempDir()
+	ctx = filelocation.WithAppUserConfigDir(ctx, configDir)
+	cfg, err := client.LoadConfig(ctx)
+	require.NoError(t, err)
+	ctx = client.WithConfig(ctx, cfg)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.InfoLevel)
+	ctx = dlog.WithLogger(ctx, dlog.WrapLogrus(logger))
+	ctx = log.WithLevelSetter(ctx, logger)
+
+	d := newService(cfg, "/logs/daemon.log", "/run/daemon.socket", "/run/connector.socket")
+	sigCh := make(chan os.Signal)
+	done := make(chan error)
+	go func() { done <- d.reloadOnSignal(ctx, sigCh) }()
+
+	// Nothing changes until the signal arrives
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.yml"), []byte(`
+logLevels:
+  rootDaemon: debug
+timeouts:
+  helm: 42s
+`), 0o600))
+	assert.Equal(t, logrus.InfoLevel, logger.GetLevel())
+
+	sigCh <- syscall.SIGHUP
+	assert.Eventually(t, func() bool {
+		return logger.GetLevel() == logrus.DebugLevel
+	}, 5*time.Second, 10*time.Millisecond)
+	dc, err := d.GetConfig(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, 42*time.Second, dc.Timeouts["helm"].AsDuration())
+
+	// An invalid configuration is rejected, and the current one is retained
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.yml"), []byte("timeouts: 42"), 0o600))
+	sigCh <- syscall.SIGHUP
+	sigCh <- syscall.SIGHUP // returns when the first one has been handled
+	dc, err = d.GetConfig(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, 42*time.Second, dc.Timeouts["helm"].AsDuration())
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// stuckDaemon is a daemon whose Version call doesn't return until release is closed, regardless
+// of whether the call is cancelled.
+type stuckDaemon struct {
+	rpc.UnimplementedDaemonServer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (d *stuckDaemon) Version(context.Context, *empty.Empty) (*common.VersionInfo, error) {
+	close(d.entered)
+	<-d.release
+	return &common.VersionInfo{}, nil
+}
+
+type callKey struct{}
+
+func Test_callContext(t *testing.T) {
+	cfg := client.GetDefaultConfig()
+	serverCtx := client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+	callCtx, cancel := context.WithCancel(context.WithValue(context.Background(), callKey{}, "call"))
+	ctx := callContext{Context: callCtx, serverCtx: serverCtx}
+
+	// The values of the call come first, and the server provides the rest
+	assert.Equal(t, "call", ctx.Value(callKey{}))
+	assert.Same(t, &cfg, client.GetConfig(ctx))
+	assert.Nil(t, ctx.Value(struct{}{}))
+
+	// The call, not the server, decides when the context ends
+	assert.NoError(t, ctx.Err())
+	cancel()
+	<-ctx.Done()
+	assert.NoError(t, serverCtx.Err())
+}
+
+func Test_stopGrpcServer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	sd := &stuckDaemon{entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(sd.release)
+	svc := grpc.NewServer()
+	rpc.RegisterDaemonServer(svc, sd)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- svc.Serve(l)
+	}()
+
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := rpc.NewDaemonClient(conn).Version(ctx, &empty.Empty{})
+		callErr <- err
+	}()
+	<-sd.entered
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	stopGrpcServer(ctx, svc, timeout)
+	assert.Less(t, time.Since(start), timeout+time.Second, "the stuck call delayed the shutdown")
+	assert.GreaterOrEqual(t, time.Since(start), timeout, "the server was stopped before the timeout")
+	assert.NoError(t, <-serveErr)
+	assert.Error(t, <-callErr)
+}
+
+func Test_stopGrpcServer_drained(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	svc := grpc.NewServer()
+	go func() {
+		_ = svc.Serve(l)
+	}()
+	start := time.Now()
+	stopGrpcServer(ctx, svc, time.Minute)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestService_TailLog(t *testing.T) {
+	saved := logging.TailPollInterval
+	logging.TailPollInterval = 10 * time.Millisecond
+	defer func() { logging.TailPollInterval = saved }()
+
+	ctx := dlog.NewTestContext(t, false)
+	logFile := filepath.Join(t.TempDir(), "daemon.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("one\ntwo\n"), 0o600))
+	serverCtx, stopServer := context.WithCancel(ctx)
+	defer stopServer()
+	d := &service{logFile: logFile, serverCtx: serverCtx}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	svc := grpc.NewServer()
+	rpc.RegisterDaemonServer(svc, d)
+	go func() {
+		_ = svc.Serve(l)
+	}()
+	defer svc.Stop()
+
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := rpc.NewDaemonClient(conn).TailLog(ctx, &rpc.TailLogRequest{Lines: 1, Follow: true})
+	require.NoError(t, err)
+
+	recv := func() string {
+		line, err := stream.Recv()
+		require.NoError(t, err)
+		return line.Text
+	}
+	assert.Equal(t, "two", recv())
+
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString("three\nfour\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "three", recv())
+	assert.Equal(t, "four", recv())
+
+	// The stream ends when the server stops
+	stopServer()
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func Test_socketPermissions(t *testing.T) {
+	tests := []struct {
+		name      string
+		daemons   client.Daemons
+		sudoGID   string
+		wantMode  os.FileMode
+		wantGroup string
+		wantErr   bool
+	}{
+		{
+			name:     "no group",
+			wantMode: 0o777,
+		},
+		{
+			name:      "sudo group",
+			sudoGID:   "20",
+			wantMode:  0o660,
+			wantGroup: "20",
+		},
+		{
+			name:      "configured group",
+			daemons:   client.Daemons{RootDaemonSocketGroup: "telepresence"},
+			sudoGID:   "20",
+			wantMode:  0o660,
+			wantGroup: "telepresence",
+		},
+		{
+			name:      "explicit opt-in to everyone",
+			daemons:   client.Daemons{RootDaemonSocketMode: "0777"},
+			sudoGID:   "20",
+			wantMode:  0o777,
+			wantGroup: "20",
+		},
+		{
+			name:     "configured mode",
+			daemons:  client.Daemons{RootDaemonSocketMode: "600"},
+			wantMode: 0o600,
+		},
+		{
+			name:    "invalid mode",
+			daemons: client.Daemons{RootDaemonSocketMode: "0999"},
+			wantErr: true,
+		},
+		{
+			name:    "not a permission mode",
+			daemons: client.Daemons{RootDaemonSocketMode: "10777"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SUDO_GID", tt.sudoGID)
+			mode, group, err := socketPermissions(&tt.daemons)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, mode)
+			assert.Equal(t, tt.wantGroup, group)
+		})
+	}
+}
+
+func TestService_reloadOnSignal_dnsFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	configDir := t.TempDir()
+	ctx = filelocation.WithAppUserConfigDir(ctx, configDir)
+	cfg, err := client.LoadConfig(ctx)
+	require.NoError(t, err)
+	ctx = client.WithConfig(ctx, cfg)
+
+	// A session that uses the fallback given by the user daemon
+	oi := &rpc.OutboundInfo{
+		Session: &manager.SessionInfo{SessionId: "session-1"},
+		Dns:     &rpc.DNSConfig{FallbackIps: [][]byte{net.IP{8, 8, 8, 8}}},
+	}
+	d := newService(cfg, "/logs/daemon.log", "/run/daemon.socket", "/run/connector.socket")
+	sCtx, sCancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	d.session = &session{info: oi, dnsServer: dns.NewServer(proto.Clone(oi.Dns).(*rpc.DNSConfig), nil, false)}
+	d.sessionContext, d.sessionCancel, d.sessionDone = sCtx, sCancel, done
+	go func() {
+		<-sCtx.Done()
+		close(done)
+	}()
+
+	// Act as manageSessions, creating the new session with the DNS configuration that newSession would use
+	connected := make(chan struct{})
+	go func() {
+		defer close(connected)
+		got := <-d.connectCh
+		assert.True(t, proto.Equal(oi, got), "the session is reconnected with the same outbound info")
+		dc, err := configuredDNS(ctx, got.Dns)
+		assert.NoError(t, err)
+		d.sessionLock.Lock()
+		d.session = &session{info: got, dnsServer: dns.NewServer(dc, nil, false)}
+		d.sessionLock.Unlock()
+		d.connectReplyCh <- sessionReply{status: &rpc.DaemonStatus{OutboundConfig: got}}
+	}()
+
+	sigCh := make(chan os.Signal)
+	reloaded := make(chan error)
+	go func() { reloaded <- d.reloadOnSignal(ctx, sigCh) }()
+
+	// A reload that doesn't change the DNS leaves the session alone
+	sigCh <- syscall.SIGHUP
+	sigCh <- syscall.SIGHUP // returns when the first one has been handled
+	assert.NoError(t, sCtx.Err())
+
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.yml"), []byte(`
+daemons:
+  rootDaemonDNSFallbackIPs:
+    - 1.1.1.1
+    - 9.9.9.9
+`), 0o600))
+	sigCh <- syscall.SIGHUP
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session wasn't reconnected")
+	}
+	assert.Error(t, sCtx.Err(), "the old session is cancelled")
+
+	// The new session serves the new fallback servers
+	dc, err := d.GetConfig(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	require.NotNil(t, dc.Dns)
+	assert.Equal(t, [][]byte{net.IP{1, 1, 1, 1}, net.IP{9, 9, 9, 9}}, dc.Dns.FallbackIps)
+
+	cancel()
+	require.NoError(t, <-reloaded)
+}
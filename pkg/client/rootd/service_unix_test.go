@@ -0,0 +1,131 @@
+//go:build !windows
+// +build !windows
+
+package rootd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/log"
+)
+
+func TestService_isolatedSockets(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	dir := t.TempDir()
+
+	// Start two daemons, each with its own socket
+	startedAt := []time.Time{time.Unix(1000, 0), time.Unix(2000, 0)}
+	sockets := []string{filepath.Join(dir, "daemon-1.socket"), filepath.Join(dir, "daemon-2.socket")}
+	wg := sync.WaitGroup{}
+	for i, socket := range sockets {
+		l, err := client.ListenSocket(ctx, ProcessName, socket)
+		require.NoError(t, err)
+		d := &service{
+			startedAt:           startedAt[i],
+			health:              health.NewServer(),
+			connectorSocketName: filepath.Join(dir, "connector.socket"),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = d.serveGrpc(ctx, nil, l)
+		}()
+	}
+
+	// Each daemon answers on its own socket
+	for i, socket := range sockets {
+		conn, err := client.DialSocket(ctx, socket)
+		require.NoError(t, err)
+		vi, err := rpc.NewDaemonClient(conn).Version(ctx, &empty.Empty{})
+		require.NoError(t, err)
+		assert.True(t, startedAt[i].Equal(vi.StartTime.AsTime()))
+		require.NoError(t, conn.Close())
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+// lockedBuffer is a bytes.Buffer that can be written and read concurrently.
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestService_SetLogLevel(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+
+	// The daemon logs at info level
+	var logged lockedBuffer
+	logger := logrus.New()
+	logger.SetOutput(&logged)
+	logger.SetLevel(logrus.InfoLevel)
+	ctx = dlog.WithLogger(ctx, dlog.WrapLogrus(logger))
+	ctx = log.WithLevelSetter(ctx, logger)
+
+	socket := filepath.Join(t.TempDir(), "daemon.socket")
+	l, err := client.ListenSocket(ctx, ProcessName, socket)
+	require.NoError(t, err)
+	d := &service{health: health.NewServer(), timedLogLevel: log.NewTimedLevel("info", log.SetLevel)}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = d.serveGrpc(ctx, nil, l)
+	}()
+	conn, err := client.DialSocket(ctx, socket)
+	require.NoError(t, err)
+	dc := rpc.NewDaemonClient(conn)
+
+	// Changing the level over gRPC affects what the daemon emits
+	dlog.Debug(ctx, "not emitted at info")
+	_, err = dc.SetLogLevel(ctx, &manager.LogLevelRequest{LogLevel: "debug"})
+	require.NoError(t, err)
+	dlog.Debug(ctx, "emitted at debug")
+	_, err = dc.SetLogLevel(ctx, &manager.LogLevelRequest{LogLevel: "info"})
+	require.NoError(t, err)
+	dlog.Debug(ctx, "not emitted at info again")
+
+	out := logged.String()
+	assert.NotContains(t, out, "not emitted at info")
+	assert.Contains(t, out, "emitted at debug")
+	assert.NotContains(t, out, "not emitted at info again")
+
+	require.NoError(t, conn.Close())
+	cancel()
+	<-served
+}
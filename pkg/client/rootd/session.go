@@ -22,6 +22,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 
@@ -94,6 +95,9 @@ type session struct {
 	// are obtained using a connpool.ConnID.
 	handlers *tunnel.Pool
 
+	// metrics count the connections and bytes that are tunneled to the cluster
+	metrics metrics
+
 	// fragmentMap is when concatenating ipv4 fragments
 	fragmentMap map[uint16][]*buffer.Data
 
@@ -143,10 +147,14 @@ type session struct {
 
 	// vifReady is closed when the virtual network interface has been configured.
 	vifReady chan error
+
+	// info is the OutboundInfo that the session was created from
+	info *rpc.OutboundInfo
 }
 
-// connectToManager connects to the traffic-manager and asserts that its version is compatible.
-func connectToManager(c context.Context) (*grpc.ClientConn, manager.ManagerClient, semver.Version, error) {
+// connectToManager connects to the traffic-manager through the connector's socket and asserts that its version
+// is compatible.
+func connectToManager(c context.Context, connectorSocketName string) (*grpc.ClientConn, manager.ManagerClient, semver.Version, error) {
 	// First check. Establish connection
 	clientConfig := client.GetConfig(c)
 	tos := &clientConfig.Timeouts
@@ -154,7 +162,7 @@ func connectToManager(c context.Context) (*grpc.ClientConn, manager.ManagerClien
 	defer cancel()
 
 	var conn *grpc.ClientConn
-	conn, err := client.DialSocket(tc, client.ConnectorSocketName,
+	conn, err := client.DialSocket(tc, connectorSocketName,
 		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
 		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
 	)
@@ -199,10 +207,170 @@ func convertSubnets(ms []*manager.IPNet) []*net.IPNet {
 	return ns
 }
 
+// clusterDNS returns the DNS settings of the given cluster info.
+func clusterDNS(mgrInfo *manager.ClusterInfo) *manager.DNS {
+	if dns := mgrInfo.Dns; dns != nil {
+		return dns
+	}
+	// Older traffic-manager. Use deprecated mgrInfo fields for DNS
+	return &manager.DNS{
+		KubeIp:        mgrInfo.KubeDnsIp,
+		ClusterDomain: mgrInfo.ClusterDomain,
+	}
+}
+
+// clusterSubnets returns the unique service and pod subnets of the given cluster info.
+func clusterSubnets(mgrInfo *manager.ClusterInfo) []*net.IPNet {
+	subnets := make([]*net.IPNet, 0, 1+len(mgrInfo.PodSubnets))
+	if mgrInfo.ServiceSubnet != nil {
+		subnets = append(subnets, iputil.IPNetFromRPC(mgrInfo.ServiceSubnet))
+	}
+	for _, sn := range mgrInfo.PodSubnets {
+		subnets = append(subnets, iputil.IPNetFromRPC(sn))
+	}
+	return subnet.Unique(subnets)
+}
+
+// routedSubnets returns the unique subnets that are routed to the cluster.
+func routedSubnets(clusterSubnets, alsoProxySubnets []*net.IPNet) []*net.IPNet {
+	subnets := make([]*net.IPNet, len(clusterSubnets)+len(alsoProxySubnets))
+	copy(subnets, clusterSubnets)
+	copy(subnets[len(clusterSubnets):], alsoProxySubnets)
+	return subnet.Unique(subnets)
+}
+
+// overlapsAny returns true if the never-proxy subnet n overlaps with any of the given routed subnets, in
+// which case n needs a static route to keep it from being routed to the cluster.
+func overlapsAny(n *net.IPNet, subnets []*net.IPNet) bool {
+	for _, s := range subnets {
+		if s.Contains(n.IP) || n.Contains(s.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewNetwork returns the network configuration that a session that is created with the given outbound
+// info applies when it receives the given cluster info.
+func previewNetwork(mi *rpc.OutboundInfo, mgrInfo *manager.ClusterInfo) *rpc.NetworkPreview {
+	alsoProxy := convertSubnets(mi.AlsoProxySubnets)
+	neverProxy := convertSubnets(mi.NeverProxySubnets)
+	if r := mgrInfo.Routing; r != nil {
+		alsoProxy = subnet.Unique(append(alsoProxy, convertSubnets(r.AlsoProxySubnets)...))
+	nextNeverProxy:
+		for _, n := range convertSubnets(r.NeverProxySubnets) {
+			for _, e := range neverProxy {
+				if subnet.Equal(e, n) {
+					continue nextNeverProxy
+				}
+			}
+			neverProxy = append(neverProxy, n)
+		}
+	}
+	routed := routedSubnets(clusterSubnets(mgrInfo), alsoProxy)
+
+	toRPC := func(ns []*net.IPNet) []*manager.IPNet {
+		rs := make([]*manager.IPNet, len(ns))
+		for i, n := range ns {
+			rs[i] = iputil.IPNetToRPC(n)
+		}
+		return rs
+	}
+	var staticRoutes []*net.IPNet
+	for _, n := range neverProxy {
+		if overlapsAny(n, routed) {
+			staticRoutes = append(staticRoutes, n)
+		}
+	}
+	preview := &rpc.NetworkPreview{
+		RoutedSubnets:     toRPC(routed),
+		NeverProxySubnets: toRPC(neverProxy),
+		StaticRoutes:      toRPC(staticRoutes),
+		DnsIp:             mgrInfo.ManagerPodIp,
+		ClusterDomain:     clusterDNS(mgrInfo).ClusterDomain,
+	}
+	if mi.Dns != nil {
+		preview.Dns = proto.Clone(mi.Dns).(*rpc.DNSConfig)
+	}
+	return preview
+}
+
+// validateDNSConfig checks that the IPs of the given DNS configuration are valid IPv4 or IPv6 addresses. An
+// empty IP is valid and means that the DNS server will find a suitable address by itself.
+func validateDNSConfig(dc *rpc.DNSConfig) error {
+	if dc == nil {
+		return nil
+	}
+	check := func(what string, ip []byte) error {
+		if len(ip) != 0 && len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			return errcat.User.Newf("invalid DNS %s %v: must be an IPv4 or IPv6 address", what, ip)
+		}
+		return nil
+	}
+	if err := check("local IP", dc.LocalIp); err != nil {
+		return err
+	}
+	if err := check("remote IP", dc.RemoteIp); err != nil {
+		return err
+	}
+	for _, ip := range dc.FallbackIps {
+		if len(ip) == 0 {
+			return errcat.User.New("invalid DNS fallback IP: must not be empty")
+		}
+		if err := check("fallback IP", ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configuredDNS returns the given DNS configuration with its fallback IPs replaced by the
+// daemons.rootDaemonDNSFallbackIPs of the config, if any. The given configuration isn't modified.
+func configuredDNS(c context.Context, dc *rpc.DNSConfig) (*rpc.DNSConfig, error) {
+	ips := client.GetConfig(c).Daemons.RootDaemonDNSFallbackIPs
+	if len(ips) == 0 {
+		return dc, nil
+	}
+	if dc == nil {
+		dc = &rpc.DNSConfig{}
+	} else {
+		dc = proto.Clone(dc).(*rpc.DNSConfig)
+	}
+	dc.FallbackIps = make([][]byte, len(ips))
+	for i, s := range ips {
+		ip := iputil.Parse(s)
+		if ip == nil {
+			return nil, errcat.Config.Newf("invalid daemons.rootDaemonDNSFallbackIPs entry %q: must be an IPv4 or IPv6 address", s)
+		}
+		dc.FallbackIps[i] = ip
+	}
+	return dc, nil
+}
+
+// equalIPs returns true if the given lists contain the same IPs in the same order.
+func equalIPs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !net.IP(a[i]).Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // newSession returns a new properly initialized session object.
-func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo) (*session, error) {
+func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo, connectorSocketName string) (*session, error) {
 	dlog.Info(c, "-- Starting new session")
-	conn, mc, ver, err := connectToManager(c)
+	dc, err := configuredDNS(c, mi.Dns)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDNSConfig(dc); err != nil {
+		return nil, err
+	}
+	conn, mc, ver, err := connectToManager(c, connectorSocketName)
 	if mc == nil || err != nil {
 		return nil, err
 	}
@@ -210,6 +378,7 @@ func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo)
 	as := convertSubnets(mi.AlsoProxySubnets)
 	ns := convertSubnets(mi.NeverProxySubnets)
 	s := &session{
+		info:             proto.Clone(mi).(*rpc.OutboundInfo),
 		scout:            scout,
 		handlers:         tunnel.NewPool(),
 		fragmentMap:      make(map[uint16][]*buffer.Data),
@@ -230,9 +399,9 @@ func newSession(c context.Context, scout *scout.Reporter, mi *rpc.OutboundInfo)
 	}
 
 	if dnsproxy.ManagerCanDoDNSQueryTypes(ver) {
-		s.dnsServer = dns.NewServer(mi.Dns, s.clusterLookup, false)
+		s.dnsServer = dns.NewServer(dc, s.clusterLookup, false)
 	} else {
-		s.dnsServer = dns.NewServer(mi.Dns, s.legacyClusterLookup, true)
+		s.dnsServer = dns.NewServer(dc, s.legacyClusterLookup, true)
 	}
 	dlog.Infof(c, "also-proxy subnets %v", as)
 	dlog.Infof(c, "never-proxy subnets %v", ns)
@@ -288,6 +457,14 @@ func (s *session) legacyClusterLookup(ctx context.Context, q *dns2.Question) (rr
 	return rrs, dns2.RcodeSuccess, nil
 }
 
+// dnsHealth probes the DNS resolver and returns the result.
+func (s *session) dnsHealth(ctx context.Context) *rpc.DNSHealth {
+	if err := s.dnsServer.Health(ctx); err != nil {
+		return &rpc.DNSHealth{State: rpc.DNSHealth_DNS_FAILURE, Error: err.Error()}
+	}
+	return &rpc.DNSHealth{State: rpc.DNSHealth_OK}
+}
+
 func (s *session) getInfo() *rpc.OutboundInfo {
 	info := rpc.OutboundInfo{
 		Session: s.session,
@@ -326,11 +503,8 @@ func (s *session) reconcileStaticRoutes(ctx context.Context) (err error) {
 	// We're not going to add static routes unless they're actually needed
 	// (i.e. unless the existing CIDRs overlap with the never-proxy subnets)
 	for _, r := range s.neverProxyRoutes {
-		for _, s := range s.curSubnets {
-			if s.Contains(r.RoutedNet.IP) || r.Routes(s.IP) {
-				desired = append(desired, r)
-				break
-			}
+		if overlapsAny(r.RoutedNet, s.curSubnets) {
+			desired = append(desired, r)
 		}
 	}
 
@@ -366,10 +540,7 @@ func (s *session) refreshSubnets(ctx context.Context) (err error) {
 	// Create a unique slice of all desired subnets.
 	ctx, span := otel.GetTracerProvider().Tracer("").Start(ctx, "refreshSubnets")
 	defer tracing.EndAndRecord(span, err)
-	desired := make([]*net.IPNet, len(s.clusterSubnets)+len(s.alsoProxySubnets))
-	copy(desired, s.clusterSubnets)
-	copy(desired[len(s.clusterSubnets):], s.alsoProxySubnets)
-	desired = subnet.Unique(desired)
+	desired := routedSubnets(s.clusterSubnets, s.alsoProxySubnets)
 
 	// Remove all no longer desired subnets from the t.curSubnets
 	var removed []*net.IPNet
@@ -500,14 +671,7 @@ func (s *session) onFirstClusterInfo(ctx context.Context, mgrInfo *manager.Clust
 
 func (s *session) onClusterInfo(ctx context.Context, mgrInfo *manager.ClusterInfo, span trace.Span) {
 	dlog.Debugf(ctx, "WatchClusterInfo update")
-	dns := mgrInfo.Dns
-	if dns == nil {
-		// Older traffic-manager. Use deprecated mgrInfo fields for DNS
-		dns = &manager.DNS{
-			KubeIp:        mgrInfo.KubeDnsIp,
-			ClusterDomain: mgrInfo.ClusterDomain,
-		}
-	}
+	dns := clusterDNS(mgrInfo)
 
 	// We use the ManagerPodIp as the dnsIP. The reason for this is that no one should ever
 	// talk to the traffic-manager directly using the TUN device, so it's safe to use its
@@ -543,21 +707,14 @@ func (s *session) onClusterInfo(ctx context.Context, mgrInfo *manager.ClusterInf
 		dlog.Infof(ctx, "never-proxy subnets %v", routing.Subnets(s.neverProxyRoutes))
 	}
 
-	subnets := make([]*net.IPNet, 0, 1+len(mgrInfo.PodSubnets))
 	if s.proxyCluster {
 		if mgrInfo.ServiceSubnet != nil {
-			cidr := iputil.IPNetFromRPC(mgrInfo.ServiceSubnet)
-			dlog.Infof(ctx, "Adding service subnet %s", cidr)
-			subnets = append(subnets, cidr)
+			dlog.Infof(ctx, "Adding service subnet %s", iputil.IPNetFromRPC(mgrInfo.ServiceSubnet))
 		}
-
 		for _, sn := range mgrInfo.PodSubnets {
-			cidr := iputil.IPNetFromRPC(sn)
-			dlog.Infof(ctx, "Adding pod subnet %s", cidr)
-			subnets = append(subnets, cidr)
+			dlog.Infof(ctx, "Adding pod subnet %s", iputil.IPNetFromRPC(sn))
 		}
-
-		s.clusterSubnets = subnet.Unique(subnets)
+		s.clusterSubnets = clusterSubnets(mgrInfo)
 		if err := s.refreshSubnets(ctx); err != nil {
 			dlog.Error(ctx, err)
 		}
@@ -0,0 +1,150 @@
+package rootd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	dns2 "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/rootd/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnsproxy"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func Test_session_dnsHealth(t *testing.T) {
+	failing := func(context.Context, *dns2.Question) (dnsproxy.RRs, int, error) {
+		return nil, dns2.RcodeServerFailure, errors.New("no route to host")
+	}
+	s := &session{dnsServer: dns.NewServer(nil, failing, false)}
+	h := s.dnsHealth(context.Background())
+	assert.Equal(t, rpc.DNSHealth_DNS_FAILURE, h.State)
+	assert.Contains(t, h.Error, "no route to host")
+}
+
+func Test_validateDNSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *rpc.DNSConfig
+		wantErr string
+	}{
+		{name: "nil config"},
+		{name: "empty IPs", config: &rpc.DNSConfig{}},
+		{
+			name: "valid IPs",
+			config: &rpc.DNSConfig{
+				LocalIp:     net.IP{192, 168, 1, 1},
+				RemoteIp:    net.ParseIP("fd00::1"),
+				FallbackIps: [][]byte{net.IP{8, 8, 8, 8}, net.ParseIP("2001:4860:4860::8888")},
+			},
+		},
+		{name: "malformed local IP", config: &rpc.DNSConfig{LocalIp: []byte{192, 168, 1}}, wantErr: "invalid DNS local IP"},
+		{name: "malformed remote IP", config: &rpc.DNSConfig{RemoteIp: []byte("10.0.0.1")}, wantErr: "invalid DNS remote IP"},
+		{name: "malformed fallback IP", config: &rpc.DNSConfig{FallbackIps: [][]byte{{8, 8, 8, 8}, {8, 8}}}, wantErr: "invalid DNS fallback IP"},
+		{name: "empty fallback IP", config: &rpc.DNSConfig{FallbackIps: [][]byte{{}}}, wantErr: "must not be empty"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDNSConfig(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		})
+	}
+}
+
+func Test_configuredDNS(t *testing.T) {
+	requested := &rpc.DNSConfig{LocalIp: net.IP{192, 168, 1, 1}, FallbackIps: [][]byte{net.IP{8, 8, 8, 8}}}
+	cfg := client.GetDefaultConfig()
+	ctx := client.WithConfig(context.Background(), &cfg)
+
+	// Without configured fallback IPs, the requested configuration is used as is
+	dc, err := configuredDNS(ctx, requested)
+	require.NoError(t, err)
+	assert.Same(t, requested, dc)
+
+	// The configured fallback IPs replace the requested ones
+	cfg.Daemons.RootDaemonDNSFallbackIPs = []string{"1.1.1.1", "2001:4860:4860::8888"}
+	dc, err = configuredDNS(ctx, requested)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{net.IP{1, 1, 1, 1}, net.ParseIP("2001:4860:4860::8888")}, dc.FallbackIps)
+	assert.Equal(t, []byte(net.IP{192, 168, 1, 1}), dc.LocalIp)
+	assert.Equal(t, [][]byte{net.IP{8, 8, 8, 8}}, requested.FallbackIps, "the requested configuration is unchanged")
+
+	cfg.Daemons.RootDaemonDNSFallbackIPs = []string{"not-an-ip"}
+	_, err = configuredDNS(ctx, requested)
+	require.Error(t, err)
+	assert.Equal(t, errcat.Config, errcat.GetCategory(err))
+}
+
+func Test_previewNetwork(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		_, n, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return n
+	}
+	rpcCIDRs := func(ss ...string) []*manager.IPNet {
+		ns := make([]*manager.IPNet, len(ss))
+		for i, s := range ss {
+			ns[i] = iputil.IPNetToRPC(cidr(s))
+		}
+		return ns
+	}
+	cidrs := func(ns []*manager.IPNet) []string {
+		ss := make([]string, len(ns))
+		for i, n := range ns {
+			ss[i] = iputil.IPNetFromRPC(n).String()
+		}
+		return ss
+	}
+
+	mi := &rpc.OutboundInfo{
+		Dns:               &rpc.DNSConfig{LocalIp: net.IP{192, 168, 1, 1}},
+		AlsoProxySubnets:  rpcCIDRs("172.20.0.0/16"),
+		NeverProxySubnets: rpcCIDRs("10.96.0.10/32"),
+	}
+	mgrInfo := &manager.ClusterInfo{
+		ServiceSubnet: iputil.IPNetToRPC(cidr("10.96.0.0/12")),
+		PodSubnets:    rpcCIDRs("10.244.0.0/24", "10.244.1.0/24", "10.244.0.0/24"),
+		ManagerPodIp:  net.IP{10, 244, 0, 5},
+		Dns:           &manager.DNS{ClusterDomain: "cluster.local."},
+		Routing: &manager.Routing{
+			AlsoProxySubnets:  rpcCIDRs("172.20.1.0/24"),
+			NeverProxySubnets: rpcCIDRs("10.96.0.10/32", "192.168.0.0/16"),
+		},
+	}
+	p := previewNetwork(mi, mgrInfo)
+
+	// Duplicates and subnets that are covered by other subnets are routed once
+	assert.ElementsMatch(t, []string{"10.96.0.0/12", "10.244.0.0/24", "10.244.1.0/24", "172.20.0.0/16"}, cidrs(p.RoutedSubnets))
+	assert.ElementsMatch(t, []string{"10.96.0.10/32", "192.168.0.0/16"}, cidrs(p.NeverProxySubnets))
+
+	// Only never-proxy subnets that overlap with a routed subnet need a static route
+	assert.Equal(t, []string{"10.96.0.10/32"}, cidrs(p.StaticRoutes))
+
+	assert.Equal(t, net.IP{10, 244, 0, 5}, net.IP(p.DnsIp))
+	assert.Equal(t, "cluster.local.", p.ClusterDomain)
+	assert.Equal(t, []byte{192, 168, 1, 1}, p.Dns.LocalIp)
+
+	// The preview doesn't modify the outbound info
+	assert.Len(t, mi.AlsoProxySubnets, 1)
+	assert.Len(t, mi.NeverProxySubnets, 1)
+
+	// Older traffic-managers provide the cluster domain in a deprecated field
+	p = previewNetwork(&rpc.OutboundInfo{}, &manager.ClusterInfo{ClusterDomain: "example.org."})
+	assert.Equal(t, "example.org.", p.ClusterDomain)
+	assert.Empty(t, p.RoutedSubnets)
+	assert.Nil(t, p.Dns)
+}
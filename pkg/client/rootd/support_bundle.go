@@ -0,0 +1,229 @@
+package rootd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// supportBundleChunkSize is the maximum size of the chunks that SupportBundle sends.
+const supportBundleChunkSize = 64 * 1024
+
+const (
+	scrubbedIP     = "<ip>"
+	scrubbedSecret = "<secret>"
+)
+
+var (
+	// ipCandidateRx matches everything that might be an IP address, optionally followed by a port. The
+	// candidates are verified using net.ParseIP, so that e.g. timestamps and versions are left alone.
+	ipCandidateRx = regexp.MustCompile(`[0-9A-Fa-f:.]*[:.][0-9A-Fa-f:.]*`)
+
+	// secretRx matches the value of an assignment to something that is named like a secret.
+	secretRx = regexp.MustCompile(`(?i)((?:token|password|passwd|secret|api[-_]?key|authorization)["']?\s*[:=]\s*["']?(?:bearer\s+)?)[^\s"',}]+`)
+)
+
+func (d *service) SupportBundle(request *rpc.SupportBundleRequest, stream rpc.Daemon_SupportBundleServer) error {
+	cw := chunkWriter(func(data []byte) error {
+		return stream.Send(&rpc.SupportBundleChunk{Data: data})
+	})
+	bw := bufio.NewWriterSize(cw, supportBundleChunkSize)
+	if err := d.writeSupportBundle(stream.Context(), bw, request.Scrub); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return bw.Flush()
+}
+
+// chunkWriter sends what's written to it in chunks of at most supportBundleChunkSize bytes. The function
+// must not retain the chunk.
+type chunkWriter func([]byte) error
+
+func (cw chunkWriter) Write(data []byte) (int, error) {
+	n := 0
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > supportBundleChunkSize {
+			chunk = chunk[:supportBundleChunkSize]
+		}
+		if err := cw(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		data = data[len(chunk):]
+	}
+	return n, nil
+}
+
+// writeSupportBundle writes a gzipped tarball with the daemon's log file and the results of Version, Status,
+// GetConfig, and Metrics to w. Metrics are only included when the daemon has a session. The reasons why
+// something couldn't be included are listed in an errors.txt entry. IP addresses and values that look like
+// secrets are replaced with placeholders when scrub is true.
+func (d *service) writeSupportBundle(ctx context.Context, w io.Writer, scrub bool) error {
+	gz := gzip.NewWriter(w)
+	sb := &supportBundle{tw: tar.NewWriter(gz), scrub: scrub, modTime: time.Now()}
+
+	var problems bytes.Buffer
+	addMessage := func(name string, m proto.Message, err error) {
+		if err == nil {
+			err = sb.addMessage(name, m)
+		}
+		if err != nil {
+			fmt.Fprintf(&problems, "%s: %v\n", name, err)
+		}
+	}
+	v, err := d.Version(ctx, &empty.Empty{})
+	addMessage("version.json", v, err)
+	st, err := d.Status(ctx, &empty.Empty{})
+	addMessage("status.json", st, err)
+	dc, err := d.GetConfig(ctx, &empty.Empty{})
+	addMessage("config.json", dc, err)
+	if ms, err := d.Metrics(ctx, &rpc.MetricsRequest{}); err == nil {
+		addMessage("metrics.json", ms, nil)
+	} else {
+		dlog.Debugf(ctx, "support bundle has no metrics: %v", err)
+	}
+	if err = sb.addFile(ProcessName+".log", d.logFile); err != nil {
+		fmt.Fprintf(&problems, "%s.log: %v\n", ProcessName, err)
+	}
+	if problems.Len() > 0 {
+		if err = sb.add("errors.txt", problems.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err = sb.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+type supportBundle struct {
+	tw      *tar.Writer
+	scrub   bool
+	modTime time.Time
+}
+
+// add adds an entry with the given name and data to the bundle.
+func (sb *supportBundle) add(name string, data []byte) error {
+	if err := sb.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: sb.modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := sb.tw.Write(data)
+	return err
+}
+
+// addMessage adds the given message to the bundle, encoded as JSON.
+func (sb *supportBundle) addMessage(name string, m proto.Message) error {
+	if sb.scrub {
+		m = proto.Clone(m)
+		scrubMessage(m.ProtoReflect())
+	}
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return sb.add(name, data)
+}
+
+// addFile adds the contents of the given file to the bundle.
+func (sb *supportBundle) addFile(name, file string) error {
+	if file == "" {
+		return os.ErrNotExist
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if sb.scrub {
+		data = []byte(scrubText(string(data)))
+	}
+	return sb.add(name, data)
+}
+
+// scrubText replaces the IP addresses, and the values that look like secrets, in the given text.
+func scrubText(s string) string {
+	s = secretRx.ReplaceAllString(s, "${1}"+scrubbedSecret)
+	return ipCandidateRx.ReplaceAllStringFunc(s, func(c string) string {
+		// The candidate may end with the punctuation of a sentence
+		ip := strings.TrimRight(c, ".:")
+		rest := c[len(ip):]
+		if net.ParseIP(ip) != nil {
+			return scrubbedIP + rest
+		}
+		if host, port, err := net.SplitHostPort(ip); err == nil && net.ParseIP(host) != nil {
+			return scrubbedIP + ":" + port + rest
+		}
+		return c
+	})
+}
+
+// scrubMessage scrubs the given message, and the messages that it contains. Strings are scrubbed using
+// scrubText, and bytes that have the length of an IPv4 or IPv6 address are zeroed.
+func scrubMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			l := m.Mutable(fd).List()
+			for i := 0; i < l.Len(); i++ {
+				if sv, ok := scrubValue(fd, l.Get(i)); ok {
+					l.Set(i, sv)
+				}
+			}
+		case fd.IsMap():
+			mp := m.Mutable(fd).Map()
+			mp.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				if sv, ok := scrubValue(fd.MapValue(), v); ok {
+					mp.Set(k, sv)
+				}
+				return true
+			})
+		default:
+			if sv, ok := scrubValue(fd, v); ok {
+				m.Set(fd, sv)
+			}
+		}
+		return true
+	})
+}
+
+// scrubValue returns the scrubbed value, and true, if the given value must be replaced. Messages are
+// scrubbed in place.
+func scrubValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, bool) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		scrubMessage(v.Message())
+	case protoreflect.BytesKind:
+		if b := v.Bytes(); len(b) == net.IPv4len || len(b) == net.IPv6len {
+			return protoreflect.ValueOfBytes(make([]byte, len(b))), true
+		}
+	case protoreflect.StringKind:
+		if s := scrubText(v.String()); s != v.String() {
+			return protoreflect.ValueOfString(s), true
+		}
+	}
+	return v, false
+}
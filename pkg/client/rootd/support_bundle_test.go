@@ -0,0 +1,115 @@
+package rootd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// readSupportBundle returns the entries of the given support bundle, keyed by name.
+func readSupportBundle(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries[hdr.Name] = string(content)
+	}
+	return entries
+}
+
+func TestService_writeSupportBundle(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+	logFile := filepath.Join(t.TempDir(), "daemon.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("connected to 10.0.0.1:8080 using token=s3cr3t\n"), 0o644))
+	d := newService(&cfg, logFile, "/run/daemon.socket", "/run/connector.socket")
+
+	var buf bytes.Buffer
+	require.NoError(t, d.writeSupportBundle(ctx, &buf, false))
+	entries := readSupportBundle(t, buf.Bytes())
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "connected to 10.0.0.1:8080 using token=s3cr3t\n", entries["daemon.log"])
+	for _, name := range []string{"version.json", "status.json", "config.json"} {
+		assert.Contains(t, entries, name)
+	}
+
+	var dc rpc.DaemonConfig
+	require.NoError(t, protojson.Unmarshal([]byte(entries["config.json"]), &dc))
+	assert.Equal(t, "/run/daemon.socket", dc.SocketName)
+	assert.Equal(t, logFile, dc.LogFile)
+
+	// Scrubbed
+	buf.Reset()
+	require.NoError(t, d.writeSupportBundle(ctx, &buf, true))
+	entries = readSupportBundle(t, buf.Bytes())
+	assert.Equal(t, "connected to <ip>:8080 using token=<secret>\n", entries["daemon.log"])
+
+	// A log file that can't be read is reported
+	d.logFile = filepath.Join(t.TempDir(), "missing.log")
+	buf.Reset()
+	require.NoError(t, d.writeSupportBundle(ctx, &buf, false))
+	entries = readSupportBundle(t, buf.Bytes())
+	assert.NotContains(t, entries, "daemon.log")
+	assert.Contains(t, entries["errors.txt"], "daemon.log: ")
+}
+
+func Test_scrubText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"2022-10-27 12:30:45.1234 version 2.8.3", "2022-10-27 12:30:45.1234 version 2.8.3"},
+		{"dial 10.0.0.1:8080 and fe80::1.", "dial <ip>:8080 and <ip>."},
+		{"subnet 10.96.0.0/12, dns [2001:db8::1]:53", "subnet <ip>/12, dns [<ip>]:53"},
+		{`password: "hunter2" Authorization: Bearer abc.def`, `password: "<secret>" Authorization: Bearer <secret>`},
+		{"apiKey='k1', secret=s", "apiKey='<secret>', secret=<secret>"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, scrubText(tt.in))
+	}
+}
+
+func Test_scrubMessage(t *testing.T) {
+	oi := &rpc.OutboundInfo{
+		Dns: &rpc.DNSConfig{
+			LocalIp:         net.IP{192, 168, 1, 1},
+			FallbackIps:     [][]byte{net.IP{8, 8, 8, 8}, net.ParseIP("2001:db8::1")},
+			ExcludeSuffixes: []string{".com", "10.0.0.1"},
+			LookupTimeout:   durationpb.New(4 * time.Second),
+		},
+		AlsoProxySubnets: []*manager.IPNet{{Ip: net.IP{10, 1, 0, 0}, Mask: 16}},
+	}
+	scrubMessage(oi.ProtoReflect())
+	assert.Equal(t, []byte{0, 0, 0, 0}, oi.Dns.LocalIp)
+	assert.Equal(t, [][]byte{make([]byte, 4), make([]byte, 16)}, oi.Dns.FallbackIps)
+	assert.Equal(t, []string{".com", "<ip>"}, oi.Dns.ExcludeSuffixes)
+	assert.Equal(t, 4*time.Second, oi.Dns.LookupTimeout.AsDuration())
+	assert.Equal(t, []byte{0, 0, 0, 0}, oi.AlsoProxySubnets[0].Ip)
+	assert.Equal(t, int32(16), oi.AlsoProxySubnets[0].Mask)
+}
@@ -0,0 +1,59 @@
+package rootd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// tlsConfig returns the configuration of the root daemon's TCP listener. The listener presents the
+// configured certificate and only accepts clients with a certificate that is signed by the configured
+// client CA.
+func tlsConfig(d *client.Daemons) (*tls.Config, error) {
+	if d.RootDaemonTLSCert == "" || d.RootDaemonTLSKey == "" || d.RootDaemonTLSClientCA == "" {
+		return nil, errors.New("rootDaemonTLSAddress requires rootDaemonTLSCert, rootDaemonTLSKey, and rootDaemonTLSClientCA")
+	}
+	cert, err := tls.LoadX509KeyPair(d.RootDaemonTLSCert, d.RootDaemonTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the root daemon's certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(d.RootDaemonTLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", d.RootDaemonTLSClientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// listenTLS returns the TCP listener that the Daemons.RootDaemonTLSAddress setting describes, or nil when
+// there's no such setting. The connections that it accepts have completed, or will complete, a TLS handshake
+// that requires a trusted client certificate.
+func listenTLS(ctx context.Context, d *client.Daemons) (net.Listener, error) {
+	if d.RootDaemonTLSAddress == "" {
+		return nil, nil
+	}
+	tc, err := tlsConfig(d)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", d.RootDaemonTLSAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", d.RootDaemonTLSAddress, err)
+	}
+	return tls.NewListener(l, tc), nil
+}
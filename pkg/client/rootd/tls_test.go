@@ -0,0 +1,167 @@
+package rootd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// testCA is a certificate authority that issues the certificates of a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of a new server or client.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(file, data, 0o600))
+	return file
+}
+
+func TestService_serveGrpcTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	cfg := client.GetDefaultConfig()
+	ctx = client.WithConfig(ctx, &cfg)
+
+	dir := t.TempDir()
+	serverCA, clientCA, untrustedCA := newTestCA(t, "server CA"), newTestCA(t, "client CA"), newTestCA(t, "untrusted CA")
+	certPEM, keyPEM := serverCA.issue(t, "daemon", x509.ExtKeyUsageServerAuth)
+	cfg.Daemons.RootDaemonTLSAddress = "127.0.0.1:0"
+	cfg.Daemons.RootDaemonTLSCert = writeTestFile(t, dir, "daemon.crt", certPEM)
+	cfg.Daemons.RootDaemonTLSKey = writeTestFile(t, dir, "daemon.key", keyPEM)
+	cfg.Daemons.RootDaemonTLSClientCA = writeTestFile(t, dir, "client-ca.crt", clientCA.pem)
+
+	l, err := listenTLS(ctx, &cfg.Daemons)
+	require.NoError(t, err)
+	startedAt := time.Unix(1000, 0)
+	d := &service{startedAt: startedAt, health: health.NewServer()}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = d.serveGrpc(ctx, nil, l)
+	}()
+
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(serverCA.cert)
+	version := func(ca *testCA) (*common.VersionInfo, error) {
+		tc := &tls.Config{RootCAs: serverCAs, MinVersion: tls.VersionTLS12}
+		if ca != nil {
+			certPEM, keyPEM := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			require.NoError(t, err)
+			tc.Certificates = []tls.Certificate{cert}
+		}
+		conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(tc)))
+		require.NoError(t, err)
+		defer conn.Close()
+		tCtx, tCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer tCancel()
+		return rpc.NewDaemonClient(conn).Version(tCtx, &empty.Empty{})
+	}
+
+	// A client with a certificate from the client CA is served
+	vi, err := version(clientCA)
+	require.NoError(t, err)
+	assert.True(t, startedAt.Equal(vi.StartTime.AsTime()))
+
+	// Other clients are rejected
+	_, err = version(untrustedCA)
+	assert.Error(t, err)
+	_, err = version(nil)
+	assert.Error(t, err)
+
+	cancel()
+	<-served
+}
+
+func Test_listenTLS(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// No listener unless configured
+	l, err := listenTLS(ctx, &client.Daemons{})
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	// Certificates are required
+	_, err = listenTLS(ctx, &client.Daemons{RootDaemonTLSAddress: "127.0.0.1:0", RootDaemonTLSCert: "daemon.crt"})
+	assert.ErrorContains(t, err, "requires")
+
+	dir := t.TempDir()
+	ca := newTestCA(t, "CA")
+	certPEM, keyPEM := ca.issue(t, "daemon", x509.ExtKeyUsageServerAuth)
+	_, err = listenTLS(ctx, &client.Daemons{
+		RootDaemonTLSAddress:  "127.0.0.1:0",
+		RootDaemonTLSCert:     writeTestFile(t, dir, "daemon.crt", certPEM),
+		RootDaemonTLSKey:      writeTestFile(t, dir, "daemon.key", keyPEM),
+		RootDaemonTLSClientCA: writeTestFile(t, dir, "client-ca.crt", []byte("not a certificate")),
+	})
+	assert.ErrorContains(t, err, "no certificates found")
+}
@@ -21,6 +21,19 @@ func ListenSocket(ctx context.Context, processName, socketName string) (net.List
 	return listenSocket(ctx, processName, socketName)
 }
 
+// ListenSocketWithPermissions is like ListenSocket, but the socket never has other permissions than the given
+// mode and, unless group is empty, group, see SetSocketPermissions. The socket is removed again and an error is
+// returned if they can't be applied.
+func ListenSocketWithPermissions(ctx context.Context, processName, socketName string, mode os.FileMode, group string) (net.Listener, error) {
+	return listenSocketWithPermissions(ctx, processName, socketName, mode, group)
+}
+
+// SetSocketPermissions changes the mode of the given socket and, unless group is empty, the group that owns
+// it. The group is either a group name or a numeric group id.
+func SetSocketPermissions(socketName string, mode os.FileMode, group string) error {
+	return setSocketPermissions(socketName, mode, group)
+}
+
 // RemoveSocket removes any representation of the socket from the filesystem.
 func RemoveSocket(listener net.Listener) error {
 	return removeSocket(listener)
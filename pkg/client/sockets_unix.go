@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -78,8 +80,32 @@ func dialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption)
 }
 
 func listenSocket(_ context.Context, processName, socketName string) (net.Listener, error) {
+	umask := -1
 	if proc.IsAdmin() {
-		origUmask := unix.Umask(0)
+		umask = 0
+	}
+	return listenUnix(processName, socketName, umask)
+}
+
+func listenSocketWithPermissions(_ context.Context, processName, socketName string, mode os.FileMode, group string) (net.Listener, error) {
+	// The umask makes the socket come into existence with the given mode rather than being accessible to
+	// everyone until it's changed.
+	listener, err := listenUnix(processName, socketName, int(^mode.Perm()&os.ModePerm))
+	if err != nil {
+		return nil, err
+	}
+	if err = setSocketPermissions(socketName, mode, group); err != nil {
+		_ = listener.Close()
+		_ = os.Remove(socketName)
+		return nil, fmt.Errorf("failed to set permissions of %s: %w", socketName, err)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on the given socket, which is created using the given umask unless it's negative.
+func listenUnix(processName, socketName string, umask int) (net.Listener, error) {
+	if umask >= 0 {
+		origUmask := unix.Umask(umask)
 		defer unix.Umask(origUmask)
 	}
 	listener, err := net.Listen("unix", socketName)
@@ -95,6 +121,30 @@ func listenSocket(_ context.Context, processName, socketName string) (net.Listen
 	return listener, nil
 }
 
+func setSocketPermissions(socketName string, mode os.FileMode, group string) error {
+	if group != "" {
+		gid, err := lookupGroupID(group)
+		if err != nil {
+			return err
+		}
+		if err = os.Chown(socketName, -1, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(socketName, mode)
+}
+
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
 func removeSocket(listener net.Listener) error {
 	return os.Remove(listener.Addr().String())
 }
@@ -8,9 +8,12 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 
 	"github.com/datawire/dlib/dgroup"
@@ -101,3 +104,52 @@ func TestDialSocket(t *testing.T) {
 		assert.Contains(t, err.Error(), "this usually means that the process is not running")
 	})
 }
+
+func TestSetSocketPermissions(t *testing.T) {
+	sockname := filepath.Join(t.TempDir(), "perm.sock")
+	listener, err := net.Listen("unix", sockname)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	modeOf := func() os.FileMode {
+		s, err := os.Stat(sockname)
+		require.NoError(t, err)
+		return s.Mode().Perm()
+	}
+
+	require.NoError(t, client.SetSocketPermissions(sockname, 0o660, ""))
+	assert.Equal(t, os.FileMode(0o660), modeOf())
+
+	// Changing the group to one that the current user belongs to is always permitted
+	require.NoError(t, client.SetSocketPermissions(sockname, 0o600, strconv.Itoa(os.Getgid())))
+	assert.Equal(t, os.FileMode(0o600), modeOf())
+
+	require.NoError(t, client.SetSocketPermissions(sockname, 0o777, ""))
+	assert.Equal(t, os.FileMode(0o777), modeOf())
+
+	assert.Error(t, client.SetSocketPermissions(sockname, 0o660, "no-such-group-for-telepresence"))
+}
+
+func TestListenSocketWithPermissions(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	umask := unix.Umask(0o22)
+	unix.Umask(umask)
+
+	sockname := filepath.Join(t.TempDir(), "perm.sock")
+	listener, err := client.ListenSocketWithPermissions(ctx, "test", sockname, 0o600, strconv.Itoa(os.Getgid()))
+	require.NoError(t, err)
+	s, err := os.Stat(sockname)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), s.Mode().Perm())
+	require.NoError(t, listener.Close())
+	require.NoError(t, os.Remove(sockname))
+
+	// A socket whose permissions can't be applied isn't left behind
+	_, err = client.ListenSocketWithPermissions(ctx, "test", sockname, 0o660, "no-such-group-for-telepresence")
+	require.Error(t, err)
+	_, err = os.Stat(sockname)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The umask of the process is restored
+	assert.Equal(t, umask, unix.Umask(umask))
+}
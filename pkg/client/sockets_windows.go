@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os"
 
 	"github.com/Microsoft/go-winio"
 	"golang.org/x/sys/windows"
@@ -60,6 +61,18 @@ func listenSocket(_ context.Context, processName, socketName string) (net.Listen
 	return winio.ListenPipe(socketName, config)
 }
 
+// listenSocketWithPermissions returns a listener for the given named pipe. The mode and group are ignored,
+// see setSocketPermissions.
+func listenSocketWithPermissions(ctx context.Context, processName, socketName string, _ os.FileMode, _ string) (net.Listener, error) {
+	return listenSocket(ctx, processName, socketName)
+}
+
+// setSocketPermissions does nothing because the access to a named pipe is controlled by the security
+// descriptor that it is created with.
+func setSocketPermissions(socketName string, mode os.FileMode, group string) error {
+	return nil
+}
+
 // removeSocket does nothing because a named pipe has no representation in the file system that
 // needs to be removed.
 func removeSocket(listener net.Listener) error {
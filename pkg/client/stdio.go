@@ -7,46 +7,72 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
-// StdOutput sends everything written to its Stdout and Stderr to its
+// StdOutput sends everything written to its Stdout, Stderr, and Diagnostic to its
 // ResultChannel. Things written to Stdout will have an error category
-// of errcat.OK whereas Stderr with use errcat.NoDaemonLogs.
+// of errcat.OK whereas Stderr and Diagnostic will use errcat.NoDaemonLogs.
 type StdOutput interface {
 	Stdout() io.Writer
 	Stderr() io.Writer
+
+	// Diagnostic returns a writer for diagnostics, such as progress updates, that
+	// the client may show on its stderr or suppress.
+	Diagnostic() io.Writer
+
 	ResultChannel() <-chan *connector.StreamResult
 	Finish(error)
+
+	// Prompt sends the given prompt to the ResultChannel, ordered with what's
+	// written to Stdout and Stderr.
+	Prompt(*connector.Prompt) error
+
+	// Progress sends the given progress to the ResultChannel, ordered with what's
+	// written to Stdout and Stderr.
+	Progress(*connector.Progress) error
+
+	// Started tells the client that the command has started and reads its stdin.
+	Started() error
+
+	// Queued tells the client that the command waits for a slot before it can start.
+	Queued() error
 }
 
 type dispatchToCh struct {
-	out    chan<- *connector.StreamResult
-	errCat connector.Result_ErrorCategory
+	out     chan<- *connector.StreamResult
+	errCat  connector.Result_ErrorCategory
+	channel connector.Result_Channel
 }
 
 func (d dispatchToCh) Write(p []byte) (n int, err error) {
 	n = len(p)
 	if n > 0 {
-		defer func() {
-			// Don't bail out when writing on a closed stream
-			if r := recover(); r != nil {
-				if re, ok := r.(error); ok && re.Error() == "send on closed channel" {
-					err = io.ErrClosedPipe
-				} else {
-					panic(r)
-				}
-			}
-		}()
-
 		// Need a private copy because the sender might reuse p in subsequent calls.
 		cp := make([]byte, len(p))
 		copy(cp, p)
-		d.out <- &connector.StreamResult{
+		err = send(d.out, &connector.StreamResult{
 			Data: &connector.Result{
 				Data:          cp,
 				ErrorCategory: d.errCat,
+				Channel:       d.channel,
 			},
-		}
+		})
 	}
-	return n, nil
+	return n, err
+}
+
+// send sends r to out, and returns io.ErrClosedPipe if out is closed.
+func send(out chan<- *connector.StreamResult, r *connector.StreamResult) (err error) {
+	defer func() {
+		// Don't bail out when writing on a closed stream
+		if r := recover(); r != nil {
+			if re, ok := r.(error); ok && re.Error() == "send on closed channel" {
+				err = io.ErrClosedPipe
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	out <- r
+	return nil
 }
 
 type stdioHandler chan *connector.StreamResult
@@ -65,18 +91,44 @@ func (h stdioHandler) Finish(err error) {
 
 func (h stdioHandler) Stdout() io.Writer {
 	return dispatchToCh{
-		out:    h,
-		errCat: 0,
+		out:     h,
+		errCat:  0,
+		channel: connector.Result_CHANNEL_STDOUT,
 	}
 }
 
 func (h stdioHandler) Stderr() io.Writer {
 	return dispatchToCh{
-		out:    h,
-		errCat: connector.Result_NO_DAEMON_LOGS,
+		out:     h,
+		errCat:  connector.Result_NO_DAEMON_LOGS,
+		channel: connector.Result_CHANNEL_STDERR,
 	}
 }
 
+func (h stdioHandler) Diagnostic() io.Writer {
+	return dispatchToCh{
+		out:     h,
+		errCat:  connector.Result_NO_DAEMON_LOGS,
+		channel: connector.Result_CHANNEL_DIAGNOSTIC,
+	}
+}
+
+func (h stdioHandler) Prompt(p *connector.Prompt) error {
+	return send(h, &connector.StreamResult{Prompt: p})
+}
+
+func (h stdioHandler) Progress(p *connector.Progress) error {
+	return send(h, &connector.StreamResult{Progress: p})
+}
+
+func (h stdioHandler) Started() error {
+	return send(h, &connector.StreamResult{Started: true})
+}
+
+func (h stdioHandler) Queued() error {
+	return send(h, &connector.StreamResult{Queued: true})
+}
+
 func (h stdioHandler) ResultChannel() <-chan *connector.StreamResult {
 	return h
 }
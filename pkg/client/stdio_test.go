@@ -5,7 +5,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 )
 
 func TestClosedChannel(t *testing.T) {
@@ -19,3 +22,30 @@ func TestClosedChannel(t *testing.T) {
 	_, err := so.Stdout().Write([]byte("boom"))
 	require.Error(t, err, io.ErrClosedPipe)
 }
+
+func TestStdOutput_channels(t *testing.T) {
+	so := NewStdOutput()
+	results := make(chan *connector.StreamResult, 3)
+	go func() {
+		for r := range so.ResultChannel() {
+			results <- r
+		}
+		close(results)
+	}()
+	_, _ = so.Stdout().Write([]byte("out"))
+	_, _ = so.Stderr().Write([]byte("err"))
+	_, _ = so.Diagnostic().Write([]byte("progress"))
+
+	r := <-results
+	assert.Equal(t, connector.Result_CHANNEL_STDOUT, r.Data.Channel)
+	assert.Equal(t, connector.Result_UNSPECIFIED, r.Data.ErrorCategory)
+
+	// The error category makes clients that don't know the channels write to stderr
+	r = <-results
+	assert.Equal(t, connector.Result_CHANNEL_STDERR, r.Data.Channel)
+	assert.Equal(t, connector.Result_NO_DAEMON_LOGS, r.Data.ErrorCategory)
+	r = <-results
+	assert.Equal(t, connector.Result_CHANNEL_DIAGNOSTIC, r.Data.Channel)
+	assert.Equal(t, connector.Result_NO_DAEMON_LOGS, r.Data.ErrorCategory)
+	so.Finish(nil)
+}
@@ -0,0 +1,108 @@
+package userd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/user"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// redactedArg replaces the arguments that are redacted in the audit log.
+const redactedArg = "***"
+
+// auditEntry is the JSON line that is written to the audit log for each command that RunCommand executes.
+type auditEntry struct {
+	ID        string    `json:"id,omitempty"`
+	User      string    `json:"user,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Args      []string  `json:"args"`
+	Cwd       string    `json:"cwd,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries to the file that the Daemons.UserDaemonAuditLog setting names. The file is
+// opened for each entry, so that changes to the setting take effect without a restart, and so that the
+// file can be rotated.
+type auditLog struct {
+	sync.Mutex
+}
+
+// write appends the given entry to the audit log, after redacting its arguments using the
+// Daemons.UserDaemonAuditRedact setting. Nothing is written unless an audit log is configured.
+func (al *auditLog) write(ctx context.Context, e *auditEntry) {
+	cfg := client.GetConfig(ctx)
+	if cfg == nil || cfg.Daemons.UserDaemonAuditLog == "" {
+		return
+	}
+	e.Args = redactArgs(ctx, e.Args, cfg.Daemons.UserDaemonAuditRedact)
+	if e.User == "" {
+		if u, err := user.Current(); err == nil {
+			e.User = u.Username
+		}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to encode audit entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	al.Lock()
+	defer al.Unlock()
+	f, err := os.OpenFile(cfg.Daemons.UserDaemonAuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		dlog.Errorf(ctx, "unable to write audit log: %v", err)
+	}
+}
+
+// redactArgs returns a copy of args where each argument that matches one of the given regular expressions
+// is replaced by redactedArg. Patterns that don't compile are logged and ignored.
+func redactArgs(ctx context.Context, args, patterns []string) []string {
+	if len(patterns) == 0 {
+		return args
+	}
+	rxs := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		rx, err := regexp.Compile(p)
+		if err != nil {
+			dlog.Errorf(ctx, "invalid audit redact pattern %q: %v", p, err)
+			continue
+		}
+		rxs = append(rxs, rx)
+	}
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		for _, rx := range rxs {
+			if rx.MatchString(arg) {
+				redacted[i] = redactedArg
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// auditSessionID returns the id of the current session, or an empty string when there is none.
+func (s *Service) auditSessionID() string {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	if s.session == nil {
+		return ""
+	}
+	return s.session.SessionInfo().GetSessionId()
+}
@@ -0,0 +1,104 @@
+package userd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func readAuditEntries(t *testing.T, file string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var entries []auditEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e auditEntry
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	require.NoError(t, sc.Err())
+	return entries
+}
+
+func TestService_RunCommand_audit(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	cfg := client.GetDefaultConfig()
+	cfg.Daemons.UserDaemonAuditLog = auditFile
+	cfg.Daemons.UserDaemonAuditRedact = []string{"^--token="}
+	ctx := client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+
+	s := &Service{getCommands: testCommands(
+		&cobra.Command{
+			Use:                "login",
+			DisableFlagParsing: true,
+			RunE:               func(*cobra.Command, []string) error { return nil },
+		},
+		&cobra.Command{
+			Use:  "fail",
+			RunE: func(*cobra.Command, []string) error { return errors.New("boom") },
+		},
+	)}
+	cwd := t.TempDir()
+	run := func(args ...string) {
+		stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+			OsArgs: args,
+			Cwd:    cwd,
+		}}})
+		require.NoError(t, s.RunCommand(stream))
+	}
+	run("login", "--token=secret", "--name", "me")
+	run("fail")
+
+	entries := readAuditEntries(t, auditFile)
+	require.Len(t, entries, 2)
+
+	e := entries[0]
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, []string{"login", redactedArg, "--name", "me"}, e.Args)
+	assert.Equal(t, cwd, e.Cwd)
+	assert.Equal(t, 0, e.ExitCode)
+	assert.Empty(t, e.Error)
+	assert.False(t, e.StartTime.IsZero())
+	assert.False(t, e.EndTime.Before(e.StartTime))
+
+	e = entries[1]
+	assert.Equal(t, []string{"fail"}, e.Args)
+	assert.Equal(t, 1, e.ExitCode)
+	assert.Equal(t, "boom", e.Error)
+}
+
+func TestService_RunCommand_noAudit(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use:  "ok",
+		RunE: func(*cobra.Command, []string) error { return nil },
+	})}
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"ok"},
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+	assert.True(t, stream.nextResult(t).Final)
+}
+
+func Test_redactArgs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	args := []string{"connect", "--password=hunter2", "API_KEY=abc", "--namespace", "default"}
+	assert.Equal(t, args, redactArgs(ctx, args, nil))
+	assert.Equal(t,
+		[]string{"connect", redactedArg, redactedArg, "--namespace", "default"},
+		redactArgs(ctx, args, []string{"(?i)password", "[(", "^[A-Z_]+KEY="}))
+	assert.Equal(t, "--password=hunter2", args[1], "the args must not be modified")
+}
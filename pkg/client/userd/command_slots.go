@@ -0,0 +1,62 @@
+package userd
+
+import (
+	"context"
+	"sync"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// commandSlots limits the number of commands that RunCommand runs at the same time, see
+// Grpc.MaxConcurrentCommands. The limit is given on each acquire, so that a reloaded config takes effect
+// without a restart.
+type commandSlots struct {
+	sync.Mutex
+	running int
+
+	// freed is closed, and replaced, when a slot is released
+	freed chan struct{}
+}
+
+// acquire takes a slot, unless max slots are taken already. It then either waits for a slot to be released,
+// or returns an errcat.User error, depending on wait. The queued function is called once before the wait
+// begins. A positive max is required for the limit to apply. The returned function releases the slot.
+func (cs *commandSlots) acquire(ctx context.Context, max int, wait bool, queued func()) (func(), error) {
+	for {
+		cs.Lock()
+		if max <= 0 || cs.running < max {
+			cs.running++
+			cs.Unlock()
+			return cs.release, nil
+		}
+		if !wait {
+			cs.Unlock()
+			return nil, errcat.User.Newf("the user daemon is busy running %d commands; retry later, or use --wait to wait for an available slot", max)
+		}
+		if cs.freed == nil {
+			cs.freed = make(chan struct{})
+		}
+		freed := cs.freed
+		cs.Unlock()
+
+		if queued != nil {
+			queued()
+			queued = nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+func (cs *commandSlots) release() {
+	cs.Lock()
+	cs.running--
+	if cs.freed != nil {
+		close(cs.freed)
+		cs.freed = nil
+	}
+	cs.Unlock()
+}
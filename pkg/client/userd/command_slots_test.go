@@ -0,0 +1,128 @@
+package userd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_commandSlots(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var cs commandSlots
+	noQueue := func() { t.Fatal("queued although a slot was available") }
+
+	// There's no limit unless max is positive
+	for i := 0; i < 3; i++ {
+		_, err := cs.acquire(ctx, 0, false, noQueue)
+		require.NoError(t, err)
+	}
+
+	cs = commandSlots{}
+	release, err := cs.acquire(ctx, 1, false, noQueue)
+	require.NoError(t, err)
+
+	// A busy user daemon fails fast unless asked to wait
+	_, err = cs.acquire(ctx, 1, false, noQueue)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	// The waiting is reported once, and ends when the slot is released
+	queued := 0
+	acquired := make(chan func(), 1)
+	go func() {
+		r, err := cs.acquire(ctx, 1, true, func() { queued++ })
+		assert.NoError(t, err)
+		acquired <- r
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a slot was acquired although none was available")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case r := <-acquired:
+		r()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released slot wasn't acquired")
+	}
+	assert.Equal(t, 1, queued)
+
+	// A wait ends when the context is cancelled
+	release, err = cs.acquire(ctx, 1, false, noQueue)
+	require.NoError(t, err)
+	defer release()
+	cCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = cs.acquire(cCtx, 1, true, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestService_RunCommand_queued(t *testing.T) {
+	cfg := client.GetDefaultConfig()
+	cfg.Grpc.MaxConcurrentCommands = 1
+	ctx := client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	s := &Service{getCommands: testCommands(
+		&cobra.Command{
+			Use: "block",
+			RunE: func(*cobra.Command, []string) error {
+				close(started)
+				<-unblock
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use: "hello",
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				fmt.Fprint(cmd.OutOrStdout(), "hello")
+				return nil
+			},
+		},
+	)}
+	run := func(wait bool, args ...string) (*fakeServerStream, <-chan error) {
+		stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+			OsArgs: args,
+			Wait:   wait,
+		}}})
+		done := make(chan error, 1)
+		go func() { done <- s.RunCommand(stream) }()
+		return stream, done
+	}
+
+	_, blockDone := run(false, "block")
+	<-started
+
+	// The busy user daemon rejects a command that can't wait
+	stream, done := run(false, "hello")
+	require.NoError(t, <-done)
+	sr := stream.nextResult(t)
+	require.True(t, sr.Final)
+	assert.ErrorContains(t, errcat.FromResult(sr.Data), "busy")
+
+	// A command that waits is queued, and runs once the slot is released
+	stream, done = run(true, "hello")
+	assert.True(t, stream.nextResult(t).Queued)
+	select {
+	case <-done:
+		t.Fatal("the queued command ran although no slot was available")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(unblock)
+	require.NoError(t, <-blockDone)
+	require.NoError(t, <-done)
+	sr = stream.nextResult(t)
+	assert.False(t, sr.Queued)
+	assert.Equal(t, "hello", string(sr.Data.GetData()))
+}
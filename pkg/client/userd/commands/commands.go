@@ -3,11 +3,15 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
 
+	"github.com/blang/semver"
 	"github.com/spf13/cobra"
 
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
 const (
@@ -144,3 +148,120 @@ func GetCwd(ctx context.Context) string {
 	}
 	return ""
 }
+
+type envKey struct{}
+
+// WithEnv returns a context with the environment variables that the client forwarded to the command.
+func WithEnv(ctx context.Context, env map[string]string) context.Context {
+	return context.WithValue(ctx, envKey{}, env)
+}
+
+// GetEnv returns the environment variables that the client forwarded to the command.
+func GetEnv(ctx context.Context) map[string]string {
+	if env, ok := ctx.Value(envKey{}).(map[string]string); ok {
+		return env
+	}
+	return nil
+}
+
+// ClientInfo describes the client that runs a command, so that the command can adapt to it. Its zero value
+// describes a client that is too old to tell.
+type ClientInfo struct {
+	// Version is the version of the client, or nil when it's unknown.
+	Version *semver.Version
+
+	// Capabilities is a bitwise OR of the rpc.RunCommandRequest_Command_Capability values of the client.
+	Capabilities uint64
+}
+
+// NewClientInfo returns the ClientInfo of a client that sent the given version and capabilities. The version
+// is ignored unless it's valid.
+func NewClientInfo(version string, capabilities uint64) ClientInfo {
+	ci := ClientInfo{Capabilities: capabilities}
+	if v, err := semver.ParseTolerant(version); err == nil {
+		ci.Version = &v
+	}
+	return ci
+}
+
+// Can returns true if the client has the given capability.
+func (ci ClientInfo) Can(c rpc.RunCommandRequest_Command_Capability) bool {
+	return c != rpc.RunCommandRequest_Command_CAPABILITY_NONE && ci.Capabilities&uint64(c) == uint64(c)
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a context with the ClientInfo of the client that runs the command.
+func WithClientInfo(ctx context.Context, ci ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, ci)
+}
+
+// GetClientInfo returns the ClientInfo of the client that runs the command. Its zero value is returned when
+// the context has none.
+func GetClientInfo(ctx context.Context) ClientInfo {
+	ci, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return ci
+}
+
+// Prompter asks the user of the client that runs a command for input.
+type Prompter interface {
+	// Prompt writes text to the client's stdout and returns the line, or the single character, that the user
+	// answers with. The line doesn't include the line ending. What was read is returned together with io.EOF
+	// when the client's stdin ends before the answer is complete.
+	Prompt(ctx context.Context, text string, singleChar bool) (string, error)
+}
+
+type prompterKey struct{}
+
+// WithPrompter returns a context with the Prompter of the client that runs the command.
+func WithPrompter(ctx context.Context, p Prompter) context.Context {
+	return context.WithValue(ctx, prompterKey{}, p)
+}
+
+// Prompt asks the user of the client that runs the command for input, see Prompter. An errcat.User error is
+// returned when the client doesn't answer prompts, e.g. because the command is detached.
+func Prompt(ctx context.Context, text string, singleChar bool) (string, error) {
+	p, ok := ctx.Value(prompterKey{}).(Prompter)
+	if !ok {
+		return "", errcat.User.New("the client doesn't answer prompts")
+	}
+	return p.Prompt(ctx, text, singleChar)
+}
+
+// ProgressReporter shows the progress of a command to the user of the client that runs it.
+type ProgressReporter interface {
+	Progress(*rpc.Progress) error
+}
+
+type progressReporterKey struct{}
+
+// WithProgressReporter returns a context with the ProgressReporter of the client that runs the command.
+func WithProgressReporter(ctx context.Context, r ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterKey{}, r)
+}
+
+// ReportProgress reports the progress of the command, see ProgressReporter. The progress is written as a line
+// to the Diagnostic writer when the client doesn't show progress, e.g. because the command is detached.
+func ReportProgress(ctx context.Context, p *rpc.Progress) error {
+	if r, ok := ctx.Value(progressReporterKey{}).(ProgressReporter); ok {
+		return r.Progress(p)
+	}
+	_, err := fmt.Fprintln(Diagnostic(ctx), client.ProgressText(p))
+	return err
+}
+
+type diagnosticKey struct{}
+
+// WithDiagnostic returns a context with the writer for the diagnostics of the command, see Diagnostic.
+func WithDiagnostic(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, diagnosticKey{}, w)
+}
+
+// Diagnostic returns the writer for diagnostics, such as progress updates, that the client may show on its
+// stderr or suppress. What's written is discarded when the context has no such writer.
+func Diagnostic(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(diagnosticKey{}).(io.Writer); ok {
+		return w
+	}
+	return io.Discard
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/trafficmgr"
 	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/k8sapi"
@@ -55,6 +56,8 @@ func (c *traceCommand) cobraCommand(ctx context.Context) *cobra.Command {
 		},
 		Annotations: map[string]string{
 			CommandRequiresSession: "true",
+			// Gathering the traces again overwrites the output file with the same traces
+			cliutil.CommandIdempotent: "true",
 		},
 		SilenceUsage:  true,
 		SilenceErrors: true,
@@ -334,7 +334,7 @@ func (c *interceptCommand) intercept(ctx context.Context, args interceptArgs) er
 			}
 			cmd, err = is.startInDocker(ctx, envFile, args.cmdline)
 		} else {
-			cmd, err = proc.Start(ctx, is.env, c.command, args.cmdline[0], args.cmdline[1:]...)
+			cmd, err = proc.Start(ctx, is.commandEnv(ctx), c.command, args.cmdline[0], args.cmdline[1:]...)
 		}
 		if err != nil {
 			dlog.Errorf(ctx, "error interceptor starting process: %v", err)
@@ -1014,6 +1014,23 @@ func (is *interceptState) writeEnvFile() error {
 	return is.writeEnvToFileAndClose(file)
 }
 
+// commandEnv returns the environment of the intercepting command, which is the environment that the
+// client forwarded, overridden by the environment of the intercepted container.
+func (is *interceptState) commandEnv(ctx context.Context) map[string]string {
+	fwd := GetEnv(ctx)
+	if len(fwd) == 0 {
+		return is.env
+	}
+	env := make(map[string]string, len(fwd)+len(is.env))
+	for k, v := range fwd {
+		env[k] = v
+	}
+	for k, v := range is.env {
+		env[k] = v
+	}
+	return env
+}
+
 func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
 	defer file.Close()
 	w := bufio.NewWriter(file)
@@ -0,0 +1,235 @@
+package userd
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// maxDetachedOutput is the number of bytes of output that is kept for a detached command while no client is
+// attached to it. The oldest output is dropped when the command produces more.
+const maxDetachedOutput = 64 * 1024
+
+// detachedCommands keeps track of the detached commands, from when they're detached until a client has
+// received their final result.
+type detachedCommands struct {
+	sync.Mutex
+	streams map[string]*detachedStream
+}
+
+func (dc *detachedCommands) add(id string, ds *detachedStream) {
+	dc.Lock()
+	if dc.streams == nil {
+		dc.streams = make(map[string]*detachedStream)
+	}
+	dc.streams[id] = ds
+	dc.Unlock()
+}
+
+func (dc *detachedCommands) get(id string) *detachedStream {
+	dc.Lock()
+	defer dc.Unlock()
+	return dc.streams[id]
+}
+
+func (dc *detachedCommands) remove(id string) {
+	dc.Lock()
+	delete(dc.streams, id)
+	dc.Unlock()
+}
+
+// detachedStream is the stream of a command that keeps running when the client is gone. Everything that
+// the command sends is forwarded to the client until the command is detached. After that, it's forwarded
+// to the client that is attached to the command, if any, and kept until a client attaches otherwise.
+// The command's stdin is closed.
+type detachedStream struct {
+	rpc.Connector_RunCommandServer
+	ctx      context.Context
+	commands *detachedCommands
+	mu       sync.Mutex
+	id       string
+	client   rpc.Connector_RunCommandServer
+	eofSent  bool
+	detached chan struct{}
+	ended    chan struct{}
+
+	// attached is closed and replaced each time a client attaches
+	attached chan struct{}
+
+	// output is what the command has sent while no client was attached, and outputSize is the
+	// number of bytes in it
+	output     []*rpc.StreamResult
+	outputSize int
+
+	// final is the final result of a command that ended while no client was attached
+	final *rpc.StreamResult
+}
+
+func newDetachedStream(ctx context.Context, client rpc.Connector_RunCommandServer, commands *detachedCommands) *detachedStream {
+	return &detachedStream{
+		Connector_RunCommandServer: client,
+		ctx:                        ctx,
+		commands:                   commands,
+		client:                     client,
+		detached:                   make(chan struct{}),
+		ended:                      make(chan struct{}),
+		attached:                   make(chan struct{}),
+	}
+}
+
+func (ds *detachedStream) Context() context.Context {
+	return ds.ctx
+}
+
+func (ds *detachedStream) Send(sr *rpc.StreamResult) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	isDetached := ds.id != ""
+	if ds.client != nil {
+		err := ds.client.Send(sr)
+		if !isDetached {
+			return err
+		}
+		if err == nil {
+			if sr.Final {
+				ds.commands.remove(ds.id)
+			}
+			return nil
+		}
+		// The attached client is gone. The command keeps running.
+		dlog.Debugf(ds.ctx, "Detached command %s lost its client: %v", ds.id, err)
+		ds.client = nil
+	}
+	switch {
+	case sr.Final:
+		if sr.Data != nil && len(sr.Data.Data) > 0 {
+			dlog.Infof(ds.ctx, "Detached command %s ended: %s", ds.id, sr.Data.Data)
+		}
+		ds.final = sr
+	case sr.Data != nil:
+		ds.keepOutput(sr)
+	}
+	return nil
+}
+
+// keepOutput adds the given output to the output that is kept for the next client that attaches, and drops
+// the oldest output when the total exceeds maxDetachedOutput.
+func (ds *detachedStream) keepOutput(sr *rpc.StreamResult) {
+	ds.output = append(ds.output, sr)
+	ds.outputSize += len(sr.Data.Data)
+	for ds.outputSize > maxDetachedOutput {
+		excess := ds.outputSize - maxDetachedOutput
+		first := ds.output[0].Data
+		if n := len(first.Data); n > excess {
+			ds.output[0] = &rpc.StreamResult{Data: &rpc.Result{Data: first.Data[excess:], ErrorCategory: first.ErrorCategory, Channel: first.Channel}}
+			ds.outputSize -= excess
+		} else {
+			ds.output = ds.output[1:]
+			ds.outputSize -= n
+		}
+	}
+}
+
+// Recv returns a request that closes stdin, and then the requests other than stdin that the attached
+// clients send, until the command has ended.
+func (ds *detachedStream) Recv() (*rpc.RunCommandRequest, error) {
+	ds.mu.Lock()
+	eofSent := ds.eofSent
+	ds.eofSent = true
+	ds.mu.Unlock()
+	if !eofSent {
+		return &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_StdinClosed{StdinClosed: true}}, nil
+	}
+	for {
+		ds.mu.Lock()
+		client, attached := ds.client, ds.attached
+		if ds.id == "" {
+			// The client that started the command sends nothing more
+			client = nil
+		}
+		ds.mu.Unlock()
+		if client == nil {
+			select {
+			case <-attached:
+				continue
+			case <-ds.ended:
+				return nil, io.EOF
+			}
+		}
+		rq, err := client.Recv()
+		if err != nil {
+			// The client is done sending, but may still be receiving
+			select {
+			case <-client.Context().Done():
+				ds.release(client)
+				continue
+			case <-ds.ended:
+				return nil, io.EOF
+			}
+		}
+		if rq.GetData() != nil || rq.GetStdinClosed() {
+			// Stdin was closed when the command was detached
+			continue
+		}
+		return rq, nil
+	}
+}
+
+// detach sends the id of the command to the client, and stops forwarding to it.
+func (ds *detachedStream) detach(id string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.commands.add(id, ds)
+	if err := ds.client.Send(&rpc.StreamResult{DetachedId: id}); err != nil {
+		dlog.Errorf(ds.ctx, "failed to send the id of detached command %s: %v", id, err)
+	}
+	ds.id = id
+	ds.client = nil
+	close(ds.detached)
+}
+
+// attach sends the output that has been kept to the given client, and then makes it the client that everything
+// that the command sends is forwarded to. The client isn't attached if the command has ended. Its final result
+// is sent instead, and ok is false.
+func (ds *detachedStream) attach(client rpc.Connector_RunCommandServer) (ok bool, err error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.client != nil {
+		return false, errcat.User.Newf("another client is attached to command %s", ds.id)
+	}
+	for _, sr := range ds.output {
+		if err = client.Send(sr); err != nil {
+			return false, err
+		}
+	}
+	ds.output, ds.outputSize = nil, 0
+	if ds.final != nil {
+		if err = client.Send(ds.final); err != nil {
+			return false, err
+		}
+		ds.commands.remove(ds.id)
+		return false, nil
+	}
+	ds.client = client
+	close(ds.attached)
+	ds.attached = make(chan struct{})
+	return true, nil
+}
+
+// release stops forwarding to the given client, unless another client has attached since.
+func (ds *detachedStream) release(client rpc.Connector_RunCommandServer) {
+	ds.mu.Lock()
+	if ds.client == client {
+		ds.client = nil
+	}
+	ds.mu.Unlock()
+}
+
+// end is called when the command has ended.
+func (ds *detachedStream) end() {
+	close(ds.ended)
+}
@@ -0,0 +1,246 @@
+package userd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// fakeServerStream is a rpc.Connector_RunCommandServer that receives the given requests and records what's sent.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs chan *rpc.RunCommandRequest
+	sent chan *rpc.StreamResult
+}
+
+func newFakeServerStream(ctx context.Context, first *rpc.RunCommandRequest) *fakeServerStream {
+	f := &fakeServerStream{ctx: ctx, reqs: make(chan *rpc.RunCommandRequest, 1), sent: make(chan *rpc.StreamResult, 100)}
+	f.reqs <- first
+	return f
+}
+
+// nextResult returns the next result that was sent on the stream.
+func (f *fakeServerStream) nextResult(t *testing.T) *rpc.StreamResult {
+	t.Helper()
+	select {
+	case sr := <-f.sent:
+		return sr
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result was sent")
+		return nil
+	}
+}
+
+func testCommands(cmds ...*cobra.Command) CommandFactory {
+	return func(context.Context) cliutil.CommandGroups {
+		return cliutil.CommandGroups{"Test": cmds}
+	}
+}
+
+// startDetached starts the given command detached and returns its id.
+func startDetached(ctx context.Context, t *testing.T, s *Service, args ...string) string {
+	t.Helper()
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: args,
+		Detach: true,
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+	id := stream.nextResult(t).DetachedId
+	require.NotEmpty(t, id)
+	return id
+}
+
+func attachRequest(id string) *rpc.RunCommandRequest {
+	return &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Attach{Attach: id}}
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeServerStream) Send(sr *rpc.StreamResult) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	f.sent <- sr
+	return nil
+}
+
+func (f *fakeServerStream) Recv() (*rpc.RunCommandRequest, error) {
+	select {
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	case rq := <-f.reqs:
+		return rq, nil
+	}
+}
+
+func TestService_RunCommand_detach(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	started := make(chan struct{})
+	ended := make(chan struct{})
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "wait",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			defer close(ended)
+			close(started)
+			<-cmd.Context().Done()
+			return nil
+		},
+	})}
+
+	clientCtx, disconnect := context.WithCancel(ctx)
+	stream := newFakeServerStream(clientCtx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"wait"},
+		Detach: true,
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+
+	// The call returns with the id of the command as its only result
+	var id string
+	select {
+	case sr := <-stream.sent:
+		id = sr.DetachedId
+	default:
+		t.Fatal("no id was sent")
+	}
+	require.NotEmpty(t, id)
+	assert.Empty(t, stream.sent)
+
+	// The command keeps running after the client is gone
+	disconnect()
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-ended:
+		t.Fatal("the command ended when the client disconnected")
+	default:
+	}
+	cmds := s.runningCommands.list()
+	require.Len(t, cmds, 1)
+	assert.Equal(t, id, cmds[0].Id)
+
+	// It can be cancelled using its id
+	require.True(t, s.runningCommands.cancel(id, time.Second))
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not cancelled")
+	}
+	require.Eventually(t, func() bool { return len(s.runningCommands.list()) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestService_RunCommand_attachAfterOutput(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	release := make(chan struct{})
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "talk",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			fmt.Fprint(cmd.OutOrStdout(), "hello")
+			<-release
+			fmt.Fprint(cmd.OutOrStdout(), "bye")
+			return nil
+		},
+	})}
+	id := startDetached(ctx, t, s, "talk")
+	ds := s.detachedCommands.get(id)
+	require.NotNil(t, ds)
+	require.Eventually(t, func() bool {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		return ds.outputSize == len("hello")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	clientCtx, disconnect := context.WithCancel(ctx)
+	defer disconnect()
+	stream := newFakeServerStream(clientCtx, attachRequest(id))
+	attached := make(chan error, 1)
+	go func() {
+		attached <- s.RunCommand(stream)
+	}()
+
+	// The output that was produced while detached comes first, then the output that follows
+	assert.Equal(t, "hello", string(stream.nextResult(t).Data.GetData()))
+	close(release)
+	assert.Equal(t, "bye", string(stream.nextResult(t).Data.GetData()))
+	sr := stream.nextResult(t)
+	assert.True(t, sr.Final)
+	assert.NoError(t, errcat.FromResult(sr.Data))
+	select {
+	case err := <-attached:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunCommand didn't return when the attached command ended")
+	}
+	assert.Nil(t, s.detachedCommands.get(id))
+}
+
+func TestService_RunCommand_attachAfterExit(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "fail",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			fmt.Fprint(cmd.OutOrStdout(), "done")
+			return errcat.User.New("it failed")
+		},
+	})}
+	id := startDetached(ctx, t, s, "fail")
+	ds := s.detachedCommands.get(id)
+	require.NotNil(t, ds)
+	select {
+	case <-ds.ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command didn't end")
+	}
+
+	// The captured result is sent, and the call returns right away
+	stream := newFakeServerStream(ctx, attachRequest(id))
+	require.NoError(t, s.RunCommand(stream))
+	assert.Equal(t, "done", string(stream.nextResult(t).Data.GetData()))
+	sr := stream.nextResult(t)
+	require.True(t, sr.Final)
+	err := errcat.FromResult(sr.Data)
+	require.Error(t, err)
+	assert.Equal(t, "it failed", err.Error())
+	assert.Nil(t, s.detachedCommands.get(id))
+
+	// The result is only sent once
+	stream = newFakeServerStream(ctx, attachRequest(id))
+	require.NoError(t, s.RunCommand(stream))
+	sr = stream.nextResult(t)
+	require.True(t, sr.Final)
+	assert.Equal(t, errcat.User, errcat.GetCategory(errcat.FromResult(sr.Data)))
+}
+
+func Test_detachedStream_keepOutput(t *testing.T) {
+	ds := &detachedStream{}
+	half := bytes.Repeat([]byte("a"), maxDetachedOutput/2)
+	ds.keepOutput(&rpc.StreamResult{Data: &rpc.Result{Data: []byte("first")}})
+	ds.keepOutput(&rpc.StreamResult{Data: &rpc.Result{Data: half}})
+	ds.keepOutput(&rpc.StreamResult{Data: &rpc.Result{Data: half[2:], ErrorCategory: rpc.Result_NO_DAEMON_LOGS}})
+	assert.Equal(t, maxDetachedOutput, ds.outputSize)
+	require.Len(t, ds.output, 3)
+	assert.Equal(t, "st", string(ds.output[0].Data.Data))
+
+	// The oldest output is dropped
+	ds.keepOutput(&rpc.StreamResult{Data: &rpc.Result{Data: []byte("last")}})
+	assert.Equal(t, maxDetachedOutput, ds.outputSize)
+	require.Len(t, ds.output, 3)
+	assert.Len(t, ds.output[0].Data.Data, maxDetachedOutput/2-2)
+	assert.Equal(t, rpc.Result_UNSPECIFIED, ds.output[0].Data.ErrorCategory)
+	assert.Equal(t, rpc.Result_NO_DAEMON_LOGS, ds.output[1].Data.ErrorCategory)
+	assert.Equal(t, "last", string(ds.output[2].Data.Data))
+}
@@ -6,11 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
@@ -145,7 +147,7 @@ func (s *Service) Connect(ctx context.Context, cr *rpc.ConnectRequest) (result *
 
 func (s *Service) Disconnect(c context.Context, _ *empty.Empty) (*empty.Empty, error) {
 	s.logCall(c, "Disconnect", func(c context.Context) {
-		s.cancelSession()
+		s.cancelSession(c)
 	})
 	return &empty.Empty{}, nil
 }
@@ -497,25 +499,98 @@ func (s *Service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 
 func (s *Service) Quit(ctx context.Context, ex *empty.Empty) (*empty.Empty, error) {
 	s.logCall(ctx, "Quit", func(c context.Context) {
-		s.sessionLock.RLock()
-		defer s.sessionLock.RUnlock()
-		s.cancelSessionReadLocked()
-		s.quit()
-		_ = s.withRootDaemon(ctx, func(ctx context.Context, rd daemon.DaemonClient) error {
-			_, err := rd.Quit(ctx, ex)
-			return err
-		})
+		s.quitDaemons(ctx)
 	})
 	return ex, nil
 }
 
-func (s *Service) ListCommands(ctx context.Context, _ *empty.Empty) (groups *rpc.CommandGroups, err error) {
+func (s *Service) QuitGraceful(ctx context.Context, req *rpc.QuitGracefulRequest) (result *rpc.QuitGracefulResponse, err error) {
+	s.logCall(ctx, "QuitGraceful", func(ctx context.Context) {
+		result = &rpc.QuitGracefulResponse{Running: s.runningCommands.waitUntilIdle(ctx, req.GetTimeout().AsDuration())}
+		if len(result.Running) > 0 {
+			dlog.Infof(ctx, "Not quitting, %d commands are still running", len(result.Running))
+			return
+		}
+		s.quitDaemons(ctx)
+	})
+	return
+}
+
+// quitDaemons ends the session and quits this daemon and the root daemon.
+func (s *Service) quitDaemons(ctx context.Context) {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	s.cancelSessionReadLocked()
+	s.quit()
+	_ = s.withRootDaemon(ctx, func(ctx context.Context, rd daemon.DaemonClient) error {
+		_, err := rd.Quit(ctx, &empty.Empty{})
+		return err
+	})
+}
+
+func (s *Service) ListCommands(ctx context.Context, req *rpc.ListCommandsRequest) (groups *rpc.CommandGroups, err error) {
 	s.logCall(ctx, "ListCommands", func(ctx context.Context) {
-		groups, err = cliutil.CommandsToRPC(s.getCommands(ctx)), nil
+		groups, err = cliutil.CommandsToRPC(s.getCommands(ctx).Select(req.GetGroups())), nil
 	})
 	return
 }
 
+func (s *Service) RunningCommands(ctx context.Context, _ *empty.Empty) (result *rpc.RunningCommandList, err error) {
+	s.logCall(ctx, "RunningCommands", func(ctx context.Context) {
+		result = &rpc.RunningCommandList{Commands: s.runningCommands.list()}
+	})
+	return
+}
+
+func (s *Service) CancelCommand(ctx context.Context, req *rpc.CancelCommandRequest) (result *empty.Empty, err error) {
+	s.logCall(ctx, "CancelCommand", func(ctx context.Context) {
+		if s.runningCommands.cancel(req.GetId(), cli.HardCancelGrace) {
+			result = &empty.Empty{}
+		} else {
+			err = status.Errorf(codes.NotFound, "no command with id %q is running", req.GetId())
+		}
+	})
+	return
+}
+
+// commandEnv returns the environment variables that the client forwarded to the command, together with the
+// client's locale variables that it didn't forward explicitly. Forwarded variables that the command doesn't
+// accept are dropped, see cliutil.AcceptsEnv, because clients that predate the check send them anyway.
+func commandEnv(ctx context.Context, cmd *cobra.Command, req *rpc.RunCommandRequest_Command) map[string]string {
+	env := make(map[string]string, len(req.GetLocale())+len(req.GetEnv()))
+	for k, v := range req.GetLocale() {
+		env[k] = v
+	}
+	for k, v := range req.GetEnv() {
+		if !cliutil.AcceptsEnv(cmd, k) {
+			dlog.Debugf(ctx, "dropping environment variable %s, because %q doesn't accept it", k, cmd.Name())
+			continue
+		}
+		env[k] = v
+	}
+	return env
+}
+
+// validateCwd returns an errcat.User error if the given working directory of a command doesn't exist or
+// isn't a directory. An empty cwd is valid.
+func validateCwd(cwd string) error {
+	if cwd == "" {
+		return nil
+	}
+	fi, err := os.Stat(cwd)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return errcat.User.Newf("the user daemon cannot use %s as the working directory: %v", cwd, err)
+	}
+	if !fi.IsDir() {
+		return errcat.User.Newf("the user daemon cannot use %s as the working directory: not a directory", cwd)
+	}
+	return nil
+}
+
 func needsPTY(cmd *cobra.Command) bool {
 	if runtime.GOOS == "windows" || cmd.Name() != "intercept" {
 		// intercept is the only known command that might need a PTY, and never on Windows
@@ -571,25 +646,100 @@ func needsPTY(cmd *cobra.Command) bool {
 	return false
 }
 
-func stdinPump(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, withPTY bool) (context.Context, io.Reader, error) {
+// stdinAcker counts the number of stdin bytes consumed by a command and notifies the
+// stdout/stderr pump so that it can acknowledge them to the client.
+type stdinAcker struct {
+	consumed uint64 // atomic
+	notify   chan struct{}
+}
+
+func newStdinAcker() *stdinAcker {
+	a := &stdinAcker{notify: make(chan struct{}, 1)}
+	// The initial acknowledgement tells the client that flow control is supported.
+	a.notify <- struct{}{}
+	return a
+}
+
+func (a *stdinAcker) result() *rpc.StreamResult {
+	return &rpc.StreamResult{StdinAck: &rpc.StdinAck{Consumed: atomic.LoadUint64(&a.consumed)}}
+}
+
+// ackingReader is an io.Reader that reports everything that is read to a stdinAcker.
+type ackingReader struct {
+	io.Reader
+	acker *stdinAcker
+}
+
+func (r ackingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.acker.consume(n)
+	return n, err
+}
+
+// consume records that n bytes of stdin have been consumed.
+func (a *stdinAcker) consume(n int) {
+	if a == nil || n <= 0 {
+		return
+	}
+	atomic.AddUint64(&a.consumed, uint64(n))
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// stdinPump forwards the stdin data received on the cmdStream to the command and returns the reader that the
+// command must use as its stdin. The data is written to the given PTY master, if any, in which case the returned
+// reader is its TTY. Data written to a PTY is considered consumed once the write succeeds, because the command
+// must read directly from the TTY to see that it's a terminal. The replies to prompts are delivered to the given
+// prompter, unless it's nil. Everything that the client sends resets the given idle timer, which cancels the
+// command when it expires. The returned function soft cancels the command.
+func stdinPump(
+	ctx context.Context,
+	cmdStream rpc.Connector_RunCommandServer,
+	ptm, tty *os.File,
+	acker *stdinAcker,
+	prompter *commandPrompter,
+	idle *idleTimer,
+) (context.Context, io.Reader, func()) {
 	var wr io.WriteCloser
 	var rd io.Reader
+	withPTY := ptm != nil
 	if withPTY {
-		var err error
-		if wr, rd, err = pty.Open(); err != nil {
-			return ctx, nil, err
-		}
+		wr = ptm
+		rd = tty
 	} else {
 		pi := ioutils.NewBytesPipe()
 		wr = pi
 		rd = pi
+		if acker != nil {
+			rd = ackingReader{Reader: rd, acker: acker}
+		}
 	}
 	ctx, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	softCancel := func() {
+		if ctx.Err() != nil {
+			return
+		}
+		dlog.Debug(ctx, "Soft cancel")
+		if withPTY {
+			// Write a CTRL-C to the PTY. This should trigger a graceful shutdown
+			if _, err := wr.Write([]byte{0x03}); err != nil {
+				dlog.Errorf(ctx, "failed to forward <CTRL>-C stdin: %v", err)
+			}
+		} else {
+			cancel()
+		}
+	}
+	idle.start(ctx, cancel)
 	go func() {
 		defer func() {
+			idle.stop()
 			cancel()
 			wr.Close()
+			prompter.close()
 		}()
+		stdinClosed := false
 		for ctx.Err() == nil {
 			cr, err := cmdStream.Recv()
 			if err != nil {
@@ -598,30 +748,87 @@ func stdinPump(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, wi
 				}
 				break
 			}
+			idle.reset()
 			if cr.GetSoftCancel() {
-				dlog.Debug(ctx, "Soft cancel")
-				if withPTY {
-					// Write a CTRL-C to the PTY. This should trigger a graceful shutdown
-					if _, err = wr.Write([]byte{0x03}); err != nil {
-						dlog.Errorf(ctx, "failed to forward <CTRL>-C stdin: %v", err)
+				softCancel()
+			}
+			if sig := cr.GetSignal(); sig != rpc.RunCommandRequest_SIGNAL_UNSPECIFIED {
+				dlog.Debugf(ctx, "Signal %s", sig)
+				if withPTY && sig == rpc.RunCommandRequest_SIGQUIT {
+					// Write a CTRL-\ to the PTY, which the terminal delivers as a SIGQUIT
+					if _, err = wr.Write([]byte{0x1c}); err != nil {
+						dlog.Errorf(ctx, "failed to forward <CTRL>-\\ stdin: %v", err)
 					}
 				} else {
 					cancel()
 				}
+				continue
+			}
+			if cr.GetKeepAlive() {
+				// Only sent to keep the stream alive
+				continue
 			}
-			if data := cr.GetData(); data != nil {
-				if _, err = wr.Write(data); err != nil {
+			if pr := cr.GetPromptReply(); pr != nil {
+				prompter.reply(pr)
+				continue
+			}
+			if cr.GetStdinClosed() {
+				dlog.Debug(ctx, "Stdin closed")
+				if withPTY {
+					// Write a CTRL-D to the PTY, which the terminal delivers as an end of file
+					if _, err = wr.Write([]byte{0x04}); err != nil {
+						dlog.Errorf(ctx, "failed to forward <CTRL>-D stdin: %v", err)
+					}
+				} else {
+					// The command reads the remaining data, and then an end of file
+					stdinClosed = true
+					_ = wr.Close()
+				}
+				continue
+			}
+			if ws := cr.GetWindowSize(); ws != nil {
+				if withPTY {
+					if err = pty.Setsize(ptm, &pty.Winsize{Rows: uint16(ws.Rows), Cols: uint16(ws.Cols)}); err != nil {
+						dlog.Errorf(ctx, "failed to set window size: %v", err)
+					}
+				}
+				continue
+			}
+			if data := cr.GetData(); data != nil && !stdinClosed {
+				n, err := wr.Write(data)
+				if withPTY {
+					acker.consume(n)
+				}
+				if err != nil {
 					dlog.Errorf(ctx, "failed to forward to stdin: %v", err)
 					break
 				}
 			}
 		}
 	}()
-	return ctx, rd, nil
+	return ctx, rd, softCancel
+}
+
+// ptyOutputPump copies everything that is written to the TTY of a PTY to the given writer. The returned
+// channel is closed when the TTY has been closed and all output has been copied.
+func ptyOutputPump(ctx context.Context, ptm *os.File, out io.Writer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Reading the PTY master fails with EIO once the TTY is closed, so only log other errors.
+		if _, err := io.Copy(out, ptm); err != nil && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+			dlog.Errorf(ctx, "failed to read PTY output: %v", err)
+		}
+	}()
+	return done
 }
 
-func stdoutAndStderrPump(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, ch <-chan *rpc.StreamResult, wg *sync.WaitGroup) {
+func stdoutAndStderrPump(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, ch <-chan *rpc.StreamResult, acker *stdinAcker, wg *sync.WaitGroup) {
 	defer wg.Done()
+	var ackCh <-chan struct{}
+	if acker != nil {
+		ackCh = acker.notify
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -634,6 +841,13 @@ func stdoutAndStderrPump(ctx context.Context, cmdStream rpc.Connector_RunCommand
 					return
 				}
 			}
+		case <-ackCh:
+			if err := cmdStream.Send(acker.result()); err != nil {
+				if ctx.Err() == nil {
+					dlog.Errorf(ctx, "Send of stdin acknowledgement failed: %v", err)
+				}
+				return
+			}
 		case sm, ok := <-ch:
 			if !ok {
 				return
@@ -648,13 +862,13 @@ func stdoutAndStderrPump(ctx context.Context, cmdStream rpc.Connector_RunCommand
 	}
 }
 
-func (s *Service) executeCmd(ctx context.Context, cmd *cobra.Command, wd string) error {
+func (s *Service) executeCmd(ctx context.Context, cmd *cobra.Command, wd string, env map[string]string) error {
 	// the context within this scope is not derived from the context of the outer scope
 	ctx = output.WithStructure(ctx, cmd)
 	if _, ok := cmd.Annotations[commands.CommandRequiresConnectorServer]; ok {
 		ctx = commands.WithConnectorServer(ctx, s)
 	}
-	return cmd.ExecuteContext(commands.WithCwd(ctx, wd))
+	return cmd.ExecuteContext(commands.WithEnv(commands.WithCwd(ctx, wd), env))
 }
 
 func (s *Service) RunCommand(cmdStream rpc.Connector_RunCommandServer) (err error) {
@@ -665,97 +879,250 @@ func (s *Service) RunCommand(cmdStream rpc.Connector_RunCommandServer) (err erro
 			err = status.Errorf(codes.Aborted, "RunCommand failed to read initial stream message: %v", err)
 			return
 		}
+		if id := cr.GetAttach(); id != "" {
+			s.attachCommand(ctx, cmdStream, id)
+			return
+		}
 		req := cr.GetCommand()
 		if req == nil {
 			err = status.Error(codes.InvalidArgument, "RunCommand got incorrect initial stream message")
 			return
 		}
-
-		var cmdErr error
-		cmd := &cobra.Command{
-			Use: "telepresence",
+		if req.Detach {
+			s.runDetachedCommand(ctx, cmdStream, req)
+			return
 		}
-		cmd.SetContext(ctx)
-		wg := sync.WaitGroup{}
-
-		// Start the stdout/stderr pump
-		so := client.NewStdOutput()
-		wg.Add(1)
-		go stdoutAndStderrPump(ctx, cmdStream, so.ResultChannel(), &wg)
-		defer func() {
-			if cmdErr == pflag.ErrHelp {
-				cmdErr = nil
-				_ = cmd.Usage()
-			}
-			so.Finish(cmdErr)
-			wg.Wait()
-		}()
+		s.runCommand(ctx, cmdStream, req, nil)
+	})
+	return nil
+}
 
-		cmd.SetIn(bytes.NewReader(nil))
-		cmd.SetOut(so.Stdout())
-		cmd.SetErr(so.Stderr())
-		cmd.SetFlagErrorFunc(func(_ *cobra.Command, e error) error {
-			return errcat.User.New(e)
-		})
+func (s *Service) RunCommands(mux rpc.Connector_RunCommandsServer) error {
+	return client.ServeMuxedCommands(mux, s.RunCommand)
+}
 
-		cli.AddCommandGroups(cmd, s.getCommands(ctx))
+// runDetachedCommand starts the given command so that it keeps running when the client is gone. It returns
+// once the id of the command has been sent to the client, or when the command ends before it has started.
+func (s *Service) runDetachedCommand(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, req *rpc.RunCommandRequest_Command) {
+	if req.Tty {
+		err := errcat.User.New("a detached command cannot use a pseudo-terminal")
+		_ = cmdStream.Send(&rpc.StreamResult{Final: true, Data: errcat.ToResult(err)})
+		return
+	}
+	ds := newDetachedStream(dcontext.WithoutCancel(ctx), cmdStream, &s.detachedCommands)
+	go func() {
+		defer ds.end()
+		s.runCommand(ds.Context(), ds, req, ds.detach)
+	}()
+	select {
+	case <-ds.detached:
+	case <-ds.ended:
+	}
+}
 
-		args := req.GetOsArgs()
-		cmd.SetArgs(args)
-		cmd, _, cmdErr = cmd.Find(args)
+// attachCommand attaches the cmdStream to the detached command with the given id, and returns when the command
+// has ended or the client is gone.
+func (s *Service) attachCommand(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, id string) {
+	ds := s.detachedCommands.get(id)
+	if ds == nil {
+		err := errcat.User.Newf("there is no detached command with id %s", id)
+		_ = cmdStream.Send(&rpc.StreamResult{Final: true, Data: errcat.ToResult(err)})
+		return
+	}
+	ok, err := ds.attach(cmdStream)
+	if err != nil {
+		_ = cmdStream.Send(&rpc.StreamResult{Final: true, Data: errcat.ToResult(err)})
+		return
+	}
+	if !ok {
+		// The command had already ended, and its final result has been sent
+		return
+	}
+	defer ds.release(cmdStream)
+	select {
+	case <-ds.ended:
+	case <-ctx.Done():
+	}
+}
 
+// runCommand executes the given command using the stdin, stdout, and stderr of the cmdStream. The detach
+// function, unless nil, is called with the id of the command once it has started.
+func (s *Service) runCommand(ctx context.Context, cmdStream rpc.Connector_RunCommandServer, req *rpc.RunCommandRequest_Command, detach func(string)) {
+	var cmdErr error
+	cmd := &cobra.Command{
+		Use: "telepresence",
+	}
+	cmd.SetContext(ctx)
+	wg := sync.WaitGroup{}
+
+	// The audit entry is written when everything else is done, so that it reflects the final error
+	audit := &auditEntry{
+		Args:      req.GetOsArgs(),
+		Cwd:       req.GetCwd(),
+		StartTime: time.Now(),
+		SessionID: s.auditSessionID(),
+	}
+	defer func() {
+		audit.EndTime = time.Now()
+		audit.ExitCode = errcat.ExitCode(cmdErr)
 		if cmdErr != nil {
-			cmdErr = errcat.User.New(cmdErr)
-			return
+			audit.Error = cmdErr.Error()
 		}
+		s.auditLog.write(ctx, audit)
+	}()
 
-		cmd.SetOut(so.Stdout())
-		cmd.SetErr(so.Stderr())
-		cmd.SilenceUsage = true
-
-		for _, group := range cli.GlobalFlagGroups() {
-			cmd.PersistentFlags().AddFlagSet(group.Flags)
+	// Start the stdout/stderr pump
+	var acker *stdinAcker
+	if req.StdinFlowControl {
+		acker = newStdinAcker()
+	}
+	so := client.NewStdOutput()
+	wg.Add(1)
+	go stdoutAndStderrPump(ctx, cmdStream, so.ResultChannel(), acker, &wg)
+	defer func() {
+		if cmdErr == pflag.ErrHelp {
+			cmdErr = nil
+			_ = cmd.Usage()
 		}
+		so.Finish(cmdErr)
+		wg.Wait()
+	}()
+
+	cmd.SetIn(bytes.NewReader(nil))
+	cmd.SetOut(so.Stdout())
+	cmd.SetErr(so.Stderr())
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, e error) error {
+		return errcat.User.New(e)
+	})
+
+	// A working directory that can't be used is reported before the command starts
+	if cmdErr = validateCwd(req.GetCwd()); cmdErr != nil {
+		return
+	}
+
+	cli.AddCommandGroups(cmd, s.getCommands(ctx))
+
+	args := req.GetOsArgs()
+	cmd.SetArgs(args)
+	cmd, _, cmdErr = cmd.Find(args)
+
+	if cmdErr != nil {
+		cmdErr = errcat.User.New(cmdErr)
+		return
+	}
+
+	cmd.SetOut(so.Stdout())
+	cmd.SetErr(so.Stderr())
+	cmd.SilenceUsage = true
 
-		var rd io.Reader
-		if ctx, rd, cmdErr = stdinPump(ctx, cmdStream, needsPTY(cmd)); cmdErr != nil {
+	for _, group := range cli.GlobalFlagGroups() {
+		cmd.PersistentFlags().AddFlagSet(group.Flags)
+	}
+
+	// A command that must wait for a slot hasn't started, so it's neither running nor reading its stdin
+	maxCommands := 0
+	if cfg := client.GetConfig(ctx); cfg != nil {
+		maxCommands = cfg.Grpc.MaxConcurrentCommands
+	}
+	release, err := s.commandSlots.acquire(ctx, maxCommands, req.Wait, func() { _ = so.Queued() })
+	if err != nil {
+		cmdErr = err
+		return
+	}
+	defer release()
+
+	var ptm, tty *os.File
+	if req.Tty || needsPTY(cmd) {
+		if ptm, tty, cmdErr = pty.Open(); cmdErr != nil {
+			cmdErr = errcat.User.Newf("unable to allocate a pseudo-terminal: %v", cmdErr)
 			return
 		}
-		cmd.SetContext(ctx)
-		cmd.SetIn(rd)
-
-		if _, ok := cmd.Annotations[commands.CommandRequiresSession]; ok {
-			err = s.withSession(ctx, "cmd-"+cmd.Name(), func(sessionCtx context.Context, ts trafficmgr.Session) error {
-				// NOTE: For command termination to work properly, the command must execute using the stream
-				// context, not the session context. The fact that the command needs a session here, is just
-				// to get hold of the managerClient. For the actions where it truly needs a long-running session
-				// scoped context that survives the command invocation, it will call methods on the connectorServer.
-
-				// Let the session context cancel the stream context in case the user quits
-				ctx, cancel := context.WithCancel(ctx)
-				go func() {
-					select {
-					case <-ctx.Done():
-					case <-sessionCtx.Done():
-						cancel()
-					}
-				}()
+		defer tty.Close()
+	}
+	if req.Tty {
+		// Everything that the command writes goes through the PTY, so that the output gets the
+		// same treatment that it would get in a local terminal.
+		cmd.SetOut(tty)
+		cmd.SetErr(tty)
+		outputDone := ptyOutputPump(ctx, ptm, so.Stdout())
+		defer func() {
+			_ = tty.Close()
+			<-outputDone
+			cmd.SetOut(so.Stdout())
+			cmd.SetErr(so.Stderr())
+		}()
+	}
 
-				// Copy the SystemAPoolProvider over
-				if pool := a8rcloud.GetSystemAPoolProvider[a8rcloud.SessionClient](sessionCtx, a8rcloud.UserdConnName); pool != nil {
-					ctx = a8rcloud.WithSystemAPool[a8rcloud.SessionClient](ctx, a8rcloud.UserdConnName, pool)
-				}
-				if ki := k8sapi.GetK8sInterface(sessionCtx); ki != nil {
-					ctx = k8sapi.WithK8sInterface(ctx, ki)
-				}
-				cmdErr = s.executeCmd(trafficmgr.WithSession(ctx, ts), cmd, req.GetCwd())
-				return nil
-			})
-		} else {
-			cmdErr = s.executeCmd(ctx, cmd, req.GetCwd())
+	// The command can be cancelled by CancelCommand from now on
+	ctx, id, done := s.runningCommands.add(ctx, req.GetOsArgs(), req.GetCwd())
+	defer done()
+	audit.ID = id
+
+	var rd io.Reader
+	var softCancel func()
+	var prompter *commandPrompter
+	if req.Prompts && detach == nil {
+		// A detached command has no client to answer its prompts
+		prompter = newCommandPrompter(so)
+		ctx = commands.WithPrompter(ctx, prompter)
+	}
+	if req.Progress && detach == nil {
+		ctx = commands.WithProgressReporter(ctx, so)
+	}
+	ctx = commands.WithDiagnostic(ctx, so.Diagnostic())
+	// An older client sends neither, which leaves the command with the zero ClientInfo
+	ctx = commands.WithClientInfo(ctx, commands.NewClientInfo(req.GetClientVersion(), req.GetClientCapabilities()))
+	var idle *idleTimer
+	if cfg := client.GetConfig(ctx); cfg != nil && detach == nil {
+		// A detached command has no client to hear from
+		idle = newIdleTimer(cfg.Grpc.CommandIdleTimeout)
+	}
+	ctx, rd, softCancel = stdinPump(ctx, cmdStream, ptm, tty, acker, prompter, idle)
+	defer func() {
+		if idle.hasExpired() {
+			cmdErr = errcat.Timeout.Newf("the command was cancelled because its client was idle for %s", idle.timeout)
 		}
-	})
-	return nil
+	}()
+	s.runningCommands.setSoftCancel(id, softCancel)
+	cmd.SetContext(ctx)
+	cmd.SetIn(rd)
+	if detach != nil {
+		detach(id)
+	} else if req.StartAck {
+		// The client holds back its stdin until it knows that the command reads it
+		_ = so.Started()
+	}
+
+	if _, ok := cmd.Annotations[commands.CommandRequiresSession]; ok {
+		_ = s.withSession(ctx, "cmd-"+cmd.Name(), func(sessionCtx context.Context, ts trafficmgr.Session) error {
+			// NOTE: For command termination to work properly, the command must execute using the stream
+			// context, not the session context. The fact that the command needs a session here, is just
+			// to get hold of the managerClient. For the actions where it truly needs a long-running session
+			// scoped context that survives the command invocation, it will call methods on the connectorServer.
+
+			// Let the session context cancel the stream context in case the user quits
+			ctx, cancel := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-sessionCtx.Done():
+					cancel()
+				}
+			}()
+
+			// Copy the SystemAPoolProvider over
+			if pool := a8rcloud.GetSystemAPoolProvider[a8rcloud.SessionClient](sessionCtx, a8rcloud.UserdConnName); pool != nil {
+				ctx = a8rcloud.WithSystemAPool[a8rcloud.SessionClient](ctx, a8rcloud.UserdConnName, pool)
+			}
+			if ki := k8sapi.GetK8sInterface(sessionCtx); ki != nil {
+				ctx = k8sapi.WithK8sInterface(ctx, ki)
+			}
+			cmdErr = s.executeCmd(trafficmgr.WithSession(ctx, ts), cmd, req.GetCwd(), commandEnv(ctx, cmd, req))
+			return nil
+		})
+	} else {
+		cmdErr = s.executeCmd(ctx, cmd, req.GetCwd(), commandEnv(ctx, cmd, req))
+	}
 }
 
 func (s *Service) ResolveIngressInfo(ctx context.Context, req *userdaemon.IngressInfoRequest) (resp *userdaemon.IngressInfoResponse, err error) {
@@ -824,9 +1191,8 @@ func (s *Service) ValidArgsForCommand(ctx context.Context, req *rpc.ValidArgsFor
 				flagValueToComplete = req.ToComplete
 			)
 
-			var shellCompDir cobra.ShellCompDirective
-			resp.Completions, shellCompDir = s.autocompleteFlag(ctx, cmd, req.OsArgs, flagName, flagValueToComplete)
-			resp.ShellCompDirective = int32(shellCompDir)
+			completions, shellCompDir := s.autocompleteFlag(ctx, cmd, req.OsArgs, flagName, flagValueToComplete)
+			setCompletions(&resp, completions, shellCompDir)
 			return &resp, nil
 		}
 	}
@@ -843,10 +1209,8 @@ func (s *Service) ValidArgsForCommand(ctx context.Context, req *rpc.ValidArgsFor
 			flagValueToComplete = flagParts[1]
 		)
 
-		var shellCompDir cobra.ShellCompDirective
-		resp.Completions, shellCompDir = s.autocompleteFlag(ctx, cmd, req.OsArgs, flagName, flagValueToComplete)
-		resp.ShellCompDirective = int32(shellCompDir)
-
+		completions, shellCompDir := s.autocompleteFlag(ctx, cmd, req.OsArgs, flagName, flagValueToComplete)
+		setCompletions(&resp, completions, shellCompDir)
 		return &resp, nil
 	}
 
@@ -857,6 +1221,7 @@ func (s *Service) ValidArgsForCommand(ctx context.Context, req *rpc.ValidArgsFor
 	}
 
 	var (
+		completions  []string
 		shellCompDir cobra.ShellCompDirective
 		err          error
 	)
@@ -866,23 +1231,41 @@ func (s *Service) ValidArgsForCommand(ctx context.Context, req *rpc.ValidArgsFor
 			if _, ok := cmd.Annotations[commands.CommandRequiresConnectorServer]; ok {
 				ctx = commands.WithConnectorServer(ctx, s)
 			}
-			resp.Completions, shellCompDir = vaf(trafficmgr.WithSession(ctx, ts), cmd, req.OsArgs, req.ToComplete)
+			completions, shellCompDir = vaf(trafficmgr.WithSession(ctx, ts), cmd, req.OsArgs, req.ToComplete)
 			return nil
 		})
 	} else {
 		if _, ok := cmd.Annotations[commands.CommandRequiresConnectorServer]; ok {
 			ctx = commands.WithConnectorServer(ctx, s)
 		}
-		resp.Completions, shellCompDir = vaf(ctx, cmd, req.OsArgs, req.ToComplete)
+		completions, shellCompDir = vaf(ctx, cmd, req.OsArgs, req.ToComplete)
 	}
 	if err != nil {
 		return &resp, err
 	}
 
-	resp.ShellCompDirective = int32(shellCompDir)
+	setCompletions(&resp, completions, shellCompDir)
 	return &resp, nil
 }
 
+// setCompletions assigns the completions and the directive to the response. The file filtering directives
+// are turned into a file filter, because files must be completed using the client's file system.
+func setCompletions(resp *rpc.ValidArgsForCommandResponse, completions []string, dir cobra.ShellCompDirective) {
+	switch {
+	case dir&cobra.ShellCompDirectiveFilterFileExt != 0:
+		// The completions are the file extensions
+		resp.FileFilter = &rpc.ValidArgsForCommandResponse_FileFilter{Extensions: completions}
+		completions = []string{}
+	case dir&cobra.ShellCompDirectiveFilterDirs != 0:
+		resp.FileFilter = &rpc.ValidArgsForCommandResponse_FileFilter{DirsOnly: true}
+		completions = []string{}
+	}
+	if completions != nil {
+		resp.Completions = completions
+	}
+	resp.ShellCompDirective = int32(dir &^ (cobra.ShellCompDirectiveFilterFileExt | cobra.ShellCompDirectiveFilterDirs))
+}
+
 func (s *Service) autocompleteFlag(ctx context.Context, cmd *cobra.Command, args []string, flagName, toComplete string) ([]string, cobra.ShellCompDirective) {
 	faf := commands.GetFlagAutocompletionFuncFor(ctx, cmd, flagName)
 	if faf == nil {
@@ -0,0 +1,162 @@
+package userd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/commands"
+)
+
+func Test_validateCwd(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	assert.NoError(t, validateCwd(""))
+	assert.NoError(t, validateCwd(dir))
+	for _, cwd := range []string{filepath.Join(dir, "missing"), file} {
+		err := validateCwd(cwd)
+		require.Error(t, err, cwd)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), cwd)
+	}
+}
+
+func TestService_RunCommand_cwdRejected(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "never",
+		RunE: func(*cobra.Command, []string) error {
+			t.Fatal("the command ran with a working directory that doesn't exist")
+			return nil
+		},
+	})}
+	cwd := filepath.Join(t.TempDir(), "missing")
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"never"},
+		Cwd:    cwd,
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+
+	// The error is the only result
+	sr := stream.nextResult(t)
+	require.True(t, sr.Final)
+	err := errcat.FromResult(sr.Data)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), cwd)
+	assert.Empty(t, stream.sent)
+	assert.Empty(t, s.runningCommands.list())
+}
+
+func TestService_ListCommands_groups(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: func(context.Context) cliutil.CommandGroups {
+		return cliutil.CommandGroups{
+			"net":       {{Use: "ping"}},
+			"intercept": {{Use: "intercept"}, {Use: "leave"}},
+		}
+	}}
+
+	groups, err := s.ListCommands(ctx, &rpc.ListCommandsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, groups.CommandGroups, 2)
+
+	groups, err = s.ListCommands(ctx, &rpc.ListCommandsRequest{Groups: []string{"net"}})
+	require.NoError(t, err)
+	require.Len(t, groups.CommandGroups, 1)
+	require.Len(t, groups.CommandGroups["net"].Commands, 1)
+	assert.Equal(t, "ping", groups.CommandGroups["net"].Commands[0].Name)
+
+	// Unknown groups give an empty result
+	groups, err = s.ListCommands(ctx, &rpc.ListCommandsRequest{Groups: []string{"unknown"}})
+	require.NoError(t, err)
+	assert.Empty(t, groups.CommandGroups)
+}
+
+func Test_commandEnv(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cmd := &cobra.Command{Use: "date"}
+
+	// The locale fills in what the explicitly forwarded environment doesn't have
+	env := commandEnv(ctx, cmd, &rpc.RunCommandRequest_Command{
+		Env:    map[string]string{"TZ": "UTC", "FOO": "bar"},
+		Locale: map[string]string{"LANG": "de_DE.UTF-8", "TZ": "Europe/Berlin"},
+	})
+	assert.Equal(t, map[string]string{"LANG": "de_DE.UTF-8", "TZ": "UTC", "FOO": "bar"}, env)
+
+	env = commandEnv(ctx, cmd, &rpc.RunCommandRequest_Command{Locale: map[string]string{"LC_TIME": "sv_SE.UTF-8"}})
+	assert.Equal(t, map[string]string{"LC_TIME": "sv_SE.UTF-8"}, env)
+
+	assert.Empty(t, commandEnv(ctx, cmd, &rpc.RunCommandRequest_Command{}))
+
+	// Forwarded variables that the command doesn't accept are dropped, also when an older client sends them
+	cmd.Annotations = map[string]string{cliutil.CommandEnvAllow: "TZ,FOO*", cliutil.CommandEnvDeny: "FOO_SECRET"}
+	env = commandEnv(ctx, cmd, &rpc.RunCommandRequest_Command{
+		Env:    map[string]string{"TZ": "UTC", "FOO": "bar", "FOO_SECRET": "s3cr3t", "AWS_SECRET_ACCESS_KEY": "key"},
+		Locale: map[string]string{"LANG": "de_DE.UTF-8"},
+	})
+	assert.Equal(t, map[string]string{"LANG": "de_DE.UTF-8", "TZ": "UTC", "FOO": "bar"}, env)
+}
+
+func TestService_RunCommand_locale(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var got map[string]string
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "date",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			got = commands.GetEnv(cmd.Context())
+			return nil
+		},
+	})}
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"date"},
+		Locale: map[string]string{"LANG": "fr_FR.UTF-8", "TZ": "Europe/Paris"},
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+	assert.True(t, stream.nextResult(t).Final)
+	assert.Equal(t, map[string]string{"LANG": "fr_FR.UTF-8", "TZ": "Europe/Paris"}, got)
+}
+
+func TestService_RunCommand_clientInfo(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var got commands.ClientInfo
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "version",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			got = commands.GetClientInfo(cmd.Context())
+			return nil
+		},
+	})}
+	run := func(c *rpc.RunCommandRequest_Command) {
+		t.Helper()
+		c.OsArgs = []string{"version"}
+		stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: c}})
+		require.NoError(t, s.RunCommand(stream))
+		assert.True(t, stream.nextResult(t).Final)
+	}
+
+	run(&rpc.RunCommandRequest_Command{
+		ClientVersion:      "v2.9.0",
+		ClientCapabilities: uint64(rpc.RunCommandRequest_Command_CAPABILITY_OUTPUT_CHANNELS),
+	})
+	require.NotNil(t, got.Version)
+	assert.Equal(t, "2.9.0", got.Version.String())
+	assert.True(t, got.Can(rpc.RunCommandRequest_Command_CAPABILITY_OUTPUT_CHANNELS))
+	assert.False(t, got.Can(rpc.RunCommandRequest_Command_CAPABILITY_DIAGNOSTIC_CHANNEL))
+
+	// An older client tells neither
+	run(&rpc.RunCommandRequest_Command{})
+	assert.Equal(t, commands.ClientInfo{}, got)
+	assert.False(t, got.Can(rpc.RunCommandRequest_Command_CAPABILITY_OUTPUT_CHANNELS))
+}
@@ -0,0 +1,78 @@
+package userd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// idleTimer cancels a command when its client has sent nothing, not even a keep-alive, for the duration of
+// the timeout. It reaps the commands of clients that have gone without cancelling their streams. All methods
+// are no-ops on a nil *idleTimer, which is what commands without an idle timeout use.
+type idleTimer struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired bool
+}
+
+// newIdleTimer returns an idleTimer with the given timeout, or nil if the timeout isn't positive.
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	return &idleTimer{timeout: timeout}
+}
+
+// start starts the timer. The given function is called, and the timer is expired, unless the timer is reset
+// or stopped within the timeout.
+func (it *idleTimer) start(ctx context.Context, cancel context.CancelFunc) {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.timer = time.AfterFunc(it.timeout, func() {
+		it.mu.Lock()
+		it.expired = true
+		it.mu.Unlock()
+		dlog.Warnf(ctx, "Cancelling command because its client has been idle for %s", it.timeout)
+		cancel()
+	})
+}
+
+// reset restarts the timeout, unless the timer has expired or isn't started.
+func (it *idleTimer) reset() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	if it.timer != nil && !it.expired {
+		it.timer.Reset(it.timeout)
+	}
+	it.mu.Unlock()
+}
+
+// stop stops the timer.
+func (it *idleTimer) stop() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	if it.timer != nil {
+		it.timer.Stop()
+	}
+	it.mu.Unlock()
+}
+
+// hasExpired returns true if the timer has expired.
+func (it *idleTimer) hasExpired() bool {
+	if it == nil {
+		return false
+	}
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.expired
+}
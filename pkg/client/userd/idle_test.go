@@ -0,0 +1,88 @@
+package userd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func TestService_RunCommand_idle(t *testing.T) {
+	cfg := client.GetDefaultConfig()
+	cfg.Grpc.CommandIdleTimeout = 200 * time.Millisecond
+	ctx := client.WithConfig(dlog.NewTestContext(t, false), &cfg)
+	s := &Service{getCommands: testCommands(&cobra.Command{
+		Use: "wait",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			<-cmd.Context().Done()
+			return nil
+		},
+	})}
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"wait"},
+	}}})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RunCommand(stream)
+	}()
+
+	// Keep-alives keep the command running for longer than the timeout
+	for i := 0; i < 8; i++ {
+		select {
+		case <-done:
+			t.Fatal("the command was cancelled although its client sent keep-alives")
+		case <-time.After(50 * time.Millisecond):
+		}
+		stream.reqs <- &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_KeepAlive{KeepAlive: true}}
+	}
+
+	// The client goes silent without cancelling its stream
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command of an idle client wasn't cancelled")
+	}
+	sr := stream.nextResult(t)
+	require.True(t, sr.Final)
+	err := errcat.FromResult(sr.Data)
+	require.Error(t, err)
+	assert.Equal(t, errcat.Timeout, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "idle for 200ms")
+	assert.Empty(t, s.runningCommands.list())
+}
+
+func Test_idleTimer(t *testing.T) {
+	assert.Nil(t, newIdleTimer(0))
+
+	// All methods are no-ops on a nil timer
+	var it *idleTimer
+	it.start(dlog.NewTestContext(t, false), func() { t.Fatal("a nil timer expired") })
+	it.reset()
+	it.stop()
+	assert.False(t, it.hasExpired())
+
+	// A stopped timer doesn't expire
+	cancelled := make(chan struct{})
+	it = newIdleTimer(50 * time.Millisecond)
+	it.start(dlog.NewTestContext(t, false), func() { close(cancelled) })
+	it.stop()
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, it.hasExpired())
+
+	it = newIdleTimer(50 * time.Millisecond)
+	it.start(dlog.NewTestContext(t, false), func() { close(cancelled) })
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the timer didn't expire")
+	}
+	assert.True(t, it.hasExpired())
+}
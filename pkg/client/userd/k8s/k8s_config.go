@@ -25,6 +25,12 @@ type dnsConfig struct {
 	// to the first line of /etc/resolv.conf
 	LocalIP iputil.IPKey `json:"local-ip,omitempty"`
 
+	// FallbackIPs are addresses of DNS servers that are tried, in order, when the
+	// local DNS server fails to respond. Like LocalIP, this entry is only used on
+	// Linux systems that are not configured to use systemd-resolved. No other
+	// servers are tried when it's empty.
+	FallbackIPs []iputil.IPKey `json:"fallback-ips,omitempty"`
+
 	// RemoteIP is the address of the cluster's DNS service. It will default
 	// to the IP of the kube-dns.kube-system or the dns-default.openshift-dns service.
 	RemoteIP iputil.IPKey `json:"remote-ip,omitempty"`
@@ -0,0 +1,88 @@
+package userd
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// commandPrompter is the commands.Prompter of a command whose client answers prompts. The prompts are sent
+// through the command's output, so that they're ordered with what the command writes, and the replies are
+// delivered by the stdinPump.
+type commandPrompter struct {
+	so      client.StdOutput
+	mu      sync.Mutex
+	lastID  uint32
+	pending map[uint32]chan *rpc.PromptReply
+	closed  bool
+}
+
+func newCommandPrompter(so client.StdOutput) *commandPrompter {
+	return &commandPrompter{so: so, pending: make(map[uint32]chan *rpc.PromptReply)}
+}
+
+func (cp *commandPrompter) Prompt(ctx context.Context, text string, singleChar bool) (string, error) {
+	cp.mu.Lock()
+	if cp.closed {
+		cp.mu.Unlock()
+		return "", io.EOF
+	}
+	cp.lastID++
+	id := cp.lastID
+	replyCh := make(chan *rpc.PromptReply, 1)
+	cp.pending[id] = replyCh
+	cp.mu.Unlock()
+	defer func() {
+		cp.mu.Lock()
+		delete(cp.pending, id)
+		cp.mu.Unlock()
+	}()
+
+	if err := cp.so.Prompt(&rpc.Prompt{Id: id, Text: text, SingleChar: singleChar}); err != nil {
+		return "", err
+	}
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r, ok := <-replyCh:
+		switch {
+		case !ok:
+			return "", io.EOF
+		case r.Eof:
+			return r.Text, io.EOF
+		default:
+			return r.Text, nil
+		}
+	}
+}
+
+// reply delivers the given reply to the prompt that it answers. Replies to unknown prompts are ignored.
+func (cp *commandPrompter) reply(r *rpc.PromptReply) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	replyCh, ok := cp.pending[r.Id]
+	delete(cp.pending, r.Id)
+	cp.mu.Unlock()
+	if ok {
+		replyCh <- r
+	}
+}
+
+// close makes pending and future prompts return io.EOF. It's called when no more replies can arrive.
+func (cp *commandPrompter) close() {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	cp.closed = true
+	for id, replyCh := range cp.pending {
+		close(replyCh)
+		delete(cp.pending, id)
+	}
+	cp.mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package userd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/userd/commands"
+)
+
+// confirmCommand prompts for a confirmation, and then writes the answer and what it reads from stdin.
+func confirmCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "confirm",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "deleting everything")
+			answer, err := commands.Prompt(cmd.Context(), "are you sure? [y/N] ", true)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "answer %q\n", answer)
+			stdin, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "stdin %q\n", stdin)
+			return nil
+		},
+	}
+}
+
+func TestService_RunCommand_prompt(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: testCommands(confirmCommand())}
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs:  []string{"confirm"},
+		Prompts: true,
+	}}})
+	done := make(chan error, 1)
+	go func() { done <- s.RunCommand(stream) }()
+
+	// The prompt is sent after the output that precedes it
+	assert.Equal(t, "deleting everything\n", string(stream.nextResult(t).GetData().GetData()))
+	p := stream.nextResult(t).Prompt
+	require.NotNil(t, p)
+	assert.Equal(t, "are you sure? [y/N] ", p.Text)
+	assert.True(t, p.SingleChar)
+
+	// Stdin that arrives before the answer isn't mistaken for it
+	stream.reqs <- &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Data{Data: []byte("typed ahead\n")}}
+	stream.reqs <- &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_PromptReply{PromptReply: &rpc.PromptReply{Id: p.Id, Text: "y"}}}
+	stream.reqs <- &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_StdinClosed{StdinClosed: true}}
+
+	assert.Equal(t, "answer \"y\"\n", string(stream.nextResult(t).GetData().GetData()))
+	assert.Equal(t, "stdin \"typed ahead\\n\"\n", string(stream.nextResult(t).GetData().GetData()))
+	sr := stream.nextResult(t)
+	assert.True(t, sr.Final)
+	assert.Nil(t, sr.Data)
+	require.NoError(t, <-done)
+}
+
+func TestService_RunCommand_promptNotSupported(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{getCommands: testCommands(confirmCommand())}
+	stream := newFakeServerStream(ctx, &rpc.RunCommandRequest{COrD: &rpc.RunCommandRequest_Command_{Command: &rpc.RunCommandRequest_Command{
+		OsArgs: []string{"confirm"},
+	}}})
+	require.NoError(t, s.RunCommand(stream))
+
+	assert.Equal(t, "deleting everything\n", string(stream.nextResult(t).GetData().GetData()))
+	sr := stream.nextResult(t)
+	assert.True(t, sr.Final)
+	assert.Equal(t, "the client doesn't answer prompts", string(sr.GetData().GetData()))
+	assert.Equal(t, rpc.Result_USER, sr.GetData().GetErrorCategory())
+}
+
+func Test_commandPrompter_eof(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	so := client.NewStdOutput()
+	cp := newCommandPrompter(so)
+
+	// The client's stdin ended before the line was complete
+	go func() {
+		sr := <-so.ResultChannel()
+		cp.reply(&rpc.PromptReply{Id: sr.Prompt.Id, Text: "par", Eof: true})
+	}()
+	answer, err := cp.Prompt(ctx, "name: ", false)
+	assert.Equal(t, "par", answer)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// The stream ended with a prompt pending
+	go func() {
+		<-so.ResultChannel()
+		cp.close()
+	}()
+	_, err = cp.Prompt(ctx, "name: ", false)
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = cp.Prompt(ctx, "name: ", false)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// A cancelled prompt
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-so.ResultChannel()
+		cancel()
+	}()
+	_, err = newCommandPrompter(so).Prompt(ctx, "name: ", false)
+	assert.ErrorIs(t, err, context.Canceled)
+}
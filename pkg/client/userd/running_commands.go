@@ -0,0 +1,152 @@
+package userd
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// runningCommands keeps track of the commands that are executed by RunCommand.
+type runningCommands struct {
+	sync.Mutex
+	lastSeq  uint64
+	commands map[uint64]*runningCommand
+}
+
+type runningCommand struct {
+	info       *rpc.RunningCommand
+	softCancel func()
+	hardCancel context.CancelFunc
+	ended      chan struct{}
+}
+
+// add registers a command that starts now, and returns its id together with a function that must be
+// called when the command ends. The returned context is cancelled when the command is hard cancelled.
+func (rc *runningCommands) add(ctx context.Context, args []string, cwd string) (context.Context, string, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.commands == nil {
+		rc.commands = make(map[uint64]*runningCommand)
+	}
+	rc.lastSeq++
+	seq := rc.lastSeq
+	id := strconv.FormatUint(seq, 10)
+	c := &runningCommand{
+		info: &rpc.RunningCommand{
+			Id:        id,
+			OsArgs:    args,
+			Cwd:       cwd,
+			StartTime: timestamppb.New(time.Now()),
+		},
+		hardCancel: cancel,
+		ended:      make(chan struct{}),
+	}
+	rc.commands[seq] = c
+	return ctx, id, func() {
+		rc.Lock()
+		delete(rc.commands, seq)
+		rc.Unlock()
+		close(c.ended)
+		cancel()
+	}
+}
+
+// setSoftCancel sets the function that soft cancels the command with the given id.
+func (rc *runningCommands) setSoftCancel(id string, softCancel func()) {
+	rc.Lock()
+	if c := rc.get(id); c != nil {
+		c.softCancel = softCancel
+	}
+	rc.Unlock()
+}
+
+// cancel soft cancels the command with the given id, and then hard cancels it unless it ends within the grace
+// period. A zero grace period means that the hard cancel happens immediately and a negative grace period means
+// that it never happens. The command is hard cancelled right away if it can't be soft cancelled. It returns false
+// if no command with the given id is running.
+func (rc *runningCommands) cancel(id string, grace time.Duration) bool {
+	rc.Lock()
+	c := rc.get(id)
+	var softCancel func()
+	if c != nil {
+		softCancel = c.softCancel
+	}
+	rc.Unlock()
+	if c == nil {
+		return false
+	}
+	if softCancel == nil {
+		c.hardCancel()
+		return true
+	}
+	softCancel()
+	switch {
+	case grace == 0:
+		c.hardCancel()
+	case grace > 0:
+		go func() {
+			select {
+			case <-c.ended:
+			case <-time.After(grace):
+				c.hardCancel()
+			}
+		}()
+	}
+	return true
+}
+
+// get returns the command with the given id, or nil if no such command is running. The caller must hold the lock.
+func (rc *runningCommands) get(id string) *runningCommand {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return rc.commands[seq]
+}
+
+// waitUntilIdle waits until no command is running, or until the timeout expires or the context is cancelled. It
+// returns the commands that are still running, which is nil when it didn't have to give up.
+func (rc *runningCommands) waitUntilIdle(ctx context.Context, timeout time.Duration) []*rpc.RunningCommand {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		var ended chan struct{}
+		rc.Lock()
+		for _, c := range rc.commands {
+			ended = c.ended
+			break
+		}
+		rc.Unlock()
+		if ended == nil {
+			return nil
+		}
+		select {
+		case <-ended:
+		case <-ctx.Done():
+			return rc.list()
+		}
+	}
+}
+
+// list returns the running commands in the order that they were started.
+func (rc *runningCommands) list() []*rpc.RunningCommand {
+	rc.Lock()
+	defer rc.Unlock()
+	seqs := make([]uint64, 0, len(rc.commands))
+	for seq := range rc.commands {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	cmds := make([]*rpc.RunningCommand, len(seqs))
+	for i, seq := range seqs {
+		cmds[i] = rc.commands[seq].info
+	}
+	return cmds
+}
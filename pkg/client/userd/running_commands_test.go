@@ -0,0 +1,131 @@
+package userd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func Test_runningCommands(t *testing.T) {
+	ctx := context.Background()
+	var rc runningCommands
+	assert.Empty(t, rc.list())
+
+	_, id1, done1 := rc.add(ctx, []string{"intercept", "echo", "--port", "8080"}, "/home/me")
+	_, id2, done2 := rc.add(ctx, []string{"list"}, "/tmp")
+	_, id3, done3 := rc.add(ctx, []string{"status"}, "/")
+	assert.NotEqual(t, id1, id2)
+	assert.NotEqual(t, id2, id3)
+
+	cmds := rc.list()
+	require.Len(t, cmds, 3)
+	assert.Equal(t, id1, cmds[0].Id)
+	assert.Equal(t, []string{"intercept", "echo", "--port", "8080"}, cmds[0].OsArgs)
+	assert.Equal(t, "/home/me", cmds[0].Cwd)
+	assert.NotNil(t, cmds[0].StartTime)
+	assert.Equal(t, id2, cmds[1].Id)
+	assert.Equal(t, id3, cmds[2].Id)
+	assert.False(t, cmds[2].StartTime.AsTime().Before(cmds[0].StartTime.AsTime()))
+
+	// Ended commands are removed, and their ids aren't reused
+	done2()
+	cmds = rc.list()
+	require.Len(t, cmds, 2)
+	assert.Equal(t, id1, cmds[0].Id)
+	assert.Equal(t, id3, cmds[1].Id)
+
+	_, id4, done4 := rc.add(ctx, []string{"list"}, "/tmp")
+	assert.NotContains(t, []string{id1, id2, id3}, id4)
+	done1()
+	done3()
+	done4()
+	assert.Empty(t, rc.list())
+}
+
+func Test_runningCommands_cancel(t *testing.T) {
+	ctx := context.Background()
+	var rc runningCommands
+	ctx1, id1, done1 := rc.add(ctx, []string{"intercept", "echo", "--", "sleep", "1000"}, "/")
+	defer done1()
+	ctx2, id2, done2 := rc.add(ctx, []string{"intercept", "other", "--", "sleep", "1000"}, "/")
+	defer done2()
+
+	softCancelled := make(chan string, 2)
+	rc.setSoftCancel(id1, func() { softCancelled <- id1 })
+	rc.setSoftCancel(id2, func() { softCancelled <- id2 })
+
+	// Unknown ids are rejected
+	assert.False(t, rc.cancel("42", time.Millisecond))
+	assert.False(t, rc.cancel("not-an-id", time.Millisecond))
+
+	// Only the cancelled command is soft cancelled, and then hard cancelled when it doesn't end
+	require.True(t, rc.cancel(id1, 10*time.Millisecond))
+	assert.Equal(t, id1, <-softCancelled)
+	assert.NoError(t, ctx1.Err())
+	select {
+	case <-ctx1.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not hard cancelled")
+	}
+	assert.Empty(t, softCancelled)
+	assert.NoError(t, ctx2.Err())
+
+	// A command that ends when it is soft cancelled is no longer running
+	ctx3, id3, done3 := rc.add(ctx, []string{"list"}, "/")
+	rc.setSoftCancel(id3, done3)
+	require.True(t, rc.cancel(id3, time.Hour))
+	assert.ErrorIs(t, ctx3.Err(), context.Canceled)
+	assert.False(t, rc.cancel(id3, time.Hour))
+
+	// A command without a soft cancel is hard cancelled right away
+	ctx4, id4, done4 := rc.add(ctx, []string{"list"}, "/")
+	defer done4()
+	require.True(t, rc.cancel(id4, time.Hour))
+	assert.ErrorIs(t, ctx4.Err(), context.Canceled)
+	assert.NoError(t, ctx2.Err())
+}
+
+func Test_runningCommands_waitUntilIdle(t *testing.T) {
+	ctx := context.Background()
+	var rc runningCommands
+
+	// Idle
+	assert.Nil(t, rc.waitUntilIdle(ctx, 0))
+
+	// Busy, with commands that don't end in time
+	_, id1, done1 := rc.add(ctx, []string{"intercept", "echo", "--", "sleep", "1000"}, "/")
+	_, _, done2 := rc.add(ctx, []string{"list"}, "/")
+	running := rc.waitUntilIdle(ctx, 0)
+	require.Len(t, running, 2)
+	assert.Equal(t, id1, running[0].Id)
+	done2()
+	running = rc.waitUntilIdle(ctx, 10*time.Millisecond)
+	require.Len(t, running, 1)
+	assert.Equal(t, id1, running[0].Id)
+
+	// Busy, with commands that end in time
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done1()
+	}()
+	assert.Nil(t, rc.waitUntilIdle(ctx, 5*time.Second))
+}
+
+func TestService_QuitGraceful_busy(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Service{quit: func() { t.Fatal("quit while a command was running") }}
+	_, id, done := s.runningCommands.add(ctx, []string{"intercept", "echo", "--", "sleep", "1000"}, "/")
+	defer done()
+
+	rsp, err := s.QuitGraceful(ctx, &rpc.QuitGracefulRequest{Timeout: durationpb.New(10 * time.Millisecond)})
+	require.NoError(t, err)
+	require.Len(t, rsp.Running, 1)
+	assert.Equal(t, id, rsp.Running[0].Id)
+}
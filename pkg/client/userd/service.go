@@ -13,6 +13,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // Lets clients compress the RunCommand stream
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
@@ -23,6 +24,7 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
@@ -90,6 +92,18 @@ type Service struct {
 
 	// This is used for the service to know which CLI commands it supports
 	getCommands CommandFactory
+
+	// The commands that are currently executed by RunCommand
+	runningCommands runningCommands
+
+	// The commands that RunCommand has detached, see RunCommandRequest.Command.detach
+	detachedCommands detachedCommands
+
+	// The log that RunCommand writes an entry to for each command, see Daemons.UserDaemonAuditLog
+	auditLog auditLog
+
+	// The slots that limit the number of commands that RunCommand runs at the same time
+	commandSlots commandSlots
 }
 
 func (s *Service) SetManagerClient(managerClient manager.ManagerClient, callOptions ...grpc.CallOption) {
@@ -102,6 +116,7 @@ func (s *Service) LoginExecutor() auth.LoginExecutor {
 
 // Command returns the CLI sub-command for "connector-foreground".
 func Command(getCommands CommandFactory, daemonServices []DaemonService, sessionServices []trafficmgr.SessionService) *cobra.Command {
+	var logFormat string
 	c := &cobra.Command{
 		Use:    ProcessName + "-foreground",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
@@ -109,9 +124,18 @@ func Command(getCommands CommandFactory, daemonServices []DaemonService, session
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), getCommands, daemonServices, sessionServices)
+			ctx := cmd.Context()
+			if logFormat != "" {
+				format, err := logging.ParseFormat(logFormat)
+				if err != nil {
+					return err
+				}
+				ctx = logging.WithFormat(ctx, format)
+			}
+			return run(ctx, getCommands, daemonServices, sessionServices)
 		},
 	}
+	c.Flags().StringVar(&logFormat, "log-format", "", "the format of the log, 'text' or 'json'. Defaults to $"+logging.FormatEnv+", or 'text'")
 	return c
 }
 
@@ -155,6 +179,7 @@ nextSession:
 				if sCtx.Err() == nil && rsp.Error == rpc.ConnectInfo_UNSPECIFIED {
 					s.sessionContext = session.WithK8sInterface(sCtx)
 					s.session = session
+					invalidateCommandsCache(c)
 				} else {
 					sCancel()
 					s.sessionCancel = nil
@@ -170,7 +195,7 @@ nextSession:
 		default:
 			// Nobody there to read the response? That's fine. The user may have got
 			// impatient.
-			s.cancelSession()
+			s.cancelSession(c)
 			continue
 		}
 		if rsp.Error != rpc.ConnectInfo_UNSPECIFIED {
@@ -186,7 +211,7 @@ nextSession:
 				if errors.Is(err, trafficmgr.ErrSessionExpired) {
 					// Session has expired. We need to cancel the owner session and reconnect
 					dlog.Info(c, "refreshing session")
-					s.cancelSession()
+					s.cancelSession(c)
 					select {
 					case <-c.Done():
 					case s.connectRequest <- cr:
@@ -211,7 +236,7 @@ func (s *Service) cancelSessionReadLocked() {
 	}
 }
 
-func (s *Service) cancelSession() {
+func (s *Service) cancelSession(ctx context.Context) {
 	if !atomic.CompareAndSwapInt32(&s.sessionQuitting, 0, 1) {
 		return
 	}
@@ -226,6 +251,15 @@ func (s *Service) cancelSession() {
 	s.sessionCancel = nil
 	atomic.StoreInt32(&s.sessionQuitting, 0)
 	s.sessionLock.Unlock()
+	invalidateCommandsCache(ctx)
+}
+
+// invalidateCommandsCache removes the client's cached list of commands, because the commands
+// may change when a session is created or cancelled.
+func invalidateCommandsCache(ctx context.Context) {
+	if err := cache.DeleteCommandsFromUserCache(ctx); err != nil {
+		dlog.Errorf(ctx, "failed to remove the commands cache: %v", err)
+	}
 }
 
 func GetPoddService(sc *scout.Reporter, cfg client.Config, login auth.LoginExecutor) Service {
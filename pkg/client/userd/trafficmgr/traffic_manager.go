@@ -92,6 +92,7 @@ type Session interface {
 	GatherLogs(context.Context, *connector.LogsRequest) (*connector.LogsResponse, error)
 	ForeachAgentPod(ctx context.Context, fn func(context.Context, typed.PodInterface, *core.Pod), filter func(*core.Pod) bool) error
 	LoginExecutor() auth.LoginExecutor
+	SessionInfo() *manager.SessionInfo
 }
 
 type Service interface {
@@ -590,6 +591,11 @@ func (tm *TrafficManager) session() *manager.SessionInfo {
 	return tm.sessionInfo
 }
 
+// SessionInfo returns the info of the session that the traffic-manager assigned to this client.
+func (tm *TrafficManager) SessionInfo() *manager.SessionInfo {
+	return tm.session()
+}
+
 // getInfosForWorkloads returns a list of workloads found in the given namespace that fulfils the given filter criteria.
 func (tm *TrafficManager) getInfosForWorkloads(
 	ctx context.Context,
@@ -1124,6 +1130,9 @@ func (tm *TrafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundI
 		if len(tm.DNS.LocalIP) > 0 {
 			info.Dns.LocalIp = tm.DNS.LocalIP.IP()
 		}
+		for _, ip := range tm.DNS.FallbackIPs {
+			info.Dns.FallbackIps = append(info.Dns.FallbackIps, ip.IP())
+		}
 		if len(tm.DNS.RemoteIP) > 0 {
 			info.Dns.RemoteIp = tm.DNS.RemoteIP.IP()
 		}
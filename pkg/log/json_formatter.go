@@ -0,0 +1,56 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSONFormatter formats log messages for Telepresence as JSON objects, one per line, for ingestion into log
+// pipelines. The fields of the entry, other than the name of the goroutine, are kept in a nested object so
+// that they never clash with the fixed keys.
+type JSONFormatter struct{}
+
+func NewJSONFormatter() *JSONFormatter {
+	return &JSONFormatter{}
+}
+
+type jsonEntry struct {
+	Time    string            `json:"time"`
+	Level   string            `json:"level"`
+	Worker  string            `json:"worker,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Caller  string            `json:"caller,omitempty"`
+}
+
+// Format implements logrus.Formatter.
+func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	je := jsonEntry{
+		Time:    entry.Time.Format(time.RFC3339Nano),
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	for k, v := range entry.Data {
+		if k == "THREAD" {
+			goroutine, _ := v.(string)
+			je.Worker = strings.TrimPrefix(goroutine, "/")
+			continue
+		}
+		if je.Fields == nil {
+			je.Fields = make(map[string]string, len(entry.Data))
+		}
+		je.Fields[k] = fmt.Sprintf("%+v", v)
+	}
+	if entry.HasCaller() && strings.HasPrefix(entry.Caller.File, thisModule+"/") {
+		je.Caller = fmt.Sprintf("%s:%d", strings.TrimPrefix(entry.Caller.File, thisModule+"/"), entry.Caller.Line)
+	}
+	data, err := json.Marshal(&je)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/datawire/dlib/dexec"
 	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
@@ -72,12 +73,34 @@ func Wait(ctx context.Context, cancel context.CancelFunc, cmd *dexec.Cmd) error
 	}
 
 	exitCode := s.ExitCode()
+	if ws, ok := s.Sys().(interface {
+		Signaled() bool
+		Signal() syscall.Signal
+	}); ok && ws.Signaled() {
+		// Use the same convention as the shells for processes that are terminated by a signal
+		exitCode = 128 + int(ws.Signal())
+	}
 	if exitCode != 0 {
-		return fmt.Errorf("%s: exited with %d", shellquote.ShellString(cmd.Path, cmd.Args), exitCode)
+		return &ExitError{Cmd: shellquote.ShellString(cmd.Path, cmd.Args), Code: exitCode}
 	}
 	return nil
 }
 
+// ExitError is returned by Wait when the process terminates with a non-zero exit code.
+type ExitError struct {
+	Cmd  string
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s: exited with %d", e.Cmd, e.Code)
+}
+
+// ExitCode returns the exit code of the process.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
 // Run will run the given executable with given args and env, wait for it to terminate, and return
 // the result. The run will dispatch signals as appropriate for the given platform (SIGTERM and SIGINT on Unix platforms
 // and os.Interrupt on Windows).
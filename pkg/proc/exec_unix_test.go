@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package proc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type testStdio struct {
+	out bytes.Buffer
+}
+
+func (s *testStdio) InOrStdin() io.Reader {
+	return bytes.NewReader(nil)
+}
+
+func (s *testStdio) OutOrStdout() io.Writer {
+	return &s.out
+}
+
+func (s *testStdio) ErrOrStderr() io.Writer {
+	return &s.out
+}
+
+func TestRun_exitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		code   int
+	}{
+		{"success", "exit 0", 0},
+		{"failure", "exit 17", 17},
+		{"signal", "kill -TERM $$", 128 + 15},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			err := Run(ctx, nil, &testStdio{}, "sh", "-c", tt.script)
+			if tt.code == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var ee *ExitError
+			require.True(t, errors.As(err, &ee), "unexpected error %v", err)
+			assert.Equal(t, tt.code, ee.ExitCode())
+		})
+	}
+}
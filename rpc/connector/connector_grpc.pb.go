@@ -77,10 +77,25 @@ type ConnectorClient interface {
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// Quits (terminates) the connector process.
 	Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// QuitGraceful quits like Quit does, but only when no commands are executed
+	// by RunCommand. It waits up to the given timeout for running commands to
+	// end, and returns the commands that are still running, without quitting,
+	// if they don't.
+	QuitGraceful(ctx context.Context, in *QuitGracefulRequest, opts ...grpc.CallOption) (*QuitGracefulResponse, error)
 	// ListCommands returns a list of CLI commands that are implemented remotely by this daemon.
-	ListCommands(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CommandGroups, error)
+	ListCommands(ctx context.Context, in *ListCommandsRequest, opts ...grpc.CallOption) (*CommandGroups, error)
 	// RunCommand executes a CLI command.
 	RunCommand(ctx context.Context, opts ...grpc.CallOption) (Connector_RunCommandClient, error)
+	// RunCommands executes any number of CLI commands concurrently over a
+	// single stream. Each message is tagged with the id of the command's
+	// stream, and is otherwise handled as by RunCommand.
+	RunCommands(ctx context.Context, opts ...grpc.CallOption) (Connector_RunCommandsClient, error)
+	// RunningCommands returns the commands that are currently executed by RunCommand.
+	RunningCommands(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RunningCommandList, error)
+	// CancelCommand cancels a command that is executed by RunCommand. The command
+	// is first cancelled softly, as when the user presses Ctrl-C, and then
+	// forcefully unless it ends within a grace period.
+	CancelCommand(ctx context.Context, in *CancelCommandRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// ValidArgsForCommand handles autocompletion
 	ValidArgsForCommand(ctx context.Context, in *ValidArgsForCommandRequest, opts ...grpc.CallOption) (*ValidArgsForCommandResponse, error)
 	// ResolveIngressInfo is a temporary rpc intended to allow the cli to ask
@@ -350,7 +365,16 @@ func (c *connectorClient) Quit(ctx context.Context, in *emptypb.Empty, opts ...g
 	return out, nil
 }
 
-func (c *connectorClient) ListCommands(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CommandGroups, error) {
+func (c *connectorClient) QuitGraceful(ctx context.Context, in *QuitGracefulRequest, opts ...grpc.CallOption) (*QuitGracefulResponse, error) {
+	out := new(QuitGracefulResponse)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/QuitGraceful", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) ListCommands(ctx context.Context, in *ListCommandsRequest, opts ...grpc.CallOption) (*CommandGroups, error) {
 	out := new(CommandGroups)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ListCommands", in, out, opts...)
 	if err != nil {
@@ -390,6 +414,55 @@ func (x *connectorRunCommandClient) Recv() (*StreamResult, error) {
 	return m, nil
 }
 
+func (c *connectorClient) RunCommands(ctx context.Context, opts ...grpc.CallOption) (Connector_RunCommandsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[3], "/telepresence.connector.Connector/RunCommands", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorRunCommandsClient{stream}
+	return x, nil
+}
+
+type Connector_RunCommandsClient interface {
+	Send(*MuxedRunCommandRequest) error
+	Recv() (*MuxedStreamResult, error)
+	grpc.ClientStream
+}
+
+type connectorRunCommandsClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorRunCommandsClient) Send(m *MuxedRunCommandRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *connectorRunCommandsClient) Recv() (*MuxedStreamResult, error) {
+	m := new(MuxedStreamResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) RunningCommands(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RunningCommandList, error) {
+	out := new(RunningCommandList)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/RunningCommands", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) CancelCommand(ctx context.Context, in *CancelCommandRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/CancelCommand", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) ValidArgsForCommand(ctx context.Context, in *ValidArgsForCommandRequest, opts ...grpc.CallOption) (*ValidArgsForCommandResponse, error) {
 	out := new(ValidArgsForCommandResponse)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ValidArgsForCommand", in, out, opts...)
@@ -498,10 +571,25 @@ type ConnectorServer interface {
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
 	// Quits (terminates) the connector process.
 	Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// QuitGraceful quits like Quit does, but only when no commands are executed
+	// by RunCommand. It waits up to the given timeout for running commands to
+	// end, and returns the commands that are still running, without quitting,
+	// if they don't.
+	QuitGraceful(context.Context, *QuitGracefulRequest) (*QuitGracefulResponse, error)
 	// ListCommands returns a list of CLI commands that are implemented remotely by this daemon.
-	ListCommands(context.Context, *emptypb.Empty) (*CommandGroups, error)
+	ListCommands(context.Context, *ListCommandsRequest) (*CommandGroups, error)
 	// RunCommand executes a CLI command.
 	RunCommand(Connector_RunCommandServer) error
+	// RunCommands executes any number of CLI commands concurrently over a
+	// single stream. Each message is tagged with the id of the command's
+	// stream, and is otherwise handled as by RunCommand.
+	RunCommands(Connector_RunCommandsServer) error
+	// RunningCommands returns the commands that are currently executed by RunCommand.
+	RunningCommands(context.Context, *emptypb.Empty) (*RunningCommandList, error)
+	// CancelCommand cancels a command that is executed by RunCommand. The command
+	// is first cancelled softly, as when the user presses Ctrl-C, and then
+	// forcefully unless it ends within a grace period.
+	CancelCommand(context.Context, *CancelCommandRequest) (*emptypb.Empty, error)
 	// ValidArgsForCommand handles autocompletion
 	ValidArgsForCommand(context.Context, *ValidArgsForCommandRequest) (*ValidArgsForCommandResponse, error)
 	// ResolveIngressInfo is a temporary rpc intended to allow the cli to ask
@@ -590,12 +678,24 @@ func (UnimplementedConnectorServer) SetLogLevel(context.Context, *manager.LogLev
 func (UnimplementedConnectorServer) Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Quit not implemented")
 }
-func (UnimplementedConnectorServer) ListCommands(context.Context, *emptypb.Empty) (*CommandGroups, error) {
+func (UnimplementedConnectorServer) QuitGraceful(context.Context, *QuitGracefulRequest) (*QuitGracefulResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QuitGraceful not implemented")
+}
+func (UnimplementedConnectorServer) ListCommands(context.Context, *ListCommandsRequest) (*CommandGroups, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListCommands not implemented")
 }
 func (UnimplementedConnectorServer) RunCommand(Connector_RunCommandServer) error {
 	return status.Errorf(codes.Unimplemented, "method RunCommand not implemented")
 }
+func (UnimplementedConnectorServer) RunCommands(Connector_RunCommandsServer) error {
+	return status.Errorf(codes.Unimplemented, "method RunCommands not implemented")
+}
+func (UnimplementedConnectorServer) RunningCommands(context.Context, *emptypb.Empty) (*RunningCommandList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunningCommands not implemented")
+}
+func (UnimplementedConnectorServer) CancelCommand(context.Context, *CancelCommandRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelCommand not implemented")
+}
 func (UnimplementedConnectorServer) ValidArgsForCommand(context.Context, *ValidArgsForCommandRequest) (*ValidArgsForCommandResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ValidArgsForCommand not implemented")
 }
@@ -1029,8 +1129,26 @@ func _Connector_Quit_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_QuitGraceful_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuitGracefulRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).QuitGraceful(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/QuitGraceful",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).QuitGraceful(ctx, req.(*QuitGracefulRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_ListCommands_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(emptypb.Empty)
+	in := new(ListCommandsRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -1042,7 +1160,7 @@ func _Connector_ListCommands_Handler(srv interface{}, ctx context.Context, dec f
 		FullMethod: "/telepresence.connector.Connector/ListCommands",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ConnectorServer).ListCommands(ctx, req.(*emptypb.Empty))
+		return srv.(ConnectorServer).ListCommands(ctx, req.(*ListCommandsRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -1073,6 +1191,68 @@ func (x *connectorRunCommandServer) Recv() (*RunCommandRequest, error) {
 	return m, nil
 }
 
+func _Connector_RunCommands_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ConnectorServer).RunCommands(&connectorRunCommandsServer{stream})
+}
+
+type Connector_RunCommandsServer interface {
+	Send(*MuxedStreamResult) error
+	Recv() (*MuxedRunCommandRequest, error)
+	grpc.ServerStream
+}
+
+type connectorRunCommandsServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorRunCommandsServer) Send(m *MuxedStreamResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *connectorRunCommandsServer) Recv() (*MuxedRunCommandRequest, error) {
+	m := new(MuxedRunCommandRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Connector_RunningCommands_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).RunningCommands(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/RunningCommands",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).RunningCommands(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_CancelCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelCommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).CancelCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/CancelCommand",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).CancelCommand(ctx, req.(*CancelCommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_ValidArgsForCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ValidArgsForCommandRequest)
 	if err := dec(in); err != nil {
@@ -1268,10 +1448,22 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Quit",
 			Handler:    _Connector_Quit_Handler,
 		},
+		{
+			MethodName: "QuitGraceful",
+			Handler:    _Connector_QuitGraceful_Handler,
+		},
 		{
 			MethodName: "ListCommands",
 			Handler:    _Connector_ListCommands_Handler,
 		},
+		{
+			MethodName: "RunningCommands",
+			Handler:    _Connector_RunningCommands_Handler,
+		},
+		{
+			MethodName: "CancelCommand",
+			Handler:    _Connector_CancelCommand_Handler,
+		},
 		{
 			MethodName: "ValidArgsForCommand",
 			Handler:    _Connector_ValidArgsForCommand_Handler,
@@ -1314,6 +1506,12 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "RunCommands",
+			Handler:       _Connector_RunCommands_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "rpc/connector/connector.proto",
 }
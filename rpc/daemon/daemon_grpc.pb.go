@@ -43,6 +43,33 @@ type DaemonClient interface {
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// WaitForNetwork waits for the network of the currently connected session to become ready.
 	WaitForNetwork(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Reconnect tears down the network of the current session and creates a new session
+	// using the same OutboundInfo. It's used to recover from a degraded network without
+	// disconnecting the user daemon.
+	Reconnect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DaemonStatus, error)
+	// TailLog streams the last lines of the daemon's log file, and optionally
+	// the lines that are added to it, until the client disconnects.
+	TailLog(ctx context.Context, in *TailLogRequest, opts ...grpc.CallOption) (Daemon_TailLogClient, error)
+	// Metrics returns counters that describe the traffic that the current
+	// session has routed to the cluster.
+	Metrics(ctx context.Context, in *MetricsRequest, opts ...grpc.CallOption) (*SessionMetrics, error)
+	// PreviewNetwork returns the routes and DNS configuration that Connect
+	// would apply for the given OutboundInfo, without applying them.
+	PreviewNetwork(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*NetworkPreview, error)
+	// Ping echoes the request without doing any other work, so that a client
+	// can measure the round-trip latency of a call to the daemon.
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+	// GetConfig returns the configuration that the daemon was started with,
+	// together with the DNS configuration of the current session.
+	GetConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DaemonConfig, error)
+	// SupportBundle streams a gzipped tarball with the daemon's log file and
+	// the results of Version, Status, GetConfig, and Metrics, suitable for
+	// attaching to a bug report.
+	SupportBundle(ctx context.Context, in *SupportBundleRequest, opts ...grpc.CallOption) (Daemon_SupportBundleClient, error)
+	// DNSQueryLog streams an entry for each DNS query that the current session's
+	// DNS server serves, until the client disconnects or the session ends. The
+	// entries are only created while someone is streaming them.
+	DNSQueryLog(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Daemon_DNSQueryLogClient, error)
 }
 
 type daemonClient struct {
@@ -134,6 +161,147 @@ func (c *daemonClient) WaitForNetwork(ctx context.Context, in *emptypb.Empty, op
 	return out, nil
 }
 
+func (c *daemonClient) Reconnect(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DaemonStatus, error) {
+	out := new(DaemonStatus)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/Reconnect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) TailLog(ctx context.Context, in *TailLogRequest, opts ...grpc.CallOption) (Daemon_TailLogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[0], "/telepresence.daemon.Daemon/TailLog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonTailLogClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_TailLogClient interface {
+	Recv() (*LogLine, error)
+	grpc.ClientStream
+}
+
+type daemonTailLogClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonTailLogClient) Recv() (*LogLine, error) {
+	m := new(LogLine)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *daemonClient) Metrics(ctx context.Context, in *MetricsRequest, opts ...grpc.CallOption) (*SessionMetrics, error) {
+	out := new(SessionMetrics)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/Metrics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) PreviewNetwork(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*NetworkPreview, error) {
+	out := new(NetworkPreview)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/PreviewNetwork", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) GetConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DaemonConfig, error) {
+	out := new(DaemonConfig)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/GetConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) SupportBundle(ctx context.Context, in *SupportBundleRequest, opts ...grpc.CallOption) (Daemon_SupportBundleClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[1], "/telepresence.daemon.Daemon/SupportBundle", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonSupportBundleClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_SupportBundleClient interface {
+	Recv() (*SupportBundleChunk, error)
+	grpc.ClientStream
+}
+
+type daemonSupportBundleClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonSupportBundleClient) Recv() (*SupportBundleChunk, error) {
+	m := new(SupportBundleChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *daemonClient) DNSQueryLog(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Daemon_DNSQueryLogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[2], "/telepresence.daemon.Daemon/DNSQueryLog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonDNSQueryLogClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_DNSQueryLogClient interface {
+	Recv() (*DNSQuery, error)
+	grpc.ClientStream
+}
+
+type daemonDNSQueryLogClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonDNSQueryLogClient) Recv() (*DNSQuery, error) {
+	m := new(DNSQuery)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // DaemonServer is the server API for Daemon service.
 // All implementations must embed UnimplementedDaemonServer
 // for forward compatibility
@@ -156,6 +324,33 @@ type DaemonServer interface {
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
 	// WaitForNetwork waits for the network of the currently connected session to become ready.
 	WaitForNetwork(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// Reconnect tears down the network of the current session and creates a new session
+	// using the same OutboundInfo. It's used to recover from a degraded network without
+	// disconnecting the user daemon.
+	Reconnect(context.Context, *emptypb.Empty) (*DaemonStatus, error)
+	// TailLog streams the last lines of the daemon's log file, and optionally
+	// the lines that are added to it, until the client disconnects.
+	TailLog(*TailLogRequest, Daemon_TailLogServer) error
+	// Metrics returns counters that describe the traffic that the current
+	// session has routed to the cluster.
+	Metrics(context.Context, *MetricsRequest) (*SessionMetrics, error)
+	// PreviewNetwork returns the routes and DNS configuration that Connect
+	// would apply for the given OutboundInfo, without applying them.
+	PreviewNetwork(context.Context, *OutboundInfo) (*NetworkPreview, error)
+	// Ping echoes the request without doing any other work, so that a client
+	// can measure the round-trip latency of a call to the daemon.
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+	// GetConfig returns the configuration that the daemon was started with,
+	// together with the DNS configuration of the current session.
+	GetConfig(context.Context, *emptypb.Empty) (*DaemonConfig, error)
+	// SupportBundle streams a gzipped tarball with the daemon's log file and
+	// the results of Version, Status, GetConfig, and Metrics, suitable for
+	// attaching to a bug report.
+	SupportBundle(*SupportBundleRequest, Daemon_SupportBundleServer) error
+	// DNSQueryLog streams an entry for each DNS query that the current session's
+	// DNS server serves, until the client disconnects or the session ends. The
+	// entries are only created while someone is streaming them.
+	DNSQueryLog(*emptypb.Empty, Daemon_DNSQueryLogServer) error
 	mustEmbedUnimplementedDaemonServer()
 }
 
@@ -190,6 +385,30 @@ func (UnimplementedDaemonServer) SetLogLevel(context.Context, *manager.LogLevelR
 func (UnimplementedDaemonServer) WaitForNetwork(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WaitForNetwork not implemented")
 }
+func (UnimplementedDaemonServer) Reconnect(context.Context, *emptypb.Empty) (*DaemonStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reconnect not implemented")
+}
+func (UnimplementedDaemonServer) TailLog(*TailLogRequest, Daemon_TailLogServer) error {
+	return status.Errorf(codes.Unimplemented, "method TailLog not implemented")
+}
+func (UnimplementedDaemonServer) Metrics(context.Context, *MetricsRequest) (*SessionMetrics, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Metrics not implemented")
+}
+func (UnimplementedDaemonServer) PreviewNetwork(context.Context, *OutboundInfo) (*NetworkPreview, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewNetwork not implemented")
+}
+func (UnimplementedDaemonServer) Ping(context.Context, *PingRequest) (*PingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedDaemonServer) GetConfig(context.Context, *emptypb.Empty) (*DaemonConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedDaemonServer) SupportBundle(*SupportBundleRequest, Daemon_SupportBundleServer) error {
+	return status.Errorf(codes.Unimplemented, "method SupportBundle not implemented")
+}
+func (UnimplementedDaemonServer) DNSQueryLog(*emptypb.Empty, Daemon_DNSQueryLogServer) error {
+	return status.Errorf(codes.Unimplemented, "method DNSQueryLog not implemented")
+}
 func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
 
 // UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
@@ -365,6 +584,159 @@ func _Daemon_WaitForNetwork_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_Reconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).Reconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/Reconnect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).Reconnect(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_TailLog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailLogRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).TailLog(m, &daemonTailLogServer{stream})
+}
+
+type Daemon_TailLogServer interface {
+	Send(*LogLine) error
+	grpc.ServerStream
+}
+
+type daemonTailLogServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonTailLogServer) Send(m *LogLine) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Daemon_Metrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).Metrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/Metrics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).Metrics(ctx, req.(*MetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_PreviewNetwork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OutboundInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).PreviewNetwork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/PreviewNetwork",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).PreviewNetwork(ctx, req.(*OutboundInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/GetConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).GetConfig(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_SupportBundle_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SupportBundleRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).SupportBundle(m, &daemonSupportBundleServer{stream})
+}
+
+type Daemon_SupportBundleServer interface {
+	Send(*SupportBundleChunk) error
+	grpc.ServerStream
+}
+
+type daemonSupportBundleServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonSupportBundleServer) Send(m *SupportBundleChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Daemon_DNSQueryLog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).DNSQueryLog(m, &daemonDNSQueryLogServer{stream})
+}
+
+type Daemon_DNSQueryLogServer interface {
+	Send(*DNSQuery) error
+	grpc.ServerStream
+}
+
+type daemonDNSQueryLogServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonDNSQueryLogServer) Send(m *DNSQuery) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Daemon_ServiceDesc is the grpc.ServiceDesc for Daemon service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -408,7 +780,43 @@ var Daemon_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "WaitForNetwork",
 			Handler:    _Daemon_WaitForNetwork_Handler,
 		},
+		{
+			MethodName: "Reconnect",
+			Handler:    _Daemon_Reconnect_Handler,
+		},
+		{
+			MethodName: "Metrics",
+			Handler:    _Daemon_Metrics_Handler,
+		},
+		{
+			MethodName: "PreviewNetwork",
+			Handler:    _Daemon_PreviewNetwork_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Daemon_Ping_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _Daemon_GetConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TailLog",
+			Handler:       _Daemon_TailLog_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SupportBundle",
+			Handler:       _Daemon_SupportBundle_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DNSQueryLog",
+			Handler:       _Daemon_DNSQueryLog_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "rpc/daemon/daemon.proto",
 }